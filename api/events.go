@@ -0,0 +1,183 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Build event types sent by StreamBuildEvents.
+const (
+	EventLog    = "log"
+	EventStep   = "step"
+	EventStatus = "status"
+)
+
+// sseHeartbeatInterval is how often an idle stream sends a comment so proxies keep it open.
+const sseHeartbeatInterval = 15 * time.Second
+
+// LogEvent is the payload of a log event.
+type LogEvent struct {
+	Line   string `json:"line"`
+	Output bool   `json:"output"` // Raw output of a command run by the build
+}
+
+// StepEvent is the payload of a step event.
+type StepEvent struct {
+	Step  string `json:"step"`
+	State string `json:"state"`
+}
+
+// StatusEvent is the payload of a status event.
+type StatusEvent struct {
+	Status   string `json:"status"`
+	Progress int    `json:"progress"`
+	Error    string `json:"error,omitempty"`
+}
+
+// StreamBuildEvents Stream build events
+// @Summary Stream build events
+// @Description Follow a build live over Server-Sent Events. Log lines are sent as "log" events whose ID is the
+// @Description 1-based index of the line; reconnecting with the Last-Event-ID header (or the lastEventId query
+// @Description parameter) resumes after that line. "step" and "status" events carry step transitions and progress.
+// @Description The stream ends after the status event of a finished build.
+// @Tags iso
+// @Produce text/event-stream
+// @Param id path string true "Build ID"
+// @Param Last-Event-ID header string false "ID of the last received event"
+// @Param lastEventId query string false "ID of the last received event, for clients that cannot set headers"
+// @Success 200 {string} string "Event stream"
+// @Failure 400 {object} map[string]interface{} "Invalid last event ID"
+// @Failure 404 {object} map[string]interface{} "Build ID does not exist"
+// @Router /build/events/{id} [get]
+func (h *Handler) StreamBuildEvents(c *gin.Context) {
+	buildID := c.Param("id")
+	next, err := lastEventID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid last event ID: " + err.Error(),
+		})
+		return
+	}
+
+	// A client may have seen lines that were lost by a crash before being
+	// written; Watch resumes those at the end of the log.
+	watch, exists := h.builds.Watch(buildID, next)
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Build ID does not exist",
+		})
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	stream := &eventStream{w: c.Writer}
+	sentSteps := make(map[string]string)
+	sent := StatusEvent{Progress: -1}
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		for i, line := range watch.Lines {
+			stream.send(strconv.Itoa(watch.Next-len(watch.Lines)+i+1), EventLog, line)
+		}
+		next = watch.Next
+
+		status := watch.Status
+		steps := make([]string, 0, len(status.Steps))
+		for step := range status.Steps {
+			steps = append(steps, step)
+		}
+		sort.Strings(steps)
+		for _, step := range steps {
+			if state := status.Steps[step]; sentSteps[step] != state {
+				stream.send("", EventStep, StepEvent{Step: step, State: state})
+				sentSteps[step] = state
+			}
+		}
+
+		current := StatusEvent{Status: status.Status, Progress: status.Progress, Error: status.Error}
+		if current != sent {
+			stream.send("", EventStatus, current)
+			sent = current
+		}
+
+		if stream.err != nil || status.Finished() {
+			return
+		}
+		c.Writer.Flush()
+
+		select {
+		case <-watch.Changed:
+		case <-heartbeat.C:
+			stream.comment("heartbeat")
+			c.Writer.Flush()
+		case <-c.Request.Context().Done():
+			return
+		}
+		if stream.err != nil {
+			return
+		}
+		if watch, exists = h.builds.Watch(buildID, next); !exists {
+			return
+		}
+	}
+}
+
+// lastEventID returns the number of log lines the client already received.
+func lastEventID(c *gin.Context) (int, error) {
+	id := c.GetHeader("Last-Event-ID")
+	if id == "" {
+		id = c.Query("lastEventId")
+	}
+	if id == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(id)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%q is not a log line number", id)
+	}
+	return n, nil
+}
+
+// eventStream writes Server-Sent Events and remembers the first write error.
+type eventStream struct {
+	w   io.Writer
+	err error
+}
+
+// send writes one event with a JSON payload. Events without an ID leave the
+// client's last event ID unchanged.
+func (s *eventStream) send(id, event string, payload interface{}) {
+	if s.err != nil {
+		return
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		s.err = err
+		return
+	}
+	if id != "" {
+		_, s.err = fmt.Fprintf(s.w, "id: %s\nevent: %s\ndata: %s\n\n", id, event, data)
+		return
+	}
+	_, s.err = fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", event, data)
+}
+
+// comment writes an SSE comment line, ignored by clients.
+func (s *eventStream) comment(text string) {
+	if s.err == nil {
+		_, s.err = fmt.Fprintf(s.w, ": %s\n\n", text)
+	}
+}
@@ -0,0 +1,90 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newEventsServer serves StreamBuildEvents for the given registry.
+func newEventsServer(t *testing.T, registry *BuildRegistry) *httptest.Server {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	h := &Handler{builds: registry}
+	engine.GET("/build/events/:id", h.StreamBuildEvents)
+	server := httptest.NewServer(engine)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// Test a reconnecting client only receives the log lines after its last event ID,
+// and only lines marked as output are reported as command output.
+func TestStreamBuildEvents_Resume(t *testing.T) {
+	registry, err := NewBuildRegistry(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, registry.Add(&BuildStatus{
+		ID:     "build_1",
+		Status: BuildStatusRunning,
+		Steps:  map[string]string{},
+		Logs:   []string{"first", "    indented"},
+	}))
+	registry.AppendOutput("build_1", "xorriso output")
+	registry.Update("build_1", func(status *BuildStatus) {
+		status.Status = BuildStatusCompleted
+		status.Progress = 100
+		status.Steps["extract"] = "completed"
+	})
+	server := newEventsServer(t, registry)
+
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/build/events/build_1", nil)
+	require.NoError(t, err)
+	req.Header.Set("Last-Event-ID", "1")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	stream := string(body)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	assert.NotContains(t, stream, `"first"`)
+	assert.Contains(t, stream, "id: 2\nevent: log\ndata: {\"line\":\"    indented\",\"output\":false}\n\n")
+	assert.Contains(t, stream, "id: 3\nevent: log\ndata: {\"line\":\"xorriso output\",\"output\":true}\n\n")
+	assert.Contains(t, stream, "event: step\ndata: {\"step\":\"extract\",\"state\":\"completed\"}\n\n")
+	assert.True(t, strings.HasSuffix(stream, "event: status\ndata: {\"status\":\"completed\",\"progress\":100}\n\n"))
+}
+
+// Test a running build pushes new lines as they are appended and ends when it finishes.
+func TestStreamBuildEvents_Live(t *testing.T) {
+	registry, err := NewBuildRegistry(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, registry.Add(&BuildStatus{ID: "build_1", Status: BuildStatusRunning, Steps: map[string]string{}}))
+	server := newEventsServer(t, registry)
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		registry.AppendOutput("build_1", "apt-get output")
+		registry.Update("build_1", func(status *BuildStatus) {
+			status.Status = BuildStatusFailed
+			status.Error = "boom"
+		})
+	}()
+
+	resp, err := http.Get(server.URL + "/build/events/build_1")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	stream := string(body)
+	assert.Contains(t, stream, "id: 1\nevent: log\ndata: {\"line\":\"apt-get output\",\"output\":true}\n\n")
+	assert.Contains(t, stream, "event: status\ndata: {\"status\":\"running\",\"progress\":0}\n\n")
+	assert.True(t, strings.HasSuffix(stream, "event: status\ndata: {\"status\":\"failed\",\"progress\":0,\"error\":\"boom\"}\n\n"))
+}
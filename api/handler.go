@@ -1,16 +1,18 @@
 package api
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/lefeck/ubuntu-autoinstaller/cmd"
 	"github.com/lefeck/ubuntu-autoinstaller/logger"
-	"github.com/lefeck/ubuntu-autoinstaller/utils"
 
 	"github.com/lefeck/ubuntu-autoinstaller/config"
 	"github.com/lefeck/ubuntu-autoinstaller/generator"
@@ -22,35 +24,32 @@ import (
 type Handler struct {
 	userDataGen *generator.UserDataGenerator
 	generator   *generator.Generator
-	buildStatus map[string]*BuildStatus
-}
+	builds      *BuildRegistry
+	queue       *BuildQueue
 
-// BuildStatus
-type BuildStatus struct {
-	ID       string            `json:"id"`
-	Status   string            `json:"status"`
-	Progress int               `json:"progress"`
-	Steps    map[string]string `json:"steps"`
-	Logs     []string          `json:"logs"`
-	Error    string            `json:"error,omitempty"`
-	Output   string            `json:"output,omitempty"`
+	cancelMu sync.Mutex
+	cancels  map[string]context.CancelFunc
 }
 
-// NewHandler
-func NewHandler() *Handler {
-	dir, err := os.MkdirTemp("", "tmp.")
+// NewHandler creates a handler whose generator and build registry live under rootDir.
+// Up to workers builds run in parallel, each in its own workspace.
+func NewHandler(rootDir string, workers int) *Handler {
+	executor, err := generator.NewGenerator(&cmd.Executor{}, rootDir)
 	if err != nil {
-		logger.Fatalf("Failed to create temporary directory: %v", err)
+		logger.Fatalf("Failed to create generator: %v", err)
 	}
-	executor, err := generator.NewGenerator(&cmd.Executor{}, dir)
+	builds, err := NewBuildRegistry(executor.Path.RegistryDir())
 	if err != nil {
-		logger.Error("Failed to create executor: %v", err)
+		logger.Fatalf("Failed to open build registry: %v", err)
 	}
-	return &Handler{
+	h := &Handler{
 		userDataGen: generator.NewUserDataGenerator(),
 		generator:   executor,
-		buildStatus: make(map[string]*BuildStatus),
+		builds:      builds,
+		cancels:     make(map[string]context.CancelFunc),
 	}
+	h.queue = NewBuildQueue(workers, h.runBuild)
+	return h
 }
 
 // GenerateUserData Generate user-data configuration file
@@ -61,12 +60,14 @@ func NewHandler() *Handler {
 // @Produce json
 // @Param request body config.Config true "Configuration object"
 // @Success 200 {object} map[string]interface{} "User-data generated successfully"
-// @Failure 400 {object} map[string]interface{} "Invalid request parameters"
+// @Failure 400 {object} map[string]interface{} "Invalid request parameters or schema violations"
 // @Failure 500 {object} map[string]interface{} "Failed to generate user-data"
 // @Router /userdata/generate [post]
 func (h *Handler) GenerateUserData(c *gin.Context) {
 	var request struct {
-		Config *config.Config `json:"config" binding:"required"`
+		Config  *config.Config `json:"config" binding:"required"`
+		Release string         `json:"release"` // Ubuntu release whose autoinstall schema applies, defaults to noble
+		Source  string         `json:"source"`  // YAML document the config was edited from, whose unmodelled keys and comments are kept
 	}
 
 	if err := c.ShouldBindJSON(&request); err != nil {
@@ -76,9 +77,19 @@ func (h *Handler) GenerateUserData(c *gin.Context) {
 		return
 	}
 
+	source, ok := loadSource(c, request.Source)
+	if !ok {
+		return
+	}
+	// Warnings are about the config as sent, before generation fills it in
+	warnings := request.Config.Check().Warnings()
+
 	// Generate user-data
-	userData, err := h.userDataGen.GenerateFromConfig(request.Config)
+	userData, keys, err := h.generateUserData(request.Config, source, request.Release)
 	if err != nil {
+		if validationFailed(c, "Failed to generate user-data", err) {
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Failed to generate user-data: " + err.Error(),
 		})
@@ -96,6 +107,8 @@ func (h *Handler) GenerateUserData(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"success":  true,
 		"userData": string(userData),
+		"warnings": warnings,
+		"keys":     keys,
 		"message":  "User-data generated successfully",
 	})
 }
@@ -158,10 +171,12 @@ func (h *Handler) LoadConfigFromYAML(c *gin.Context) {
 		return
 	}
 
-	// Validate configuration
-	if err := cfg.Validate(); err != nil {
+	// Collect all errors and warnings of the configuration
+	result := cfg.Check()
+	if err := result.Err(); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Config validation failed: " + err.Error(),
+			"error":  "Config validation failed: " + err.Error(),
+			"issues": result.Issues,
 		})
 		return
 	}
@@ -169,23 +184,25 @@ func (h *Handler) LoadConfigFromYAML(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"config":  cfg,
+		"issues":  result.Issues,
 		"message": "Config loaded successfully",
 	})
 }
 
 // ValidateConfig Validate configuration
 // @Summary Validate configuration
-// @Description Validate a configuration object
+// @Description Validate a configuration object and return every error and warning with its JSON-pointer path, code and suggestion
 // @Tags config
 // @Accept json
 // @Produce json
 // @Param request body config.Config true "Configuration object"
-// @Success 200 {object} map[string]interface{} "Config validation passed"
-// @Failure 400 {object} map[string]interface{} "Config validation failed"
+// @Success 200 {object} map[string]interface{} "Config validation passed, with any warnings in issues"
+// @Failure 400 {object} map[string]interface{} "Config validation failed, with all issues or schema violations"
 // @Router /config/validate [post]
 func (h *Handler) ValidateConfig(c *gin.Context) {
 	var request struct {
-		Config *config.Config `json:"config" binding:"required"`
+		Config  *config.Config `json:"config" binding:"required"`
+		Release string         `json:"release"` // Ubuntu release whose autoinstall schema applies, defaults to noble
 	}
 
 	if err := c.ShouldBindJSON(&request); err != nil {
@@ -195,8 +212,26 @@ func (h *Handler) ValidateConfig(c *gin.Context) {
 		return
 	}
 
-	// Validate configuration
-	if err := request.Config.Validate(); err != nil {
+	// Collect all errors and warnings of the configuration, for the release
+	result := request.Config.Check()
+	result.Issues = append(result.Issues, request.Config.CheckRelease(request.Release).Issues...)
+	if err := result.Err(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":  "Config validation failed: " + err.Error(),
+			"issues": result.Issues,
+		})
+		return
+	}
+
+	// Validate the resulting user-data against the autoinstall schema
+	yamlData, err := h.userDataGen.SaveConfigToYAML(request.Config)
+	if err == nil {
+		err = config.ValidateUserDataSchema(yamlData, request.Release)
+	}
+	if err != nil {
+		if validationFailed(c, "Config validation failed", err) {
+			return
+		}
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": "Config validation failed: " + err.Error(),
 		})
@@ -205,10 +240,34 @@ func (h *Handler) ValidateConfig(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
+		"issues":  result.Issues,
 		"message": "Config validation passed",
 	})
 }
 
+// validationFailed responds with 400 and every issue or schema violation when
+// err is a config validation or schema error, and reports whether it did.
+func validationFailed(c *gin.Context, message string, err error) bool {
+	var verr *config.ValidationError
+	if errors.As(err, &verr) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":  message + ": " + err.Error(),
+			"issues": verr.Issues,
+		})
+		return true
+	}
+	var serr *config.SchemaError
+	if errors.As(err, &serr) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":      message + ": " + err.Error(),
+			"release":    serr.Release,
+			"violations": serr.Violations,
+		})
+		return true
+	}
+	return false
+}
+
 // PreviewUserData Preview generated user-data
 // @Summary Preview user-data configuration
 // @Description Preview the user-data configuration that would be generated from a config object
@@ -217,12 +276,14 @@ func (h *Handler) ValidateConfig(c *gin.Context) {
 // @Produce json
 // @Param request body config.Config true "Configuration object"
 // @Success 200 {object} map[string]interface{} "User-data preview generated successfully"
-// @Failure 400 {object} map[string]interface{} "Invalid request parameters"
+// @Failure 400 {object} map[string]interface{} "Invalid request parameters or schema violations"
 // @Failure 500 {object} map[string]interface{} "Failed to generate user-data preview"
 // @Router /userdata/preview [post]
 func (h *Handler) PreviewUserData(c *gin.Context) {
 	var request struct {
-		Config *config.Config `json:"config" binding:"required"`
+		Config  *config.Config `json:"config" binding:"required"`
+		Release string         `json:"release"` // Ubuntu release whose autoinstall schema applies, defaults to noble
+		Source  string         `json:"source"`  // YAML document the config was edited from, whose unmodelled keys and comments are kept
 	}
 
 	if err := c.ShouldBindJSON(&request); err != nil {
@@ -232,9 +293,17 @@ func (h *Handler) PreviewUserData(c *gin.Context) {
 		return
 	}
 
-	// Generate user-data preview
-	userData, err := h.userDataGen.GenerateFromConfig(request.Config)
+	source, ok := loadSource(c, request.Source)
+	if !ok {
+		return
+	}
+
+	// Generate user-data preview, the generated keys are not shown
+	userData, _, err := h.generateUserData(request.Config, source, request.Release)
 	if err != nil {
+		if validationFailed(c, "Failed to generate user-data preview", err) {
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Failed to generate user-data preview: " + err.Error(),
 		})
@@ -248,17 +317,45 @@ func (h *Handler) PreviewUserData(c *gin.Context) {
 	})
 }
 
+// generateUserData generates user-data from cfg. With a source document, the
+// config is written into it, keeping the keys and comments it does not model.
+func (h *Handler) generateUserData(cfg *config.Config, source *config.Document, release string) ([]byte, []config.GeneratedKey, error) {
+	if source == nil {
+		return h.userDataGen.GenerateWithKeys(cfg, release)
+	}
+	source.Config = cfg
+	return h.userDataGen.GenerateFromDocument(source, release)
+}
+
+// loadSource parses the source document of a generate request, if any. It
+// responds with an error and returns false when the document is invalid.
+func loadSource(c *gin.Context, source string) (*config.Document, bool) {
+	if source == "" {
+		return nil, true
+	}
+	doc, err := config.LoadDocument([]byte(source))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Failed to parse source document: " + err.Error(),
+		})
+		return nil, false
+	}
+	return doc, true
+}
+
 // GenerateISORequest Generate ISO request structure
 type GenerateISORequest struct {
 	SourceType     string   `json:"sourceType" binding:"required"` // "local" or "download"
 	SourceISO      string   `json:"sourceISO"`                     // Local ISO file path (when sourceType is "local")
 	CodeName       string   `json:"codeName"`                      // Ubuntu release name (when sourceType is "download")
-	DestinationISO string   `json:"destinationISO"`                // Output ISO file path
+	Arch           string   `json:"arch"`                          // ISO architecture (when sourceType is "download"): "amd64" (default) or "arm64"
+	DestinationISO string   `json:"destinationISO"`                // Output ISO file name, written into the output directory of the build
 	UserData       string   `json:"userData" binding:"required"`   // user-data configuration content
 	PackageList    []string `json:"packageList"`                   // Additional package list
 	UseHWEKernel   bool     `json:"useHWEKernel"`                  // Whether to use HWE kernel
 	MD5Checksum    bool     `json:"md5Checksum"`                   // Whether to update MD5 checksum
 	GPGVerify      bool     `json:"gpgVerify"`                     // Whether to perform GPG verification
+	Mode           string   `json:"mode"`                          // "full" (default) or "overlay"
 	Apps           string   `json:"apps"`                          // local build Application packages
 }
 
@@ -269,6 +366,16 @@ func (h *Handler) validateGenerateISORequest(request *GenerateISORequest) error
 		return fmt.Errorf("sourceType must be 'local' or 'download'")
 	}
 
+	// Validate architecture
+	if err := generator.ValidateArch(request.Arch); err != nil {
+		return err
+	}
+
+	// Validate build mode
+	if request.Mode != "" && request.Mode != generator.BuildModeFull && request.Mode != generator.BuildModeOverlay {
+		return fmt.Errorf("mode must be 'full' or 'overlay'")
+	}
+
 	// If local ISO, validate path
 	if request.SourceType == "local" && request.SourceISO == "" {
 		return fmt.Errorf("sourceISO is required when sourceType is 'local'")
@@ -298,6 +405,11 @@ func (h *Handler) validateGenerateISORequest(request *GenerateISORequest) error
 	if !strings.HasSuffix(request.DestinationISO, ".iso") {
 		return fmt.Errorf("outputPath must end with .iso extension")
 	}
+	// The ISO is written into the output directory of the build; paths are
+	// only accepted from the CLI, which runs with the rights of its user.
+	if filepath.Base(request.DestinationISO) != request.DestinationISO {
+		return fmt.Errorf("destinationISO must be a file name without a directory")
+	}
 
 	return nil
 }
@@ -312,6 +424,7 @@ func (h *Handler) validateGenerateISORequest(request *GenerateISORequest) error
 // @Success 200 {object} map[string]interface{} "ISO generation started"
 // @Failure 400 {object} map[string]interface{} "Invalid request parameters"
 // @Failure 500 {object} map[string]interface{} "Failed to start ISO generation"
+// @Failure 503 {object} map[string]interface{} "Build queue is full"
 // @Router /iso/generate [post]
 func (h *Handler) GenerateISO(c *gin.Context) {
 	var request GenerateISORequest
@@ -336,69 +449,202 @@ func (h *Handler) GenerateISO(c *gin.Context) {
 	// Initialize build status
 	buildStatus := &BuildStatus{
 		ID:       buildID,
-		Status:   "running",
+		Status:   BuildStatusQueued,
 		Progress: 0,
 		Steps:    make(map[string]string),
-		Logs:     []string{},
+		Logs:     []string{"⏳ Waiting for a free build worker..."},
+		Request:  &request,
+	}
+	if err := h.builds.Add(buildStatus); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to register build: " + err.Error(),
+		})
+		return
 	}
-	h.buildStatus[buildID] = buildStatus
 
 	// Execute build process in background
-	go func() {
-		if err := h.buildProcessWithStatus(&request, buildStatus, c); err != nil {
-			//if err := h.buildProcessWithStatus(&request, buildStatus); err != nil {
-			buildStatus.Status = "failed"
-			buildStatus.Error = err.Error()
-			buildStatus.Logs = append(buildStatus.Logs, fmt.Sprintf("ERROR: %s", err.Error()))
-		} else {
-			buildStatus.Status = "completed"
-			buildStatus.Progress = 100
-			buildStatus.Steps["complete"] = "completed"
-
-			// Use correct ISO file path
-			// If file is in download directory
-			downloadPath := filepath.Join(h.generator.Path.DownloadDir(), request.DestinationISO)
-			if _, err := os.Stat(downloadPath); err == nil {
-				// File is in download directory
-				buildStatus.Output = downloadPath
-				logger.Infof("Found ISO file at: %s", downloadPath)
-			} else if filepath.IsAbs(request.DestinationISO) && fileExists(request.DestinationISO) {
-				// Use absolute path
-				buildStatus.Output = request.DestinationISO
-				logger.Infof("Using absolute path: %s", request.DestinationISO)
-			} else {
-				// Try to find in current directory
-				absPath, err := filepath.Abs(request.DestinationISO)
-				if err == nil && fileExists(absPath) {
-					buildStatus.Output = absPath
-					logger.Infof("Using file in current directory: %s", absPath)
-				} else {
-					// Finally try to find in working directory
-					workingDir, _ := os.Getwd()
-					workingPath := filepath.Join(workingDir, request.DestinationISO)
-					if fileExists(workingPath) {
-						buildStatus.Output = workingPath
-						logger.Infof("Using file in working directory: %s", workingPath)
-					} else {
-						// File not found, record all attempted paths
-						logger.Warnf("Could not find ISO file at any of these locations:")
-						logger.Warnf("- %s", downloadPath)
-						logger.Warnf("- %s", request.DestinationISO)
-						logger.Warnf("- %s", absPath)
-						logger.Warnf("- %s", workingPath)
-						buildStatus.Output = downloadPath // Use most likely path
-					}
-				}
-			}
+	ctx := h.trackBuild(buildID)
+	if err := h.queue.Enqueue(buildJob{ctx: ctx, id: buildID, request: &request}); err != nil {
+		h.untrackBuild(buildID)
+		h.finishBuild(buildID, "", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "Failed to start ISO generation: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"buildID": buildID,
+		"message": "ISO generation started",
+	})
+}
+
+// runBuild executes a queued build in its own workspace and records the result.
+func (h *Handler) runBuild(job buildJob) {
+	defer h.untrackBuild(job.id)
+	if err := job.ctx.Err(); err != nil {
+		h.finishBuild(job.id, "", err)
+		return
+	}
+	// CancelBuild may have finished the queued build since the check above.
+	started := false
+	h.builds.Update(job.id, func(status *BuildStatus) {
+		if status.Status != BuildStatusQueued {
+			return
+		}
+		status.Status = BuildStatusRunning
+		started = true
+	})
+	if !started {
+		return
+	}
 
-			buildStatus.Logs = append(buildStatus.Logs, "✅ ISO generation completed successfully!")
+	gen, err := h.generator.NewWorkspace(job.id)
+	if err != nil {
+		h.finishBuild(job.id, "", fmt.Errorf("failed to create build workspace: %w", err))
+		return
+	}
+	defer func() {
+		if err := gen.CleanUp(); err != nil {
+			logger.Warnf("Failed to clean up workspace of build %s: %v", job.id, err)
 		}
 	}()
 
+	if err := h.buildProcessWithStatus(job.ctx, gen, job.id, job.request); err != nil {
+		if ctxErr := job.ctx.Err(); ctxErr != nil {
+			// Drop everything the build produced, including a partial ISO.
+			if err := gen.RemoveWorkspace(); err != nil {
+				logger.Warnf("Failed to remove workspace of cancelled build %s: %v", job.id, err)
+			}
+			err = ctxErr
+		}
+		h.finishBuild(job.id, "", err)
+		return
+	}
+
+	output := gen.DestinationISOFile(job.request.DestinationISO)
+	if !fileExists(output) {
+		h.finishBuild(job.id, "", fmt.Errorf("ISO file not found at %s after repackaging", output))
+		return
+	}
+	logger.Infof("Found ISO file at: %s", output)
+	h.finishBuild(job.id, output, nil)
+}
+
+// finishBuild records the final state of a build. Builds that already
+// finished, e.g. a queued build that was cancelled, are left untouched.
+func (h *Handler) finishBuild(buildID, output string, buildErr error) {
+	h.builds.Update(buildID, func(status *BuildStatus) {
+		finishStatus(status, output, buildErr)
+	})
+}
+
+// finishStatus sets the final state of status unless it already finished.
+func finishStatus(status *BuildStatus, output string, buildErr error) {
+	if status.Finished() {
+		return
+	}
+	now := time.Now()
+	status.FinishedAt = &now
+	switch {
+	case errors.Is(buildErr, context.Canceled):
+		status.Status = BuildStatusCancelled
+		status.Error = "build cancelled"
+		status.Logs = append(status.Logs, "🛑 Build cancelled")
+	case buildErr != nil:
+		status.Status = BuildStatusFailed
+		status.Error = buildErr.Error()
+		status.Logs = append(status.Logs, fmt.Sprintf("ERROR: %s", buildErr.Error()))
+	default:
+		status.Status = BuildStatusCompleted
+		status.Progress = 100
+		status.Steps["complete"] = "completed"
+		status.Output = output
+		status.Logs = append(status.Logs, "✅ ISO generation completed successfully!")
+	}
+}
+
+// trackBuild returns the context of a new build and remembers its cancel function.
+func (h *Handler) trackBuild(buildID string) context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	h.cancelMu.Lock()
+	h.cancels[buildID] = cancel
+	h.cancelMu.Unlock()
+	return ctx
+}
+
+// untrackBuild releases the context of a build that is no longer queued or running.
+func (h *Handler) untrackBuild(buildID string) {
+	h.cancelMu.Lock()
+	cancel, ok := h.cancels[buildID]
+	delete(h.cancels, buildID)
+	h.cancelMu.Unlock()
+	if ok {
+		cancel()
+	}
+}
+
+// cancelBuild cancels the context of a queued or running build.
+func (h *Handler) cancelBuild(buildID string) bool {
+	h.cancelMu.Lock()
+	cancel, ok := h.cancels[buildID]
+	h.cancelMu.Unlock()
+	if ok {
+		cancel()
+	}
+	return ok
+}
+
+// CancelBuild Cancel a build
+// @Summary Cancel build
+// @Description Cancel a queued or running ISO build. Running commands are killed and the build workspace is removed.
+// @Tags iso
+// @Produce json
+// @Param id path string true "Build ID"
+// @Success 200 {object} map[string]interface{} "Build cancellation requested"
+// @Failure 400 {object} map[string]interface{} "Build ID cannot be empty"
+// @Failure 404 {object} map[string]interface{} "Build ID does not exist"
+// @Failure 409 {object} map[string]interface{} "Build already finished"
+// @Router /build/{id} [delete]
+func (h *Handler) CancelBuild(c *gin.Context) {
+	buildID := c.Param("id")
+	if buildID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Build ID cannot be empty",
+		})
+		return
+	}
+
+	status, exists := h.builds.Get(buildID)
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Build ID does not exist",
+		})
+		return
+	}
+	if status.Finished() || !h.cancelBuild(buildID) {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "Build already finished with status " + status.Status,
+		})
+		return
+	}
+
+	h.builds.Update(buildID, func(status *BuildStatus) {
+		// A queued build never reaches a worker step, so record the result
+		// right away; the worker skips builds that are no longer queued.
+		if status.Status == BuildStatusQueued {
+			finishStatus(status, "", context.Canceled)
+			return
+		}
+		status.Logs = append(status.Logs, "🛑 Cancellation requested, stopping build...")
+	})
+	logger.Infof("Cancellation requested for build %s", buildID)
+
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"buildID": buildID,
-		"message": "ISO generation started",
+		"message": "Build cancellation requested",
 	})
 }
 
@@ -463,134 +709,49 @@ func (h *Handler) UploadISO(c *gin.Context) {
 }
 
 // buildProcessWithStatus Execute complete ISO build process (with status updates, maintain compatibility)
-func (h *Handler) buildProcessWithStatus(request *GenerateISORequest, status *BuildStatus, c *gin.Context) error {
-	updateProgress := func(progress int, step, message string) {
-		status.Progress = progress
-		status.Steps[step] = "completed"
-		status.Logs = append(status.Logs, message)
-	}
-
-	// Step 1: Preprocessing - Create temporary directory and check packages
-	status.Steps["prepare"] = "running"
-	status.Logs = append(status.Logs, "📁 Preparing installation environment...")
-	if err := h.generator.PrepareEnvironment(request.CodeName); err != nil {
-		return fmt.Errorf("preprocessing failed: %w", err)
-	}
-	updateProgress(10, "prepare", "✅ Installation environment ready")
-
-	var localImagePath string
-	var imageMeta *utils.ImageMeta
-	var err error
-
-	// Step 2: Process ISO file based on source type
-	switch request.SourceType {
-	case "download":
-		status.Steps["download"] = "running"
-		status.Logs = append(status.Logs, "🌎 Downloading ISO...")
-		localImagePath, err = h.generator.DownloadISOImage(request.CodeName, request.GPGVerify)
-		if err != nil {
-			return fmt.Errorf("ISO download failed: %w", err)
-		}
-		updateProgress(30, "download", "✅ ISO downloaded successfully")
-
-		imageMeta, err = utils.NewImageMeta(localImagePath)
-		if err != nil {
-			return fmt.Errorf("failed to get image meta: %w", err)
-		}
-
-		if request.GPGVerify {
-			status.Steps["verify"] = "running"
-			status.Logs = append(status.Logs, "🔐 Verifying ISO (GPG)...")
-			if err := h.generator.VerifyISO(request.GPGVerify, localImagePath, request.CodeName); err != nil {
-				return fmt.Errorf("ISO verification failed: %w", err)
-			}
-			updateProgress(40, "verify", "✅ ISO verified successfully")
-		}
-
-	case "local":
-		status.Steps["upload"] = "running"
-		status.Logs = append(status.Logs, "📦 Using previously uploaded local ISO...")
-		if request.SourceISO == "" {
-			return fmt.Errorf("local SourceISO path is empty")
-		}
-		if _, err := os.Stat(request.SourceISO); err != nil {
-			return fmt.Errorf("local ISO not found: %w", err)
-		}
-		localImagePath = request.SourceISO
-		updateProgress(20, "upload", "✅ Local ISO ready")
-
-		imageMeta, err = utils.NewImageMeta(localImagePath)
-		if err != nil {
-			return fmt.Errorf("failed to get image meta: %w", err)
-		}
-	}
-
-	// Step 3: Extract ISO image
-	status.Steps["extract"] = "running"
-	status.Logs = append(status.Logs, "📂 Extracting ISO contents...")
-	if err := h.generator.ExtractISO(imageMeta.CodeName, localImagePath); err != nil {
-		return fmt.Errorf("ISO extraction failed: %w", err)
-	}
-	updateProgress(50, "extract", "✅ ISO contents extracted")
-
-	// Step 4: Add configuration data (user-data and meta-data)
-	status.Steps["inject"] = "running"
-	status.Logs = append(status.Logs, "🧩 Injecting user-data configuration...")
-
-	userDataFile := h.generator.Path.MetaDataFile(generator.UserDataFile)
-	if err := os.WriteFile(userDataFile, []byte(request.UserData), 0644); err != nil {
-		return fmt.Errorf("failed to create temporary user-data file: %w", err)
-	}
-	if err := h.generator.InjectNoCloudConfig(imageMeta.CodeName); err != nil {
-		return fmt.Errorf("failed to add config data: %w", err)
-	}
-	updateProgress(60, "inject", "✅ user-data injected")
-
-	// Step 5: Download and prepare additional packages (if any)
-	if len(request.PackageList) > 0 {
-		status.Steps["packages"] = "running"
-		status.Logs = append(status.Logs, "📦 Preparing additional packages...")
-
-		if err := h.generator.PrepareLocalPackagesRepo(request.PackageList); err != nil {
-			return fmt.Errorf("failed to download and prepare packages: %w", err)
-		}
-
-		updateProgress(65, "packages", "✅ Extra packages prepared")
-	}
-
-	// Step 6: Add autoinstall parameters to kernel command line
-	status.Steps["kernel"] = "running"
-	status.Logs = append(status.Logs, "⚙️ Adding autoinstall kernel parameters...")
-	if err := h.generator.AddAutoinstallKernelParams(imageMeta.CodeName); err != nil {
-		return fmt.Errorf("failed to add autoinstall parameter: %w", err)
-	}
-	updateProgress(70, "kernel", "✅ Kernel parameters added")
+func (h *Handler) buildProcessWithStatus(ctx context.Context, gen *generator.Generator, buildID string, request *GenerateISORequest) error {
+	opts := &generator.BuildOptions{
+		SourceType:     request.SourceType,
+		SourceISO:      request.SourceISO,
+		CodeName:       request.CodeName,
+		Arch:           request.Arch,
+		DestinationISO: request.DestinationISO,
+		UserData:       request.UserData,
+		PackageList:    request.PackageList,
+		UseHWEKernel:   request.UseHWEKernel,
+		MD5Checksum:    request.MD5Checksum,
+		GPGVerify:      request.GPGVerify,
+		Mode:           request.Mode,
+	}
+	return gen.Build(ctx, opts, &statusReporter{buildID: buildID, builds: h.builds})
+}
 
-	// Step 7: Configure HWE kernel (if enabled)
-	status.Steps["hwe"] = "running"
-	status.Logs = append(status.Logs, "🧪 Configuring HWE kernel if requested...")
-	if err := h.generator.ConfigureHWEKernel(imageMeta.CodeName, request.UseHWEKernel); err != nil {
-		return fmt.Errorf("failed to configure HWE kernel: %w", err)
-	}
-	updateProgress(80, "hwe", "✅ HWE kernel configuration processed")
+// statusReporter records generator build steps into the build registry.
+type statusReporter struct {
+	buildID string
+	builds  *BuildRegistry
+}
 
-	// Step 8: Update MD5 (if enabled)
-	status.Steps["md5"] = "running"
-	status.Logs = append(status.Logs, "🔢 Updating MD5 checksums if requested...")
-	if err := h.generator.UpdateGrubMD5Sums(imageMeta.CodeName, request.MD5Checksum); err != nil {
-		return fmt.Errorf("failed to update MD5 checksum: %w", err)
-	}
-	updateProgress(90, "md5", "✅ MD5 checksums updated")
+// StepStarted marks the step as running and logs its message.
+func (r *statusReporter) StepStarted(step, message string) {
+	r.builds.Update(r.buildID, func(status *BuildStatus) {
+		status.Steps[step] = "running"
+		status.Logs = append(status.Logs, message)
+	})
+}
 
-	// Step 9: Repackage ISO image
-	status.Steps["repackage"] = "running"
-	status.Logs = append(status.Logs, "📦 Repackaging ISO image...")
-	if err := h.generator.RepackageISOImage(imageMeta.CodeName, request.DestinationISO); err != nil {
-		return fmt.Errorf("failed to repackage ISO: %w", err)
-	}
-	updateProgress(100, "repackage", "✅ ISO repackaged successfully")
+// StepCompleted marks the step as completed and updates the progress.
+func (r *statusReporter) StepCompleted(step string, progress int, message string) {
+	r.builds.Update(r.buildID, func(status *BuildStatus) {
+		status.Progress = progress
+		status.Steps[step] = "completed"
+		status.Logs = append(status.Logs, message)
+	})
+}
 
-	return nil
+// CommandOutput appends a command output line to the build logs.
+func (r *statusReporter) CommandOutput(line string) {
+	r.builds.AppendOutput(r.buildID, line)
 }
 
 // GetBuildStatus Get build status
@@ -612,7 +773,7 @@ func (h *Handler) GetBuildStatus(c *gin.Context) {
 		return
 	}
 
-	status, exists := h.buildStatus[buildID]
+	status, exists := h.builds.Get(buildID)
 	if !exists {
 		c.JSON(http.StatusNotFound, gin.H{
 			"error": "Build ID does not exist",
@@ -646,7 +807,7 @@ func (h *Handler) GetBuildLogs(c *gin.Context) {
 		return
 	}
 
-	status, exists := h.buildStatus[buildID]
+	status, exists := h.builds.Get(buildID)
 	if !exists {
 		c.JSON(http.StatusNotFound, gin.H{
 			"error": "Build ID does not exist",
@@ -661,6 +822,21 @@ func (h *Handler) GetBuildLogs(c *gin.Context) {
 	})
 }
 
+// ListBuilds List all builds
+// @Summary List builds
+// @Description List all known ISO builds, including builds from previous server runs, newest first
+// @Tags iso
+// @Produce json
+// @Success 200 {object} map[string]interface{} "Builds retrieved successfully"
+// @Router /build/list [get]
+func (h *Handler) ListBuilds(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"builds":  h.builds.List(),
+		"message": "Builds retrieved successfully",
+	})
+}
+
 // DownloadISO handles downloading of a generated ISO file by build ID
 // @Summary Download generated ISO
 // @Description Download the ISO file associated with a completed build task
@@ -684,7 +860,7 @@ func (h *Handler) DownloadISO(c *gin.Context) {
 	}
 
 	// Check if the build status exists for the given ID
-	status, exists := h.buildStatus[buildID]
+	status, exists := h.builds.Get(buildID)
 	if !exists {
 		c.JSON(http.StatusNotFound, gin.H{
 			"error": "Build ID does not exist",
@@ -693,7 +869,7 @@ func (h *Handler) DownloadISO(c *gin.Context) {
 	}
 
 	// Ensure the build has completed successfully
-	if status.Status != "completed" {
+	if status.Status != BuildStatusCompleted {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": "Build is not completed yet",
 		})
@@ -767,6 +943,12 @@ func (h *Handler) DownloadISO(c *gin.Context) {
 		return
 	}
 
+	// Remember where the file was found for subsequent downloads
+	output := status.Output
+	h.builds.Update(buildID, func(status *BuildStatus) {
+		status.Output = output
+	})
+
 	// Log file size
 	logger.Infof("File exists, size: %d bytes", fileInfo.Size())
 
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Test clients can only name the output ISO, which is written into the output
+// directory of the build, never a path elsewhere on the server.
+func TestValidateGenerateISORequest_DestinationISO(t *testing.T) {
+	h := &Handler{}
+	for dest, valid := range map[string]bool{
+		"ubuntu.iso":             true,
+		"/etc/ubuntu.iso":        false,
+		"../ubuntu.iso":          false,
+		"output/ubuntu.iso":      false,
+		"/var/lib/isos/base.iso": false,
+		"ubuntu.img":             false,
+	} {
+		request := &GenerateISORequest{SourceType: "download", CodeName: "jammy", DestinationISO: dest}
+		err := h.validateGenerateISORequest(request)
+		if valid {
+			assert.NoError(t, err, dest)
+		} else {
+			assert.Error(t, err, dest)
+		}
+	}
+}
+
+// Test a worker that picks up a build cancelled while queued leaves it
+// cancelled and never creates a workspace for it.
+func TestHandler_RunBuildCancelledWhileQueued(t *testing.T) {
+	registry, err := NewBuildRegistry(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, registry.Add(&BuildStatus{ID: "build_1", Status: BuildStatusQueued, Steps: map[string]string{}}))
+	h := &Handler{builds: registry, cancels: make(map[string]context.CancelFunc)}
+	ctx := h.trackBuild("build_1")
+
+	// CancelBuild ran between the context check of the worker and its update.
+	registry.Update("build_1", func(status *BuildStatus) {
+		finishStatus(status, "", context.Canceled)
+	})
+	h.runBuild(buildJob{ctx: ctx, id: "build_1", request: &GenerateISORequest{}})
+
+	status, ok := registry.Get("build_1")
+	require.True(t, ok)
+	assert.Equal(t, BuildStatusCancelled, status.Status)
+	assert.NotNil(t, status.FinishedAt)
+}
@@ -0,0 +1,62 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/lefeck/ubuntu-autoinstaller/utils"
+)
+
+// VerifyPasswordRequest tests a plaintext password against a stored crypt hash.
+type VerifyPasswordRequest struct {
+	Password string `json:"password" binding:"required"` // Plaintext password
+	Hash     string `json:"hash" binding:"required"`     // $1$, $5$, $6$ or $y$ crypt hash, e.g. identity.password of a config
+}
+
+// VerifyPassword Verify a password against a crypt hash
+// @Summary Verify a password against a crypt hash
+// @Description Check whether a plaintext password matches a $1$, $5$, $6$ or $y$ crypt hash, such as the identity password of a config. Hashes of more than 5000000 rounds or 64 MiB of yescrypt memory are refused
+// @Tags identity
+// @Accept json
+// @Produce json
+// @Param request body VerifyPasswordRequest true "Password and hash"
+// @Success 200 {object} map[string]interface{} "Verified, see match and algorithm"
+// @Failure 400 {object} map[string]interface{} "Invalid request parameters, unsupported hash or a hash too expensive to verify"
+// @Router /identity/verify [post]
+func (h *Handler) VerifyPassword(c *gin.Context) {
+	var request VerifyPasswordRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request parameters: " + err.Error(),
+		})
+		return
+	}
+
+	// The hash decides how long verifying takes, so expensive ones are refused.
+	if err := utils.CheckCryptCost(request.Hash); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Hash is too expensive to verify: " + err.Error(),
+		})
+		return
+	}
+
+	match, err := utils.VerifyCrypt(request.Password, request.Hash)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Failed to verify password: " + err.Error(),
+		})
+		return
+	}
+
+	message := "Password does not match the hash"
+	if match {
+		message = "Password matches the hash"
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success":   true,
+		"match":     match,
+		"algorithm": utils.CryptHashAlgorithm(request.Hash),
+		"message":   message,
+	})
+}
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// verifyPassword posts body to VerifyPassword and returns the status and response.
+func verifyPassword(t *testing.T, body string) (int, map[string]interface{}) {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	h := &Handler{}
+	engine.POST("/identity/verify", h.VerifyPassword)
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/identity/verify", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	engine.ServeHTTP(w, req)
+
+	var resp map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	return w.Code, resp
+}
+
+func TestVerifyPassword(t *testing.T) {
+	const hash = "$5$rounds=10000$saltstring$BXKRfHOWGOryjAm0GVQk8VRJRERBkg4gV1V0f0ddop."
+
+	code, resp := verifyPassword(t, `{"password": "password", "hash": "`+hash+`"}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, true, resp["match"])
+	assert.Equal(t, "sha256", resp["algorithm"])
+
+	code, resp = verifyPassword(t, `{"password": "Password", "hash": "`+hash+`"}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, false, resp["match"])
+
+	code, _ = verifyPassword(t, `{"password": "password", "hash": "$5$saltstring$BXKRf"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	expensive := "$6$rounds=999999999$saltstring$" + strings.Repeat("a", 86)
+	code, resp = verifyPassword(t, `{"password": "password", "hash": "`+expensive+`"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Contains(t, resp["error"], "too expensive")
+	code, _ = verifyPassword(t, `{"hash": "`+hash+`"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+}
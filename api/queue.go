@@ -0,0 +1,59 @@
+package api
+
+import (
+	"context"
+	"errors"
+
+	"github.com/lefeck/ubuntu-autoinstaller/logger"
+)
+
+// DefaultBuildWorkers is the number of builds run in parallel when not configured.
+const DefaultBuildWorkers = 1
+
+// maxQueuedBuilds bounds the number of builds waiting for a worker.
+const maxQueuedBuilds = 64
+
+// ErrQueueFull is returned when no more builds can be queued.
+var ErrQueueFull = errors.New("build queue is full, try again later")
+
+// buildJob is a queued ISO build. Cancelling ctx aborts the build, whether it
+// is still waiting in the queue or already running.
+type buildJob struct {
+	ctx     context.Context
+	id      string
+	request *GenerateISORequest
+}
+
+// BuildQueue runs queued builds on a fixed number of worker goroutines.
+type BuildQueue struct {
+	jobs chan buildJob
+}
+
+// NewBuildQueue starts workers goroutines that pass every queued job to run.
+func NewBuildQueue(workers int, run func(job buildJob)) *BuildQueue {
+	if workers < 1 {
+		workers = DefaultBuildWorkers
+	}
+	q := &BuildQueue{
+		jobs: make(chan buildJob, maxQueuedBuilds),
+	}
+	for i := 0; i < workers; i++ {
+		go func() {
+			for job := range q.jobs {
+				run(job)
+			}
+		}()
+	}
+	logger.Infof("Started %d build workers", workers)
+	return q
+}
+
+// Enqueue adds a job to the queue without blocking.
+func (q *BuildQueue) Enqueue(job buildJob) error {
+	select {
+	case q.jobs <- job:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
@@ -0,0 +1,434 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lefeck/ubuntu-autoinstaller/logger"
+)
+
+// Build states recorded in BuildStatus.Status.
+const (
+	BuildStatusQueued    = "queued"
+	BuildStatusRunning   = "running"
+	BuildStatusCompleted = "completed"
+	BuildStatusFailed    = "failed"
+	BuildStatusCancelled = "cancelled"
+)
+
+// recordFileExt is the file extension of persisted build records.
+const recordFileExt = ".json"
+
+// logFileExt is the file extension of the append-only build logs.
+const logFileExt = ".log"
+
+// BuildStatus
+type BuildStatus struct {
+	ID         string              `json:"id"`
+	Status     string              `json:"status"`
+	Progress   int                 `json:"progress"`
+	Steps      map[string]string   `json:"steps"`
+	Logs       []string            `json:"logs"` // Messages of the build pipeline; command output is only in the build log
+	Error      string              `json:"error,omitempty"`
+	Output     string              `json:"output,omitempty"`
+	Request    *GenerateISORequest `json:"request,omitempty"`
+	CreatedAt  time.Time           `json:"createdAt"`
+	UpdatedAt  time.Time           `json:"updatedAt"`
+	FinishedAt *time.Time          `json:"finishedAt,omitempty"`
+}
+
+// clone returns a deep copy of the status that can be read without holding the registry lock.
+func (s *BuildStatus) clone() *BuildStatus {
+	c := s.withoutLogs()
+	c.Logs = append([]string(nil), s.Logs...)
+	return c
+}
+
+// withoutLogs returns a deep copy of the status without its logs.
+func (s *BuildStatus) withoutLogs() *BuildStatus {
+	c := *s
+	c.Logs = nil
+	c.Steps = make(map[string]string, len(s.Steps))
+	for k, v := range s.Steps {
+		c.Steps[k] = v
+	}
+	if s.FinishedAt != nil {
+		finishedAt := *s.FinishedAt
+		c.FinishedAt = &finishedAt
+	}
+	return &c
+}
+
+// Finished reports whether the build reached a final state.
+func (s *BuildStatus) Finished() bool {
+	switch s.Status {
+	case BuildStatusCompleted, BuildStatusFailed, BuildStatusCancelled:
+		return true
+	}
+	return false
+}
+
+// BuildRegistry keeps build records in memory and persists every record as a
+// JSON file, so builds survive server restarts. All access goes through the
+// registry lock; callers only ever see copies of the stored records.
+//
+// Next to its record every build has an append-only log file that
+// StreamBuildEvents replays: each log line, the pipeline messages of the record
+// and raw command output alike, as one JSON-encoded LogEvent. Command output is
+// written there as it arrives and never rewritten with the record.
+type BuildRegistry struct {
+	mu     sync.RWMutex
+	dir    string
+	builds map[string]*BuildStatus
+	logs   map[string]*buildLog
+
+	// watchers holds, per build, a channel that is closed on its next change.
+	watchers map[string]chan struct{}
+}
+
+// NewBuildRegistry opens the registry stored in dir and reloads existing records.
+// Builds that were still running when the server stopped are marked as failed.
+func NewBuildRegistry(dir string) (*BuildRegistry, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create registry directory %s: %w", dir, err)
+	}
+	r := &BuildRegistry{
+		dir:      dir,
+		builds:   make(map[string]*BuildStatus),
+		logs:     make(map[string]*buildLog),
+		watchers: make(map[string]chan struct{}),
+	}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// load reads all records from disk and fails builds interrupted by a restart.
+func (r *BuildRegistry) load() error {
+	files, err := filepath.Glob(filepath.Join(r.dir, "*"+recordFileExt))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("failed to read build record %s: %w", file, err)
+		}
+		var status BuildStatus
+		if err := json.Unmarshal(data, &status); err != nil {
+			logger.Warnf("Skipping corrupt build record %s: %v", file, err)
+			continue
+		}
+		if status.ID == "" {
+			status.ID = strings.TrimSuffix(filepath.Base(file), recordFileExt)
+		}
+		if status.Steps == nil {
+			status.Steps = make(map[string]string)
+		}
+		r.builds[status.ID] = &status
+		if status.Status == BuildStatusRunning || status.Status == BuildStatusQueued {
+			status.Status = BuildStatusFailed
+			status.Error = "build interrupted by server restart"
+			status.Logs = append(status.Logs, "ERROR: "+status.Error)
+			now := time.Now()
+			status.UpdatedAt = now
+			status.FinishedAt = &now
+			if err := r.write(&status); err != nil {
+				return err
+			}
+			r.appendLog(&status, messageEvents(status.Logs[len(status.Logs)-1:])...)
+		}
+	}
+	logger.Infof("Loaded %d build records from %s", len(r.builds), r.dir)
+	return nil
+}
+
+// Add registers a new build and persists it.
+func (r *BuildRegistry) Add(status *BuildStatus) error {
+	now := time.Now()
+	status.CreatedAt = now
+	status.UpdatedAt = now
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.builds[status.ID]; exists {
+		return fmt.Errorf("build %s already exists", status.ID)
+	}
+	if err := r.write(status); err != nil {
+		return err
+	}
+	if err := os.Remove(r.logFile(status.ID)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove stale build log: %w", err)
+	}
+	r.builds[status.ID] = status.clone()
+	r.appendLog(status, messageEvents(status.Logs)...)
+	return nil
+}
+
+// Get returns a copy of the build with the given ID.
+func (r *BuildRegistry) Get(id string) (*BuildStatus, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	status, ok := r.builds[id]
+	if !ok {
+		return nil, false
+	}
+	return status.clone(), true
+}
+
+// List returns copies of all builds, newest first.
+func (r *BuildRegistry) List() []*BuildStatus {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	list := make([]*BuildStatus, 0, len(r.builds))
+	for _, status := range r.builds {
+		list = append(list, status.clone())
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.After(list[j].CreatedAt)
+	})
+	return list
+}
+
+// Update applies fn to the stored build under the registry lock and persists
+// the result. Messages fn adds to the logs are appended to the build log as
+// well. Persistence failures are logged rather than returned so that a full
+// disk does not abort a running build.
+func (r *BuildRegistry) Update(id string, fn func(status *BuildStatus)) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	status, ok := r.builds[id]
+	if !ok {
+		return false
+	}
+	n := len(status.Logs)
+	fn(status)
+	status.UpdatedAt = time.Now()
+	if err := r.write(status); err != nil {
+		logger.Warnf("Failed to persist build record %s: %v", status.ID, err)
+	}
+	var added []LogEvent
+	if len(status.Logs) > n {
+		added = messageEvents(status.Logs[n:])
+	}
+	r.appendLog(status, added...)
+	r.notify(id)
+	return true
+}
+
+// AppendOutput adds raw command output lines to the build log only, so
+// high-volume output never rewrites the record file.
+func (r *BuildRegistry) AppendOutput(id string, lines ...string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	status, ok := r.builds[id]
+	if !ok {
+		return false
+	}
+	events := make([]LogEvent, 0, len(lines))
+	for _, line := range lines {
+		events = append(events, LogEvent{Line: line, Output: true})
+	}
+	r.appendLog(status, events...)
+	status.UpdatedAt = time.Now()
+	r.notify(id)
+	return true
+}
+
+// BuildWatch is a snapshot of a build returned by Watch.
+type BuildWatch struct {
+	Status  *BuildStatus    // Status fields of the build, without Logs
+	Lines   []LogEvent      // Lines of the build log from the requested index on
+	Next    int             // Index of the line after Lines
+	Changed <-chan struct{} // Closed as soon as the build changes again
+}
+
+// Watch returns the build with the lines of its log from index from on, and a
+// channel to wait for the next change without polling. Only the new lines are
+// copied, and they are read from the log file after the registry lock is
+// released. An index past the end of the log resumes at its end.
+func (r *BuildRegistry) Watch(id string, from int) (*BuildWatch, bool) {
+	r.mu.Lock()
+	status, ok := r.builds[id]
+	if !ok {
+		r.mu.Unlock()
+		return nil, false
+	}
+	ch, ok := r.watchers[id]
+	if !ok {
+		ch = make(chan struct{})
+		r.watchers[id] = ch
+	}
+	l := r.log(id)
+	if from > len(l.offsets) {
+		from = len(l.offsets)
+	}
+	start, end := l.size, l.size
+	if from < len(l.offsets) {
+		start = l.offsets[from]
+	}
+	count := len(l.offsets) - from
+	w := &BuildWatch{Status: status.withoutLogs(), Next: from, Changed: ch}
+	r.mu.Unlock()
+
+	if count == 0 {
+		return w, true
+	}
+	lines, err := readLogEvents(r.logFile(id), start, end)
+	if err != nil {
+		logger.Warnf("Failed to read build log %s: %v", id, err)
+		return w, true
+	}
+	w.Lines = lines
+	w.Next = from + len(lines)
+	return w, true
+}
+
+// notify wakes up all watchers of a build. The caller must hold the lock.
+func (r *BuildRegistry) notify(id string) {
+	if ch, ok := r.watchers[id]; ok {
+		close(ch)
+		delete(r.watchers, id)
+	}
+}
+
+// write atomically replaces the record file of a build.
+func (r *BuildRegistry) write(status *BuildStatus) error {
+	data, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal build record: %w", err)
+	}
+	file := filepath.Join(r.dir, status.ID+recordFileExt)
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write build record: %w", err)
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		return fmt.Errorf("failed to replace build record: %w", err)
+	}
+	return nil
+}
+
+// buildLog indexes the append-only log file of a build.
+type buildLog struct {
+	file    *os.File // Open for appending until the build finishes
+	offsets []int64  // File offset of every line
+	size    int64    // File size after the last complete line
+}
+
+// logFile returns the path of the log file of a build.
+func (r *BuildRegistry) logFile(id string) string {
+	return filepath.Join(r.dir, id+logFileExt)
+}
+
+// log returns the index of the build log, reading an existing log file the
+// first time. The caller must hold the lock.
+func (r *BuildRegistry) log(id string) *buildLog {
+	if l, ok := r.logs[id]; ok {
+		return l
+	}
+	l := &buildLog{}
+	data, err := os.ReadFile(r.logFile(id))
+	if err != nil && !os.IsNotExist(err) {
+		logger.Warnf("Failed to read build log %s: %v", id, err)
+	}
+	for start := 0; start < len(data); {
+		n := bytes.IndexByte(data[start:], '\n')
+		if n < 0 {
+			break // A line cut off by a crash
+		}
+		l.offsets = append(l.offsets, int64(start))
+		start += n + 1
+		l.size = int64(start)
+	}
+	r.logs[id] = l
+	return l
+}
+
+// appendLog writes events to the log file of a build and closes the file once
+// the build finished. Write failures are logged like those of records. The
+// caller must hold the lock.
+func (r *BuildRegistry) appendLog(status *BuildStatus, events ...LogEvent) {
+	l := r.log(status.ID)
+	if len(events) > 0 {
+		if err := l.append(r.logFile(status.ID), events); err != nil {
+			logger.Warnf("Failed to append to build log %s: %v", status.ID, err)
+		}
+	}
+	if status.Finished() && l.file != nil {
+		if err := l.file.Close(); err != nil {
+			logger.Warnf("Failed to close build log %s: %v", status.ID, err)
+		}
+		l.file = nil
+	}
+}
+
+func (l *buildLog) append(file string, events []LogEvent) error {
+	if l.file == nil {
+		f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE, 0600)
+		if err != nil {
+			return err
+		}
+		l.file = f
+	}
+	var buf bytes.Buffer
+	offsets := make([]int64, 0, len(events))
+	for _, event := range events {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		offsets = append(offsets, l.size+int64(buf.Len()))
+		buf.Write(data)
+		buf.WriteByte('\n')
+	}
+	// Writing at the end of the last complete line drops a line cut off by a crash.
+	if _, err := l.file.WriteAt(buf.Bytes(), l.size); err != nil {
+		return err
+	}
+	l.offsets = append(l.offsets, offsets...)
+	l.size += int64(buf.Len())
+	return nil
+}
+
+// readLogEvents decodes the lines of a build log between the offsets start and end.
+func readLogEvents(file string, start, end int64) ([]LogEvent, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data := make([]byte, end-start)
+	if _, err := f.ReadAt(data, start); err != nil {
+		return nil, err
+	}
+	var events []LogEvent
+	for _, line := range bytes.SplitAfter(data, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		var event LogEvent
+		if err := json.Unmarshal(line, &event); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// messageEvents returns the log events of pipeline messages.
+func messageEvents(lines []string) []LogEvent {
+	events := make([]LogEvent, 0, len(lines))
+	for _, line := range lines {
+		events = append(events, LogEvent{Line: line})
+	}
+	return events
+}
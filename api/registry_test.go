@@ -0,0 +1,115 @@
+package api
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Test records are reloaded and interrupted builds are marked as failed.
+func TestBuildRegistry_Reload(t *testing.T) {
+	dir := t.TempDir()
+	registry, err := NewBuildRegistry(dir)
+	require.NoError(t, err)
+
+	done := &BuildStatus{ID: "build_1", Status: BuildStatusCompleted, Steps: map[string]string{}, Output: "/tmp/out.iso"}
+	running := &BuildStatus{ID: "build_2", Status: BuildStatusRunning, Steps: map[string]string{}}
+	require.NoError(t, registry.Add(done))
+	require.NoError(t, registry.Add(running))
+
+	reloaded, err := NewBuildRegistry(dir)
+	require.NoError(t, err)
+
+	status, ok := reloaded.Get("build_1")
+	require.True(t, ok)
+	assert.Equal(t, BuildStatusCompleted, status.Status)
+	assert.Equal(t, "/tmp/out.iso", status.Output)
+
+	status, ok = reloaded.Get("build_2")
+	require.True(t, ok)
+	assert.Equal(t, BuildStatusFailed, status.Status)
+	assert.NotNil(t, status.FinishedAt)
+	assert.Len(t, reloaded.List(), 2)
+}
+
+// Test concurrent updates and reads only ever touch copies of the records.
+func TestBuildRegistry_ConcurrentUpdate(t *testing.T) {
+	registry, err := NewBuildRegistry(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, registry.Add(&BuildStatus{ID: "build_1", Status: BuildStatusRunning, Steps: map[string]string{}}))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			registry.Update("build_1", func(status *BuildStatus) {
+				status.Progress = i
+				status.Logs = append(status.Logs, "step")
+			})
+		}(i)
+		go func() {
+			defer wg.Done()
+			status, ok := registry.Get("build_1")
+			require.True(t, ok)
+			status.Logs = append(status.Logs, "local only")
+		}()
+	}
+	wg.Wait()
+
+	status, _ := registry.Get("build_1")
+	assert.Len(t, status.Logs, 20)
+}
+
+// Test command output only goes to the append-only build log: the record keeps
+// the pipeline messages, and Watch returns the log lines after an index,
+// across a restart.
+func TestBuildRegistry_BuildLog(t *testing.T) {
+	dir := t.TempDir()
+	registry, err := NewBuildRegistry(dir)
+	require.NoError(t, err)
+	require.NoError(t, registry.Add(&BuildStatus{ID: "build_1", Status: BuildStatusRunning, Steps: map[string]string{}, Logs: []string{"queued"}}))
+	registry.AppendOutput("build_1", "apt-get output", "xorriso output")
+	registry.Update("build_1", func(status *BuildStatus) {
+		status.Progress = 50
+		status.Logs = append(status.Logs, "step")
+	})
+
+	record, err := os.ReadFile(filepath.Join(dir, "build_1"+recordFileExt))
+	require.NoError(t, err)
+	assert.NotContains(t, string(record), "apt-get output")
+	status, _ := registry.Get("build_1")
+	assert.Equal(t, []string{"queued", "step"}, status.Logs)
+
+	watch, ok := registry.Watch("build_1", 1)
+	require.True(t, ok)
+	assert.Equal(t, []LogEvent{
+		{Line: "apt-get output", Output: true},
+		{Line: "xorriso output", Output: true},
+		{Line: "step"},
+	}, watch.Lines)
+	assert.Equal(t, 4, watch.Next)
+	assert.Nil(t, watch.Status.Logs)
+	assert.Equal(t, 50, watch.Status.Progress)
+
+	// A line cut off by a crash is dropped, and later lines are written over it.
+	f, err := os.OpenFile(filepath.Join(dir, "build_1"+logFileExt), os.O_WRONLY|os.O_APPEND, 0600)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"line":"cut`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	reloaded, err := NewBuildRegistry(dir)
+	require.NoError(t, err)
+	watch, ok = reloaded.Watch("build_1", 4)
+	require.True(t, ok)
+	assert.Equal(t, []LogEvent{{Line: "ERROR: build interrupted by server restart"}}, watch.Lines)
+	assert.Equal(t, 5, watch.Next)
+	watch, _ = reloaded.Watch("build_1", 10)
+	assert.Empty(t, watch.Lines)
+	assert.Equal(t, 5, watch.Next, "an index past the end resumes at the end")
+}
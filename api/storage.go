@@ -0,0 +1,115 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/lefeck/ubuntu-autoinstaller/config"
+)
+
+// SimulateStorageRequest lays out a storage config on disks of hypothetical sizes.
+type SimulateStorageRequest struct {
+	Storage   config.Storage    `json:"storage"`   // Storage section of the autoinstall config, with config or layout
+	DiskSize  string            `json:"diskSize"`  // Size of every disk, e.g. "240GB" or "4T"
+	DiskSizes map[string]string `json:"diskSizes"` // Sizes of single disks by storage ID, overriding diskSize
+}
+
+// SimulateStorage Simulate a storage layout
+// @Summary Simulate a storage layout
+// @Description Compute the partition tables, volume group space, filesystem sizes and mount table a storage config produces on disks of the given sizes, and whether it fits
+// @Tags storage
+// @Accept json
+// @Produce json
+// @Param request body SimulateStorageRequest true "Storage config and disk sizes"
+// @Success 200 {object} map[string]interface{} "Layout simulated, see layout.fits"
+// @Failure 400 {object} map[string]interface{} "Invalid request parameters or storage config"
+// @Router /storage/simulate [post]
+func (h *Handler) SimulateStorage(c *gin.Context) {
+	var request SimulateStorageRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request parameters: " + err.Error(),
+		})
+		return
+	}
+	if err := request.Storage.ExpandLayout(); err != nil {
+		if validationFailed(c, "Invalid storage layout", err) {
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Failed to expand storage layout: " + err.Error(),
+		})
+		return
+	}
+	if request.Storage.Layout != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "storage layout " + request.Storage.Layout.Name + " is partitioned by the installer and cannot be simulated",
+		})
+		return
+	}
+	if len(request.Storage.Config) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "storage.config must not be empty",
+		})
+		return
+	}
+
+	var defaultSize int64
+	if request.DiskSize != "" {
+		size, err := config.ParseSize(request.DiskSize)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Invalid diskSize: " + err.Error(),
+			})
+			return
+		}
+		defaultSize = size
+	}
+	sizes := make(map[string]int64, len(request.DiskSizes))
+	for id, value := range request.DiskSizes {
+		size, err := config.ParseSize(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Invalid size of disk " + id + ": " + err.Error(),
+			})
+			return
+		}
+		sizes[id] = size
+	}
+
+	layout, err := config.SimulateLayout(request.Storage.Config, sizes, defaultSize)
+	if err != nil {
+		if validationFailed(c, "Invalid storage config", err) {
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Failed to simulate layout: " + err.Error(),
+		})
+		return
+	}
+
+	message := "Layout fits on the disks"
+	if !layout.Fits {
+		message = "Layout does not fit on the disks"
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"layout":  layout,
+		"message": message,
+	})
+}
+
+// GetStoragePresets List storage layout presets
+// @Summary List storage layout presets
+// @Description Get the named storage layouts a storage layout shorthand can refer to, with their default sizes and the storage actions they expand to
+// @Tags storage
+// @Produce json
+// @Success 200 {object} map[string]interface{} "Storage presets"
+// @Router /storage/presets [get]
+func (h *Handler) GetStoragePresets(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"presets": config.StoragePresets(),
+	})
+}
@@ -0,0 +1,261 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/lefeck/ubuntu-autoinstaller/cmd"
+	"github.com/lefeck/ubuntu-autoinstaller/config"
+	"github.com/lefeck/ubuntu-autoinstaller/generator"
+	"github.com/lefeck/ubuntu-autoinstaller/logger"
+)
+
+// buildFlags holds the parsed flags of the build subcommand.
+type buildFlags struct {
+	configFile   string
+	userDataFile string
+	source       string
+	codename     string
+	arch         string
+	out          string
+	packages     string
+	hwe          bool
+	md5          bool
+	gpgVerify    bool
+	workDir      string
+	keepWorkDir  bool
+	verbose      bool
+	mode         string
+
+	keys []config.GeneratedKey // dm_crypt keys generated with the user-data
+}
+
+// runBuild implements `ubuntu-autoinstaller build`.
+func runBuild(args []string) error {
+	var f buildFlags
+	fs := flag.NewFlagSet("build", flag.ContinueOnError)
+	fs.StringVar(&f.configFile, "config", "", "Autoinstall config YAML file (converted to user-data)")
+	fs.StringVar(&f.userDataFile, "user-data", "", "Ready-made user-data file (alternative to --config)")
+	fs.StringVar(&f.source, "source", "", "Local source ISO file")
+	fs.StringVar(&f.codename, "codename", "", "Download the source ISO for this release instead (focal, jammy, noble)")
+	fs.StringVar(&f.arch, "arch", generator.ArchAMD64, "Architecture of the downloaded ISO (amd64, arm64); local ISOs use the one in their file name")
+	fs.StringVar(&f.out, "out", "", "Output ISO file")
+	fs.StringVar(&f.packages, "packages", "", "Comma-separated list of extra packages to embed")
+	fs.BoolVar(&f.hwe, "hwe", false, "Use the HWE kernel if the source ISO provides it")
+	fs.BoolVar(&f.md5, "md5", true, "Update md5sum.txt for modified files (otherwise it is cleared)")
+	fs.BoolVar(&f.gpgVerify, "gpg-verify", false, "Verify the downloaded ISO against the signed SHA256SUMS")
+	fs.StringVar(&f.workDir, "workdir", "", "Working directory (default: a new temporary directory); of a given one only the build files are removed")
+	fs.BoolVar(&f.keepWorkDir, "keep-workdir", false, "Do not remove the working directory after the build")
+	fs.StringVar(&f.mode, "mode", generator.BuildModeFull, "Build mode: full (extract the whole ISO) or overlay (write only modified files over the source ISO)")
+	fs.BoolVar(&f.verbose, "verbose", false, "Print the output of xorriso, apt-get and other commands as they run")
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return err
+	}
+
+	opts, err := f.buildOptions()
+	if err != nil {
+		return err
+	}
+
+	workDir, temporary := f.workDir, f.workDir == ""
+	if temporary {
+		workDir, err = os.MkdirTemp("", "tmp.")
+		if err != nil {
+			return fmt.Errorf("failed to create temporary directory: %w", err)
+		}
+	}
+	gen, err := generator.NewGenerator(&cmd.Executor{}, workDir)
+	if err != nil {
+		return err
+	}
+	if !f.keepWorkDir {
+		defer cleanupWorkDir(gen, temporary)
+	}
+
+	// Ctrl-C or SIGTERM cancels the build and kills the commands it started.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := gen.Build(ctx, opts, &terminalReporter{out: os.Stdout, verbose: f.verbose}); err != nil {
+		if ctx.Err() != nil {
+			if err := os.Remove(opts.DestinationISO); err != nil && !errors.Is(err, os.ErrNotExist) {
+				logger.Warnf("Failed to remove partial ISO %s: %v", opts.DestinationISO, err)
+			}
+			return fmt.Errorf("build cancelled")
+		}
+		return err
+	}
+	fmt.Fprintf(os.Stdout, "ISO written to %s\n", opts.DestinationISO)
+	return writeKeys(os.Stdout, opts.DestinationISO, f.keys)
+}
+
+// cleanupWorkDir removes the files of a build. Only a temporary directory the
+// CLI created is removed as a whole; a --workdir given by the user may hold
+// other files, the download cache among them, so only its build tree goes.
+func cleanupWorkDir(gen *generator.Generator, temporary bool) {
+	if !temporary {
+		if err := gen.CleanUp(); err != nil {
+			logger.Warnf("Failed to remove the build files in %s: %v", gen.Path.WorkDir, err)
+		}
+		return
+	}
+	if err := gen.Cleanup(); err != nil {
+		logger.Warnf("Failed to remove working directory %s: %v", gen.Path.RootDir, err)
+	}
+}
+
+// writeKeys stores the generated dm_crypt keys next to the ISO, readable only
+// by the owner, and tells where to upload them.
+func writeKeys(out io.Writer, iso string, keys []config.GeneratedKey) error {
+	for _, key := range keys {
+		path := strings.TrimSuffix(iso, ".iso") + "." + key.ID + ".key"
+		if err := os.WriteFile(path, []byte(key.Key), 0o600); err != nil {
+			return fmt.Errorf("failed to write the key of dm_crypt %s: %w", key.ID, err)
+		}
+		fmt.Fprintf(out, "Key of dm_crypt %s written to %s, upload it to %s before installing\n", key.ID, path, key.URL)
+	}
+	return nil
+}
+
+// buildOptions validates the flags and converts them into generator build options.
+func (f *buildFlags) buildOptions() (*generator.BuildOptions, error) {
+	if f.out == "" {
+		return nil, fmt.Errorf("--out is required")
+	}
+	if filepath.Ext(f.out) != ".iso" {
+		return nil, fmt.Errorf("--out must end with .iso extension")
+	}
+	out, err := filepath.Abs(f.out)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve output path: %w", err)
+	}
+
+	opts := &generator.BuildOptions{
+		DestinationISO: out,
+		UseHWEKernel:   f.hwe,
+		MD5Checksum:    f.md5,
+		GPGVerify:      f.gpgVerify,
+		Mode:           f.mode,
+	}
+	if f.mode != generator.BuildModeFull && f.mode != generator.BuildModeOverlay {
+		return nil, fmt.Errorf("--mode must be full or overlay")
+	}
+
+	switch {
+	case f.source != "" && f.codename != "":
+		return nil, fmt.Errorf("--source and --codename are mutually exclusive")
+	case f.source != "":
+		source, err := filepath.Abs(f.source)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve source path: %w", err)
+		}
+		if _, err := os.Stat(source); err != nil {
+			return nil, fmt.Errorf("source ISO not found: %w", err)
+		}
+		opts.SourceType = generator.SourceTypeLocal
+		opts.SourceISO = source
+	case f.codename != "":
+		if err := generator.ValidateArch(f.arch); err != nil {
+			return nil, err
+		}
+		opts.SourceType = generator.SourceTypeDownload
+		opts.CodeName = f.codename
+		opts.Arch = f.arch
+	default:
+		return nil, fmt.Errorf("either --source or --codename is required")
+	}
+
+	userData, err := f.loadUserData()
+	if err != nil {
+		return nil, err
+	}
+	opts.UserData = string(userData)
+
+	for _, pkg := range strings.Split(f.packages, ",") {
+		if pkg = strings.TrimSpace(pkg); pkg != "" {
+			opts.PackageList = append(opts.PackageList, pkg)
+		}
+	}
+	return opts, nil
+}
+
+// loadUserData reads the user-data either from a config file or a ready-made user-data file.
+func (f *buildFlags) loadUserData() ([]byte, error) {
+	userDataGen := generator.NewUserDataGenerator()
+	switch {
+	case f.configFile != "" && f.userDataFile != "":
+		return nil, fmt.Errorf("--config and --user-data are mutually exclusive")
+	case f.configFile != "":
+		data, err := os.ReadFile(f.configFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file: %w", err)
+		}
+		// Keys, comments and ordering the config does not model are kept
+		doc, err := config.LoadDocument(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse YAML config: %w", err)
+		}
+		userData, keys, err := userDataGen.GenerateFromDocument(doc, f.schemaRelease())
+		if err != nil {
+			return nil, err
+		}
+		f.keys = keys
+		return userData, nil
+	case f.userDataFile != "":
+		userData, err := os.ReadFile(f.userDataFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read user-data file: %w", err)
+		}
+		if err := userDataGen.ValidateUserData(userData); err != nil {
+			return nil, fmt.Errorf("user-data validation failed: %w", err)
+		}
+		return userData, nil
+	default:
+		return nil, fmt.Errorf("either --config or --user-data is required")
+	}
+}
+
+// schemaRelease returns the release whose autoinstall schema the generated
+// user-data must match: the downloaded release when it has one, else the default.
+func (f *buildFlags) schemaRelease() string {
+	for _, release := range config.SchemaReleases {
+		if release == f.codename {
+			return release
+		}
+	}
+	return ""
+}
+
+// terminalReporter prints build progress to a terminal.
+type terminalReporter struct {
+	out     io.Writer
+	verbose bool
+}
+
+// StepStarted prints the message of a starting step.
+func (r *terminalReporter) StepStarted(step, message string) {
+	fmt.Fprintf(r.out, "[ .. ] %s\n", message)
+}
+
+// StepCompleted prints the message of a finished step with the overall progress.
+func (r *terminalReporter) StepCompleted(step string, progress int, message string) {
+	fmt.Fprintf(r.out, "[%3d%%] %s\n", progress, message)
+}
+
+// CommandOutput prints an output line of a running command in verbose mode.
+func (r *terminalReporter) CommandOutput(line string) {
+	if r.verbose {
+		fmt.Fprintf(r.out, "       %s\n", line)
+	}
+}
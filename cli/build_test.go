@@ -0,0 +1,32 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lefeck/ubuntu-autoinstaller/cmd"
+	"github.com/lefeck/ubuntu-autoinstaller/generator"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Test a --workdir given by the user keeps everything but the build tree,
+// while a temporary working directory is removed as a whole.
+func TestCleanupWorkDir(t *testing.T) {
+	workDir := t.TempDir()
+	gen, err := generator.NewGenerator(&cmd.Executor{}, workDir)
+	require.NoError(t, err)
+	cached := gen.Path.DownloadFile("ubuntu.iso")
+	require.NoError(t, os.WriteFile(cached, nil, 0o644))
+	other := filepath.Join(workDir, "notes.txt")
+	require.NoError(t, os.WriteFile(other, nil, 0o644))
+
+	cleanupWorkDir(gen, false)
+	assert.FileExists(t, cached)
+	assert.FileExists(t, other)
+	assert.NoDirExists(t, gen.Path.BuildDir())
+
+	cleanupWorkDir(gen, true)
+	assert.NoDirExists(t, workDir)
+}
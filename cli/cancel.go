@@ -0,0 +1,61 @@
+package cli
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// defaultServer is the address of a locally started web server.
+const defaultServer = "http://localhost:8080"
+
+// runCancel implements `ubuntu-autoinstaller cancel <build-id>`.
+func runCancel(args []string) error {
+	fs := flag.NewFlagSet("cancel", flag.ContinueOnError)
+	server := fs.String("server", defaultServer, "Base URL of the ubuntu-autoinstaller web server")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: ubuntu-autoinstaller cancel [--server URL] <build-id>")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("exactly one build ID is required")
+	}
+	buildID := fs.Arg(0)
+
+	endpoint := strings.TrimSuffix(*server, "/") + "/api/v1/build/" + url.PathEscape(buildID)
+	req, err := http.NewRequest(http.MethodDelete, endpoint, nil)
+	if err != nil {
+		return fmt.Errorf("invalid server URL: %w", err)
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach server: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Message string `json:"message"`
+		Error   string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return fmt.Errorf("unexpected response (%s): %w", resp.Status, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s (%s)", body.Error, resp.Status)
+	}
+	fmt.Fprintf(os.Stdout, "%s: %s\n", buildID, body.Message)
+	return nil
+}
@@ -0,0 +1,64 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+)
+
+// command is a headless subcommand of the ubuntu-autoinstaller binary.
+type command struct {
+	name        string
+	description string
+	run         func(args []string) error
+}
+
+var commands = []command{
+	{name: "build", description: "Build a customized autoinstall ISO without starting the web server", run: runBuild},
+	{name: "follow", description: "Follow the live log of a build on a web server", run: runFollow},
+	{name: "cancel", description: "Cancel a queued or running build on a web server", run: runCancel},
+	{name: "simulate", description: "Lay out the storage config on disks of a given size and check it fits", run: runSimulate},
+}
+
+// IsCommand reports whether name is a known subcommand.
+func IsCommand(name string) bool {
+	for _, c := range commands {
+		if c.name == name {
+			return true
+		}
+	}
+	return name == "help"
+}
+
+// Run executes the subcommand named by args[0] and returns the process exit code.
+func Run(args []string) int {
+	if len(args) == 0 || args[0] == "help" {
+		usage()
+		return 0
+	}
+	for _, c := range commands {
+		if c.name != args[0] {
+			continue
+		}
+		if err := c.run(args[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+	fmt.Fprintf(os.Stderr, "Unknown command: %s\n", args[0])
+	usage()
+	return 2
+}
+
+// usage prints the list of available subcommands.
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: ubuntu-autoinstaller [flags]            start the web server")
+	fmt.Fprintln(os.Stderr, "       ubuntu-autoinstaller <command> [flags]  run a headless command")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Commands:")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", c.name, c.description)
+	}
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Run 'ubuntu-autoinstaller <command> -h' for command flags.")
+}
@@ -0,0 +1,191 @@
+package cli
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// followRetryDelay is the pause before reconnecting a dropped event stream.
+	followRetryDelay = 2 * time.Second
+	// followMaxRetries is the number of consecutive failed reconnects before giving up.
+	followMaxRetries = 10
+)
+
+// permanentError marks follow errors that reconnecting cannot fix.
+type permanentError struct {
+	error
+}
+
+// buildEvent is one Server-Sent Event received from the server.
+type buildEvent struct {
+	id    string
+	event string
+	data  string
+}
+
+// runFollow implements `ubuntu-autoinstaller follow <build-id>`.
+func runFollow(args []string) error {
+	fs := flag.NewFlagSet("follow", flag.ContinueOnError)
+	server := fs.String("server", defaultServer, "Base URL of the ubuntu-autoinstaller web server")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: ubuntu-autoinstaller follow [--server URL] <build-id>")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("exactly one build ID is required")
+	}
+	buildID := fs.Arg(0)
+	endpoint := strings.TrimSuffix(*server, "/") + "/api/v1/build/events/" + url.PathEscape(buildID)
+
+	f := &follower{endpoint: endpoint}
+	for retries := 0; ; retries++ {
+		received, err := f.stream()
+		if f.final != nil {
+			return f.result(buildID)
+		}
+		var permanent permanentError
+		if errors.As(err, &permanent) {
+			return permanent.error
+		}
+		if received {
+			retries = 0
+		}
+		if retries >= followMaxRetries {
+			return fmt.Errorf("giving up after %d reconnects: %w", retries, err)
+		}
+		fmt.Fprintf(os.Stderr, "Event stream interrupted (%v), reconnecting...\n", err)
+		time.Sleep(followRetryDelay)
+	}
+}
+
+// follower reads the event stream of one build and resumes it after disconnects.
+type follower struct {
+	endpoint    string
+	lastEventID string
+	final       *followStatus
+}
+
+// followStatus is the payload of a status event.
+type followStatus struct {
+	Status   string `json:"status"`
+	Progress int    `json:"progress"`
+	Error    string `json:"error"`
+}
+
+// stream connects once and prints events until the stream ends. It reports
+// whether any event was received, so reconnect attempts can be counted.
+func (f *follower) stream() (bool, error) {
+	req, err := http.NewRequest(http.MethodGet, f.endpoint, nil)
+	if err != nil {
+		return false, permanentError{fmt.Errorf("invalid server URL: %w", err)}
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	if f.lastEventID != "" {
+		req.Header.Set("Last-Event-ID", f.lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+	case resp.StatusCode >= 400 && resp.StatusCode < 500:
+		var body struct {
+			Error string `json:"error"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&body)
+		return false, permanentError{fmt.Errorf("%s (%s)", body.Error, resp.Status)}
+	default:
+		return false, fmt.Errorf("unexpected response %s", resp.Status)
+	}
+
+	received := false
+	var ev buildEvent
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if ev.event != "" || ev.data != "" {
+				received = true
+				f.handle(ev)
+			}
+			ev = buildEvent{}
+		case strings.HasPrefix(line, ":"):
+			// comment, e.g. heartbeat
+		case strings.HasPrefix(line, "id:"):
+			ev.id = strings.TrimSpace(strings.TrimPrefix(line, "id:"))
+		case strings.HasPrefix(line, "event:"):
+			ev.event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			ev.data += strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " ")
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return received, err
+	}
+	return received, fmt.Errorf("stream closed by server")
+}
+
+// handle prints one event and records the final build status.
+func (f *follower) handle(ev buildEvent) {
+	if ev.id != "" {
+		f.lastEventID = ev.id
+	}
+	switch ev.event {
+	case "log":
+		var payload struct {
+			Line   string `json:"line"`
+			Output bool   `json:"output"`
+		}
+		if err := json.Unmarshal([]byte(ev.data), &payload); err != nil {
+			return
+		}
+		if payload.Output {
+			fmt.Fprintf(os.Stdout, "       %s\n", payload.Line)
+		} else {
+			fmt.Fprintln(os.Stdout, payload.Line)
+		}
+	case "status":
+		var status followStatus
+		if err := json.Unmarshal([]byte(ev.data), &status); err != nil {
+			return
+		}
+		switch status.Status {
+		case "completed", "failed", "cancelled":
+			f.final = &status
+		}
+	}
+}
+
+// result converts the final build status into the command result.
+func (f *follower) result(buildID string) error {
+	switch f.final.Status {
+	case "completed":
+		fmt.Fprintf(os.Stdout, "Build %s completed\n", buildID)
+		return nil
+	case "cancelled":
+		return fmt.Errorf("build %s was cancelled", buildID)
+	default:
+		return fmt.Errorf("build %s failed: %s", buildID, f.final.Error)
+	}
+}
@@ -0,0 +1,147 @@
+package cli
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/lefeck/ubuntu-autoinstaller/config"
+)
+
+// runSimulate implements `ubuntu-autoinstaller simulate`.
+func runSimulate(args []string) error {
+	fs := flag.NewFlagSet("simulate", flag.ContinueOnError)
+	configFile := fs.String("config", "", "Autoinstall config YAML file (default: the built-in default config)")
+	diskSize := fs.String("disk-size", "", "Disk size, e.g. 240GB or 4T; use disk0=240GB,disk1=4T for disks of different sizes")
+	asJSON := fs.Bool("json", false, "Print the simulated layout as JSON")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: ubuntu-autoinstaller simulate --disk-size SIZE [--config FILE] [--json]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return err
+	}
+	if *diskSize == "" {
+		fs.Usage()
+		return fmt.Errorf("--disk-size is required")
+	}
+	defaultSize, sizes, err := parseDiskSizes(*diskSize)
+	if err != nil {
+		return err
+	}
+
+	cfg := config.NewDefaultConfig()
+	if *configFile != "" {
+		if cfg, err = config.LoadConfig(*configFile); err != nil {
+			return err
+		}
+	}
+
+	if err := cfg.Autoinstall.Storage.ExpandLayout(); err != nil {
+		return fmt.Errorf("invalid storage layout: %w", err)
+	}
+	if layout := cfg.Autoinstall.Storage.Layout; layout != nil {
+		return fmt.Errorf("storage layout %s is partitioned by the installer and cannot be simulated", layout.Name)
+	}
+	layout, err := config.SimulateLayout(cfg.Autoinstall.Storage.Config, sizes, defaultSize)
+	if err != nil {
+		return fmt.Errorf("invalid storage config: %w", err)
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(layout); err != nil {
+			return err
+		}
+	} else {
+		printLayout(os.Stdout, layout)
+	}
+	if !layout.Fits {
+		return fmt.Errorf("layout does not fit on the disks")
+	}
+	return nil
+}
+
+// parseDiskSizes parses "SIZE" or a comma-separated list of "ID=SIZE" entries.
+func parseDiskSizes(value string) (int64, map[string]int64, error) {
+	var defaultSize int64
+	sizes := make(map[string]int64)
+	for _, entry := range strings.Split(value, ",") {
+		id, size, found := strings.Cut(strings.TrimSpace(entry), "=")
+		if !found {
+			size, id = id, ""
+		}
+		bytes, err := config.ParseSize(size)
+		if err != nil {
+			return 0, nil, fmt.Errorf("invalid --disk-size: %w", err)
+		}
+		if id == "" {
+			defaultSize = bytes
+		} else {
+			sizes[id] = bytes
+		}
+	}
+	return defaultSize, sizes, nil
+}
+
+// printLayout prints the partition tables, volume groups and mount table of a simulated layout.
+func printLayout(out io.Writer, layout *config.LayoutSimulation) {
+	size := config.FormatSize
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	for _, d := range layout.Disks {
+		switch {
+		case d.RAIDLevel != "":
+			fmt.Fprintf(w, "RAID %s (%s, %s)\n", d.ID, d.RAIDLevel, size(d.Size))
+		case d.Ptable == "":
+			fmt.Fprintf(w, "Disk %s (%s)\n", d.ID, size(d.Size))
+		default:
+			fmt.Fprintf(w, "Disk %s (%s, %s)\n", d.ID, d.Ptable, size(d.Size))
+		}
+		if d.Usage != "" {
+			fmt.Fprintf(w, "  whole disk\t%s\n", d.Usage)
+			continue
+		}
+		fmt.Fprintln(w, "  #\tID\tSTART\tSIZE\tUSAGE")
+		for _, p := range d.Partitions {
+			usage := p.Usage
+			if !p.Aligned {
+				usage += " (not aligned to 1M)"
+			}
+			fmt.Fprintf(w, "  %d\t%s\t%s\t%s\t%s\n", p.Number, p.ID, size(p.Start), size(p.Size), usage)
+		}
+		fmt.Fprintf(w, "  \tfree\t\t%s\t\n", size(d.Free))
+	}
+	for _, vg := range layout.VolumeGroups {
+		fmt.Fprintf(w, "Volume group %s (%s, %s used, %s free)\n", vg.Name, size(vg.Size), size(vg.Used), size(vg.Free))
+		fmt.Fprintln(w, "  NAME\tID\tSIZE\tUSAGE")
+		for _, lv := range vg.Volumes {
+			fmt.Fprintf(w, "  %s\t%s\t%s\t%s\n", lv.Name, lv.ID, size(lv.Size), lv.Usage)
+		}
+	}
+	fmt.Fprintln(w, "Mounts")
+	fmt.Fprintln(w, "  PATH\tFSTYPE\tVOLUME\tSIZE\tENCRYPTED")
+	for _, m := range layout.Mounts {
+		path := m.Path
+		if path == "" {
+			path = "[SWAP]"
+		}
+		fmt.Fprintf(w, "  %s\t%s\t%s\t%s\t%t\n", path, m.Fstype, m.Volume, size(m.Size), m.Encrypted)
+	}
+	w.Flush()
+
+	if layout.Fits {
+		fmt.Fprintln(out, "The layout fits.")
+		return
+	}
+	fmt.Fprintln(out, "The layout does not fit:")
+	for _, p := range layout.Problems {
+		fmt.Fprintf(out, "  - %s\n", p)
+	}
+}
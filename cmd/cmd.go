@@ -2,7 +2,11 @@ package cmd
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"sync"
 
 	"github.com/lefeck/ubuntu-autoinstaller/logger"
 
@@ -15,7 +19,10 @@ import (
 
 // CmdOptions holds optional command execution parameters.
 type CmdOptions struct {
-	Name string
+	Name     string
+	Timeout  time.Duration
+	OnStdout func(line string)
+	OnStderr func(line string)
 }
 
 // Options applies optional settings to CmdOptions.
@@ -38,20 +45,58 @@ func (c CmdName) Apply(opt *CmdOptions) {
 	opt.Name = string(c)
 }
 
+// CmdTimeout sets a deadline for a single command run. Zero means no deadline.
+type CmdTimeout time.Duration
+
+// Apply sets the Timeout on CmdOptions.
+func (t CmdTimeout) Apply(opt *CmdOptions) {
+	opt.Timeout = time.Duration(t)
+}
+
+// StdoutFunc is called for every line the command writes to stdout, while it runs.
+type StdoutFunc func(line string)
+
+// Apply sets the OnStdout callback on CmdOptions.
+func (f StdoutFunc) Apply(opt *CmdOptions) {
+	opt.OnStdout = f
+}
+
+// StderrFunc is called for every line the command writes to stderr, while it runs.
+type StderrFunc func(line string)
+
+// Apply sets the OnStderr callback on CmdOptions.
+func (f StderrFunc) Apply(opt *CmdOptions) {
+	opt.OnStderr = f
+}
+
 // CmdExecutor defines a generic command executor interface.
 type CmdExecutor interface {
 	RunCmd(cmd interface{}, opts ...Options) (string, string, error)
 	RunCmdWithAttempts(cmd interface{}, attempts int, timeout time.Duration, opts ...Options) (string, string, error)
+	RunCmdContext(ctx context.Context, cmd interface{}, opts ...Options) (string, string, error)
+	RunCmdWithAttemptsContext(ctx context.Context, cmd interface{}, attempts int, timeout time.Duration, opts ...Options) (string, string, error)
 }
 
+// killWaitDelay bounds how long a cancelled command may keep its output pipes open.
+const killWaitDelay = 5 * time.Second
+
+// attemptRetryDelay is the pause between two attempts of RunCmdWithAttempts.
+const attemptRetryDelay = 2 * time.Second
+
 // Executor is a default implementation of CmdExecutor.
 type Executor struct {
 }
 
-// RunCmdWithAttempts runs command with attempts and timeout.
+// RunCmdWithAttempts runs command up to attempts times, each attempt limited to timeout.
 func (e *Executor) RunCmdWithAttempts(cmd interface{}, attempts int, timeout time.Duration, opts ...Options) (string, string, error) {
-	options := &CmdOptions{}
-	options.ApplyOptions(opts)
+	return e.RunCmdWithAttemptsContext(context.Background(), cmd, attempts, timeout, opts...)
+}
+
+// RunCmdWithAttemptsContext runs command up to attempts times until it succeeds.
+// Every attempt is killed after timeout (zero means no deadline); cancelling
+// ctx stops the current attempt and all further retries.
+func (e *Executor) RunCmdWithAttemptsContext(ctx context.Context, cmd interface{}, attempts int, timeout time.Duration, opts ...Options) (string, string, error) {
+	attemptOpts := append(append([]Options(nil), opts...), CmdTimeout(timeout))
 
 	ll := logger.Logger
 	var (
@@ -59,33 +104,71 @@ func (e *Executor) RunCmdWithAttempts(cmd interface{}, attempts int, timeout tim
 		stderr string
 		err    error
 	)
-	for i := 0; i < attempts; i++ {
-		if stdout, stderr, err := e.RunCmd(cmd); err == nil {
+	for i := 1; i <= attempts; i++ {
+		stdout, stderr, err = e.RunCmdContext(ctx, attemptCmd(cmd, i), attemptOpts...)
+		if err == nil {
+			return stdout, stderr, nil
+		}
+		if ctx.Err() != nil {
 			return stdout, stderr, err
 		}
 		ll.Warnf("Unable to execute cmd: %v. Attempt %d out of %d.", err, i, attempts)
-		<-time.After(timeout)
+		if i == attempts {
+			break
+		}
+		select {
+		case <-time.After(attemptRetryDelay):
+		case <-ctx.Done():
+			return stdout, stderr, fmt.Errorf("command cancelled: %w", ctx.Err())
+		}
 	}
-	errMsg := fmt.Errorf("failed to execute command after %d attempt, error: %v", attempts, err)
-	return stdout, stderr, errMsg
+	return stdout, stderr, fmt.Errorf("failed to execute command after %d attempts: %w", attempts, err)
+}
+
+// attemptCmd returns the command to run for the given attempt. An exec.Cmd can
+// only be started once, so retries run a fresh copy of it.
+func attemptCmd(cmd interface{}, attempt int) interface{} {
+	c, ok := cmd.(*exec.Cmd)
+	if !ok || attempt == 1 {
+		return cmd
+	}
+	retry := exec.Command(c.Path, c.Args[1:]...)
+	retry.Dir = c.Dir
+	retry.Env = c.Env
+	retry.Stdin = c.Stdin
+	return retry
 }
 
 // RunCmd runs a command once.
 func (e *Executor) RunCmd(cmd interface{}, opts ...Options) (string, string, error) {
+	return e.RunCmdContext(context.Background(), cmd, opts...)
+}
+
+// RunCmdContext runs a command once. When ctx is done or the CmdTimeout option
+// expires, the command and every process it started are killed. Output is
+// passed line by line to the StdoutFunc and StderrFunc options as it is
+// produced, and returned in full once the command exits.
+func (e *Executor) RunCmdContext(ctx context.Context, cmd interface{}, opts ...Options) (string, string, error) {
 	options := &CmdOptions{}
 	options.ApplyOptions(opts)
 
+	if options.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, options.Timeout)
+		defer cancel()
+	}
+
 	if cmdstr, ok := cmd.(string); ok {
-		return e.runCmdFromStr(cmdstr)
+		return e.runCmdFromStr(ctx, cmdstr, options)
 	}
 	if cmdstr, ok := cmd.(*exec.Cmd); ok {
-		return e.runCmdFromCmdObj(cmdstr)
+		return e.runCmdFromCmdObj(ctx, cmdstr, options)
 	}
 	return "", "", fmt.Errorf("could not interpret command from %v", cmd)
 }
 
 // runCmdFromStr runs command from a string.
-func (e *Executor) runCmdFromStr(cmd string) (string, string, error) {
+func (e *Executor) runCmdFromStr(ctx context.Context, cmd string, options *CmdOptions) (string, string, error) {
 	fields := strings.Fields(cmd)
 	if len(fields) == 0 {
 		return "", "", fmt.Errorf("empty command string")
@@ -95,19 +178,32 @@ func (e *Executor) runCmdFromStr(cmd string) (string, string, error) {
 	args := fields[1:]
 	execCmd := exec.Command(name, args...)
 
-	return e.runCmdFromCmdObj(execCmd)
+	return e.runCmdFromCmdObj(ctx, execCmd, options)
 }
 
 // runCmdFromCmdObj runs command from an exec.Cmd object.
-func (e *Executor) runCmdFromCmdObj(cmd *exec.Cmd) (outStr string, errStr string, err error) {
+func (e *Executor) runCmdFromCmdObj(ctx context.Context, cmd *exec.Cmd, options *CmdOptions) (outStr string, errStr string, err error) {
 	var stdout bytes.Buffer
 	var stderr bytes.Buffer
 
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
 
+	// Both streams share one lock so callbacks never run concurrently.
+	var callbackMu sync.Mutex
+	if options.OnStdout != nil {
+		w := &lineWriter{mu: &callbackMu, fn: options.OnStdout}
+		defer w.Flush()
+		cmd.Stdout = io.MultiWriter(&stdout, w)
+	}
+	if options.OnStderr != nil {
+		w := &lineWriter{mu: &callbackMu, fn: options.OnStderr}
+		defer w.Flush()
+		cmd.Stderr = io.MultiWriter(&stderr, w)
+	}
+
 	cmdStartTime := time.Now()
-	err = cmd.Run()
+	err = runWithContext(ctx, cmd)
 	cmdDuration := time.Since(cmdStartTime)
 
 	outStr, errStr = stdout.String(), stderr.String()
@@ -153,3 +249,82 @@ func (e *Executor) runCmdFromCmdObj(cmd *exec.Cmd) (outStr string, errStr string
 	}
 	return outStr, errStr, err
 }
+
+// runWithContext starts cmd in its own process group and waits for it. If ctx
+// is done first, the whole process group is killed so that helpers spawned by
+// the command (e.g. dpkg under apt-get) don't outlive it.
+func runWithContext(ctx context.Context, cmd *exec.Cmd) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("command %s cancelled: %w", cmd.Path, err)
+	}
+	setProcessGroup(cmd)
+	cmd.WaitDelay = killWaitDelay
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			if err := killProcessGroup(cmd); err != nil {
+				logger.Warnf("Failed to kill command %s: %v", cmd.Path, err)
+			}
+		case <-done:
+		}
+	}()
+
+	err := cmd.Wait()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("command %s timed out: %w", cmd.Path, ctx.Err())
+	}
+	if ctx.Err() != nil {
+		return fmt.Errorf("command %s cancelled: %w", cmd.Path, ctx.Err())
+	}
+	return err
+}
+
+// maxLineLength is the longest line buffered by lineWriter; longer output
+// without a newline (e.g. progress bars) is passed on in chunks.
+const maxLineLength = 64 * 1024
+
+// lineWriter splits written output into lines and passes each one to fn.
+type lineWriter struct {
+	mu  *sync.Mutex
+	fn  func(line string)
+	buf []byte
+}
+
+// Write buffers p and emits every complete line.
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.emit(w.buf[:i])
+		w.buf = w.buf[i+1:]
+	}
+	if len(w.buf) >= maxLineLength {
+		w.emit(w.buf)
+		w.buf = nil
+	}
+	return len(p), nil
+}
+
+// Flush emits a trailing line that did not end with a newline.
+func (w *lineWriter) Flush() {
+	if len(w.buf) > 0 {
+		w.emit(w.buf)
+		w.buf = nil
+	}
+}
+
+// emit passes one line without its line ending to the callback.
+func (w *lineWriter) emit(line []byte) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.fn(strings.TrimRight(string(line), "\r"))
+}
@@ -1,6 +1,9 @@
 package cmd
 
 import (
+	"context"
+	osexec "os/exec"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -62,3 +65,65 @@ func TestRunCmd_EmptyCommand(t *testing.T) {
 	assert.Equal(t, "", stdout)
 	assert.Equal(t, "", stderr)
 }
+
+// Test RunCmdContext kills the command and its children when the context is cancelled.
+func TestRunCmdContext_Cancel(t *testing.T) {
+	exec := &Executor{}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, _, err := exec.RunCmdContext(ctx, osexec.Command("sh", "-c", "sleep 30 & sleep 30"))
+
+	assert.Error(t, err)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 10*time.Second)
+}
+
+// Test RunCmdContext streams stdout and stderr line by line.
+func TestRunCmdContext_LineCallbacks(t *testing.T) {
+	exec := &Executor{}
+	var outLines, errLines []string
+	stdout, _, err := exec.RunCmdContext(context.Background(),
+		osexec.Command("sh", "-c", "echo one; echo two >&2; printf three"),
+		StdoutFunc(func(line string) { outLines = append(outLines, line) }),
+		StderrFunc(func(line string) { errLines = append(errLines, line) }),
+	)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "one\nthree", stdout)
+	assert.Equal(t, []string{"one", "three"}, outLines)
+	assert.Equal(t, []string{"two"}, errLines)
+}
+
+// Test the CmdTimeout option kills a command that runs too long.
+func TestRunCmdContext_Timeout(t *testing.T) {
+	exec := &Executor{}
+	_, _, err := exec.RunCmdContext(context.Background(), "sleep 30", CmdTimeout(100*time.Millisecond))
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Contains(t, err.Error(), "timed out")
+}
+
+// Test RunCmdWithAttempts uses timeout as a per-attempt deadline and reports the last error.
+func TestRunCmdWithAttempts_AttemptTimeout(t *testing.T) {
+	exec := &Executor{}
+	start := time.Now()
+	_, _, err := exec.RunCmdWithAttempts("sleep 30", 2, 100*time.Millisecond)
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Contains(t, err.Error(), "after 2 attempts")
+	assert.Less(t, time.Since(start), 10*time.Second)
+}
+
+// Test RunCmdWithAttempts can retry an exec.Cmd, which may only be started once.
+func TestRunCmdWithAttempts_RetryCmdObject(t *testing.T) {
+	exec := &Executor{}
+	marker := filepath.Join(t.TempDir(), "marker")
+	// Fails on the first attempt and succeeds on the second.
+	script := "if [ -e " + marker + " ]; then echo ok; else touch " + marker + "; exit 1; fi"
+	stdout, _, err := exec.RunCmdWithAttempts(osexec.Command("sh", "-c", script), 2, time.Second)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "ok\n", stdout)
+}
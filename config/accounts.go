@@ -0,0 +1,188 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Accounts are the accounts of the installed system besides the identity
+// user. The installer creates the identity user only, so the accounts are
+// written into the users and groups of the user-data, which cloud-init
+// creates on the first boot.
+type Accounts struct {
+	Users    []User  `yaml:"users,omitempty" json:"users,omitempty"`
+	Groups   []Group `yaml:"groups,omitempty" json:"groups,omitempty"`
+	LockRoot bool    `yaml:"lock-root,omitempty" json:"lock-root,omitempty"` // Lock the root password and refuse root logins over SSH
+}
+
+// User is an account of the installed system.
+type User struct {
+	Name           string     `yaml:"name" json:"name"`
+	Realname       string     `yaml:"realname,omitempty" json:"realname,omitempty"`
+	UID            int        `yaml:"uid,omitempty" json:"uid,omitempty"` // Assigned by the system when 0
+	PrimaryGroup   string     `yaml:"primary-group,omitempty" json:"primary-group,omitempty"`
+	Groups         StringList `yaml:"groups,omitempty" json:"groups,omitempty" swaggertype:"array,string"`
+	Sudo           SudoRules  `yaml:"sudo,omitempty" json:"sudo,omitempty" swaggertype:"array,string"` // e.g. "ALL=(ALL) NOPASSWD:ALL"
+	Shell          string     `yaml:"shell,omitempty" json:"shell,omitempty"`                          // /bin/bash by default
+	Password       string     `yaml:"password,omitempty" json:"password,omitempty"`                    // Plain text or a crypt hash, hashed when user-data is generated
+	LockPassword   bool       `yaml:"lock-password,omitempty" json:"lock-password,omitempty"`          // Log in with authorized keys only
+	AuthorizedKeys []string   `yaml:"authorized-keys,omitempty" json:"authorized-keys,omitempty"`
+}
+
+// Group is a group of the installed system.
+type Group struct {
+	Name    string   `yaml:"name" json:"name"`
+	Members []string `yaml:"members,omitempty" json:"members,omitempty"` // Users added to the group
+}
+
+// defaultShell is the shell of account users that do not name one.
+const defaultShell = "/bin/bash"
+
+// ExpandAccounts writes the accounts into the users and groups of the
+// user-data and removes them from the config. The passwords of the users must
+// be hashed before.
+func (a *Autoinstall) ExpandAccounts() {
+	accounts := a.Accounts
+	a.Accounts = nil
+	if accounts == nil || (len(accounts.Users) == 0 && len(accounts.Groups) == 0 && !accounts.LockRoot) {
+		return
+	}
+	if a.UserData == nil {
+		a.UserData = &CloudConfig{}
+	}
+	ud := a.UserData
+	for _, g := range accounts.Groups {
+		ud.Groups = append(ud.Groups, CloudGroup{Name: g.Name, Members: g.Members})
+	}
+	for _, u := range accounts.Users {
+		locked := u.LockPassword || u.Password == ""
+		cu := CloudUser{
+			Name:              u.Name,
+			Gecos:             u.Realname,
+			UID:               u.UID,
+			PrimaryGroup:      u.PrimaryGroup,
+			Groups:            u.Groups,
+			Sudo:              u.Sudo,
+			Shell:             u.Shell,
+			LockPasswd:        &locked,
+			SSHAuthorizedKeys: u.AuthorizedKeys,
+		}
+		if cu.Shell == "" {
+			cu.Shell = defaultShell
+		}
+		if !locked {
+			cu.Passwd = u.Password
+		}
+		ud.Users = append(ud.Users, cu)
+	}
+	if accounts.LockRoot {
+		if ud.Extra == nil {
+			ud.Extra = make(map[string]interface{})
+		}
+		ud.Extra["disable_root"] = true
+		ud.RunCmd = append(ud.RunCmd, Command{Args: []string{"passwd", "--lock", "root"}})
+	}
+}
+
+// check validates the accounts against each other, the identity user and
+// the users and groups of the user-data, which they are merged into.
+func (acc *Accounts) check(r *ValidationResult, path string, a *Autoinstall) {
+	var ud CloudConfig
+	if a.UserData != nil {
+		ud = *a.UserData
+	}
+	users := make(map[string]string) // Name to the path of its definition
+	uids := make(map[int]string)     // UID to the user
+	if a.Identity.Username != "" {
+		users[a.Identity.Username] = "/identity"
+	}
+	for i, u := range ud.Users {
+		users[u.Name] = fmt.Sprintf("/user-data/users/%d", i)
+		if u.UID != 0 {
+			uids[u.UID] = u.Name
+		}
+	}
+
+	for i, u := range acc.Users {
+		at := fmt.Sprintf("%s/users/%d", path, i)
+		switch {
+		case u.Name == "":
+			r.addError(at+"/name", CodeRequired, "user has no name", "")
+		case u.Name == "root":
+			r.addError(at+"/name", CodeInvalidValue, "root cannot be added as an account", "set lock-root to lock it")
+		case !userName.MatchString(u.Name) || len(u.Name) > 32:
+			r.addError(at+"/name", CodeInvalidValue, fmt.Sprintf("%q is not a valid user name", u.Name),
+				"start with a lowercase letter or _ and use lowercase letters, digits, _ and -")
+		case users[u.Name] != "":
+			r.addError(at+"/name", CodeDuplicateUser, fmt.Sprintf("user %s is also defined in %s", u.Name, users[u.Name]), "")
+		default:
+			users[u.Name] = at
+		}
+
+		switch other, ok := uids[u.UID]; {
+		case u.UID < 0:
+			r.addError(at+"/uid", CodeInvalidValue, fmt.Sprintf("uid %d of user %s is negative", u.UID, u.Name), "")
+		case ok && u.UID != 0:
+			r.addError(at+"/uid", CodeDuplicateUID, fmt.Sprintf("users %s and %s have the same uid %d", other, u.Name, u.UID), "")
+		case u.UID != 0:
+			uids[u.UID] = u.Name
+		}
+
+		if u.PrimaryGroup != "" && !userName.MatchString(u.PrimaryGroup) {
+			r.addError(at+"/primary-group", CodeInvalidValue, fmt.Sprintf("%q is not a valid group name", u.PrimaryGroup), "")
+		}
+		for j, group := range u.Groups {
+			if !userName.MatchString(group) {
+				r.addError(fmt.Sprintf("%s/groups/%d", at, j), CodeInvalidValue, fmt.Sprintf("%q is not a valid group name", group), "")
+			}
+		}
+		if u.Shell != "" && !strings.HasPrefix(u.Shell, "/") {
+			r.addError(at+"/shell", CodeInvalidValue, fmt.Sprintf("shell %q of user %s is not an absolute path", u.Shell, u.Name), "e.g. /bin/bash")
+		}
+		for j, key := range u.AuthorizedKeys {
+			if len(strings.Fields(key)) < 2 {
+				r.addError(fmt.Sprintf("%s/authorized-keys/%d", at, j), CodeInvalidValue, fmt.Sprintf("authorized key %d of user %s is not an OpenSSH public key", j, u.Name),
+					"use a line of an authorized_keys file, e.g. ssh-ed25519 AAAA... user@host")
+			}
+		}
+		if !u.LockPassword {
+			checkPassword(r, at+"/password", u.Name, u.Password, a.PasswordPolicy)
+		}
+		switch {
+		case u.LockPassword && u.Password != "":
+			r.addWarning(at+"/password", CodeInvalidValue, fmt.Sprintf("the password of user %s is locked and not used", u.Name),
+				"remove the password or lock-password")
+		case (u.LockPassword || u.Password == "") && len(u.AuthorizedKeys) == 0:
+			r.addWarning(at, CodeNoSSHLogin, fmt.Sprintf("user %s has neither a password nor authorized keys and cannot log in", u.Name), "")
+		}
+	}
+
+	groups := make(map[string]bool)
+	for _, g := range ud.Groups {
+		groups[g.Name] = true
+	}
+	for i, g := range acc.Groups {
+		at := fmt.Sprintf("%s/groups/%d", path, i)
+		switch {
+		case g.Name == "":
+			r.addError(at+"/name", CodeRequired, "group has no name", "")
+		case !userName.MatchString(g.Name):
+			r.addError(at+"/name", CodeInvalidValue, fmt.Sprintf("%q is not a valid group name", g.Name), "")
+		case groups[g.Name]:
+			r.addError(at+"/name", CodeDuplicateGroup, fmt.Sprintf("group %s is defined twice", g.Name), "")
+		}
+		groups[g.Name] = true
+		for j, member := range g.Members {
+			if users[member] == "" {
+				r.addWarning(fmt.Sprintf("%s/members/%d", at, j), CodeUnknownReference, fmt.Sprintf("member %s of group %s is not a user of the config", member, g.Name),
+					"add the user to accounts, or make sure the system has it")
+			}
+		}
+	}
+
+	if acc.LockRoot {
+		if disable, ok := ud.Extra["disable_root"].(bool); ok && !disable {
+			r.addError(path+"/lock-root", CodeInvalidValue, "lock-root conflicts with disable_root: false in the user-data", "remove one of them")
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+const accountsYAML = `users:
+  - name: alice
+    realname: Alice Ops
+    uid: 1001
+    groups: [adm, ops]
+    sudo: ALL=(ALL) NOPASSWD:ALL
+    password: $6$saltstring$adDbXsJjcDlq2662QPgd.tkSOVmnG9Tt3oXl4HR60SusC3AGjirnDenVZp3DGwLwqy6iYKCzannhaX9DR72nN1
+    authorized-keys: [ssh-ed25519 AAAAalice alice@example.com]
+  - name: bob
+    shell: /bin/zsh
+    lock-password: true
+    authorized-keys: [ssh-ed25519 AAAAbob bob@example.com]
+groups:
+  - name: ops
+    members: [alice, bob]
+lock-root: true
+`
+
+func TestAutoinstall_ExpandAccounts(t *testing.T) {
+	cfg := NewDefaultConfig()
+	a := &cfg.Autoinstall
+	a.Accounts = &Accounts{}
+	require.NoError(t, yaml.Unmarshal([]byte(accountsYAML), a.Accounts))
+	assert.Empty(t, cfg.Check().Issues)
+
+	password := a.Accounts.Users[0].Password
+	a.ExpandAccounts()
+	a.MergeIdentity()
+	assert.Nil(t, a.Accounts)
+	require.NotNil(t, a.UserData)
+	ud := a.UserData
+	assert.Equal(t, []CloudGroup{{Name: "ops", Members: []string{"alice", "bob"}}}, ud.Groups)
+	require.Len(t, ud.Users, 3)
+	assert.Equal(t, "ubuntu", ud.Users[0].Name)
+
+	alice := ud.Users[1]
+	assert.Equal(t, "Alice Ops", alice.Gecos)
+	assert.Equal(t, 1001, alice.UID)
+	assert.Equal(t, "/bin/bash", alice.Shell)
+	assert.Equal(t, password, alice.Passwd)
+	assert.False(t, *alice.LockPasswd)
+	assert.Equal(t, SudoRules{"ALL=(ALL) NOPASSWD:ALL"}, alice.Sudo)
+	assert.Equal(t, []string{"ssh-ed25519 AAAAalice alice@example.com"}, alice.SSHAuthorizedKeys)
+
+	bob := ud.Users[2]
+	assert.Equal(t, "/bin/zsh", bob.Shell)
+	assert.True(t, *bob.LockPasswd)
+	assert.Empty(t, bob.Passwd)
+
+	assert.Equal(t, true, ud.Extra["disable_root"])
+	assert.Equal(t, []Command{{Args: []string{"passwd", "--lock", "root"}}}, ud.RunCmd)
+
+	data, err := yaml.Marshal(cfg)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "accounts:")
+	assert.Contains(t, string(data), "- ops:\n")
+	assert.NoError(t, ValidateUserDataSchema(append([]byte("#cloud-config\n"), data...), ""))
+}
+
+func TestAccounts_Check(t *testing.T) {
+	cfg := NewDefaultConfig()
+	a := &cfg.Autoinstall
+	a.UserData = &CloudConfig{
+		Groups: []CloudGroup{{Name: "ops"}},
+		Users:  []CloudUser{{Name: "carol", UID: 1002, SSHAuthorizedKeys: []string{"ssh-ed25519 AAAA"}}},
+		Extra:  map[string]interface{}{"disable_root": false},
+	}
+	a.Accounts = &Accounts{
+		Users: []User{
+			{Name: "ubuntu", Password: "secret"},
+			{Name: "root", Password: "secret"},
+			{Name: "carol", Password: "secret"},
+			{Name: "dave", UID: 1002, Password: "secret", LockPassword: true, AuthorizedKeys: []string{"AAAA"}},
+			{Name: "erin", UID: -1, Shell: "bash", Groups: StringList{"Wheel"}},
+			{Name: "dave", Password: "secret"},
+		},
+		Groups:   []Group{{Name: "ops"}, {Name: "dev", Members: []string{"erin", "frank"}}},
+		LockRoot: true,
+	}
+	issues := make(map[string]string)
+	for _, issue := range cfg.Check().Issues {
+		issues[issue.Path] = issue.Code
+	}
+	const at = "/autoinstall/accounts"
+	assert.Equal(t, map[string]string{
+		at + "/users/0/name":              CodeDuplicateUser,
+		at + "/users/1/name":              CodeInvalidValue,
+		at + "/users/2/name":              CodeDuplicateUser,
+		at + "/users/3/uid":               CodeDuplicateUID,
+		at + "/users/3/authorized-keys/0": CodeInvalidValue,
+		at + "/users/3/password":          CodeInvalidValue,
+		at + "/users/4/uid":               CodeInvalidValue,
+		at + "/users/4/shell":             CodeInvalidValue,
+		at + "/users/4/groups/0":          CodeInvalidValue,
+		at + "/users/4":                   CodeNoSSHLogin,
+		at + "/users/5/name":              CodeDuplicateUser,
+		at + "/groups/0/name":             CodeDuplicateGroup,
+		at + "/groups/1/members/1":        CodeUnknownReference,
+		at + "/lock-root":                 CodeInvalidValue,
+	}, issues)
+}
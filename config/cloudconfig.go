@@ -0,0 +1,527 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Issue codes of the cloud-config checks.
+const (
+	CodeDuplicateUser     = "duplicate-user"
+	CodeDuplicateUID      = "duplicate-uid"
+	CodeDuplicateGroup    = "duplicate-group"
+	CodePlaintextPassword = "plaintext-password"
+)
+
+// CloudConfig is the cloud-init user-data applied on the first boot of the
+// installed system. The modules below are typed; any other module, e.g.
+// timezone or disable_root, is kept in Extra and written as it is.
+type CloudConfig struct {
+	Groups         []CloudGroup `yaml:"groups,omitempty" json:"groups,omitempty"` // Created before the users
+	Users          []CloudUser  `yaml:"users,omitempty" json:"users,omitempty"`
+	WriteFiles     []WriteFile  `yaml:"write_files,omitempty" json:"write_files,omitempty"`
+	BootCmd        []Command    `yaml:"bootcmd,omitempty" json:"bootcmd,omitempty"` // Run on every boot, before the network is up
+	RunCmd         []Command    `yaml:"runcmd,omitempty" json:"runcmd,omitempty"`   // Run once on the first boot
+	NTP            *NTPConfig   `yaml:"ntp,omitempty" json:"ntp,omitempty"`
+	PackageUpgrade *bool        `yaml:"package_upgrade,omitempty" json:"package_upgrade,omitempty"`
+	CACerts        *CACerts     `yaml:"ca_certs,omitempty" json:"ca_certs,omitempty"`
+
+	Extra map[string]interface{} `yaml:",inline" json:"-"` // Other cloud-config modules
+}
+
+// CloudUser is an entry of the cloud-config users list. The entry "default",
+// the distribution's default user, has only Name set.
+type CloudUser struct {
+	Name              string     `yaml:"name" json:"name"`
+	Gecos             string     `yaml:"gecos,omitempty" json:"gecos,omitempty"` // Real name
+	UID               int        `yaml:"uid,omitempty" json:"uid,omitempty"`
+	PrimaryGroup      string     `yaml:"primary_group,omitempty" json:"primary_group,omitempty"`
+	Groups            StringList `yaml:"groups,omitempty" json:"groups,omitempty" swaggertype:"array,string"` // A list or a comma-separated string
+	Sudo              SudoRules  `yaml:"sudo,omitempty" json:"sudo,omitempty" swaggertype:"array,string"`     // e.g. "ALL=(ALL) NOPASSWD:ALL"
+	Shell             string     `yaml:"shell,omitempty" json:"shell,omitempty"`
+	Homedir           string     `yaml:"homedir,omitempty" json:"homedir,omitempty"`
+	System            bool       `yaml:"system,omitempty" json:"system,omitempty"`
+	LockPasswd        *bool      `yaml:"lock_passwd,omitempty" json:"lock_passwd,omitempty"` // cloud-init locks the password unless this is false
+	Passwd            string     `yaml:"passwd,omitempty" json:"passwd,omitempty"`           // Crypt hash
+	HashedPasswd      string     `yaml:"hashed_passwd,omitempty" json:"hashed_passwd,omitempty"`
+	PlainTextPasswd   string     `yaml:"plain_text_passwd,omitempty" json:"plain_text_passwd,omitempty"`
+	SSHAuthorizedKeys []string   `yaml:"ssh_authorized_keys,omitempty" json:"ssh_authorized_keys,omitempty"`
+	ExpireDate        string     `yaml:"expiredate,omitempty" json:"expiredate,omitempty"` // YYYY-MM-DD
+
+	Extra map[string]interface{} `yaml:",inline" json:"-"` // Other user settings
+}
+
+// CloudGroup is an entry of the cloud-config groups list: a group name, or a
+// group and its members.
+type CloudGroup struct {
+	Name    string
+	Members []string
+}
+
+// defaultUser is the users entry of the distribution's default user.
+const defaultUser = "default"
+
+// WriteFile is a file cloud-init writes.
+type WriteFile struct {
+	Path        string `yaml:"path" json:"path"`
+	Content     string `yaml:"content,omitempty" json:"content,omitempty"`
+	Encoding    string `yaml:"encoding,omitempty" json:"encoding,omitempty"`       // e.g. b64 or gz+b64, plain text by default
+	Owner       string `yaml:"owner,omitempty" json:"owner,omitempty"`             // user:group, root:root by default
+	Permissions string `yaml:"permissions,omitempty" json:"permissions,omitempty"` // Octal, e.g. "0644"
+	Append      bool   `yaml:"append,omitempty" json:"append,omitempty"`
+	Defer       bool   `yaml:"defer,omitempty" json:"defer,omitempty"` // Write after users and packages are set up
+}
+
+// NTPConfig configures time synchronization.
+type NTPConfig struct {
+	Enabled   *bool    `yaml:"enabled,omitempty" json:"enabled,omitempty"`
+	NTPClient string   `yaml:"ntp_client,omitempty" json:"ntp_client,omitempty"` // auto, chrony, ntp, ntpdate, openntpd or systemd-timesyncd
+	Servers   []string `yaml:"servers,omitempty" json:"servers,omitempty"`
+	Pools     []string `yaml:"pools,omitempty" json:"pools,omitempty"`
+}
+
+// CACerts adds trusted CA certificates.
+type CACerts struct {
+	RemoveDefaults bool     `yaml:"remove_defaults,omitempty" json:"remove_defaults,omitempty"`
+	Trusted        []string `yaml:"trusted,omitempty" json:"trusted,omitempty"` // PEM certificates
+}
+
+// UnmarshalJSON reads the typed modules and keeps the others in Extra.
+func (c *CloudConfig) UnmarshalJSON(data []byte) error {
+	type plain CloudConfig
+	extra, err := unmarshalWithExtra(data, (*plain)(c))
+	c.Extra = extra
+	return err
+}
+
+// MarshalJSON writes the typed modules and those in Extra.
+func (c CloudConfig) MarshalJSON() ([]byte, error) {
+	type plain CloudConfig
+	return marshalWithExtra(plain(c), c.Extra)
+}
+
+// UnmarshalYAML reads a users entry, a mapping or the string "default".
+func (u *CloudUser) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*u = CloudUser{Name: node.Value}
+		return nil
+	}
+	type plain CloudUser
+	return node.Decode((*plain)(u))
+}
+
+// MarshalYAML writes the "default" entry as a string.
+func (u CloudUser) MarshalYAML() (interface{}, error) {
+	if reflect.DeepEqual(u, CloudUser{Name: defaultUser}) {
+		return defaultUser, nil
+	}
+	type plain CloudUser
+	return plain(u), nil
+}
+
+// UnmarshalJSON reads a users entry, an object or the string "default".
+func (u *CloudUser) UnmarshalJSON(data []byte) error {
+	var name string
+	if json.Unmarshal(data, &name) == nil {
+		*u = CloudUser{Name: name}
+		return nil
+	}
+	type plain CloudUser
+	extra, err := unmarshalWithExtra(data, (*plain)(u))
+	u.Extra = extra
+	return err
+}
+
+// MarshalJSON writes the "default" entry as a string.
+func (u CloudUser) MarshalJSON() ([]byte, error) {
+	if reflect.DeepEqual(u, CloudUser{Name: defaultUser}) {
+		return json.Marshal(defaultUser)
+	}
+	type plain CloudUser
+	return marshalWithExtra(plain(u), u.Extra)
+}
+
+// UnmarshalYAML reads a group name or a mapping of the name to its members.
+func (g *CloudGroup) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*g = CloudGroup{Name: node.Value}
+		return nil
+	}
+	var m map[string]StringList
+	if err := node.Decode(&m); err != nil || len(m) != 1 {
+		return fmt.Errorf("line %d: group must be a name or a mapping of one name to its members", node.Line)
+	}
+	for name, members := range m {
+		*g = CloudGroup{Name: name, Members: members}
+	}
+	return nil
+}
+
+// MarshalYAML writes a group name, or a mapping of the name to its members.
+func (g CloudGroup) MarshalYAML() (interface{}, error) {
+	if len(g.Members) == 0 {
+		return g.Name, nil
+	}
+	return map[string][]string{g.Name: g.Members}, nil
+}
+
+// UnmarshalJSON reads a group name or an object of the name to its members.
+func (g *CloudGroup) UnmarshalJSON(data []byte) error {
+	var name string
+	if json.Unmarshal(data, &name) == nil {
+		*g = CloudGroup{Name: name}
+		return nil
+	}
+	var m map[string]StringList
+	if err := json.Unmarshal(data, &m); err != nil || len(m) != 1 {
+		return fmt.Errorf("group must be a name or an object of one name to its members")
+	}
+	for name, members := range m {
+		*g = CloudGroup{Name: name, Members: members}
+	}
+	return nil
+}
+
+// MarshalJSON writes a group name, or an object of the name to its members.
+func (g CloudGroup) MarshalJSON() ([]byte, error) {
+	if len(g.Members) == 0 {
+		return json.Marshal(g.Name)
+	}
+	return json.Marshal(map[string][]string{g.Name: g.Members})
+}
+
+// unmarshalWithExtra decodes the JSON object data into v, a pointer to a
+// struct, and returns the keys v has no field for.
+func unmarshalWithExtra(data []byte, v interface{}) (map[string]interface{}, error) {
+	if err := json.Unmarshal(data, v); err != nil {
+		return nil, err
+	}
+	var all map[string]interface{}
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil, err
+	}
+	t := reflect.TypeOf(v).Elem()
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		delete(all, name)
+	}
+	if len(all) == 0 {
+		return nil, nil
+	}
+	return all, nil
+}
+
+// marshalWithExtra encodes v, a struct, as a JSON object with the keys of extra added.
+func marshalWithExtra(v interface{}, extra map[string]interface{}) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil || len(extra) == 0 {
+		return data, err
+	}
+	var all map[string]interface{}
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil, err
+	}
+	for k, value := range extra {
+		if _, ok := all[k]; !ok {
+			all[k] = value
+		}
+	}
+	return json.Marshal(all)
+}
+
+// StringList is a list of strings that may also be written as one
+// comma-separated string, as cloud-init accepts for groups.
+type StringList []string
+
+// UnmarshalYAML reads a list or a comma-separated string.
+func (l *StringList) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*l = splitList(node.Value)
+		return nil
+	}
+	var v []string
+	if err := node.Decode(&v); err != nil {
+		return err
+	}
+	*l = v
+	return nil
+}
+
+// UnmarshalJSON reads a list or a comma-separated string.
+func (l *StringList) UnmarshalJSON(data []byte) error {
+	var s string
+	if json.Unmarshal(data, &s) == nil {
+		*l = splitList(s)
+		return nil
+	}
+	var v []string
+	if err := json.Unmarshal(data, &v); err != nil {
+		return fmt.Errorf("must be a list or a comma-separated string")
+	}
+	*l = v
+	return nil
+}
+
+// splitList splits a comma-separated string.
+func splitList(s string) StringList {
+	var out StringList
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// SudoRules are the sudoers rules of a user. cloud-init accepts one rule, a
+// list of rules, or false for none.
+type SudoRules []string
+
+// UnmarshalYAML reads a rule, a list of rules or false.
+func (s *SudoRules) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*s = nil
+		if node.ShortTag() != "!!bool" && node.ShortTag() != "!!null" {
+			*s = SudoRules{node.Value}
+		}
+		return nil
+	}
+	var v []string
+	if err := node.Decode(&v); err != nil {
+		return err
+	}
+	*s = v
+	return nil
+}
+
+// UnmarshalJSON reads a rule, a list of rules or false.
+func (s *SudoRules) UnmarshalJSON(data []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	switch v := v.(type) {
+	case string:
+		*s = SudoRules{v}
+	case bool, nil:
+		*s = nil
+	default:
+		var rules []string
+		if err := json.Unmarshal(data, &rules); err != nil {
+			return fmt.Errorf("sudo must be a rule, a list of rules or false")
+		}
+		*s = rules
+	}
+	return nil
+}
+
+// Command is a runcmd or bootcmd entry: a shell command line, or a program and
+// its arguments run without a shell.
+type Command struct {
+	Shell string   // Run with sh -c
+	Args  []string // Run directly when Shell is empty
+}
+
+// UnmarshalYAML reads a string or a list of strings.
+func (c *Command) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*c = Command{Shell: node.Value}
+		return nil
+	}
+	*c = Command{}
+	return node.Decode(&c.Args)
+}
+
+// MarshalYAML writes a string or a list of strings.
+func (c Command) MarshalYAML() (interface{}, error) {
+	if c.Args != nil {
+		return c.Args, nil
+	}
+	return c.Shell, nil
+}
+
+// UnmarshalJSON reads a string or a list of strings.
+func (c *Command) UnmarshalJSON(data []byte) error {
+	*c = Command{}
+	if json.Unmarshal(data, &c.Shell) == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, &c.Args); err != nil {
+		return fmt.Errorf("command must be a string or a list of strings")
+	}
+	return nil
+}
+
+// MarshalJSON writes a string or a list of strings.
+func (c Command) MarshalJSON() ([]byte, error) {
+	if c.Args != nil {
+		return json.Marshal(c.Args)
+	}
+	return json.Marshal(c.Shell)
+}
+
+// MergeIdentity adds the identity user to the users of the user-data. The
+// installer creates the identity user through cloud-init, and a users list in
+// the user-data replaces its own, so without this the identity user would not
+// be created. The user gets the groups the installer gives it, and a locked
+// password when the password policy locks it.
+func (a *Autoinstall) MergeIdentity() {
+	if a.UserData == nil || len(a.UserData.Users) == 0 || a.Identity.Username == "" {
+		return
+	}
+	for _, u := range a.UserData.Users {
+		if u.Name == a.Identity.Username {
+			return
+		}
+	}
+	locked := a.Identity.Password == lockedPassword
+	identity := CloudUser{
+		Name:       a.Identity.Username,
+		Gecos:      a.Identity.Realname,
+		Groups:     StringList{"adm", "cdrom", "dip", "lxd", "plugdev", "sudo"},
+		Shell:      "/bin/bash",
+		LockPasswd: &locked,
+	}
+	if !locked {
+		identity.Passwd = a.Identity.Password
+	}
+	a.UserData.Users = append([]CloudUser{identity}, a.UserData.Users...)
+}
+
+// Patterns of valid cloud-config values.
+var (
+	userName    = regexp.MustCompile(`^[a-z_][a-z0-9_-]*[$]?$`)
+	permissions = regexp.MustCompile(`^0?[0-7]{3,4}$`)
+	expireDate  = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+)
+
+// writeFileEncodings are the encodings cloud-init decodes write_files content from.
+var writeFileEncodings = []string{"b64", "base64", "gz", "gzip", "gz+b64", "gz+base64", "gzip+b64", "gzip+base64", "text/plain"}
+
+// ntpClients are the NTP clients cloud-init configures.
+var ntpClients = []string{"auto", "chrony", "ntp", "ntpdate", "openntpd", "systemd-timesyncd"}
+
+// check validates the typed modules of the user-data. identity is the user
+// the installer creates, which a users entry must not redefine.
+func (c *CloudConfig) check(r *ValidationResult, path, identity string) {
+	groups := make(map[string]bool)
+	for i, g := range c.Groups {
+		at := fmt.Sprintf("%s/groups/%d", path, i)
+		switch {
+		case !userName.MatchString(g.Name):
+			r.addError(at, CodeInvalidValue, fmt.Sprintf("%q is not a valid group name", g.Name), "")
+		case groups[g.Name]:
+			r.addError(at, CodeDuplicateGroup, fmt.Sprintf("group %s is defined twice", g.Name), "")
+		}
+		groups[g.Name] = true
+	}
+	names := make(map[string]int)
+	uids := make(map[int]string)
+	for i, u := range c.Users {
+		at := fmt.Sprintf("%s/users/%d", path, i)
+		if j, ok := names[u.Name]; ok && u.Name != "" {
+			r.addError(at+"/name", CodeDuplicateUser, fmt.Sprintf("user %s is also defined by users entry %d", u.Name, j), "")
+		}
+		names[u.Name] = i
+		if u.Name == identity && identity != "" {
+			r.addWarning(at+"/name", CodeDuplicateUser, fmt.Sprintf("user %s is also the identity user, this entry replaces the identity settings", u.Name),
+				"rename the user or remove it from identity")
+		}
+		if other, ok := uids[u.UID]; ok && u.UID != 0 {
+			r.addError(at+"/uid", CodeDuplicateUID, fmt.Sprintf("users %s and %s have the same uid %d", other, u.Name, u.UID), "")
+		} else if u.UID != 0 {
+			uids[u.UID] = u.Name
+		}
+		u.check(r, at)
+	}
+	for i, f := range c.WriteFiles {
+		f.check(r, fmt.Sprintf("%s/write_files/%d", path, i))
+	}
+	checkCommands(r, path, "bootcmd", c.BootCmd)
+	checkCommands(r, path, "runcmd", c.RunCmd)
+	if c.NTP != nil {
+		if c.NTP.NTPClient != "" && !containsString(ntpClients, c.NTP.NTPClient) {
+			r.addError(path+"/ntp/ntp_client", CodeInvalidValue, fmt.Sprintf("unknown ntp_client %q", c.NTP.NTPClient), "use "+joinOr(ntpClients))
+		}
+		if c.NTP.Enabled != nil && !*c.NTP.Enabled && len(c.NTP.Servers)+len(c.NTP.Pools) > 0 {
+			r.addWarning(path+"/ntp/enabled", CodeInvalidValue, "ntp is disabled, its servers and pools are not used", "")
+		}
+	}
+	if c.CACerts != nil {
+		for i, cert := range c.CACerts.Trusted {
+			if !strings.Contains(cert, "-----BEGIN CERTIFICATE-----") {
+				r.addError(fmt.Sprintf("%s/ca_certs/trusted/%d", path, i), CodeInvalidValue, "trusted CA certificate is not PEM encoded", "")
+			}
+		}
+	}
+}
+
+// checkCommands reports empty runcmd or bootcmd entries.
+func checkCommands(r *ValidationResult, path, module string, cmds []Command) {
+	for i, cmd := range cmds {
+		if strings.TrimSpace(cmd.Shell) == "" && len(cmd.Args) == 0 {
+			r.addError(fmt.Sprintf("%s/%s/%d", path, module, i), CodeRequired, fmt.Sprintf("%s entry %d is empty", module, i), "")
+		}
+	}
+}
+
+// check validates a users entry.
+func (u *CloudUser) check(r *ValidationResult, at string) {
+	switch {
+	case u.Name == "":
+		r.addError(at+"/name", CodeRequired, "user has no name", "")
+		return
+	case u.Name == defaultUser:
+		return
+	case !userName.MatchString(u.Name) || len(u.Name) > 32:
+		r.addError(at+"/name", CodeInvalidValue, fmt.Sprintf("%q is not a valid user name", u.Name),
+			"start with a lowercase letter or _ and use lowercase letters, digits, _ and -")
+	}
+	for i, group := range u.Groups {
+		if !userName.MatchString(group) {
+			r.addError(fmt.Sprintf("%s/groups/%d", at, i), CodeInvalidValue, fmt.Sprintf("%q is not a valid group name", group), "")
+		}
+	}
+	for _, field := range []struct{ name, hash string }{{"passwd", u.Passwd}, {"hashed_passwd", u.HashedPasswd}} {
+		if field.hash != "" && !strings.HasPrefix(field.hash, "$") {
+			r.addError(at+"/"+field.name, CodeInvalidValue, fmt.Sprintf("%s of user %s is not a crypt hash", field.name, u.Name),
+				"hash it, e.g. with mkpasswd -m sha-512, or use plain_text_passwd")
+		}
+	}
+	if u.PlainTextPasswd != "" {
+		r.addWarning(at+"/plain_text_passwd", CodePlaintextPassword, fmt.Sprintf("the password of user %s is stored in plain text in the user-data", u.Name),
+			"use passwd with a crypt hash")
+	}
+	hasPassword := u.Passwd != "" || u.HashedPasswd != "" || u.PlainTextPasswd != ""
+	if hasPassword && (u.LockPasswd == nil || *u.LockPasswd) {
+		r.addWarning(at+"/lock_passwd", CodeInvalidValue, fmt.Sprintf("the password of user %s is locked, cloud-init locks passwords unless lock_passwd is false", u.Name),
+			"set lock_passwd to false")
+	}
+	if !hasPassword && len(u.SSHAuthorizedKeys) == 0 && !u.System {
+		r.addWarning(at, CodeNoSSHLogin, fmt.Sprintf("user %s has neither a password nor SSH keys and cannot log in", u.Name), "")
+	}
+	if u.ExpireDate != "" && !expireDate.MatchString(u.ExpireDate) {
+		r.addError(at+"/expiredate", CodeInvalidValue, fmt.Sprintf("expiredate of user %s is not a YYYY-MM-DD date", u.Name), "")
+	}
+}
+
+// check validates a write_files entry.
+func (f *WriteFile) check(r *ValidationResult, at string) {
+	if !strings.HasPrefix(f.Path, "/") {
+		r.addError(at+"/path", CodeInvalidValue, fmt.Sprintf("write_files path %q is not absolute", f.Path), "")
+	}
+	if f.Encoding != "" && !containsString(writeFileEncodings, f.Encoding) {
+		r.addError(at+"/encoding", CodeInvalidValue, fmt.Sprintf("unknown encoding %q of %s", f.Encoding, f.Path), "use b64, gzip or gz+b64")
+	}
+	if f.Permissions != "" && !permissions.MatchString(f.Permissions) {
+		r.addError(at+"/permissions", CodeInvalidValue, fmt.Sprintf("permissions %q of %s are not octal", f.Permissions, f.Path), "quote them, e.g. '0644'")
+	}
+	if f.Owner != "" && strings.Count(f.Owner, ":") > 1 {
+		r.addError(at+"/owner", CodeInvalidValue, fmt.Sprintf("owner %q of %s is not user:group", f.Owner, f.Path), "")
+	}
+}
@@ -0,0 +1,145 @@
+package config
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+const cloudConfigYAML = `groups:
+  - admins
+  - ops: [alice, bob]
+users:
+  - default
+  - name: ops
+    gecos: Operations
+    groups: adm, sudo
+    sudo: ALL=(ALL) NOPASSWD:ALL
+    lock_passwd: false
+    passwd: $6$salt$hash
+    ssh_authorized_keys: [ssh-ed25519 AAAA ops@example.com]
+    inactive: "30"
+write_files:
+  - path: /etc/motd
+    content: Managed by cloud-init
+    permissions: 0644
+bootcmd:
+  - [cloud-init-per, once, mymkfs, mkfs, /dev/vdb]
+runcmd:
+  - systemctl restart ssh
+ntp:
+  servers: [ntp.example.com]
+package_upgrade: true
+timezone: Europe/Berlin
+`
+
+func TestCloudConfig_YAML(t *testing.T) {
+	var c CloudConfig
+	require.NoError(t, yaml.Unmarshal([]byte(cloudConfigYAML), &c))
+	assert.Equal(t, []CloudGroup{{Name: "admins"}, {Name: "ops", Members: []string{"alice", "bob"}}}, c.Groups)
+	require.Len(t, c.Users, 2)
+	assert.Equal(t, CloudUser{Name: "default"}, c.Users[0])
+	ops := c.Users[1]
+	assert.Equal(t, StringList{"adm", "sudo"}, ops.Groups)
+	assert.Equal(t, SudoRules{"ALL=(ALL) NOPASSWD:ALL"}, ops.Sudo)
+	assert.Equal(t, map[string]interface{}{"inactive": "30"}, ops.Extra)
+	assert.Equal(t, "0644", c.WriteFiles[0].Permissions)
+	assert.Equal(t, Command{Args: []string{"cloud-init-per", "once", "mymkfs", "mkfs", "/dev/vdb"}}, c.BootCmd[0])
+	assert.Equal(t, Command{Shell: "systemctl restart ssh"}, c.RunCmd[0])
+	assert.Equal(t, map[string]interface{}{"timezone": "Europe/Berlin"}, c.Extra)
+
+	data, err := yaml.Marshal(&c)
+	require.NoError(t, err)
+	out := string(data)
+	assert.Contains(t, out, "groups:\n    - admins\n    - ops:\n        - alice\n")
+	assert.Contains(t, out, "users:\n    - default\n    - name: ops\n")
+	assert.Contains(t, out, "inactive: \"30\"")
+	assert.Contains(t, out, "- - cloud-init-per\n")
+	assert.Contains(t, out, "- systemctl restart ssh\n")
+	assert.Contains(t, out, "timezone: Europe/Berlin\n")
+
+	r := &ValidationResult{}
+	c.check(r, "", "ubuntu")
+	assert.Empty(t, r.Issues)
+}
+
+func TestCloudConfig_JSON(t *testing.T) {
+	var c CloudConfig
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"users": ["default", {"name": "ops", "groups": ["adm"], "sudo": false, "inactive": "30"}],
+		"runcmd": ["echo hi", ["ls", "-l"]],
+		"disable_root": false
+	}`), &c))
+	assert.Equal(t, map[string]interface{}{"disable_root": false}, c.Extra)
+	assert.Nil(t, c.Users[1].Sudo)
+	assert.Equal(t, map[string]interface{}{"inactive": "30"}, c.Users[1].Extra)
+
+	data, err := json.Marshal(c)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"users": ["default", {"name": "ops", "groups": ["adm"], "inactive": "30"}],
+		"runcmd": ["echo hi", ["ls", "-l"]],
+		"disable_root": false
+	}`, string(data))
+}
+
+func TestCloudConfig_Check(t *testing.T) {
+	locked := true
+	c := &CloudConfig{
+		Users: []CloudUser{
+			{Name: "ops", Passwd: "secret", SSHAuthorizedKeys: []string{"ssh-ed25519 AAAA"}},
+			{Name: "ops", PlainTextPasswd: "secret", LockPasswd: &locked},
+			{Name: "Bad User", Groups: StringList{"wheel!"}, SSHAuthorizedKeys: []string{"ssh-ed25519 AAAA"}},
+			{Name: "ubuntu", SSHAuthorizedKeys: []string{"ssh-ed25519 AAAA"}},
+			{Name: "nologin"},
+		},
+		WriteFiles: []WriteFile{{Path: "etc/motd", Encoding: "rot13", Permissions: "rw-r--r--"}},
+		RunCmd:     []Command{{Shell: " "}},
+		NTP:        &NTPConfig{NTPClient: "ntpsec"},
+		CACerts:    &CACerts{Trusted: []string{"MIIB..."}},
+	}
+	r := &ValidationResult{}
+	c.check(r, "", "ubuntu")
+	issues := make(map[string]string)
+	for _, issue := range r.Issues {
+		issues[issue.Path] = issue.Code
+	}
+	assert.Equal(t, map[string]string{
+		"/users/0/passwd":            CodeInvalidValue,
+		"/users/0/lock_passwd":       CodeInvalidValue,
+		"/users/1/name":              CodeDuplicateUser,
+		"/users/1/plain_text_passwd": CodePlaintextPassword,
+		"/users/1/lock_passwd":       CodeInvalidValue,
+		"/users/2/name":              CodeInvalidValue,
+		"/users/2/groups/0":          CodeInvalidValue,
+		"/users/3/name":              CodeDuplicateUser,
+		"/users/4":                   CodeNoSSHLogin,
+		"/write_files/0/path":        CodeInvalidValue,
+		"/write_files/0/encoding":    CodeInvalidValue,
+		"/write_files/0/permissions": CodeInvalidValue,
+		"/runcmd/0":                  CodeRequired,
+		"/ntp/ntp_client":            CodeInvalidValue,
+		"/ca_certs/trusted/0":        CodeInvalidValue,
+	}, issues)
+}
+
+func TestAutoinstall_MergeIdentity(t *testing.T) {
+	a := &NewDefaultConfig().Autoinstall
+	a.MergeIdentity()
+	assert.Nil(t, a.UserData)
+
+	a.UserData = &CloudConfig{Users: []CloudUser{{Name: "ops", SSHAuthorizedKeys: []string{"ssh-ed25519 AAAA"}}}}
+	a.MergeIdentity()
+	require.Len(t, a.UserData.Users, 2)
+	identity := a.UserData.Users[0]
+	assert.Equal(t, "ubuntu", identity.Name)
+	assert.Equal(t, a.Identity.Password, identity.Passwd)
+	assert.False(t, *identity.LockPasswd)
+	assert.Contains(t, identity.Groups, "sudo")
+
+	a.MergeIdentity()
+	assert.Len(t, a.UserData.Users, 2)
+}
@@ -1,6 +1,7 @@
 package config
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 
@@ -12,23 +13,38 @@ type Config struct {
 }
 
 type Autoinstall struct {
-	Apt           AptConfig              `yaml:"apt" json:"apt"`
-	Drivers       DriversConfig          `yaml:"drivers" json:"drivers"`
-	Identity      Identity               `yaml:"identity" json:"identity"`
-	Kernel        KernelConfig           `yaml:"kernel" json:"kernel"`
-	Keyboard      KeyboardConfig         `yaml:"keyboard" json:"keyboard"`
-	Locale        string                 `yaml:"locale" json:"locale"`
-	Network       NetworkConfig          `yaml:"network" json:"network"`
-	SSH           SSHConfig              `yaml:"ssh" json:"ssh"`
-	Storage       Storage                `yaml:"storage" json:"storage"`
-	Updates       string                 `yaml:"updates" json:"updates"`
-	Shutdown      string                 `yaml:"shutdown" json:"shutdown"`
-	Version       int                    `yaml:"version" json:"version"`
-	Packages      []string               `yaml:"packages" json:"packages"`
-	EarlyCommands []string               `yaml:"early-commands" json:"early-commands"`
-	LateCommands  []string               `yaml:"late-commands" json:"late-commands"`
-	UserData      map[string]interface{} `yaml:"user-data" json:"user-data" `
-	TimeZone      string                 `yaml:"timezone" json:"timezone"`
+	Apt            AptConfig       `yaml:"apt" json:"apt"`
+	Drivers        DriversConfig   `yaml:"drivers" json:"drivers"`
+	Identity       Identity        `yaml:"identity" json:"identity"`
+	Kernel         KernelConfig    `yaml:"kernel" json:"kernel"`
+	Keyboard       KeyboardConfig  `yaml:"keyboard" json:"keyboard"`
+	Locale         string          `yaml:"locale" json:"locale"`
+	Network        NetworkConfig   `yaml:"network" json:"network"`
+	SSH            SSHConfig       `yaml:"ssh" json:"ssh"`
+	Storage        Storage         `yaml:"storage" json:"storage"`
+	Updates        string          `yaml:"updates" json:"updates"`
+	Shutdown       string          `yaml:"shutdown" json:"shutdown"`
+	Version        int             `yaml:"version" json:"version"`
+	Packages       []string        `yaml:"packages" json:"packages"`
+	EarlyCommands  []string        `yaml:"early-commands" json:"early-commands"`
+	LateCommands   []string        `yaml:"late-commands" json:"late-commands"`
+	UserData       *CloudConfig    `yaml:"user-data,omitempty" json:"user-data,omitempty"`             // cloud-init user-data of the installed system
+	Accounts       *Accounts       `yaml:"accounts,omitempty" json:"accounts,omitempty"`               // Written into the user-data when it is generated
+	PasswordPolicy *PasswordPolicy `yaml:"password-policy,omitempty" json:"password-policy,omitempty"` // Only used for validation
+	TimeZone       string          `yaml:"timezone" json:"timezone"`
+
+	Source              *SourceConfig               `yaml:"source,omitempty" json:"source,omitempty"`
+	RefreshInstaller    *RefreshInstallerConfig     `yaml:"refresh-installer,omitempty" json:"refresh-installer,omitempty"`
+	Snaps               []Snap                      `yaml:"snaps,omitempty" json:"snaps,omitempty"`
+	DebconfSelections   string                      `yaml:"debconf-selections,omitempty" json:"debconf-selections,omitempty"`
+	InteractiveSections []string                    `yaml:"interactive-sections,omitempty" json:"interactive-sections,omitempty"`
+	ErrorCommands       []string                    `yaml:"error-commands,omitempty" json:"error-commands,omitempty"`
+	Reporting           map[string]ReportingHandler `yaml:"reporting,omitempty" json:"reporting,omitempty"`
+	Proxy               string                      `yaml:"proxy,omitempty" json:"proxy,omitempty"`
+	OEM                 *OEMConfig                  `yaml:"oem,omitempty" json:"oem,omitempty"`
+	Codecs              *CodecsConfig               `yaml:"codecs,omitempty" json:"codecs,omitempty"`
+	ActiveDirectory     *ActiveDirectoryConfig      `yaml:"active-directory,omitempty" json:"active-directory,omitempty"`
+	UbuntuPro           *UbuntuProConfig            `yaml:"ubuntu-pro,omitempty" json:"ubuntu-pro,omitempty"`
 }
 
 type AptConfig struct {
@@ -68,7 +84,7 @@ type Identity struct {
 type NetworkConfig struct {
 	Renderer     string                 `yaml:"renderer" json:"renderer"` // network-renderer (e.g., "networkd", "NetworkManager")
 	Version      int                    `yaml:"version" json:"version"`
-	Ethernets    map[string]Ethernet    `yaml:"ethernets" json:"ethernets"`
+	Ethernets    map[string]Ethernet    `yaml:"ethernets,omitempty" json:"ethernets,omitempty"`
 	Wifis        map[string]Wifi        `yaml:"wifis,omitempty" json:"wifis,omitempty"`
 	Bridges      map[string]Bridge      `yaml:"bridges,omitempty" json:"bridges,omitempty"`
 	Bonds        map[string]Bond        `yaml:"bonds,omitempty" json:"bonds,omitempty"`
@@ -245,32 +261,50 @@ type SSHConfig struct {
 }
 
 type Storage struct {
-	Config []StorageConfig `yaml:"config" json:"config"`
+	Layout *StorageLayout  `yaml:"layout,omitempty" json:"layout,omitempty"` // A storage preset expanded into Config, or a Subiquity layout
+	Config []StorageConfig `yaml:"config,omitempty" json:"config"`
 	Swap   SwapConfig      `yaml:"swap" json:"swap"`
 	Grub   GrubConfig      `yaml:"grub" json:"grub"`
 }
 
 type StorageConfig struct {
-	Type       string     `yaml:"type" json:"type"`
-	ID         string     `yaml:"id" json:"id"`
-	Device     string     `yaml:"device,omitempty" json:"device,omitempty"`
-	Number     int        `yaml:"number,omitempty" json:"number,omitempty"`
-	Size       int64      `yaml:"size,omitempty" json:"size,omitempty"`
-	Flag       string     `yaml:"flag,omitempty" json:"flag,omitempty"`
-	GrubDevice bool       `yaml:"grub_device,omitempty" json:"grub_device,omitempty"`
-	Ptable     string     `yaml:"ptable,omitempty" json:"ptable,omitempty"`
-	Match      *DiskMatch `yaml:"match,omitempty" json:"match,omitempty"`
-	Name       string     `yaml:"name,omitempty" json:"name,omitempty"`
-	Preserve   bool       `yaml:"preserve" json:"preserve"`
-	Volume     string     `yaml:"volume,omitempty" json:"volume,omitempty"`
-	Fstype     string     `yaml:"fstype,omitempty" json:"fstype,omitempty"` // e.g. "ext4", "btrfs", "xfs"
-	Volgroup   string     `yaml:"volgroup,omitempty" json:"volgroup,omitempty"`
-	Path       string     `yaml:"path,omitempty" json:"path,omitempty"`
-	Devices    []string   `yaml:"devices,omitempty" json:"devices,omitempty"`
-	Key        string     `yaml:"key,omitempty" json:"key,omitempty"`         // For dm_crypt encryption
-	Dm_name    string     `yaml:"dm_name,omitempty" json:"dm_name,omitempty"` // For dm_crypt encryption
-	KeyFile    string     `yaml:"keyfile,omitempty" json:"keyfile,omitempty"` // For dm_crypt encryption
-	Wipe       string     `yaml:"wipe,omitempty" json:"wipe,omitempty"`       // Supported: superblock, superblock-recursive, pvremove, zero, random
+	Type           string            `yaml:"type" json:"type"`
+	ID             string            `yaml:"id" json:"id"`
+	Device         string            `yaml:"device,omitempty" json:"device,omitempty"`
+	Number         int               `yaml:"number,omitempty" json:"number,omitempty"`
+	Size           Size              `yaml:"size,omitempty" json:"size,omitempty" swaggertype:"string"` // e.g. 2147483648, "2G", "20%" or -1 for the remaining space
+	Flag           string            `yaml:"flag,omitempty" json:"flag,omitempty"`
+	GrubDevice     bool              `yaml:"grub_device,omitempty" json:"grub_device,omitempty"`
+	Ptable         string            `yaml:"ptable,omitempty" json:"ptable,omitempty"`
+	Match          *DiskMatch        `yaml:"match,omitempty" json:"match,omitempty"`
+	Name           string            `yaml:"name,omitempty" json:"name,omitempty"`
+	Preserve       bool              `yaml:"preserve" json:"preserve"`
+	Volume         string            `yaml:"volume,omitempty" json:"volume,omitempty"`
+	Fstype         string            `yaml:"fstype,omitempty" json:"fstype,omitempty"` // e.g. "ext4", "btrfs", "xfs"
+	Volgroup       string            `yaml:"volgroup,omitempty" json:"volgroup,omitempty"`
+	Path           string            `yaml:"path,omitempty" json:"path,omitempty"`
+	Devices        []string          `yaml:"devices,omitempty" json:"devices,omitempty"`
+	Key            string            `yaml:"key,omitempty" json:"key,omitempty"`                                  // For dm_crypt encryption
+	Dm_name        string            `yaml:"dm_name,omitempty" json:"dm_name,omitempty"`                          // For dm_crypt encryption
+	KeyFile        string            `yaml:"keyfile,omitempty" json:"keyfile,omitempty"`                          // For dm_crypt encryption
+	KeySource      string            `yaml:"key_source,omitempty" json:"key_source,omitempty"`                    // For dm_crypt instead of key: prompt or generate
+	KeyURL         string            `yaml:"key_url,omitempty" json:"key_url,omitempty"`                          // For dm_crypt, where the installer fetches a generated key
+	Wipe           string            `yaml:"wipe,omitempty" json:"wipe,omitempty"`                                // Supported: superblock, superblock-recursive, pvremove, zero, random
+	RAIDLevel      RAIDLevel         `yaml:"raidlevel,omitempty" json:"raidlevel,omitempty" swaggertype:"string"` // For raid: raid0, raid1, raid5, raid6 or raid10
+	SpareDevices   []string          `yaml:"spare_devices,omitempty" json:"spare_devices,omitempty"`              // For raid
+	BackingDevice  string            `yaml:"backing_device,omitempty" json:"backing_device,omitempty"`            // For bcache, the slow device
+	CacheDevice    string            `yaml:"cache_device,omitempty" json:"cache_device,omitempty"`                // For bcache, the fast device
+	CacheMode      string            `yaml:"cache_mode,omitempty" json:"cache_mode,omitempty"`                    // For bcache: writethrough, writeback, writearound or none
+	Pool           string            `yaml:"pool,omitempty" json:"pool,omitempty"`                                // Pool name of a zpool, zpool ID of a zfs dataset
+	Vdevs          []string          `yaml:"vdevs,omitempty" json:"vdevs,omitempty"`                              // For zpool
+	Mountpoint     string            `yaml:"mountpoint,omitempty" json:"mountpoint,omitempty"`                    // For zpool
+	PoolProperties map[string]string `yaml:"pool_properties,omitempty" json:"pool_properties,omitempty"`          // For zpool, e.g. {"ashift": "12"}
+	FSProperties   map[string]string `yaml:"fs_properties,omitempty" json:"fs_properties,omitempty"`              // For zpool, properties of its root dataset
+	Properties     map[string]string `yaml:"properties,omitempty" json:"properties,omitempty"`                    // For zfs datasets, e.g. {"mountpoint": "/home"}
+	NVMeController string            `yaml:"nvme_controller,omitempty" json:"nvme_controller,omitempty"`          // For disks, ID of their nvme_controller
+	Transport      string            `yaml:"transport,omitempty" json:"transport,omitempty"`                      // For nvme_controller: pcie or tcp
+	TCPAddr        string            `yaml:"tcp_addr,omitempty" json:"tcp_addr,omitempty"`                        // For nvme_controller over tcp
+	TCPPort        int               `yaml:"tcp_port,omitempty" json:"tcp_port,omitempty"`                        // For nvme_controller over tcp
 }
 
 type DiskMatch struct {
@@ -292,6 +326,146 @@ type GrubConfig struct {
 	ReorderUEFI bool `yaml:"reorder_uefi" json:"reorder_uefi"`
 }
 
+// SourceConfig selects the installation source, e.g. "ubuntu-server" or "ubuntu-server-minimal".
+type SourceConfig struct {
+	SearchDrivers bool   `yaml:"search_drivers" json:"search_drivers"`
+	ID            string `yaml:"id,omitempty" json:"id,omitempty"`
+}
+
+// RefreshInstallerConfig controls whether the installer updates itself before installing.
+type RefreshInstallerConfig struct {
+	Update  bool   `yaml:"update" json:"update"`
+	Channel string `yaml:"channel,omitempty" json:"channel,omitempty"` // e.g. "stable/ubuntu-$REL"
+}
+
+// Snap is a snap to install into the target system.
+type Snap struct {
+	Name    string `yaml:"name" json:"name"`
+	Channel string `yaml:"channel,omitempty" json:"channel,omitempty"` // default: stable
+	Classic bool   `yaml:"classic,omitempty" json:"classic,omitempty"`
+}
+
+// ReportingHandler sends installation progress to a destination.
+type ReportingHandler struct {
+	Type           string `yaml:"type" json:"type"`                                     // print, rsyslog, webhook or none
+	Endpoint       string `yaml:"endpoint,omitempty" json:"endpoint,omitempty"`         // webhook URL
+	Destination    string `yaml:"destination,omitempty" json:"destination,omitempty"`   // rsyslog destination, e.g. "@192.168.0.1"
+	Level          string `yaml:"level,omitempty" json:"level,omitempty"`               // minimum level, e.g. "INFO"
+	ConsumerKey    string `yaml:"consumer_key,omitempty" json:"consumer_key,omitempty"` // webhook OAuth
+	ConsumerSecret string `yaml:"consumer_secret,omitempty" json:"consumer_secret,omitempty"`
+	TokenKey       string `yaml:"token_key,omitempty" json:"token_key,omitempty"`
+	TokenSecret    string `yaml:"token_secret,omitempty" json:"token_secret,omitempty"`
+}
+
+// OEMConfig controls installation of OEM meta-packages.
+type OEMConfig struct {
+	Install AutoBool `yaml:"install" json:"install"`
+}
+
+// CodecsConfig controls installation of the ubuntu-restricted-addons package.
+type CodecsConfig struct {
+	Install bool `yaml:"install" json:"install"`
+}
+
+// ActiveDirectoryConfig joins the target system to an Active Directory domain.
+type ActiveDirectoryConfig struct {
+	AdminName  string `yaml:"admin-name,omitempty" json:"admin-name,omitempty"`
+	DomainName string `yaml:"domain-name,omitempty" json:"domain-name,omitempty"`
+}
+
+// UbuntuProConfig attaches the target system to Ubuntu Pro.
+type UbuntuProConfig struct {
+	Token string `yaml:"token,omitempty" json:"token,omitempty"`
+}
+
+// AutoBool is a boolean that may also be "auto", as oem.install is.
+type AutoBool string
+
+// AutoBool values.
+const (
+	AutoBoolAuto  AutoBool = "auto"
+	AutoBoolTrue  AutoBool = "true"
+	AutoBoolFalse AutoBool = "false"
+)
+
+// UnmarshalYAML accepts true, false and "auto".
+func (b *AutoBool) UnmarshalYAML(node *yaml.Node) error {
+	if node.Value == string(AutoBoolAuto) {
+		*b = AutoBoolAuto
+		return nil
+	}
+	var v bool
+	if err := node.Decode(&v); err != nil {
+		return fmt.Errorf("must be true, false or \"auto\"")
+	}
+	*b = autoBoolOf(v)
+	return nil
+}
+
+// MarshalYAML writes booleans as YAML booleans and "auto" as a string. An
+// unset value is written as "auto", the default of Subiquity.
+func (b AutoBool) MarshalYAML() (interface{}, error) {
+	if v, ok := b.bool(); ok {
+		return v, nil
+	}
+	return string(b.orAuto()), nil
+}
+
+// UnmarshalJSON accepts true, false and "auto".
+func (b *AutoBool) UnmarshalJSON(data []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	switch v := v.(type) {
+	case bool:
+		*b = autoBoolOf(v)
+	case string:
+		if v != string(AutoBoolAuto) && v != string(AutoBoolTrue) && v != string(AutoBoolFalse) {
+			return fmt.Errorf("must be true, false or \"auto\"")
+		}
+		*b = AutoBool(v)
+	case nil:
+		*b = ""
+	default:
+		return fmt.Errorf("must be true, false or \"auto\"")
+	}
+	return nil
+}
+
+// MarshalJSON writes booleans as JSON booleans and "auto", or an unset value,
+// as "auto".
+func (b AutoBool) MarshalJSON() ([]byte, error) {
+	if v, ok := b.bool(); ok {
+		return json.Marshal(v)
+	}
+	return json.Marshal(string(b.orAuto()))
+}
+
+func (b AutoBool) orAuto() AutoBool {
+	if b == "" {
+		return AutoBoolAuto
+	}
+	return b
+}
+
+func (b AutoBool) bool() (value, ok bool) {
+	switch b {
+	case AutoBoolTrue:
+		return true, true
+	case AutoBoolFalse:
+		return false, true
+	}
+	return false, false
+}
+
+func autoBoolOf(v bool) AutoBool {
+	if v {
+		return AutoBoolTrue
+	}
+	return AutoBoolFalse
+}
+
 // NewDefaultConfig returns a default Config suitable as a starting template.
 func NewDefaultConfig() *Config {
 	return &Config{
@@ -381,7 +555,7 @@ func NewDefaultConfig() *Config {
 						ID:       "bios-grub-part",
 						Device:   "disk0",
 						Number:   1,
-						Size:     1048576,
+						Size:     "1M",
 						Flag:     "bios_grub",
 						Preserve: false,
 						Wipe:     "superblock",
@@ -391,7 +565,7 @@ func NewDefaultConfig() *Config {
 						ID:       "boot-part",
 						Device:   "disk0",
 						Number:   2,
-						Size:     2147483648,
+						Size:     "2G",
 						Preserve: false,
 						Wipe:     "superblock",
 					},
@@ -407,7 +581,7 @@ func NewDefaultConfig() *Config {
 						ID:       "pv-part",
 						Device:   "disk0",
 						Number:   3,
-						Size:     -1,
+						Size:     SizeRemaining,
 						Preserve: false,
 						Wipe:     "superblock",
 					},
@@ -423,7 +597,7 @@ func NewDefaultConfig() *Config {
 						ID:       "lv-swap",
 						Volgroup: "vg0",
 						Name:     "swap",
-						Size:     1073741824,
+						Size:     "1G",
 						Preserve: false,
 						Wipe:     "superblock",
 					},
@@ -439,7 +613,7 @@ func NewDefaultConfig() *Config {
 						ID:       "lv-root",
 						Volgroup: "vg0",
 						Name:     "ubuntu-lv",
-						Size:     -1,
+						Size:     SizeRemaining,
 						Preserve: false,
 						Wipe:     "superblock",
 					},
@@ -450,13 +624,6 @@ func NewDefaultConfig() *Config {
 						Fstype:   "ext4",
 						Preserve: false,
 					},
-					{
-						Type:     "dm_crypt",
-						ID:       "dm_crypt-0",
-						Volume:   "lv-root",
-						Key:      "ubuntu",
-						Preserve: false,
-					},
 					{
 						Type:     "mount",
 						ID:       "mount-root",
@@ -489,6 +656,19 @@ func NewDefaultConfig() *Config {
 			Updates:  "security",
 			Shutdown: "reboot",
 			Packages: []string{},
+			Source: &SourceConfig{
+				ID:            "ubuntu-server",
+				SearchDrivers: false,
+			},
+			RefreshInstaller: &RefreshInstallerConfig{
+				Update: false,
+			},
+			OEM: &OEMConfig{
+				Install: AutoBoolAuto,
+			},
+			Codecs: &CodecsConfig{
+				Install: false,
+			},
 			LateCommands: []string{
 				"cp -rp /cdrom/mnt /target/",
 				"chmod +x /target/mnt/script/install-pkgs.sh",
@@ -540,57 +720,3 @@ func SaveConfig(config *Config, path string) error {
 
 	return nil
 }
-
-// Validate performs basic validation for top-level config.
-func (c *Config) Validate() error {
-	if c.Autoinstall.Version == 0 {
-		return fmt.Errorf("version must be non-zero")
-	}
-
-	if err := c.Autoinstall.Identity.Validate(); err != nil {
-		return fmt.Errorf("invalid identity config: %v", err)
-	}
-
-	if err := c.Autoinstall.Network.Validate(); err != nil {
-		return fmt.Errorf("invalid network config: %v", err)
-	}
-
-	if err := c.Autoinstall.Storage.Validate(); err != nil {
-		return fmt.Errorf("invalid storage config: %v", err)
-	}
-
-	return nil
-}
-
-// Validate performs basic checks for identity section.
-func (i *Identity) Validate() error {
-	if i.Username == "" {
-		return fmt.Errorf("username cannot be empty")
-	}
-	if i.Password == "" {
-		return fmt.Errorf("password cannot be empty")
-	}
-	if i.Hostname == "" {
-		return fmt.Errorf("hostname cannot be empty")
-	}
-	return nil
-}
-
-// Validate performs basic checks for network section.
-func (n *NetworkConfig) Validate() error {
-	if n.Version != 2 {
-		return fmt.Errorf("network config version must be 2")
-	}
-	if len(n.Ethernets) == 0 {
-		return fmt.Errorf("at least one ethernet interface is required")
-	}
-	return nil
-}
-
-// Validate performs basic checks for storage section.
-func (s *Storage) Validate() error {
-	if len(s.Config) == 0 {
-		return fmt.Errorf("at least one storage config is required")
-	}
-	return nil
-}
@@ -0,0 +1,148 @@
+package config
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+const fullAutoinstall = `autoinstall:
+  version: 1
+  identity:
+    hostname: host
+    password: secret
+    username: ubuntu
+  network:
+    version: 2
+    ethernets:
+      eth0:
+        dhcp4: true
+  storage:
+    config:
+      - type: disk
+        id: disk0
+        grub_device: true
+  source:
+    search_drivers: true
+    id: ubuntu-server-minimal
+  refresh-installer:
+    update: true
+    channel: latest/edge
+  snaps:
+    - name: lxd
+      channel: 5.21/stable
+    - name: code
+      classic: true
+  debconf-selections: |
+    bind9 bind9/run-resolvconf boolean false
+  interactive-sections:
+    - network
+  error-commands:
+    - tar c /var/log/installer | nc 192.168.0.1 1000
+  reporting:
+    hook:
+      type: webhook
+      endpoint: http://example.com/endpoint/path
+  proxy: http://squid.internal:3128/
+  oem:
+    install: auto
+  codecs:
+    install: true
+  active-directory:
+    admin-name: $ADMIN
+    domain-name: ad.example.com
+  ubuntu-pro:
+    token: C1NWcZTHLteJXGVMM6YhvHDpGrhyy7
+`
+
+// Test the Subiquity sections survive YAML -> JSON -> YAML, the path taken by
+// /config/load and /userdata/generate.
+func TestConfig_RoundTrip(t *testing.T) {
+	var cfg Config
+	require.NoError(t, yaml.Unmarshal([]byte(fullAutoinstall), &cfg))
+	require.NoError(t, cfg.Validate())
+
+	a := cfg.Autoinstall
+	assert.Equal(t, &SourceConfig{SearchDrivers: true, ID: "ubuntu-server-minimal"}, a.Source)
+	assert.Equal(t, &RefreshInstallerConfig{Update: true, Channel: "latest/edge"}, a.RefreshInstaller)
+	assert.Equal(t, []Snap{{Name: "lxd", Channel: "5.21/stable"}, {Name: "code", Classic: true}}, a.Snaps)
+	assert.Contains(t, a.DebconfSelections, "bind9/run-resolvconf")
+	assert.Equal(t, []string{"network"}, a.InteractiveSections)
+	assert.Len(t, a.ErrorCommands, 1)
+	assert.Equal(t, ReportingHandler{Type: "webhook", Endpoint: "http://example.com/endpoint/path"}, a.Reporting["hook"])
+	assert.Equal(t, "http://squid.internal:3128/", a.Proxy)
+	assert.Equal(t, AutoBoolAuto, a.OEM.Install)
+	assert.True(t, a.Codecs.Install)
+	assert.Equal(t, "ad.example.com", a.ActiveDirectory.DomainName)
+	assert.Equal(t, "C1NWcZTHLteJXGVMM6YhvHDpGrhyy7", a.UbuntuPro.Token)
+
+	data, err := json.Marshal(&cfg)
+	require.NoError(t, err)
+	var fromJSON Config
+	require.NoError(t, json.Unmarshal(data, &fromJSON))
+	assert.Equal(t, sections(cfg), sections(fromJSON))
+
+	out, err := yaml.Marshal(&fromJSON)
+	require.NoError(t, err)
+	var again Config
+	require.NoError(t, yaml.Unmarshal(out, &again))
+	assert.Equal(t, sections(cfg), sections(again))
+}
+
+// sections keeps the Subiquity sections that are omitted when unset.
+func sections(cfg Config) Autoinstall {
+	a := cfg.Autoinstall
+	return Autoinstall{
+		Source:              a.Source,
+		RefreshInstaller:    a.RefreshInstaller,
+		Snaps:               a.Snaps,
+		DebconfSelections:   a.DebconfSelections,
+		InteractiveSections: a.InteractiveSections,
+		ErrorCommands:       a.ErrorCommands,
+		Reporting:           a.Reporting,
+		Proxy:               a.Proxy,
+		OEM:                 a.OEM,
+		Codecs:              a.Codecs,
+		ActiveDirectory:     a.ActiveDirectory,
+		UbuntuPro:           a.UbuntuPro,
+	}
+}
+
+// Test oem.install accepts booleans and "auto" only.
+func TestAutoBool(t *testing.T) {
+	var oem OEMConfig
+	require.NoError(t, yaml.Unmarshal([]byte("install: false"), &oem))
+	assert.Equal(t, AutoBoolFalse, oem.Install)
+	out, err := yaml.Marshal(oem)
+	require.NoError(t, err)
+	assert.Equal(t, "install: false\n", string(out))
+
+	require.NoError(t, json.Unmarshal([]byte(`{"install":true}`), &oem))
+	assert.Equal(t, AutoBoolTrue, oem.Install)
+	assert.Error(t, yaml.Unmarshal([]byte("install: sometimes"), &oem))
+	assert.Error(t, json.Unmarshal([]byte(`{"install":1}`), &oem))
+}
+
+// Test an empty oem section is written with Subiquity's default "auto", which
+// the schema accepts, instead of an empty string.
+func TestAutoBool_EmptyOEM(t *testing.T) {
+	cfg := NewDefaultConfig()
+	cfg.Autoinstall.OEM = nil
+	require.NoError(t, json.Unmarshal([]byte(`{}`), &cfg.Autoinstall.OEM))
+	require.NotNil(t, cfg.Autoinstall.OEM)
+	assert.Equal(t, AutoBool(""), cfg.Autoinstall.OEM.Install)
+
+	data, err := yaml.Marshal(cfg)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "install: auto")
+	for _, release := range SchemaReleases {
+		assert.NoError(t, ValidateUserDataSchema(data, release), release)
+	}
+
+	out, err := json.Marshal(cfg.Autoinstall.OEM)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"install":"auto"}`, string(out))
+}
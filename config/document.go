@@ -0,0 +1,149 @@
+package config
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Document is a Config loaded from a hand-written YAML document. Marshal
+// writes the Config back into the document, so keys the Config does not
+// model, comments and the order of keys survive the round trip.
+type Document struct {
+	Config *Config
+
+	root *yaml.Node // The original document
+	base *yaml.Node // The Config as loaded, encoded, to tell removed keys from unknown ones
+}
+
+// LoadDocument parses a YAML document into a Config and keeps the document.
+func LoadDocument(data []byte) (*Document, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+	if root.Kind != yaml.DocumentNode || len(root.Content) == 0 || root.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("the document is not a YAML mapping")
+	}
+	var cfg Config
+	if err := root.Decode(&cfg); err != nil {
+		return nil, err
+	}
+	var base yaml.Node
+	if err := base.Encode(&cfg); err != nil {
+		return nil, err
+	}
+	return &Document{Config: &cfg, root: &root, base: &base}, nil
+}
+
+// Marshal encodes the Config into the original document: values the Config
+// changed are replaced, keys it removed are dropped, keys it added are
+// appended, and everything else is written as it was loaded.
+func (d *Document) Marshal() ([]byte, error) {
+	var edited yaml.Node
+	if err := edited.Encode(d.Config); err != nil {
+		return nil, err
+	}
+	root := *d.root
+	root.Content = []*yaml.Node{mergeNode(d.root.Content[0], d.base, &edited)}
+	return yaml.Marshal(&root)
+}
+
+// mergeNode returns edited with the comments, style and unknown keys of orig.
+// base is orig as the Config encodes it, or nil when it is not known.
+func mergeNode(orig, base, edited *yaml.Node) *yaml.Node {
+	if orig.Kind != edited.Kind {
+		return withComments(edited, orig)
+	}
+	switch edited.Kind {
+	case yaml.MappingNode:
+		return mergeMapping(orig, base, edited)
+	case yaml.SequenceNode:
+		out := *orig
+		out.Content = nil
+		for i, item := range edited.Content {
+			if i >= len(orig.Content) {
+				out.Content = append(out.Content, item)
+				continue
+			}
+			var baseItem *yaml.Node
+			if base != nil && base.Kind == yaml.SequenceNode && i < len(base.Content) {
+				baseItem = base.Content[i]
+			}
+			out.Content = append(out.Content, mergeNode(orig.Content[i], baseItem, item))
+		}
+		return &out
+	case yaml.ScalarNode:
+		if orig.Value == edited.Value && orig.ShortTag() == edited.ShortTag() {
+			return orig
+		}
+	}
+	return withComments(edited, orig)
+}
+
+// mergeMapping merges the keys of a mapping in the order of orig. Keys missing
+// in edited are dropped when the Config knows them, that is when they are in
+// base, and kept otherwise. New keys are appended unless they only hold the
+// value the Config encodes by default.
+func mergeMapping(orig, base, edited *yaml.Node) *yaml.Node {
+	out := *orig
+	out.Content = nil
+	seen := make(map[string]bool)
+	for i := 0; i+1 < len(orig.Content); i += 2 {
+		key, value := orig.Content[i], orig.Content[i+1]
+		seen[key.Value] = true
+		editedValue, baseValue := mappingValue(edited, key.Value), mappingValue(base, key.Value)
+		switch {
+		case editedValue != nil:
+			out.Content = append(out.Content, key, mergeNode(value, baseValue, editedValue))
+		case baseValue != nil:
+			// Removed from the Config
+		default:
+			out.Content = append(out.Content, key, value)
+		}
+	}
+	for i := 0; i+1 < len(edited.Content); i += 2 {
+		key, value := edited.Content[i], edited.Content[i+1]
+		if seen[key.Value] {
+			continue
+		}
+		if baseValue := mappingValue(base, key.Value); baseValue != nil && equalNodes(baseValue, value) {
+			continue
+		}
+		out.Content = append(out.Content, key, value)
+	}
+	return &out
+}
+
+// mappingValue returns the value of key in the mapping m, or nil.
+func mappingValue(m *yaml.Node, key string) *yaml.Node {
+	if m == nil || m.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return m.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// equalNodes reports whether a and b hold the same values.
+func equalNodes(a, b *yaml.Node) bool {
+	if a.Kind != b.Kind || a.ShortTag() != b.ShortTag() || a.Value != b.Value || len(a.Content) != len(b.Content) {
+		return false
+	}
+	for i := range a.Content {
+		if !equalNodes(a.Content[i], b.Content[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// withComments returns a copy of n with the comments of orig.
+func withComments(n, orig *yaml.Node) *yaml.Node {
+	out := *n
+	out.HeadComment, out.LineComment, out.FootComment = orig.HeadComment, orig.LineComment, orig.FootComment
+	return &out
+}
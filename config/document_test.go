@@ -0,0 +1,80 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const handWritten = `#cloud-config
+autoinstall:
+  version: 1
+  # Installed on every lab machine
+  identity:
+    hostname: lab01   # renamed by the build
+    username: ubuntu
+    password: $6$rounds=4096$salt$hash
+  kernel-crash-dumps:
+    enabled: true
+  late-commands:
+    - curtin in-target -- apt-get purge -y snapd
+  storage:
+    layout: {name: lvm, match: {serial: S3Z9NX0K}}
+    swap: {size: 0}
+  ssh: {install-server: true, allow-pw: false}
+  network:
+    version: 2
+    ethernets:
+      eno1: {dhcp4: true}
+`
+
+func TestDocument_RoundTrip(t *testing.T) {
+	doc, err := LoadDocument([]byte(handWritten))
+	require.NoError(t, err)
+	assert.Equal(t, "lab01", doc.Config.Autoinstall.Identity.Hostname)
+
+	data, err := doc.Marshal()
+	require.NoError(t, err)
+	out := string(data)
+	assert.Contains(t, out, "#cloud-config\nautoinstall:\n")
+	assert.Contains(t, out, "# Installed on every lab machine\n    identity:")
+	assert.Contains(t, out, "hostname: lab01 # renamed by the build")
+	assert.Contains(t, out, "kernel-crash-dumps:\n        enabled: true")
+	assert.Contains(t, out, "layout: {name: lvm, match: {serial: S3Z9NX0K}}")
+	assert.Contains(t, out, "swap: {size: 0}")
+	assert.Less(t, strings.Index(out, "version: 1"), strings.Index(out, "identity:"))
+	assert.Less(t, strings.Index(out, "identity:"), strings.Index(out, "kernel-crash-dumps:"))
+	assert.NotContains(t, out, "timezone:", "defaults the document leaves out are not added")
+}
+
+func TestDocument_Edits(t *testing.T) {
+	doc, err := LoadDocument([]byte(handWritten))
+	require.NoError(t, err)
+	a := &doc.Config.Autoinstall
+	a.Identity.Hostname = "lab02"
+	a.LateCommands = nil
+	a.Storage.Layout = nil
+	a.Storage.Config = []StorageConfig{{Type: StorageDisk, ID: "disk0", Ptable: "gpt"}}
+	a.Locale = "de_DE.UTF-8"
+
+	data, err := doc.Marshal()
+	require.NoError(t, err)
+	out := string(data)
+	assert.Contains(t, out, "hostname: lab02 # renamed by the build")
+	assert.Contains(t, out, "late-commands: []")
+	assert.NotContains(t, out, "layout:")
+	assert.Contains(t, out, "id: disk0")
+	assert.Contains(t, out, "swap: {size: 0}")
+	assert.Contains(t, out, "kernel-crash-dumps:")
+	assert.Contains(t, out, "locale: de_DE.UTF-8")
+	assert.Less(t, strings.Index(out, "network:"), strings.Index(out, "locale:"), "new keys are appended")
+}
+
+func TestLoadDocument_Invalid(t *testing.T) {
+	_, err := LoadDocument([]byte("- a\n- b\n"))
+	assert.Error(t, err)
+	_, err = LoadDocument([]byte("autoinstall: [\n"))
+	assert.Error(t, err)
+}
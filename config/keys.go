@@ -0,0 +1,123 @@
+package config
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// Sources of dm_crypt keys that keep the key out of the user-data.
+const (
+	// KeySourcePrompt stops the installer at the storage screen, where the
+	// passphrase is typed in on the console.
+	KeySourcePrompt = "prompt"
+	// KeySourceGenerate generates a random key at build time. The key is
+	// stored outside the ISO and the installer fetches it from key_url.
+	KeySourceGenerate = "generate"
+)
+
+// keyDir is where the installer stores fetched keyfiles.
+const keyDir = "/run/autoinstall-keys"
+
+// GeneratedKey is a dm_crypt key generated at build time. It is not part of
+// the user-data: it must be stored where the installer fetches it from, and it
+// is also the passphrase that unlocks the disk at boot.
+type GeneratedKey struct {
+	ID      string `json:"id"`      // ID of the dm_crypt action
+	Key     string `json:"key"`     // The passphrase
+	URL     string `json:"url"`     // Where the installer fetches the key from
+	Keyfile string `json:"keyfile"` // Where the installer stores the key
+}
+
+// checkKey validates how the key of a dm_crypt is provided.
+func (e *StorageConfig) checkKey(r *ValidationResult, at string, network *NetworkConfig) {
+	switch {
+	case e.KeySource != "" && (e.Key != "" || e.KeyFile != ""):
+		r.addError(at+"/key_source", CodeInvalidValue, fmt.Sprintf("dm_crypt %s has a key_source and a key or keyfile", e.ID), "remove key and keyfile")
+	case e.KeySource != "":
+		checkKeySource(r, at, e.KeySource, e.KeyURL, network)
+	case e.Key == "" && e.KeyFile == "":
+		r.addError(at+"/key", CodeRequired, fmt.Sprintf("dm_crypt %s has no key", e.ID), "set key_source to prompt or generate, or set a keyfile")
+	case e.Key != "" && e.KeyFile != "":
+		r.addError(at+"/keyfile", CodeInvalidValue, fmt.Sprintf("dm_crypt %s has both a key and a keyfile", e.ID), "")
+	}
+}
+
+// checkKeySource validates a key source and the URL of generated keys.
+// Generated keys are fetched in an early command, before the installer applies
+// network, so without DHCP the live installer has no network to fetch them.
+func checkKeySource(r *ValidationResult, at, source, keyURL string, network *NetworkConfig) {
+	switch source {
+	case KeySourcePrompt:
+	case KeySourceGenerate:
+		u, err := url.Parse(keyURL)
+		switch {
+		case keyURL == "":
+			r.addError(at+"/key_url", CodeRequired, "a generated key needs the key_url the installer fetches it from", "")
+		case err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || strings.ContainsAny(keyURL, "'\n"):
+			r.addError(at+"/key_url", CodeInvalidValue, fmt.Sprintf("key_url %q is not an http or https URL", keyURL), "")
+		}
+		if network != nil && !network.dhcp() {
+			r.addWarning(at+"/key_source", CodeNoDHCP,
+				"the generated key is fetched before the network config is applied, and no ethernet uses DHCP, so the installer may not reach key_url",
+				"enable dhcp4 or dhcp6 on an ethernet, or use key_source prompt")
+		}
+	default:
+		r.addError(at+"/key_source", CodeInvalidValue, fmt.Sprintf("unknown key_source %q", source), "use prompt or generate")
+	}
+}
+
+// plaintextKeyWarning warns that the key at path is embedded in the user-data.
+func plaintextKeyWarning(r *ValidationResult, path, what string) {
+	r.addWarning(path, CodePlaintextKey, what+" is stored in plain text in the user-data on the ISO",
+		"use key_source prompt or generate, a keyfile, or the tpm storage preset")
+}
+
+// ResolveStorageKeys replaces the key sources of the dm_crypt actions with
+// what the installer needs: generated keys become keyfiles the installer
+// fetches in an early command, and prompted keys make the storage section
+// interactive. The generated keys are returned, they are not part of the
+// autoinstall config. The storage layout must be expanded before.
+func (a *Autoinstall) ResolveStorageKeys() ([]GeneratedKey, error) {
+	var keys []GeneratedKey
+	prompt := false
+	for i := range a.Storage.Config {
+		e := &a.Storage.Config[i]
+		switch e.KeySource {
+		case "":
+			continue
+		case KeySourcePrompt:
+			prompt = true
+		case KeySourceGenerate:
+			key, err := generateKey()
+			if err != nil {
+				return nil, fmt.Errorf("failed to generate the key of dm_crypt %s: %w", e.ID, err)
+			}
+			keyfile := path.Join(keyDir, e.ID+".key")
+			a.EarlyCommands = append(a.EarlyCommands, fmt.Sprintf(
+				"mkdir -p %s && curl -fsS --retry 5 -o %s '%s' && chmod 600 %s", keyDir, keyfile, e.KeyURL, keyfile))
+			keys = append(keys, GeneratedKey{ID: e.ID, Key: key, URL: e.KeyURL, Keyfile: keyfile})
+			e.KeyFile = keyfile
+		default:
+			return nil, fmt.Errorf("dm_crypt %s has an unknown key_source %q", e.ID, e.KeySource)
+		}
+		e.KeySource, e.KeyURL = "", ""
+	}
+	if prompt && !containsString(a.InteractiveSections, "storage") && !containsString(a.InteractiveSections, "*") {
+		a.InteractiveSections = append(a.InteractiveSections, "storage")
+	}
+	return keys, nil
+}
+
+// generateKey returns a random passphrase of 32 characters that can also be
+// typed in at boot.
+func generateKey() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
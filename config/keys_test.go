@@ -0,0 +1,140 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestResolveStorageKeys(t *testing.T) {
+	a := &NewDefaultConfig().Autoinstall
+	keys, err := a.ResolveStorageKeys()
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+	assert.Empty(t, a.InteractiveSections, "the default config installs unattended")
+
+	a.Storage.Config = append(a.Storage.Config, StorageConfig{
+		Type: StorageDMCrypt, ID: "crypt-home", Volume: "lv-home", KeySource: KeySourcePrompt,
+	}, StorageConfig{
+		Type: StorageDMCrypt, ID: "crypt-data", Volume: "lv-data", KeySource: KeySourceGenerate, KeyURL: "https://keys.example.com/host1",
+	})
+
+	keys, err = a.ResolveStorageKeys()
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	assert.Equal(t, "crypt-data", keys[0].ID)
+	assert.Len(t, keys[0].Key, 32)
+	assert.Equal(t, "https://keys.example.com/host1", keys[0].URL)
+	assert.Equal(t, []string{"storage"}, a.InteractiveSections)
+	assert.Equal(t, []string{"mkdir -p /run/autoinstall-keys && curl -fsS --retry 5 -o /run/autoinstall-keys/crypt-data.key " +
+		"'https://keys.example.com/host1' && chmod 600 /run/autoinstall-keys/crypt-data.key"}, a.EarlyCommands)
+
+	for _, e := range a.Storage.Config {
+		assert.Empty(t, e.KeySource, e.ID)
+		assert.Empty(t, e.KeyURL, e.ID)
+	}
+	generated := a.Storage.Config[len(a.Storage.Config)-1]
+	assert.Equal(t, "/run/autoinstall-keys/crypt-data.key", generated.KeyFile)
+
+	other, err := a.ResolveStorageKeys()
+	require.NoError(t, err)
+	assert.Empty(t, other)
+}
+
+func TestStorage_Check_Keys(t *testing.T) {
+	codes := func(entry StorageConfig) map[string]string {
+		s := &Storage{Config: []StorageConfig{
+			{Type: StorageDisk, ID: "disk0", Ptable: "gpt", GrubDevice: true},
+			{Type: StoragePartition, ID: "part0", Device: "disk0", Number: 1, Size: SizeRemaining},
+			entry,
+		}}
+		r := &ValidationResult{}
+		s.check(r, "", nil)
+		out := make(map[string]string)
+		for _, issue := range r.Issues {
+			out[issue.Path] = issue.Code
+		}
+		return out
+	}
+	crypt := func(key, keyfile, source, url string) StorageConfig {
+		return StorageConfig{Type: StorageDMCrypt, ID: "crypt0", Volume: "part0", Key: key, KeyFile: keyfile, KeySource: source, KeyURL: url}
+	}
+
+	assert.Empty(t, codes(crypt("", "", KeySourcePrompt, "")))
+	assert.Empty(t, codes(crypt("", "", KeySourceGenerate, "http://10.0.0.1:8080/key")))
+	assert.Empty(t, codes(crypt("", "/etc/luks.key", "", "")))
+	assert.Equal(t, map[string]string{"/config/2/key": CodePlaintextKey}, codes(crypt("secret", "", "", "")))
+	assert.Equal(t, map[string]string{"/config/2/key": CodeRequired}, codes(crypt("", "", "", "")))
+	assert.Equal(t, map[string]string{"/config/2/key_source": CodeInvalidValue}, codes(crypt("", "", "tpm", "")))
+	assert.Equal(t, map[string]string{"/config/2/key_url": CodeRequired}, codes(crypt("", "", KeySourceGenerate, "")))
+	assert.Equal(t, map[string]string{"/config/2/key_url": CodeInvalidValue}, codes(crypt("", "", KeySourceGenerate, "ftp://example.com/key")))
+	assert.Equal(t, map[string]string{"/config/2/key_url": CodeInvalidValue}, codes(crypt("", "", KeySourceGenerate, "http://example.com/a'b")))
+	assert.Equal(t, map[string]string{"/config/2/key_source": CodeInvalidValue}, codes(crypt("", "/etc/luks.key", KeySourcePrompt, "")))
+}
+
+func TestStorageLayout_Keys(t *testing.T) {
+	check := func(layout *StorageLayout) map[string]string {
+		r := &ValidationResult{}
+		(&Storage{Layout: layout}).check(r, "", nil)
+		out := make(map[string]string)
+		for _, issue := range r.Issues {
+			out[issue.Path] = issue.Code
+		}
+		return out
+	}
+
+	assert.Equal(t, map[string]string{"/layout/password": CodePlaintextKey}, check(&StorageLayout{Preset: "lvm-luks", Password: "secret"}))
+	assert.Empty(t, check(&StorageLayout{Preset: "lvm-luks", KeySource: KeySourcePrompt}))
+	assert.Equal(t, map[string]string{"/layout/key_url": CodeRequired}, check(&StorageLayout{Preset: "lvm-luks", KeySource: KeySourceGenerate}))
+	assert.Equal(t, map[string]string{"/layout/key_source": CodeInvalidValue}, check(&StorageLayout{Preset: "lvm-luks", Password: "secret", KeySource: KeySourcePrompt}))
+	assert.Equal(t, map[string]string{"/layout/password": CodeInvalidValue}, check(&StorageLayout{Preset: "lvm", Password: "secret"}))
+	assert.Equal(t, map[string]string{"/layout/password": CodePlaintextKey}, check(&StorageLayout{Name: "lvm", Password: "secret"}))
+	assert.Empty(t, check(&StorageLayout{Preset: "tpm"}))
+	assert.Empty(t, check(&StorageLayout{Name: tpmLayout, Encrypted: true}))
+
+	s := &Storage{Layout: &StorageLayout{Preset: "lvm-luks", KeySource: KeySourceGenerate, KeyURL: "https://keys.example.com/k"}}
+	require.NoError(t, s.ExpandLayout())
+	for _, e := range s.Config {
+		if e.Type == StorageDMCrypt {
+			assert.Equal(t, KeySourceGenerate, e.KeySource)
+			assert.Equal(t, "https://keys.example.com/k", e.KeyURL)
+			assert.Empty(t, e.Key)
+		}
+	}
+}
+
+func TestStorageLayout_TPM(t *testing.T) {
+	cfg := NewDefaultConfig()
+	cfg.Autoinstall.Storage = Storage{Layout: &StorageLayout{Preset: "tpm", Match: &DiskMatch{Serial: "S3Z9NX0K"}}}
+	require.NoError(t, cfg.Autoinstall.Storage.ExpandLayout())
+	assert.True(t, cfg.Check().Valid())
+
+	data, err := yaml.Marshal(cfg)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "layout:\n            name: hybrid\n            match:\n                serial: S3Z9NX0K\n            encrypted: true\n")
+	assert.NotContains(t, string(data), "config:")
+	assert.NoError(t, ValidateUserDataSchema(data, "noble"))
+	assert.True(t, cfg.CheckRelease("noble").Valid())
+	assert.Equal(t, []Issue{{Path: "/autoinstall/storage/layout/name", Severity: SeverityError, Code: CodeInvalidValue,
+		Message: "the installer of jammy has no hybrid storage layout", Suggestion: "build for noble, or use another layout"}},
+		cfg.CheckRelease("jammy").Issues)
+}
+
+// Test generated keys warn when no ethernet uses DHCP: the installer fetches
+// them before it applies the static network config.
+func TestConfig_Check_GeneratedKeyNetwork(t *testing.T) {
+	cfg := NewDefaultConfig()
+	cfg.Autoinstall.Storage = Storage{Layout: &StorageLayout{Preset: "lvm-luks", KeySource: KeySourceGenerate, KeyURL: "https://keys.example.com/k"}}
+	r := cfg.Check()
+	assert.True(t, r.Valid())
+	require.Len(t, r.Warnings(), 1)
+	assert.Equal(t, "/autoinstall/storage/layout/key_source", r.Warnings()[0].Path)
+	assert.Equal(t, CodeNoDHCP, r.Warnings()[0].Code)
+
+	eth := cfg.Autoinstall.Network.Ethernets["ens160"]
+	eth.Dhcp4 = true
+	cfg.Autoinstall.Network.Ethernets["ens160"] = eth
+	assert.Empty(t, cfg.Check().Warnings())
+}
@@ -0,0 +1,251 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+// Issue codes of the netplan checks.
+const (
+	CodeDuplicateDevice    = "duplicate-device"
+	CodeUnknownDevice      = "unknown-device"
+	CodeInvalidAddress     = "invalid-address"
+	CodeUnreachableGateway = "unreachable-gateway"
+	CodeMemberAddress      = "member-address"
+	CodeRendererRequired   = "renderer-required"
+	CodeDuplicateTable     = "duplicate-table"
+)
+
+// rendererNetworkManager is the netplan renderer of NetworkManager.
+const rendererNetworkManager = "NetworkManager"
+
+// netDevice is a netplan device definition of any type.
+type netDevice struct {
+	kind   string  // Section of the network config, e.g. "bonds"
+	name   string  // Device ID
+	common *Common // Settings shared by most types, nil for vrfs and nm-devices
+}
+
+// path returns the JSON pointer of the device below the network config.
+func (d netDevice) path(network string) string {
+	return network + "/" + d.kind + "/" + d.name
+}
+
+// devices returns every device of the config, by section and name.
+func (n *NetworkConfig) devices() []netDevice {
+	var out []netDevice
+	add := func(kind string, names []string, common func(string) *Common) {
+		for _, name := range names {
+			out = append(out, netDevice{kind: kind, name: name, common: common(name)})
+		}
+	}
+	add("ethernets", sortedKeys(n.Ethernets), func(name string) *Common { c := n.Ethernets[name].Common; return &c })
+	add("wifis", sortedKeys(n.Wifis), func(name string) *Common { c := n.Wifis[name].Common; return &c })
+	add("bonds", sortedKeys(n.Bonds), func(name string) *Common { c := n.Bonds[name].Common; return &c })
+	add("bridges", sortedKeys(n.Bridges), func(name string) *Common { c := n.Bridges[name].Common; return &c })
+	add("vlans", sortedKeys(n.Vlans), func(name string) *Common { c := n.Vlans[name].Common; return &c })
+	add("tunnels", sortedKeys(n.Tunnels), func(name string) *Common { c := n.Tunnels[name].Common; return &c })
+	add("dummy-devices", sortedKeys(n.DummyDevices), func(name string) *Common { c := n.DummyDevices[name].Common; return &c })
+	add("modems", sortedKeys(n.Modems), func(name string) *Common { c := n.Modems[name].Common; return &c })
+	add("vrfs", sortedKeys(n.Vrfs), func(string) *Common { return nil })
+	add("nm-devices", sortedKeys(n.NMDevices), func(string) *Common { return nil })
+	return out
+}
+
+// sortedKeys returns the names of a device map in order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// dhcp reports whether an ethernet gets its address by DHCP, as the live
+// installer does before it applies the network config.
+func (n *NetworkConfig) dhcp() bool {
+	for _, eth := range n.Ethernets {
+		if eth.Dhcp4 || eth.Dhcp6 {
+			return true
+		}
+	}
+	return false
+}
+
+// checkDevices validates the devices of the network config as a whole: unique
+// names, references between devices, addresses, routes and the renderer.
+func (n *NetworkConfig) checkDevices(r *ValidationResult, path string) {
+	devices := n.devices()
+	if len(devices) == 0 {
+		r.addError(path, CodeRequired, "at least one network device is required", "add an ethernet, wifi, bond or bridge")
+		return
+	}
+
+	byName := make(map[string]netDevice)
+	for _, d := range devices {
+		if other, ok := byName[d.name]; ok {
+			r.addError(d.path(path), CodeDuplicateDevice, fmt.Sprintf("device %s is defined in %s and %s", d.name, other.kind, d.kind), "")
+			continue
+		}
+		byName[d.name] = d
+	}
+
+	members := n.checkMembers(r, path, byName)
+	for _, d := range devices {
+		if d.common == nil {
+			continue
+		}
+		at := d.path(path)
+		if master, ok := members[d.name]; ok {
+			if len(d.common.Addresses) > 0 || d.common.Dhcp4 || d.common.Dhcp6 {
+				r.addError(at, CodeMemberAddress, fmt.Sprintf("%s is a member of %s and must not have addresses or DHCP", d.name, master),
+					"configure the addresses on "+master)
+			}
+		} else if d.kind == "ethernets" && !d.common.Dhcp4 && !d.common.Dhcp6 && len(d.common.Addresses) == 0 && !d.common.Optional {
+			r.addWarning(at, CodeNoInterfaceIP, fmt.Sprintf("interface %s has neither DHCP nor a static address", d.name),
+				"enable dhcp4 or add an address")
+		}
+		d.common.check(r, at, d.name)
+	}
+
+	if n.Renderer != rendererNetworkManager {
+		for _, d := range devices {
+			if d.kind == "modems" || d.kind == "nm-devices" {
+				r.addError(d.path(path), CodeRendererRequired, fmt.Sprintf("%s %s needs the NetworkManager renderer", d.kind, d.name),
+					"set renderer to NetworkManager")
+			}
+		}
+	}
+
+	tables := make(map[int]string)
+	for _, name := range sortedKeys(n.Vrfs) {
+		vrf := n.Vrfs[name]
+		at := path + "/vrfs/" + name + "/table"
+		if other, ok := tables[vrf.Table]; ok {
+			r.addError(at, CodeDuplicateTable, fmt.Sprintf("vrfs %s and %s use the same table %d", other, name, vrf.Table), "give every VRF its own table")
+			continue
+		}
+		tables[vrf.Table] = name
+	}
+}
+
+// checkMembers checks the interfaces of bonds, bridges and VRFs and the links of
+// VLANs, and returns the bond and bridge members with the device they belong to.
+func (n *NetworkConfig) checkMembers(r *ValidationResult, path string, byName map[string]netDevice) map[string]string {
+	members := make(map[string]string)
+	ref := func(at, owner, name string) bool {
+		if _, ok := byName[name]; !ok {
+			r.addError(at, CodeUnknownDevice, fmt.Sprintf("%s refers to %s, which is not defined", owner, name), "")
+			return false
+		}
+		if name == owner {
+			r.addError(at, CodeInvalidReference, fmt.Sprintf("%s refers to itself", owner), "")
+			return false
+		}
+		return true
+	}
+	enslave := func(kind string, names []string, interfaces func(string) []string) {
+		for _, name := range names {
+			for i, member := range interfaces(name) {
+				at := fmt.Sprintf("%s/%s/%s/interfaces/%d", path, kind, name, i)
+				if !ref(at, name, member) {
+					continue
+				}
+				if other, ok := members[member]; ok {
+					r.addError(at, CodeInvalidReference, fmt.Sprintf("%s is a member of both %s and %s", member, other, name), "")
+					continue
+				}
+				members[member] = name
+			}
+		}
+	}
+	enslave("bonds", sortedKeys(n.Bonds), func(name string) []string { return n.Bonds[name].Interfaces })
+	enslave("bridges", sortedKeys(n.Bridges), func(name string) []string { return n.Bridges[name].Interfaces })
+
+	for _, name := range sortedKeys(n.Bonds) {
+		if len(n.Bonds[name].Interfaces) == 0 {
+			r.addError(path+"/bonds/"+name+"/interfaces", CodeRequired, fmt.Sprintf("bond %s has no interfaces", name), "")
+		}
+	}
+	for _, name := range sortedKeys(n.Vlans) {
+		vlan := n.Vlans[name]
+		at := path + "/vlans/" + name
+		if vlan.Link == "" {
+			r.addError(at+"/link", CodeRequired, fmt.Sprintf("vlan %s has no link", name), "")
+		} else {
+			ref(at+"/link", name, vlan.Link)
+		}
+		if vlan.ID < 0 || vlan.ID > 4094 {
+			r.addError(at+"/id", CodeInvalidValue, fmt.Sprintf("vlan %s has id %d, VLAN IDs are 0 to 4094", name, vlan.ID), "")
+		}
+	}
+	for _, name := range sortedKeys(n.Vrfs) {
+		for i, member := range n.Vrfs[name].Interfaces {
+			ref(fmt.Sprintf("%s/vrfs/%s/interfaces/%d", path, name, i), name, member)
+		}
+	}
+	return members
+}
+
+// check validates the addresses, nameservers and routes of a device. Gateways
+// must be in a subnet of the device, unless they are on-link, link-local or
+// the device gets its addresses from DHCP.
+func (c *Common) check(r *ValidationResult, at, name string) {
+	var subnets []*net.IPNet
+	for i, addr := range c.Addresses {
+		_, subnet, err := net.ParseCIDR(addr)
+		if err != nil {
+			r.addError(fmt.Sprintf("%s/addresses/%d", at, i), CodeInvalidAddress, fmt.Sprintf("address %q of %s is not in CIDR notation", addr, name),
+				"add the prefix length, e.g. 192.168.1.10/24")
+			continue
+		}
+		subnets = append(subnets, subnet)
+	}
+	if c.Nameservers != nil {
+		for i, addr := range c.Nameservers.Addresses {
+			if net.ParseIP(addr) == nil {
+				r.addError(fmt.Sprintf("%s/nameservers/addresses/%d", at, i), CodeInvalidAddress, fmt.Sprintf("nameserver %q of %s is not an IP address", addr, name), "")
+			}
+		}
+	}
+	for i, route := range c.Routes {
+		rat := fmt.Sprintf("%s/routes/%d", at, i)
+		if route.To != "default" && !isCIDROrIP(route.To) {
+			r.addError(rat+"/to", CodeInvalidAddress, fmt.Sprintf("route destination %q of %s is neither default nor a CIDR", route.To, name), "")
+		}
+		if route.Via == "" {
+			continue
+		}
+		via := net.ParseIP(route.Via)
+		switch {
+		case via == nil:
+			r.addError(rat+"/via", CodeInvalidAddress, fmt.Sprintf("gateway %q of %s is not an IP address", route.Via, name), "")
+		case route.OnLink || via.IsLinkLocalUnicast() || c.Dhcp4 || c.Dhcp6 || len(subnets) < len(c.Addresses):
+		case !reachable(via, subnets):
+			r.addError(rat+"/via", CodeUnreachableGateway, fmt.Sprintf("gateway %s of %s is not in any subnet of its addresses", route.Via, name),
+				"add an address in the gateway's subnet or set on-link")
+		}
+	}
+}
+
+// isCIDROrIP reports whether s is a network in CIDR notation or a single IP address.
+func isCIDROrIP(s string) bool {
+	if strings.Contains(s, "/") {
+		_, _, err := net.ParseCIDR(s)
+		return err == nil
+	}
+	return net.ParseIP(s) != nil
+}
+
+// reachable reports whether ip is in one of the subnets.
+func reachable(ip net.IP, subnets []*net.IPNet) bool {
+	for _, subnet := range subnets {
+		if subnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,114 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+// networkIssues returns the codes of the issues of a network config by path.
+func networkIssues(t *testing.T, data string) map[string]string {
+	var n NetworkConfig
+	require.NoError(t, yaml.Unmarshal([]byte(data), &n))
+	r := &ValidationResult{}
+	n.check(r, "")
+	out := make(map[string]string)
+	for _, issue := range r.Issues {
+		out[issue.Path] = issue.Code
+	}
+	return out
+}
+
+func TestNetworkConfig_Valid(t *testing.T) {
+	assert.Empty(t, networkIssues(t, `
+version: 2
+wifis:
+  wlan0:
+    dhcp4: true
+    access-points:
+      home: {password: secret}
+`))
+	assert.Empty(t, networkIssues(t, `
+version: 2
+ethernets:
+  eno1: {}
+  eno2: {}
+bonds:
+  bond0:
+    interfaces: [eno1, eno2]
+    addresses: [10.0.0.5/24, "2001:db8::5/64"]
+    routes:
+      - {to: default, via: 10.0.0.1}
+      - {to: "::/0", via: "fe80::1"}
+      - {to: 172.16.0.0/12, via: 192.168.9.1, on-link: true}
+vlans:
+  vlan10:
+    id: 10
+    link: bond0
+    dhcp4: true
+    routes:
+      - {to: 10.10.0.0/16, via: 10.10.0.1}
+vrfs:
+  blue: {table: 100, interfaces: [vlan10]}
+`))
+}
+
+func TestNetworkConfig_Invalid(t *testing.T) {
+	assert.Equal(t, map[string]string{
+		"/version": CodeInvalidValue,
+		"":         CodeRequired,
+	}, networkIssues(t, `version: 1`))
+
+	assert.Equal(t, map[string]string{
+		"/bonds/bond0/interfaces/1":            CodeUnknownDevice,
+		"/ethernets/eno1":                      CodeMemberAddress,
+		"/bridges/br0/interfaces/0":            CodeInvalidReference,
+		"/vlans/vlan10/link":                   CodeUnknownDevice,
+		"/vlans/vlan10/id":                     CodeInvalidValue,
+		"/vlans/eno1":                          CodeDuplicateDevice,
+		"/modems/cdc0/addresses/0":             CodeInvalidAddress,
+		"/bridges/br0/routes/0/via":            CodeUnreachableGateway,
+		"/bridges/br0/routes/1/to":             CodeInvalidAddress,
+		"/bridges/br0/nameservers/addresses/0": CodeInvalidAddress,
+		"/modems/cdc0":                         CodeRendererRequired,
+		"/vrfs/red/table":                      CodeDuplicateTable,
+	}, networkIssues(t, `
+version: 2
+renderer: networkd
+ethernets:
+  eno1: {addresses: [10.0.0.5/24]}
+bonds:
+  bond0: {interfaces: [eno1, eno9]}
+bridges:
+  br0:
+    interfaces: [eno1]
+    addresses: [192.168.2.10/24]
+    nameservers: {addresses: [dns.example.com]}
+    routes:
+      - {to: default, via: 192.168.1.1}
+      - {to: 10.0.0.0/33, via: 192.168.2.1}
+vlans:
+  vlan10: {id: 5000, link: eth7}
+  eno1: {id: 20, link: bond0}
+modems:
+  cdc0: {apn: internet, addresses: [10.1.1.1]}
+vrfs:
+  blue: {table: 100}
+  red: {table: 100}
+`))
+}
+
+func TestNetworkConfig_NetworkManager(t *testing.T) {
+	assert.Empty(t, networkIssues(t, `
+version: 2
+renderer: NetworkManager
+modems:
+  cdc0: {apn: internet, dhcp4: true}
+nm-devices:
+  vpn0:
+    name: office
+    passthrough: {connection.type: vpn}
+`))
+}
@@ -0,0 +1,98 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/lefeck/ubuntu-autoinstaller/utils"
+)
+
+// Issue codes of the password checks.
+const (
+	CodeWeakPassword = "weak-password"
+	CodeInvalidHash  = "invalid-hash"
+)
+
+// PasswordPolicy sets the minimum strength of the plaintext passwords of the
+// identity and the accounts; crypt hashes are accepted as they are. The policy
+// is only used for validation and is not part of the user-data.
+type PasswordPolicy struct {
+	MinLength      int  `yaml:"min-length,omitempty" json:"min-length,omitempty"`
+	MinClasses     int  `yaml:"min-classes,omitempty" json:"min-classes,omitempty"`         // Of lowercase letters, uppercase letters, digits and other characters
+	RejectUsername bool `yaml:"reject-username,omitempty" json:"reject-username,omitempty"` // The password must not contain the user name
+	LockIdentity   bool `yaml:"lock-identity,omitempty" json:"lock-identity,omitempty"`     // Lock the identity password, the user logs in with SSH keys only
+}
+
+// lockedPassword is the identity password of a locked identity. It is not a
+// crypt hash, so no password matches it.
+const lockedPassword = "!"
+
+// ApplyPasswordPolicy locks the identity password when the policy says so and
+// removes the policy from the config. Call it after the identity password is
+// hashed.
+func (a *Autoinstall) ApplyPasswordPolicy() {
+	policy := a.PasswordPolicy
+	a.PasswordPolicy = nil
+	if policy != nil && policy.LockIdentity {
+		a.Identity.Password = lockedPassword
+	}
+}
+
+// check validates the rules of the policy.
+func (p *PasswordPolicy) check(r *ValidationResult, path string) {
+	if p.MinLength < 0 {
+		r.addError(path+"/min-length", CodeInvalidValue, "min-length must not be negative", "")
+	}
+	if p.MinClasses < 0 || p.MinClasses > 4 {
+		r.addError(path+"/min-classes", CodeInvalidValue, "min-classes must be 0 to 4",
+			"count lowercase letters, uppercase letters, digits and other characters")
+	}
+}
+
+// checkPassword validates the password of a user: a crypt hash must be
+// complete and of a supported algorithm, and plaintext must meet the policy,
+// which may be nil. Messages never contain the password.
+func checkPassword(r *ValidationResult, at, username, password string, policy *PasswordPolicy) {
+	switch {
+	case password == "":
+	case utils.CryptHashAlgorithm(password) == utils.CryptMD5:
+		r.addWarning(at, CodeWeakPassword, fmt.Sprintf("the password of %s is an MD5 crypt hash, which is easily cracked", username),
+			"use a SHA-512 or yescrypt hash, or the plaintext password")
+	case utils.IsCryptHash(password):
+	case utils.LooksLikeCryptHash(password):
+		r.addError(at, CodeInvalidHash, fmt.Sprintf("the password of %s looks like a crypt hash, but is not a complete $1$, $5$, $6$ or $y$ hash", username),
+			"fix the hash, or give the plaintext password to have it hashed")
+	case policy != nil:
+		if n := utf8.RuneCountInString(password); n < policy.MinLength {
+			r.addError(at, CodeWeakPassword, fmt.Sprintf("the password of %s has %d characters, the password policy requires %d", username, n, policy.MinLength), "")
+		}
+		if n := passwordClasses(password); n < policy.MinClasses {
+			r.addError(at, CodeWeakPassword, fmt.Sprintf("the password of %s has %d kinds of characters, the password policy requires %d", username, n, policy.MinClasses),
+				"mix lowercase and uppercase letters, digits and other characters")
+		}
+		if policy.RejectUsername && username != "" && strings.Contains(strings.ToLower(password), strings.ToLower(username)) {
+			r.addError(at, CodeWeakPassword, fmt.Sprintf("the password of %s contains the user name", username), "")
+		}
+	}
+}
+
+// passwordClasses counts the kinds of characters of a password: lowercase
+// letters, uppercase letters, digits and others.
+func passwordClasses(password string) int {
+	var lower, upper, digit, other int
+	for _, c := range password {
+		switch {
+		case unicode.IsLower(c):
+			lower = 1
+		case unicode.IsUpper(c):
+			upper = 1
+		case unicode.IsDigit(c):
+			digit = 1
+		default:
+			other = 1
+		}
+	}
+	return lower + upper + digit + other
+}
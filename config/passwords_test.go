@@ -0,0 +1,76 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckPassword(t *testing.T) {
+	policy := &PasswordPolicy{MinLength: 10, MinClasses: 3, RejectUsername: true}
+	tests := []struct {
+		name     string
+		password string
+		policy   *PasswordPolicy
+		codes    []string
+	}{
+		{"empty", "", policy, nil},
+		{"sha512 hash", "$6$saltstring$adDbXsJjcDlq2662QPgd.tkSOVmnG9Tt3oXl4HR60SusC3AGjirnDenVZp3DGwLwqy6iYKCzannhaX9DR72nN1", policy, nil},
+		{"sha256 hash", "$5$rounds=10000$saltstring$BXKRfHOWGOryjAm0GVQk8VRJRERBkg4gV1V0f0ddop.", policy, nil},
+		{"md5 hash", "$1$saltstri$qQY4WxjABChYG1ccLpfkz/", policy, []string{CodeWeakPassword}},
+		{"truncated hash", "$5$saltstring$BXKRf", nil, []string{CodeInvalidHash}},
+		{"bcrypt hash", "$2b$12$R9h/cIPz0gi.URNNX3kh2OPST9/PgBkqquzi.Ss7KIUgO2t0jWMUW", nil, []string{CodeInvalidHash}},
+		{"plaintext without policy", "secret", nil, nil},
+		{"strong", "Correct-Horse-7", policy, nil},
+		{"short and simple", "secret", policy, []string{CodeWeakPassword, CodeWeakPassword}},
+		{"contains user name", "Alice-1234567", policy, []string{CodeWeakPassword}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &ValidationResult{}
+			checkPassword(r, "/password", "alice", tt.password, tt.policy)
+			var codes []string
+			for _, issue := range r.Issues {
+				codes = append(codes, issue.Code)
+				assert.NotContains(t, issue.Message, tt.password+" ")
+			}
+			assert.Equal(t, tt.codes, codes)
+		})
+	}
+}
+
+func TestPasswordPolicy_Check(t *testing.T) {
+	cfg := NewDefaultConfig()
+	a := &cfg.Autoinstall
+	a.PasswordPolicy = &PasswordPolicy{MinLength: -1, MinClasses: 5, LockIdentity: true}
+	issues := make(map[string]string)
+	for _, issue := range cfg.Check().Issues {
+		issues[issue.Path] = issue.Code
+	}
+	const at = "/autoinstall/password-policy"
+	assert.Equal(t, CodeInvalidValue, issues[at+"/min-length"])
+	assert.Equal(t, CodeInvalidValue, issues[at+"/min-classes"])
+	assert.Equal(t, CodeNoSSHLogin, issues[at+"/lock-identity"])
+	assert.Equal(t, CodeInvalidValue, issues["/autoinstall/identity/password"])
+}
+
+func TestAutoinstall_ApplyPasswordPolicy(t *testing.T) {
+	cfg := NewDefaultConfig()
+	a := &cfg.Autoinstall
+	a.Identity.Password = ""
+	a.SSH.AuthorizedKeys = []string{"ssh-ed25519 AAAA user@example.com"}
+	a.PasswordPolicy = &PasswordPolicy{LockIdentity: true}
+	assert.True(t, cfg.Check().Valid())
+
+	a.ApplyPasswordPolicy()
+	assert.Nil(t, a.PasswordPolicy)
+	assert.Equal(t, "!", a.Identity.Password)
+
+	a.UserData = &CloudConfig{Users: []CloudUser{{Name: "carol"}}}
+	a.MergeIdentity()
+	require.Len(t, a.UserData.Users, 2)
+	user := a.UserData.Users[0]
+	assert.True(t, *user.LockPasswd)
+	assert.Empty(t, user.Passwd)
+}
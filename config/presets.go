@@ -0,0 +1,478 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+)
+
+// CodeUnknownPreset is the issue code of a layout naming no storage preset.
+const CodeUnknownPreset = "unknown-preset"
+
+// StorageLayout is the `layout:` of the storage section. With preset it names
+// a storage preset and the disks and sizes to use, and is expanded into the
+// full list of storage actions before user-data is generated. With name it is
+// one of Subiquity's own layouts, which is passed on as it is.
+type StorageLayout struct {
+	Preset    string          `yaml:"preset,omitempty" json:"preset,omitempty"`         // Storage preset, e.g. "lvm-luks", see StoragePresets
+	Name      string          `yaml:"name,omitempty" json:"name,omitempty"`             // Subiquity layout: direct, lvm, zfs or hybrid
+	Match     *DiskMatch      `yaml:"match,omitempty" json:"match,omitempty"`           // Disk to install to, the largest one by default
+	Disks     []DiskMatch     `yaml:"disks,omitempty" json:"disks,omitempty"`           // Further disks of multi-disk presets, in order
+	Sizes     map[string]Size `yaml:"sizes,omitempty" json:"sizes,omitempty"`           // Sizes overriding the preset defaults by name, e.g. {"boot": "1G"}
+	Password  string          `yaml:"password,omitempty" json:"password,omitempty"`     // Passphrase of encrypted presets and of Subiquity's lvm and zfs layouts
+	KeySource string          `yaml:"key_source,omitempty" json:"key_source,omitempty"` // Source of the passphrase of encrypted presets instead of password: prompt or generate
+	KeyURL    string          `yaml:"key_url,omitempty" json:"key_url,omitempty"`       // URL the installer fetches a generated passphrase from
+	Encrypted bool            `yaml:"encrypted,omitempty" json:"encrypted,omitempty"`   // Seal the key of Subiquity's hybrid layout in the TPM
+
+	Extra map[string]interface{} `yaml:",inline" json:"-"` // Other keys of Subiquity layouts, e.g. sizing-policy
+}
+
+// UnmarshalJSON reads the typed keys and keeps the others in Extra.
+func (l *StorageLayout) UnmarshalJSON(data []byte) error {
+	type plain StorageLayout
+	extra, err := unmarshalWithExtra(data, (*plain)(l))
+	l.Extra = extra
+	return err
+}
+
+// MarshalJSON writes the typed keys and those in Extra.
+func (l StorageLayout) MarshalJSON() ([]byte, error) {
+	type plain StorageLayout
+	return marshalWithExtra(plain(l), l.Extra)
+}
+
+// tpmLayout is the Subiquity layout that encrypts the disk with a key sealed
+// in the TPM. Subiquity supports it since noble.
+const tpmLayout = "hybrid"
+
+// subiquityLayouts are the layouts Subiquity partitions on its own.
+var subiquityLayouts = []string{"direct", "lvm", "zfs", tpmLayout}
+
+// layoutReleases are the releases whose Subiquity has a layout, for the
+// layouts older releases lack.
+var layoutReleases = map[string][]string{
+	"zfs":     {"noble"},
+	tpmLayout: {"noble"},
+}
+
+// StoragePreset is a named storage layout.
+type StoragePreset struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Disks       int             `json:"disks"`            // Number of disks the layout uses
+	Matches     []*DiskMatch    `json:"matches"`          // Default matches of the disks, null for disks the layout must select
+	Encrypted   bool            `json:"encrypted"`        // The layout needs a password
+	Native      string          `json:"native,omitempty"` // Subiquity layout the preset is passed on as, instead of storage actions
+	Sizes       map[string]Size `json:"sizes"`            // Default sizes by name
+	Config      []StorageConfig `json:"config"`           // Storage actions with the default disks and sizes
+
+	build func(b *presetBuilder)
+}
+
+// storagePresets are the presets by name. Sizes named "root" take the rest of
+// the space by default; a "swap" size of 0 leaves out the swap volume.
+var storagePresets = map[string]*StoragePreset{
+	"direct": {
+		Description: "A single ext4 root partition",
+		Matches:     []*DiskMatch{{Size: "largest"}},
+		Sizes:       map[string]Size{"root": SizeRemaining},
+		build: func(b *presetBuilder) {
+			b.disk(0, true)
+			b.biosGrub("disk0", "bios-grub-part", 1)
+			b.partition("disk0", "root-part", 2, "root")
+			b.filesystem("root", "root-part", "ext4", "/")
+		},
+	},
+	"lvm": {
+		Description: "A /boot partition and an LVM volume group with swap and root volumes",
+		Matches:     []*DiskMatch{{Size: "largest"}},
+		Sizes:       map[string]Size{"boot": "2G", "swap": "1G", "root": SizeRemaining},
+		build: func(b *presetBuilder) {
+			b.bootDisk()
+			b.lvm("pv-part", "swap", "root")
+		},
+	},
+	"lvm-luks": {
+		Description: "Like lvm, with the volume group on a LUKS encrypted partition",
+		Matches:     []*DiskMatch{{Size: "largest"}},
+		Encrypted:   true,
+		Sizes:       map[string]Size{"boot": "2G", "swap": "1G", "root": SizeRemaining},
+		build: func(b *presetBuilder) {
+			b.bootDisk()
+			b.add(StorageConfig{Type: StorageDMCrypt, ID: "dm_crypt-0", Volume: "pv-part", Dm_name: "dm_crypt-0",
+				Key: b.layout.Password, KeySource: b.layout.KeySource, KeyURL: b.layout.KeyURL})
+			b.lvm("dm_crypt-0", "swap", "root")
+		},
+	},
+	"raid1": {
+		Description: "Software RAID1 mirrors of /boot and / on two disks, both bootable",
+		Matches:     []*DiskMatch{{Size: "largest"}, {Size: "smallest"}},
+		Sizes:       map[string]Size{"boot": "2G", "root": SizeRemaining},
+		build: func(b *presetBuilder) {
+			b.mirrored("raid1")
+		},
+	},
+	"raid10": {
+		Description: "A RAID1 mirror of /boot and a RAID10 array for / on four disks, all bootable",
+		Matches:     []*DiskMatch{{Size: "largest"}, nil, nil, nil},
+		Sizes:       map[string]Size{"boot": "2G", "root": SizeRemaining},
+		build: func(b *presetBuilder) {
+			b.mirrored("raid10")
+		},
+	},
+	"bcache": {
+		Description: "A root filesystem on a hard disk with an SSD as bcache cache",
+		Matches:     []*DiskMatch{{Size: "largest", SSD: boolPtr(false)}, {Size: "largest", SSD: boolPtr(true)}},
+		Sizes:       map[string]Size{"boot": "2G", "root": SizeRemaining, "cache": SizeRemaining},
+		build: func(b *presetBuilder) {
+			b.disk(0, true)
+			b.biosGrub("disk0", "bios-grub-part", 1)
+			b.partition("disk0", "boot-part", 2, "boot")
+			b.partition("disk0", "root-part", 3, "root")
+			b.disk(1, false)
+			b.partition("disk1", "cache-part", 1, "cache")
+			b.add(StorageConfig{Type: StorageBcache, ID: "bcache0", Name: "bcache0", BackingDevice: "root-part", CacheDevice: "cache-part", CacheMode: "writethrough"})
+			b.filesystem("boot", "boot-part", "ext4", "/boot")
+			b.filesystem("root", "bcache0", "ext4", "/")
+		},
+	},
+	"zfs": {
+		Description: "A /boot partition and a ZFS pool for / with a dataset for /home",
+		Matches:     []*DiskMatch{{Size: "largest"}},
+		Sizes:       map[string]Size{"boot": "2G", "root": SizeRemaining},
+		build: func(b *presetBuilder) {
+			b.disk(0, true)
+			b.biosGrub("disk0", "bios-grub-part", 1)
+			b.partition("disk0", "boot-part", 2, "boot")
+			b.partition("disk0", "rpool-part", 3, "root")
+			b.filesystem("boot", "boot-part", "ext4", "/boot")
+			b.add(StorageConfig{
+				Type:           StorageZpool,
+				ID:             "rpool",
+				Pool:           "rpool",
+				Vdevs:          []string{"rpool-part"},
+				Mountpoint:     "/",
+				PoolProperties: map[string]string{"ashift": "12"},
+				FSProperties:   map[string]string{"compression": "lz4", "acltype": "posixacl", "xattr": "sa", "relatime": "on"},
+			})
+			b.add(StorageConfig{Type: StorageZFS, ID: "zfs-home", Pool: "rpool", Volume: "/home", Properties: map[string]string{"mountpoint": "/home"}})
+		},
+	},
+	"var-home": {
+		Description: "Like lvm, with separate volumes for /var and /home",
+		Matches:     []*DiskMatch{{Size: "largest"}},
+		Sizes:       map[string]Size{"boot": "2G", "swap": "1G", "root": "40%", "var": "20%", "home": SizeRemaining},
+		build: func(b *presetBuilder) {
+			b.bootDisk()
+			b.lvm("pv-part", "swap", "root", "var", "home")
+		},
+	},
+	"tpm": {
+		Description: "Subiquity's TPM-backed full disk encryption, the key is sealed in the TPM and never stored in the user-data (noble and later)",
+		Matches:     []*DiskMatch{{Size: "largest"}},
+		Sizes:       map[string]Size{},
+		Native:      tpmLayout,
+	},
+	"uefi": {
+		Description: "An EFI system partition for UEFI-only machines, /boot and an ext4 root partition",
+		Matches:     []*DiskMatch{{Size: "largest"}},
+		Sizes:       map[string]Size{"esp": "1G", "boot": "2G", "root": SizeRemaining},
+		build: func(b *presetBuilder) {
+			b.disk(0, false)
+			b.add(StorageConfig{Type: StoragePartition, ID: "esp-part", Device: "disk0", Number: 1, Size: b.size("esp"), Flag: "boot", GrubDevice: true, Wipe: "superblock"})
+			b.partition("disk0", "boot-part", 2, "boot")
+			b.partition("disk0", "root-part", 3, "root")
+			b.filesystem("esp", "esp-part", "fat32", "/boot/efi")
+			b.filesystem("boot", "boot-part", "ext4", "/boot")
+			b.filesystem("root", "root-part", "ext4", "/")
+		},
+	},
+}
+
+func boolPtr(v bool) *bool {
+	return &v
+}
+
+// StoragePresets returns the storage presets sorted by name, each with the
+// storage actions it expands to by default.
+func StoragePresets() []StoragePreset {
+	presets := make([]StoragePreset, 0, len(storagePresets))
+	for name, p := range storagePresets {
+		preset := *p
+		preset.Name = name
+		preset.Disks = len(p.Matches)
+		preset.Config = preset.expand(&StorageLayout{Preset: name})
+		presets = append(presets, preset)
+	}
+	sort.Slice(presets, func(i, j int) bool { return presets[i].Name < presets[j].Name })
+	return presets
+}
+
+// presetNames returns the names of the storage presets.
+func presetNames() []string {
+	names := make([]string, 0, len(storagePresets))
+	for name := range storagePresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// expand builds the storage actions of the preset for layout. Native presets
+// have none.
+func (p *StoragePreset) expand(layout *StorageLayout) []StorageConfig {
+	if p.build == nil {
+		return nil
+	}
+	b := &presetBuilder{layout: layout, preset: p}
+	p.build(b)
+	return b.config
+}
+
+// check validates a preset layout against its preset, or the keys of a
+// Subiquity layout.
+func (l *StorageLayout) check(r *ValidationResult, path string, network *NetworkConfig) {
+	switch {
+	case l.Preset != "" && l.Name != "":
+		r.addError(path+"/name", CodeInvalidValue, fmt.Sprintf("layout has both the preset %s and the Subiquity layout %s", l.Preset, l.Name),
+			"remove preset or name")
+		return
+	case l.Preset == "":
+		l.checkSubiquity(r, path)
+		return
+	}
+	preset, ok := storagePresets[l.Preset]
+	if !ok {
+		r.addError(path+"/preset", CodeUnknownPreset, fmt.Sprintf("unknown storage preset %q", l.Preset),
+			"use one of "+joinOr(presetNames()))
+		return
+	}
+	for _, key := range sortedKeys(l.Extra) {
+		r.addError(path+"/"+key, CodeInvalidValue, fmt.Sprintf("storage preset %s has no setting %q", l.Preset, key), "")
+	}
+	if l.Encrypted {
+		r.addError(path+"/encrypted", CodeInvalidValue, fmt.Sprintf("encrypted is a setting of Subiquity's %s layout, not of storage presets", tpmLayout),
+			"use the lvm-luks or tpm preset")
+	}
+	names := make([]string, 0, len(l.Sizes))
+	for name := range l.Sizes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		at := path + "/sizes/" + name
+		if _, ok := preset.Sizes[name]; !ok {
+			r.addError(at, CodeInvalidValue, fmt.Sprintf("storage preset %s has no size %q", l.Preset, name), "")
+		} else if err := l.Sizes[name].Validate(); err != nil {
+			r.addError(at, CodeInvalidSize, err.Error(), "")
+		}
+	}
+	if preset.Encrypted {
+		switch {
+		case l.Password != "" && l.KeySource != "":
+			r.addError(path+"/key_source", CodeInvalidValue, fmt.Sprintf("storage preset %s has both a password and a key_source", l.Preset), "remove password or key_source")
+		case l.KeySource != "":
+			checkKeySource(r, path, l.KeySource, l.KeyURL, network)
+		case l.Password != "":
+			plaintextKeyWarning(r, path+"/password", fmt.Sprintf("the password of storage preset %s", l.Preset))
+		default:
+			r.addError(path+"/password", CodeRequired, fmt.Sprintf("storage preset %s is encrypted and needs a password or a key_source", l.Preset),
+				"set key_source to prompt or generate")
+		}
+	} else if l.Password != "" || l.KeySource != "" {
+		r.addWarning(path+"/password", CodeInvalidValue, fmt.Sprintf("storage preset %s is not encrypted, the password is ignored", l.Preset), "")
+	}
+	for i := len(l.Disks) + 1; i < len(preset.Matches); i++ {
+		if preset.Matches[i] == nil {
+			r.addError(path+"/disks", CodeRequired, fmt.Sprintf("storage preset %s uses %d disks, select the %d disks after the first one in disks", l.Preset, len(preset.Matches), len(preset.Matches)-1), "")
+			break
+		}
+	}
+	if len(l.Disks) >= len(preset.Matches) {
+		r.addWarning(fmt.Sprintf("%s/disks/%d", path, len(preset.Matches)-1), CodeInvalidValue,
+			fmt.Sprintf("storage preset %s uses %d disks, the other entries of disks are ignored", l.Preset, len(preset.Matches)), "")
+	}
+}
+
+// checkSubiquity validates a Subiquity layout. Its keys are passed on to
+// Subiquity, so only the name and the keys of storage presets are checked.
+func (l *StorageLayout) checkSubiquity(r *ValidationResult, path string) {
+	switch {
+	case l.Name == "":
+		r.addError(path+"/name", CodeRequired, "layout has neither a preset nor a name",
+			"set preset to one of "+joinOr(presetNames())+", or name to a Subiquity layout")
+		return
+	case !containsString(subiquityLayouts, l.Name):
+		r.addError(path+"/name", CodeInvalidValue, fmt.Sprintf("unknown Subiquity layout %q", l.Name),
+			"use one of "+joinOr(subiquityLayouts)+", or preset for a storage preset")
+		return
+	}
+	presetOnly := map[string]bool{"disks": len(l.Disks) > 0, "sizes": len(l.Sizes) > 0, "key_source": l.KeySource != "", "key_url": l.KeyURL != ""}
+	for _, key := range []string{"disks", "sizes", "key_source", "key_url"} {
+		if presetOnly[key] {
+			r.addError(path+"/"+key, CodeInvalidValue, fmt.Sprintf("%s is a setting of storage presets, Subiquity's %s layout has none", key, l.Name),
+				"set preset instead of name")
+		}
+	}
+	if l.Password != "" {
+		plaintextKeyWarning(r, path+"/password", fmt.Sprintf("the password of Subiquity's %s layout", l.Name))
+	}
+}
+
+// checkRelease reports a Subiquity layout, or the one a native preset is
+// passed on as, that Subiquity of release lacks.
+func (l *StorageLayout) checkRelease(r *ValidationResult, path, release string) {
+	name, at := l.Name, path+"/name"
+	if preset, ok := storagePresets[l.Preset]; ok {
+		name, at = preset.Native, path+"/preset"
+	}
+	releases, ok := layoutReleases[name]
+	if ok && !containsString(releases, release) {
+		r.addError(at, CodeInvalidValue, fmt.Sprintf("the installer of %s has no %s storage layout", release, name),
+			"build for "+joinOr(releases)+", or use another layout")
+	}
+}
+
+// expandLayout checks the layout and returns a copy of s with the storage
+// actions of a preset layout, or false when the layout is invalid. Subiquity
+// layouts are kept as they are, and native presets become the Subiquity
+// layout they are passed on as.
+func (s *Storage) expandLayout(r *ValidationResult, path string, network *NetworkConfig) (*Storage, bool) {
+	if len(s.Config) > 0 {
+		r.addError(path+"/layout", CodeInvalidValue, "storage has both a layout and a config", "remove either layout or config")
+		return nil, false
+	}
+	layout := &ValidationResult{}
+	s.Layout.check(layout, path+"/layout", network)
+	r.Issues = append(r.Issues, layout.Issues...)
+	if !layout.Valid() {
+		return nil, false
+	}
+	expanded := *s
+	switch preset := storagePresets[s.Layout.Preset]; {
+	case s.Layout.Preset == "":
+	case preset.Native != "":
+		expanded.Layout = &StorageLayout{Name: preset.Native, Match: s.Layout.Match, Encrypted: true}
+	default:
+		expanded.Layout = nil
+		expanded.Config = preset.expand(s.Layout)
+	}
+	return &expanded, true
+}
+
+// ExpandLayout replaces a preset layout with the storage actions of its
+// preset. Problems with the layout are returned as a *ValidationError.
+func (s *Storage) ExpandLayout() error {
+	if s.Layout == nil {
+		return nil
+	}
+	r := &ValidationResult{}
+	expanded, ok := s.expandLayout(r, "", nil)
+	if !ok {
+		return r.Err()
+	}
+	*s = *expanded
+	return nil
+}
+
+// presetBuilder appends the storage actions of a preset.
+type presetBuilder struct {
+	layout *StorageLayout
+	preset *StoragePreset
+	config []StorageConfig
+}
+
+func (b *presetBuilder) add(e StorageConfig) {
+	b.config = append(b.config, e)
+}
+
+// size returns the size named name, from the layout or the preset default.
+func (b *presetBuilder) size(name string) Size {
+	if size, ok := b.layout.Sizes[name]; ok {
+		return normalizedSize(string(size))
+	}
+	return b.preset.Sizes[name]
+}
+
+// disk adds the i-th disk of the preset as "disk<i>", matched by the layout
+// or the preset default.
+func (b *presetBuilder) disk(i int, grub bool) {
+	match := b.preset.Matches[i]
+	switch {
+	case i == 0 && b.layout.Match != nil:
+		match = b.layout.Match
+	case i > 0 && i <= len(b.layout.Disks):
+		match = &b.layout.Disks[i-1]
+	}
+	if match != nil {
+		copied := *match
+		match = &copied
+	}
+	b.add(StorageConfig{Type: StorageDisk, ID: fmt.Sprintf("disk%d", i), Ptable: "gpt", Wipe: "superblock-recursive", Match: match, GrubDevice: grub})
+}
+
+func (b *presetBuilder) biosGrub(disk, id string, number int) {
+	b.add(StorageConfig{Type: StoragePartition, ID: id, Device: disk, Number: number, Size: "1M", Flag: "bios_grub", Wipe: "superblock"})
+}
+
+// partition adds a partition with the size named size.
+func (b *presetBuilder) partition(disk, id string, number int, size string) {
+	b.add(StorageConfig{Type: StoragePartition, ID: id, Device: disk, Number: number, Size: b.size(size), Wipe: "superblock"})
+}
+
+// filesystem formats volume and mounts it at path, which is empty for swap.
+func (b *presetBuilder) filesystem(name, volume, fstype, path string) {
+	b.add(StorageConfig{Type: StorageFormat, ID: name + "-fs", Volume: volume, Fstype: fstype})
+	b.add(StorageConfig{Type: StorageMount, ID: "mount-" + name, Device: name + "-fs", Path: path})
+}
+
+// bootDisk adds disk0 with a BIOS boot partition, /boot and a partition for
+// the rest of the disk named pv-part.
+func (b *presetBuilder) bootDisk() {
+	b.disk(0, true)
+	b.biosGrub("disk0", "bios-grub-part", 1)
+	b.partition("disk0", "boot-part", 2, "boot")
+	b.add(StorageConfig{Type: StoragePartition, ID: "pv-part", Device: "disk0", Number: 3, Size: SizeRemaining, Wipe: "superblock"})
+	b.filesystem("boot", "boot-part", "ext4", "/boot")
+}
+
+// lvm adds the volume group ubuntu-vg on pv with a logical volume for each of
+// the named sizes: swap space, the root filesystem as ubuntu-lv, and the
+// others mounted at "/" + name.
+func (b *presetBuilder) lvm(pv string, volumes ...string) {
+	b.add(StorageConfig{Type: StorageLVMVolgroup, ID: "vg0", Name: "ubuntu-vg", Devices: []string{pv}})
+	for _, name := range volumes {
+		size := b.size(name)
+		if name == "swap" && size == "0" {
+			continue
+		}
+		lv, lvName, fstype, path := "lv-"+name, name, "ext4", "/"+name
+		switch name {
+		case "swap":
+			fstype, path = "swap", ""
+		case "root":
+			lvName, path = "ubuntu-lv", "/"
+		}
+		b.add(StorageConfig{Type: StorageLVMPartition, ID: lv, Volgroup: "vg0", Name: lvName, Size: size, Wipe: "superblock"})
+		b.filesystem(name, lv, fstype, path)
+	}
+}
+
+// mirrored adds a bootable copy of a BIOS boot partition, /boot and a root
+// partition to every disk of the preset, with /boot on a RAID1 of all disks and
+// / on a raid of the given level.
+func (b *presetBuilder) mirrored(level RAIDLevel) {
+	var boot, root []string
+	for i := range b.preset.Matches {
+		disk := fmt.Sprintf("disk%d", i)
+		b.disk(i, true)
+		b.biosGrub(disk, fmt.Sprintf("bios-grub-part%d", i), 1)
+		b.partition(disk, fmt.Sprintf("boot-part%d", i), 2, "boot")
+		b.partition(disk, fmt.Sprintf("root-part%d", i), 3, "root")
+		boot = append(boot, fmt.Sprintf("boot-part%d", i))
+		root = append(root, fmt.Sprintf("root-part%d", i))
+	}
+	b.add(StorageConfig{Type: StorageRAID, ID: "md-boot", Name: "md0", RAIDLevel: "raid1", Devices: boot})
+	b.add(StorageConfig{Type: StorageRAID, ID: "md-root", Name: "md1", RAIDLevel: level, Devices: root})
+	b.filesystem("boot", "md-boot", "ext4", "/boot")
+	b.filesystem("root", "md-root", "ext4", "/")
+}
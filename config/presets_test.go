@@ -0,0 +1,164 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestStoragePresets_Valid(t *testing.T) {
+	presets := StoragePresets()
+	require.Len(t, presets, len(storagePresets))
+	for _, p := range presets {
+		layout := &StorageLayout{Preset: p.Name}
+		if p.Encrypted {
+			layout.Password = "secret"
+		}
+		for i := 1; i < p.Disks; i++ {
+			layout.Disks = append(layout.Disks, DiskMatch{Serial: fmt.Sprintf("SERIAL%d", i)})
+		}
+		s := &Storage{Layout: layout}
+		r := &ValidationResult{}
+		s.check(r, "", nil)
+		assert.Empty(t, r.Errors(), p.Name)
+		for _, w := range r.Warnings() {
+			assert.Equal(t, CodePlaintextKey, w.Code, p.Name)
+		}
+
+		require.NoError(t, s.ExpandLayout(), p.Name)
+		if p.Native != "" {
+			assert.Equal(t, &StorageLayout{Name: p.Native, Encrypted: true}, s.Layout, p.Name)
+			assert.Empty(t, s.Config, p.Name)
+			continue
+		}
+		assert.Nil(t, s.Layout)
+		sim, err := SimulateLayout(s.Config, nil, 240e9)
+		require.NoError(t, err, p.Name)
+		assert.True(t, sim.Fits, p.Name)
+		assert.GreaterOrEqual(t, len(sim.Disks), p.Disks, p.Name)
+		assert.NotEmpty(t, sim.Mounts, p.Name)
+	}
+}
+
+func TestStorageLayout_Expand(t *testing.T) {
+	var cfg Config
+	require.NoError(t, yaml.Unmarshal([]byte(`
+autoinstall:
+  storage:
+    layout:
+      preset: var-home
+      match: {serial: S3Z9NX0K}
+      sizes: {swap: 0, root: 30G, var: 25%}
+`), &cfg))
+	s := &cfg.Autoinstall.Storage
+	require.NoError(t, s.ExpandLayout())
+
+	byID := make(map[string]StorageConfig)
+	for _, e := range s.Config {
+		byID[e.ID] = e
+	}
+	assert.Equal(t, "S3Z9NX0K", byID["disk0"].Match.Serial)
+	assert.Equal(t, Size("2G"), byID["boot-part"].Size)
+	assert.Equal(t, Size("30G"), byID["lv-root"].Size)
+	assert.Equal(t, Size("25%"), byID["lv-var"].Size)
+	assert.Equal(t, SizeRemaining, byID["lv-home"].Size)
+	assert.NotContains(t, byID, "lv-swap")
+}
+
+func TestStorageLayout_Invalid(t *testing.T) {
+	issues := func(s *Storage) map[string]string {
+		err := s.ExpandLayout()
+		var verr *ValidationError
+		require.True(t, errors.As(err, &verr))
+		out := make(map[string]string)
+		for _, issue := range verr.Issues {
+			out[issue.Path] = issue.Code
+		}
+		return out
+	}
+
+	assert.Equal(t, map[string]string{"/layout/preset": CodeUnknownPreset},
+		issues(&Storage{Layout: &StorageLayout{Preset: "btrfs"}}))
+	assert.Equal(t, map[string]string{"/layout/name": CodeInvalidValue},
+		issues(&Storage{Layout: &StorageLayout{Name: "lvm-luks"}}))
+	assert.Equal(t, map[string]string{"/layout/name": CodeInvalidValue},
+		issues(&Storage{Layout: &StorageLayout{Preset: "lvm", Name: "lvm"}}))
+	assert.Equal(t, map[string]string{"/layout/sizes": CodeInvalidValue, "/layout/key_source": CodeInvalidValue},
+		issues(&Storage{Layout: &StorageLayout{Name: "lvm", Sizes: map[string]Size{"root": "10G"}, KeySource: KeySourcePrompt}}))
+	assert.Equal(t, map[string]string{"/layout/sizing-policy": CodeInvalidValue},
+		issues(&Storage{Layout: &StorageLayout{Preset: "lvm", Extra: map[string]interface{}{"sizing-policy": "all"}}}))
+	assert.Equal(t, map[string]string{
+		"/layout/password":   CodeRequired,
+		"/layout/sizes/boot": CodeInvalidSize,
+		"/layout/sizes/var":  CodeInvalidValue,
+	}, issues(&Storage{Layout: &StorageLayout{Preset: "lvm-luks", Sizes: map[string]Size{"boot": "2X", "var": "10G"}}}))
+	assert.Equal(t, map[string]string{"/layout/disks": CodeRequired},
+		issues(&Storage{Layout: &StorageLayout{Preset: "raid10", Disks: []DiskMatch{{Serial: "B"}, {Serial: "C"}}}}))
+	assert.Equal(t, map[string]string{"/layout": CodeInvalidValue},
+		issues(&Storage{Layout: &StorageLayout{Preset: "lvm"}, Config: NewDefaultConfig().Autoinstall.Storage.Config}))
+}
+
+// Test Subiquity's own layouts, which share names with presets, are passed on
+// with all their keys instead of being expanded.
+func TestStorageLayout_Subiquity(t *testing.T) {
+	var cfg Config
+	require.NoError(t, yaml.Unmarshal([]byte(`
+autoinstall:
+  storage:
+    layout:
+      name: lvm
+      password: secret
+      sizing-policy: all
+      reset-partition: true
+`), &cfg))
+	s := &cfg.Autoinstall.Storage
+	r := &ValidationResult{}
+	s.check(r, "", nil)
+	assert.Empty(t, r.Errors())
+	require.Len(t, r.Warnings(), 1)
+	assert.Equal(t, CodePlaintextKey, r.Warnings()[0].Code)
+
+	require.NoError(t, s.ExpandLayout())
+	assert.Empty(t, s.Config)
+	require.NotNil(t, s.Layout)
+	assert.Equal(t, "lvm", s.Layout.Name)
+	assert.Equal(t, "secret", s.Layout.Password)
+
+	data, err := yaml.Marshal(s.Layout)
+	require.NoError(t, err)
+	assert.YAMLEq(t, "{name: lvm, password: secret, sizing-policy: all, reset-partition: true}", string(data))
+	data, err = json.Marshal(s.Layout)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"name": "lvm", "password": "secret", "sizing-policy": "all", "reset-partition": true}`, string(data))
+
+	var layout StorageLayout
+	require.NoError(t, json.Unmarshal(data, &layout))
+	assert.Equal(t, *s.Layout, layout)
+
+	hybrid := &Storage{Layout: &StorageLayout{Name: tpmLayout}}
+	require.NoError(t, hybrid.ExpandLayout())
+	assert.Equal(t, &StorageLayout{Name: tpmLayout}, hybrid.Layout, "unencrypted hybrid stays unencrypted")
+}
+
+func TestConfig_CheckRelease(t *testing.T) {
+	paths := func(layout *StorageLayout, release string) []string {
+		cfg := NewDefaultConfig()
+		cfg.Autoinstall.Storage = Storage{Layout: layout}
+		var out []string
+		for _, issue := range cfg.CheckRelease(release).Issues {
+			out = append(out, issue.Path)
+		}
+		return out
+	}
+	const at = "/autoinstall/storage/layout"
+	assert.Equal(t, []string{at + "/preset"}, paths(&StorageLayout{Preset: "tpm"}, "focal"))
+	assert.Equal(t, []string{at + "/name"}, paths(&StorageLayout{Name: "zfs"}, "jammy"))
+	assert.Empty(t, paths(&StorageLayout{Name: "zfs"}, ""), "noble by default")
+	assert.Empty(t, paths(&StorageLayout{Name: "lvm"}, "focal"))
+	assert.Empty(t, paths(&StorageLayout{Preset: "zfs"}, "focal"), "the zfs preset is written as storage actions")
+}
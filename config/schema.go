@@ -0,0 +1,160 @@
+package config
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	"gopkg.in/yaml.v3"
+)
+
+// The autoinstall JSON schemas published by Subiquity for each supported release.
+//
+//go:embed schema/*.json
+var schemaFS embed.FS
+
+// DefaultSchemaRelease is the release used when no release is given.
+const DefaultSchemaRelease = "noble"
+
+// SchemaReleases lists the releases with an embedded autoinstall schema.
+var SchemaReleases = []string{"focal", "jammy", "noble"}
+
+var (
+	schemasOnce sync.Once
+	schemas     map[string]*jsonschema.Schema
+	schemasErr  error
+)
+
+// SchemaViolation is a single schema error of a user-data document.
+type SchemaViolation struct {
+	Path    string `json:"path"`    // JSON pointer of the offending value, e.g. /autoinstall/identity/hostname
+	Message string `json:"message"` // Description of the violation
+}
+
+// SchemaError lists every schema violation of a user-data document.
+type SchemaError struct {
+	Release    string
+	Violations []SchemaViolation
+}
+
+func (e *SchemaError) Error() string {
+	msgs := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		msgs = append(msgs, fmt.Sprintf("%s: %s", v.Path, v.Message))
+	}
+	return fmt.Sprintf("user-data does not match the %s autoinstall schema: %s", e.Release, strings.Join(msgs, "; "))
+}
+
+// loadSchemas compiles the embedded schemas once.
+func loadSchemas() (map[string]*jsonschema.Schema, error) {
+	schemasOnce.Do(func() {
+		compiled := make(map[string]*jsonschema.Schema, len(SchemaReleases))
+		for _, release := range SchemaReleases {
+			data, err := schemaFS.ReadFile("schema/" + release + ".json")
+			if err != nil {
+				schemasErr = err
+				return
+			}
+			compiler := jsonschema.NewCompiler()
+			url := release + ".json"
+			if err := compiler.AddResource(url, bytes.NewReader(data)); err != nil {
+				schemasErr = fmt.Errorf("failed to load %s schema: %v", release, err)
+				return
+			}
+			schema, err := compiler.Compile(url)
+			if err != nil {
+				schemasErr = fmt.Errorf("failed to compile %s schema: %v", release, err)
+				return
+			}
+			compiled[release] = schema
+		}
+		schemas = compiled
+	})
+	return schemas, schemasErr
+}
+
+// ValidateUserDataSchema validates the autoinstall section of user-data against
+// the schema of release, or DefaultSchemaRelease when release is empty. Schema
+// violations are returned as a *SchemaError.
+func ValidateUserDataSchema(userData []byte, release string) error {
+	if release == "" {
+		release = DefaultSchemaRelease
+	}
+	compiled, err := loadSchemas()
+	if err != nil {
+		return err
+	}
+	schema, ok := compiled[release]
+	if !ok {
+		return fmt.Errorf("no autoinstall schema for release %q, must be one of %s", release, strings.Join(SchemaReleases, ", "))
+	}
+
+	doc, err := yamlToJSON(userData)
+	if err != nil {
+		return err
+	}
+	root, ok := doc.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("user-data must be a YAML mapping")
+	}
+	autoinstall, ok := root["autoinstall"]
+	if !ok {
+		return &SchemaError{Release: release, Violations: []SchemaViolation{{Path: "/autoinstall", Message: "missing required 'autoinstall' field"}}}
+	}
+
+	err = schema.Validate(autoinstall)
+	var verr *jsonschema.ValidationError
+	if !errors.As(err, &verr) {
+		return err
+	}
+	return &SchemaError{Release: release, Violations: collectViolations(verr, "/autoinstall")}
+}
+
+// yamlToJSON decodes YAML into the generic JSON values the validator expects.
+func yamlToJSON(data []byte) (interface{}, error) {
+	var doc interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid YAML syntax: %v", err)
+	}
+	raw, err := json.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("user-data cannot be represented as JSON: %v", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var out interface{}
+	if err := dec.Decode(&out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// collectViolations flattens the leaf errors of a validation error, prefixing
+// their instance locations with prefix.
+func collectViolations(verr *jsonschema.ValidationError, prefix string) []SchemaViolation {
+	seen := make(map[SchemaViolation]bool)
+	var out []SchemaViolation
+	var walk func(e *jsonschema.ValidationError)
+	walk = func(e *jsonschema.ValidationError) {
+		if len(e.Causes) > 0 {
+			for _, cause := range e.Causes {
+				walk(cause)
+			}
+			return
+		}
+		v := SchemaViolation{Path: prefix + e.InstanceLocation, Message: e.Message}
+		if !seen[v] {
+			seen[v] = true
+			out = append(out, v)
+		}
+	}
+	walk(verr)
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Path < out[j].Path })
+	return out
+}
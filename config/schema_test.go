@@ -0,0 +1,68 @@
+package config
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestValidateUserDataSchema_Default(t *testing.T) {
+	data, err := yaml.Marshal(NewDefaultConfig())
+	require.NoError(t, err)
+
+	for _, release := range SchemaReleases {
+		assert.NoError(t, ValidateUserDataSchema(data, release), release)
+	}
+}
+
+func TestValidateUserDataSchema_Violations(t *testing.T) {
+	userData := []byte(`#cloud-config
+autoinstall:
+  version: 2
+  identity:
+    hostname: host
+    username: ubuntu
+  updates: nightly
+  snaps:
+    - channel: stable
+`)
+
+	err := ValidateUserDataSchema(userData, "jammy")
+	var serr *SchemaError
+	require.True(t, errors.As(err, &serr), "got %v", err)
+	assert.Equal(t, "jammy", serr.Release)
+
+	paths := make(map[string]bool)
+	for _, v := range serr.Violations {
+		paths[v.Path] = true
+		assert.NotEmpty(t, v.Message)
+	}
+	assert.True(t, paths["/autoinstall/version"])
+	assert.True(t, paths["/autoinstall/identity"])
+	assert.True(t, paths["/autoinstall/updates"])
+	assert.True(t, paths["/autoinstall/snaps/0"])
+}
+
+func TestValidateUserDataSchema_Release(t *testing.T) {
+	userData := []byte(`autoinstall:
+  version: 1
+  active-directory:
+    admin-name: admin
+    realm: example.com
+`)
+
+	assert.NoError(t, ValidateUserDataSchema(userData, "jammy"))
+	assert.Error(t, ValidateUserDataSchema(userData, "noble"))
+	assert.Error(t, ValidateUserDataSchema(userData, "warty"))
+}
+
+func TestValidateUserDataSchema_MissingAutoinstall(t *testing.T) {
+	err := ValidateUserDataSchema([]byte("hostname: host\n"), "")
+	var serr *SchemaError
+	require.True(t, errors.As(err, &serr))
+	assert.Equal(t, DefaultSchemaRelease, serr.Release)
+	assert.Equal(t, "/autoinstall", serr.Violations[0].Path)
+}
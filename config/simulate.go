@@ -0,0 +1,379 @@
+package config
+
+import (
+	"fmt"
+)
+
+// Sizes the simulation applies like curtin and LVM do.
+const (
+	partitionAlign   = 1 << 20 // Partitions start on MiB boundaries
+	gptBackupSize    = 1 << 20 // Space kept free at the end of GPT disks for the backup table
+	lvmExtentSize    = 4 << 20 // Default LVM physical extent size
+	lvmMetadataSize  = 1 << 20 // Space LVM reserves at the start of every physical volume
+	mdMetadataSize   = 1 << 20 // Space mdadm reserves on every member for the 1.2 superblock
+	bcacheDataOffset = 8 << 10 // Space the bcache superblock takes at the start of the backing device
+)
+
+// LayoutSimulation is the result of laying out storage actions on disks of
+// hypothetical sizes.
+type LayoutSimulation struct {
+	Fits         bool                   `json:"fits"`
+	Problems     []string               `json:"problems,omitempty"`
+	Disks        []SimulatedDisk        `json:"disks"`
+	VolumeGroups []SimulatedVolumeGroup `json:"volumeGroups,omitempty"`
+	Mounts       []SimulatedMount       `json:"mounts"`
+}
+
+// SimulatedDisk is a disk with its partition table.
+type SimulatedDisk struct {
+	ID         string               `json:"id"`
+	Ptable     string               `json:"ptable,omitempty"`
+	RAIDLevel  string               `json:"raidLevel,omitempty"` // Set for raid arrays, which are laid out like disks
+	Size       int64                `json:"size"`
+	Free       int64                `json:"free"`            // Unpartitioned space
+	Usage      string               `json:"usage,omitempty"` // What uses the whole disk when it has no partition table
+	Partitions []SimulatedPartition `json:"partitions,omitempty"`
+}
+
+// SimulatedPartition is a partition placed on a disk.
+type SimulatedPartition struct {
+	ID      string `json:"id"`
+	Number  int    `json:"number"`
+	Start   int64  `json:"start"`
+	Size    int64  `json:"size"`
+	Flag    string `json:"flag,omitempty"`
+	Aligned bool   `json:"aligned"`         // Start and size are multiples of 1 MiB
+	Usage   string `json:"usage,omitempty"` // Filesystem, volume group or encryption on the partition
+}
+
+// SimulatedVolumeGroup is an LVM volume group with its logical volumes.
+type SimulatedVolumeGroup struct {
+	ID      string            `json:"id"`
+	Name    string            `json:"name"`
+	Size    int64             `json:"size"`
+	Used    int64             `json:"used"`
+	Free    int64             `json:"free"`
+	Volumes []SimulatedVolume `json:"volumes,omitempty"`
+}
+
+// SimulatedVolume is an LVM logical volume.
+type SimulatedVolume struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Size  int64  `json:"size"`
+	Usage string `json:"usage,omitempty"`
+}
+
+// SimulatedMount is an entry of the resulting mount table.
+type SimulatedMount struct {
+	Path      string `json:"path"` // Empty for swap
+	Fstype    string `json:"fstype"`
+	Format    string `json:"format"` // ID of the format action
+	Volume    string `json:"volume"` // ID of the formatted volume
+	Size      int64  `json:"size"`
+	Encrypted bool   `json:"encrypted"`
+}
+
+// SimulateLayout lays out storage actions on disks whose sizes are given by ID
+// in diskSizes, or defaultDiskSize for the others. It computes partition
+// offsets, volume group space, filesystem sizes and the mount table, and lists
+// every place where the layout does not fit. Errors in the storage graph that
+// make the layout ambiguous are returned as a *ValidationError.
+func SimulateLayout(entries []StorageConfig, diskSizes map[string]int64, defaultDiskSize int64) (*LayoutSimulation, error) {
+	storage := &Storage{Config: entries}
+	r := &ValidationResult{}
+	storage.checkGraph(r, "")
+	graph := &ValidationResult{}
+	for _, issue := range r.Errors() {
+		// Missing bootloaders and full volume groups are reported by the simulation itself
+		if issue.Code != CodeGrubDevice && issue.Code != CodeNoSpace {
+			graph.Issues = append(graph.Issues, issue)
+		}
+	}
+	if err := graph.Err(); err != nil {
+		return nil, err
+	}
+
+	sim := &layoutSimulator{
+		result:    &LayoutSimulation{Disks: []SimulatedDisk{}, Mounts: []SimulatedMount{}},
+		sizes:     make(map[string]int64),
+		usage:     make(map[string]string),
+		encrypted: make(map[string]bool),
+		disks:     make(map[string]int),
+		vgs:       make(map[string]int),
+		cursor:    make(map[string]int64),
+		end:       make(map[string]int64),
+	}
+	for i := range entries {
+		e := &entries[i]
+		switch e.Type {
+		case StorageDisk:
+			size, ok := diskSizes[e.ID]
+			if !ok {
+				size = defaultDiskSize
+			}
+			if size <= 0 {
+				return nil, fmt.Errorf("no size given for disk %s", e.ID)
+			}
+			sim.disk(e, size)
+		case StoragePartition:
+			sim.partition(e)
+		case StorageDMCrypt:
+			sim.dmCrypt(e)
+		case StorageLVMVolgroup:
+			sim.volgroup(e)
+		case StorageLVMPartition:
+			sim.logicalVolume(e)
+		case StorageFormat:
+			sim.format(e)
+		case StorageMount:
+			sim.mount(e)
+		case StorageRAID:
+			sim.raid(e)
+		case StorageBcache:
+			sim.bcache(e)
+		case StorageZpool:
+			sim.zpool(e)
+		case StorageZFS:
+			sim.zfs(e)
+		}
+	}
+	return sim.finish(), nil
+}
+
+// layoutSimulator holds the state of SimulateLayout.
+type layoutSimulator struct {
+	result    *LayoutSimulation
+	sizes     map[string]int64  // Usable size of every volume by ID
+	usage     map[string]string // What uses a volume by ID
+	encrypted map[string]bool   // Volumes whose data is encrypted
+	disks     map[string]int    // Index of the disks in result.Disks by ID
+	vgs       map[string]int    // Index of the volume groups in result.VolumeGroups by ID
+	cursor    map[string]int64  // Next free offset on every disk
+	end       map[string]int64  // End of the partitionable space on every disk
+	formats   []*StorageConfig
+}
+
+func (s *layoutSimulator) problem(format string, args ...interface{}) {
+	s.result.Problems = append(s.result.Problems, fmt.Sprintf(format, args...))
+}
+
+func (s *layoutSimulator) disk(e *StorageConfig, size int64) {
+	s.disks[e.ID] = len(s.result.Disks)
+	s.result.Disks = append(s.result.Disks, SimulatedDisk{ID: e.ID, Ptable: e.Ptable, Size: size})
+	s.sizes[e.ID] = size
+	s.cursor[e.ID] = partitionAlign
+	s.end[e.ID] = size
+	if e.Ptable == "gpt" {
+		s.end[e.ID] = size - gptBackupSize
+	}
+}
+
+func (s *layoutSimulator) partition(e *StorageConfig) {
+	d := &s.result.Disks[s.disks[e.Device]]
+	start := alignUp(s.cursor[e.Device], partitionAlign)
+	end := s.end[e.Device]
+	size, _ := e.Size.Resolve(d.Size)
+	if size == -1 {
+		size = alignDown(end, partitionAlign) - start
+		if size <= 0 {
+			s.problem("no space is left on %s for partition %s", e.Device, e.ID)
+			size = 0
+		}
+	} else if start+size > end {
+		s.problem("partition %s needs %s but only %s are left on %s", e.ID, FormatSize(size), FormatSize(max64(end-start, 0)), e.Device)
+	}
+	number := e.Number
+	if number == 0 {
+		number = len(d.Partitions) + 1
+	}
+	d.Partitions = append(d.Partitions, SimulatedPartition{
+		ID:      e.ID,
+		Number:  number,
+		Start:   start,
+		Size:    size,
+		Flag:    e.Flag,
+		Aligned: start%partitionAlign == 0 && size%partitionAlign == 0,
+	})
+	s.cursor[e.Device] = start + size
+	s.sizes[e.ID] = size
+	if e.Flag == "bios_grub" {
+		s.usage[e.ID] = "BIOS boot"
+	}
+}
+
+func (s *layoutSimulator) dmCrypt(e *StorageConfig) {
+	size := s.sizes[e.Volume] - luksHeaderSize
+	if size <= 0 {
+		s.problem("%s is too small for the LUKS header of %s", e.Volume, e.ID)
+		size = 0
+	}
+	s.sizes[e.ID] = size
+	s.usage[e.Volume] = "dm_crypt " + e.ID
+	s.encrypted[e.ID] = true
+}
+
+func (s *layoutSimulator) volgroup(e *StorageConfig) {
+	var size int64
+	encrypted := true
+	for _, dev := range e.Devices {
+		size += max64(alignDown(s.sizes[dev]-lvmMetadataSize, lvmExtentSize), 0)
+		s.usage[dev] = "LVM " + e.Name
+		encrypted = encrypted && s.encrypted[dev]
+	}
+	s.vgs[e.ID] = len(s.result.VolumeGroups)
+	s.result.VolumeGroups = append(s.result.VolumeGroups, SimulatedVolumeGroup{ID: e.ID, Name: e.Name, Size: size, Free: size})
+	s.sizes[e.ID] = size
+	s.encrypted[e.ID] = encrypted
+}
+
+func (s *layoutSimulator) logicalVolume(e *StorageConfig) {
+	vg := &s.result.VolumeGroups[s.vgs[e.Volgroup]]
+	size, _ := e.Size.Resolve(vg.Size)
+	if size > 0 {
+		size = alignUp(size, lvmExtentSize)
+	}
+	if size == -1 {
+		size = vg.Free
+		if size <= 0 {
+			s.problem("no space is left in %s for logical volume %s", vg.Name, e.Name)
+		}
+	} else if size > vg.Free {
+		s.problem("logical volume %s needs %s but only %s are free in %s", e.Name, FormatSize(size), FormatSize(vg.Free), vg.Name)
+	}
+	vg.Volumes = append(vg.Volumes, SimulatedVolume{ID: e.ID, Name: e.Name, Size: size})
+	vg.Used += size
+	vg.Free = max64(vg.Size-vg.Used, 0)
+	s.sizes[e.ID] = size
+	s.encrypted[e.ID] = s.encrypted[e.Volgroup]
+}
+
+func (s *layoutSimulator) format(e *StorageConfig) {
+	s.sizes[e.ID] = s.sizes[e.Volume]
+	s.encrypted[e.ID] = s.encrypted[e.Volume]
+	s.usage[e.Volume] = e.Fstype
+	s.formats = append(s.formats, e)
+}
+
+func (s *layoutSimulator) mount(e *StorageConfig) {
+	for _, f := range s.formats {
+		if f.ID != e.Device {
+			continue
+		}
+		s.result.Mounts = append(s.result.Mounts, SimulatedMount{
+			Path:      e.Path,
+			Fstype:    f.Fstype,
+			Format:    f.ID,
+			Volume:    f.Volume,
+			Size:      s.sizes[f.ID],
+			Encrypted: s.encrypted[f.ID],
+		})
+		if e.Path != "" {
+			s.usage[f.Volume] = f.Fstype + " " + e.Path
+		}
+	}
+}
+
+func (s *layoutSimulator) raid(e *StorageConfig) {
+	smallest := int64(-1)
+	encrypted := true
+	for _, dev := range e.Devices {
+		size := max64(s.sizes[dev]-mdMetadataSize, 0)
+		if smallest < 0 || size < smallest {
+			smallest = size
+		}
+		s.usage[dev] = "RAID " + e.Name
+		encrypted = encrypted && s.encrypted[dev]
+	}
+	n := int64(len(e.Devices))
+	var size int64
+	switch e.RAIDLevel.normalize() {
+	case "raid0":
+		size = smallest * n
+	case "raid1":
+		size = smallest
+	case "raid5":
+		size = smallest * (n - 1)
+	case "raid6":
+		size = smallest * (n - 2)
+	case "raid10":
+		size = smallest * (n / 2)
+	}
+	for _, dev := range e.SpareDevices {
+		s.usage[dev] = "RAID spare " + e.Name
+	}
+	s.sizes[e.ID] = max64(size, 0)
+	s.encrypted[e.ID] = encrypted
+	// A raid can be partitioned like a disk
+	s.disks[e.ID] = len(s.result.Disks)
+	s.result.Disks = append(s.result.Disks, SimulatedDisk{ID: e.ID, Ptable: e.Ptable, RAIDLevel: string(e.RAIDLevel.normalize()), Size: s.sizes[e.ID]})
+	s.cursor[e.ID] = partitionAlign
+	s.end[e.ID] = s.sizes[e.ID]
+}
+
+func (s *layoutSimulator) bcache(e *StorageConfig) {
+	s.sizes[e.ID] = max64(s.sizes[e.BackingDevice]-bcacheDataOffset, 0)
+	s.encrypted[e.ID] = s.encrypted[e.BackingDevice] && s.encrypted[e.CacheDevice]
+	s.usage[e.BackingDevice] = "bcache " + e.ID
+	s.usage[e.CacheDevice] = "bcache cache of " + e.ID
+}
+
+func (s *layoutSimulator) zpool(e *StorageConfig) {
+	var size int64
+	encrypted := true
+	for _, dev := range e.Vdevs {
+		size += s.sizes[dev]
+		s.usage[dev] = "ZFS " + e.Pool
+		encrypted = encrypted && s.encrypted[dev]
+	}
+	s.sizes[e.ID] = size
+	s.encrypted[e.ID] = encrypted
+	if e.Mountpoint != "" {
+		s.result.Mounts = append(s.result.Mounts, SimulatedMount{Path: e.Mountpoint, Fstype: "zfs", Volume: e.ID, Size: size, Encrypted: encrypted})
+	}
+}
+
+func (s *layoutSimulator) zfs(e *StorageConfig) {
+	// Datasets share the space of their pool
+	if path := e.Properties["mountpoint"]; path != "" && path != "none" && path != "legacy" {
+		s.result.Mounts = append(s.result.Mounts, SimulatedMount{Path: path, Fstype: "zfs", Volume: e.ID, Size: s.sizes[e.Pool], Encrypted: s.encrypted[e.Pool]})
+	}
+}
+
+// finish fills in the usage of every volume and the free space of the disks.
+func (s *layoutSimulator) finish() *LayoutSimulation {
+	for i := range s.result.Disks {
+		d := &s.result.Disks[i]
+		if len(d.Partitions) == 0 && s.usage[d.ID] != "" {
+			d.Usage = s.usage[d.ID]
+		} else {
+			d.Free = max64(s.end[d.ID]-alignUp(s.cursor[d.ID], partitionAlign), 0)
+		}
+		for j := range d.Partitions {
+			d.Partitions[j].Usage = s.usage[d.Partitions[j].ID]
+		}
+	}
+	for i := range s.result.VolumeGroups {
+		vg := &s.result.VolumeGroups[i]
+		for j := range vg.Volumes {
+			vg.Volumes[j].Usage = s.usage[vg.Volumes[j].ID]
+		}
+	}
+	s.result.Fits = len(s.result.Problems) == 0
+	return s.result
+}
+
+func alignUp(n, align int64) int64 {
+	return (n + align - 1) / align * align
+}
+
+func alignDown(n, align int64) int64 {
+	return n / align * align
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
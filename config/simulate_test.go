@@ -0,0 +1,103 @@
+package config
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSimulateLayout_Default(t *testing.T) {
+	sim, err := SimulateLayout(NewDefaultConfig().Autoinstall.Storage.Config, nil, 240e9)
+	require.NoError(t, err)
+	assert.True(t, sim.Fits)
+	assert.Empty(t, sim.Problems)
+
+	require.Len(t, sim.Disks, 1)
+	disk := sim.Disks[0]
+	require.Len(t, disk.Partitions, 3)
+	assert.Equal(t, SimulatedPartition{ID: "bios-grub-part", Number: 1, Start: 1 << 20, Size: 1 << 20, Flag: "bios_grub", Aligned: true, Usage: "BIOS boot"}, disk.Partitions[0])
+	assert.Equal(t, int64(2<<20), disk.Partitions[1].Start)
+	assert.Equal(t, "ext4 /boot", disk.Partitions[1].Usage)
+
+	// The last partition fills the disk up to the MiB before the backup GPT
+	pv := disk.Partitions[2]
+	assert.Equal(t, "LVM ubuntu-vg", pv.Usage)
+	assert.True(t, pv.Aligned)
+	assert.Equal(t, alignDown(240e9-gptBackupSize, partitionAlign), pv.Start+pv.Size)
+
+	require.Len(t, sim.VolumeGroups, 1)
+	vg := sim.VolumeGroups[0]
+	assert.Equal(t, alignDown(pv.Size-lvmMetadataSize, lvmExtentSize), vg.Size)
+	assert.Equal(t, vg.Size, vg.Used)
+	assert.Equal(t, vg.Size-(1<<30), vg.Volumes[1].Size)
+
+	mounts := make(map[string]SimulatedMount)
+	for _, m := range sim.Mounts {
+		mounts[m.Path] = m
+	}
+	assert.Equal(t, vg.Volumes[1].Size, mounts["/"].Size)
+	assert.Equal(t, "swap", mounts[""].Fstype)
+}
+
+func TestSimulateLayout_DoesNotFit(t *testing.T) {
+	sim, err := SimulateLayout(NewDefaultConfig().Autoinstall.Storage.Config, nil, 2<<30)
+	require.NoError(t, err)
+	assert.False(t, sim.Fits)
+	assert.Contains(t, sim.Problems, "partition boot-part needs 2G but only 1.99G are left on disk0")
+}
+
+func TestSimulateLayout_Encrypted(t *testing.T) {
+	entries := []StorageConfig{
+		{Type: StorageDisk, ID: "disk0", Ptable: "gpt", GrubDevice: true},
+		{Type: StoragePartition, ID: "part1", Device: "disk0", Size: SizeRemaining},
+		{Type: StorageDMCrypt, ID: "crypt0", Volume: "part1", KeyFile: "/tmp/key"},
+		{Type: StorageLVMVolgroup, ID: "vg0", Name: "vg0", Devices: []string{"crypt0"}},
+		{Type: StorageLVMPartition, ID: "lv0", Volgroup: "vg0", Name: "root", Size: "3G"},
+		{Type: StorageFormat, ID: "fs0", Volume: "lv0", Fstype: "xfs"},
+		{Type: StorageMount, ID: "mount0", Device: "fs0", Path: "/"},
+	}
+
+	sim, err := SimulateLayout(entries, map[string]int64{"disk0": 4 << 30}, 0)
+	require.NoError(t, err)
+	assert.True(t, sim.Fits)
+	assert.Equal(t, 1, sim.Disks[0].Partitions[0].Number)
+	assert.Equal(t, "dm_crypt crypt0", sim.Disks[0].Partitions[0].Usage)
+	assert.True(t, sim.Mounts[0].Encrypted)
+
+	entries[4].Size = "4G"
+	sim, err = SimulateLayout(entries, map[string]int64{"disk0": 4 << 30}, 0)
+	require.NoError(t, err)
+	assert.False(t, sim.Fits)
+
+	_, err = SimulateLayout(entries, nil, 0)
+	assert.EqualError(t, err, "no size given for disk disk0")
+}
+
+func TestSimulateLayout_Percentages(t *testing.T) {
+	entries := []StorageConfig{
+		{Type: StorageDisk, ID: "disk0", Ptable: "gpt", GrubDevice: true},
+		{Type: StoragePartition, ID: "part1", Device: "disk0", Size: "10G"},
+		{Type: StorageLVMVolgroup, ID: "vg0", Name: "vg0", Devices: []string{"part1"}},
+		{Type: StorageLVMPartition, ID: "lv0", Volgroup: "vg0", Name: "root", Size: "50%"},
+		{Type: StorageLVMPartition, ID: "lv1", Volgroup: "vg0", Name: "home", Size: SizeRemaining},
+	}
+
+	sim, err := SimulateLayout(entries, nil, 20<<30)
+	require.NoError(t, err)
+	assert.True(t, sim.Fits)
+	vg := sim.VolumeGroups[0]
+	assert.Equal(t, alignUp(vg.Size/2, lvmExtentSize), vg.Volumes[0].Size)
+	assert.Equal(t, vg.Size, vg.Used)
+}
+
+func TestSimulateLayout_InvalidGraph(t *testing.T) {
+	_, err := SimulateLayout([]StorageConfig{
+		{Type: StorageDisk, ID: "disk0", Ptable: "gpt"},
+		{Type: StoragePartition, ID: "part1", Device: "disk9", Size: SizeRemaining},
+	}, nil, 1<<40)
+	var verr *ValidationError
+	require.True(t, errors.As(err, &verr))
+	assert.Equal(t, "/config/1/device", verr.Issues[0].Path)
+}
@@ -0,0 +1,233 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// sizeUnits maps size suffixes to their multipliers. Single letters are binary
+// like curtin's, "KB", "MB"... are decimal like the sizes printed on disks.
+var sizeUnits = map[string]float64{
+	"":    1,
+	"B":   1,
+	"K":   1 << 10,
+	"KIB": 1 << 10,
+	"M":   1 << 20,
+	"MIB": 1 << 20,
+	"G":   1 << 30,
+	"GIB": 1 << 30,
+	"T":   1 << 40,
+	"TIB": 1 << 40,
+	"P":   1 << 50,
+	"PIB": 1 << 50,
+	"KB":  1e3,
+	"MB":  1e6,
+	"GB":  1e9,
+	"TB":  1e12,
+	"PB":  1e15,
+}
+
+// ParseSize parses a byte count with an optional unit, e.g. "2147483648", "2G",
+// "1.5T" or "240GB".
+func ParseSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	if i < 0 {
+		i = len(s)
+	}
+	number, unit := s[:i], strings.ToUpper(strings.TrimSpace(s[i:]))
+	multiplier, ok := sizeUnits[unit]
+	if number == "" || !ok {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	bytes := value * multiplier
+	if bytes > math.MaxInt64 {
+		return 0, fmt.Errorf("size %q is too large", s)
+	}
+	return int64(bytes), nil
+}
+
+// FormatSize formats a byte count with the largest binary unit, e.g. "2G" or
+// "223.51G". Fractions are truncated so that a size is never overstated.
+func FormatSize(bytes int64) string {
+	units := []string{"", "K", "M", "G", "T", "P"}
+	value := float64(bytes)
+	unit := 0
+	for unit < len(units)-1 && math.Abs(value) >= 1024 {
+		value /= 1024
+		unit++
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%dB", bytes)
+	}
+	return strconv.FormatFloat(math.Floor(value*100)/100, 'f', -1, 64) + units[unit]
+}
+
+// SizeRemaining is the size of a partition or logical volume that takes the
+// rest of the space of its disk or volume group.
+const SizeRemaining Size = "-1"
+
+// Size is the size of a partition or logical volume as curtin accepts it: a
+// byte count ("2147483648"), a number with a binary unit ("2G", "1.5T"), a
+// percentage of the containing disk or volume group ("50%"), or SizeRemaining.
+// Sizes are read from YAML and JSON numbers or strings and keep their units;
+// the spelling is normalized to what curtin accepts, e.g. "2 GiB" becomes "2G"
+// and decimal units like "240GB" become byte counts.
+type Size string
+
+// SizeBytes returns the Size of a byte count.
+func SizeBytes(n int64) Size {
+	return Size(strconv.FormatInt(n, 10))
+}
+
+// binaryUnits maps the binary unit spellings to the unit letters curtin accepts.
+var binaryUnits = map[string]string{
+	"K": "K", "KIB": "K",
+	"M": "M", "MIB": "M",
+	"G": "G", "GIB": "G",
+	"T": "T", "TIB": "T",
+}
+
+// Normalize returns the size in the spelling curtin accepts, or an error when
+// s is not a valid size.
+func (s Size) Normalize() (Size, error) {
+	v := strings.ReplaceAll(strings.TrimSpace(string(s)), " ", "")
+	if Size(v) == SizeRemaining {
+		return SizeRemaining, nil
+	}
+	if number, ok := strings.CutSuffix(v, "%"); ok {
+		p, err := strconv.ParseFloat(number, 64)
+		if err != nil || p <= 0 || p > 100 {
+			return s, fmt.Errorf("invalid size %q, percentages must be greater than 0 and at most 100", string(s))
+		}
+		return Size(strconv.FormatFloat(p, 'f', -1, 64) + "%"), nil
+	}
+
+	i := strings.IndexFunc(v, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	if i < 0 {
+		i = len(v)
+	}
+	number, unit := v[:i], strings.ToUpper(v[i:])
+	if letter, ok := binaryUnits[unit]; ok {
+		if _, err := strconv.ParseFloat(number, 64); err != nil {
+			return s, fmt.Errorf("invalid size %q", string(s))
+		}
+		return Size(number + letter), nil
+	}
+	// Byte counts, decimal units and petabytes, which curtin has no unit for
+	bytes, err := ParseSize(v)
+	if err != nil {
+		return s, err
+	}
+	return SizeBytes(bytes), nil
+}
+
+// Validate checks that s is a valid size.
+func (s Size) Validate() error {
+	_, err := s.Normalize()
+	return err
+}
+
+// IsRemaining reports whether s takes the rest of the space.
+func (s Size) IsRemaining() bool {
+	return s == SizeRemaining
+}
+
+// Percent returns the percentage of a percentage size.
+func (s Size) Percent() (float64, bool) {
+	number, ok := strings.CutSuffix(string(s), "%")
+	if !ok {
+		return 0, false
+	}
+	p, err := strconv.ParseFloat(number, 64)
+	return p, err == nil
+}
+
+// Bytes returns the byte count of an absolute size, or -1 for SizeRemaining.
+// Percentages depend on the size of the disk or volume group, see Resolve.
+func (s Size) Bytes() (int64, error) {
+	if s.IsRemaining() {
+		return -1, nil
+	}
+	if _, ok := s.Percent(); ok {
+		return 0, fmt.Errorf("size %q is relative", string(s))
+	}
+	return ParseSize(string(s))
+}
+
+// Resolve returns the byte count of s within a disk or volume group of total
+// bytes, or -1 for SizeRemaining.
+func (s Size) Resolve(total int64) (int64, error) {
+	if p, ok := s.Percent(); ok {
+		return int64(float64(total) * p / 100), nil
+	}
+	return s.Bytes()
+}
+
+// UnmarshalYAML reads a size from a YAML number or string.
+func (s *Size) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind != yaml.ScalarNode {
+		return fmt.Errorf("line %d: size must be a number or a string", value.Line)
+	}
+	*s = normalizedSize(value.Value)
+	return nil
+}
+
+// MarshalYAML writes byte counts as YAML numbers and other sizes as strings.
+func (s Size) MarshalYAML() (interface{}, error) {
+	if n, err := strconv.ParseInt(string(s), 10, 64); err == nil {
+		return n, nil
+	}
+	return string(s), nil
+}
+
+// UnmarshalJSON reads a size from a JSON number or string.
+func (s *Size) UnmarshalJSON(data []byte) error {
+	var raw interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&raw); err != nil {
+		return err
+	}
+	switch v := raw.(type) {
+	case json.Number:
+		*s = normalizedSize(v.String())
+	case string:
+		*s = normalizedSize(v)
+	case nil:
+		*s = ""
+	default:
+		return fmt.Errorf("size must be a number or a string")
+	}
+	return nil
+}
+
+// MarshalJSON writes byte counts as JSON numbers and other sizes as strings.
+func (s Size) MarshalJSON() ([]byte, error) {
+	if n, err := strconv.ParseInt(string(s), 10, 64); err == nil {
+		return []byte(strconv.FormatInt(n, 10)), nil
+	}
+	return json.Marshal(string(s))
+}
+
+// normalizedSize returns the normalized spelling of raw, or raw itself when it
+// is not a valid size so that validation can report it.
+func normalizedSize(raw string) Size {
+	if raw == "" {
+		return ""
+	}
+	if n, err := Size(raw).Normalize(); err == nil {
+		return n
+	}
+	return Size(raw)
+}
@@ -0,0 +1,108 @@
+package config
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestParseSize(t *testing.T) {
+	for in, want := range map[string]int64{
+		"2147483648": 2147483648,
+		"512M":       512 << 20,
+		"2G":         2 << 30,
+		"1.5GiB":     3 << 29,
+		"4T":         4 << 40,
+		"240GB":      240e9,
+		" 4 tb ":     4e12,
+	} {
+		got, err := ParseSize(in)
+		assert.NoError(t, err, in)
+		assert.Equal(t, want, got, in)
+	}
+
+	for _, in := range []string{"", "G", "2X", "1.2.3G", "-1"} {
+		_, err := ParseSize(in)
+		assert.Error(t, err, in)
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	assert.Equal(t, "512B", FormatSize(512))
+	assert.Equal(t, "2G", FormatSize(2<<30))
+	assert.Equal(t, "223.51G", FormatSize(240e9))
+	assert.Equal(t, "3.63T", FormatSize(4e12))
+}
+
+func TestSize_Normalize(t *testing.T) {
+	for in, want := range map[Size]Size{
+		"2147483648": "2147483648",
+		"2G":         "2G",
+		"2 GiB":      "2G",
+		"1.5t":       "1.5T",
+		"512MiB":     "512M",
+		"240GB":      "240000000000",
+		"1P":         "1125899906842624",
+		"20%":        "20%",
+		" 50.0 % ":   "50%",
+		"-1":         SizeRemaining,
+	} {
+		got, err := in.Normalize()
+		assert.NoError(t, err, in)
+		assert.Equal(t, want, got, in)
+	}
+
+	for _, in := range []Size{"", "G", "2X", "0%", "101%", "-5", "-1G", "abc%"} {
+		assert.Error(t, in.Validate(), in)
+	}
+}
+
+func TestSize_Resolve(t *testing.T) {
+	got, err := Size("25%").Resolve(8 << 30)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2<<30), got)
+
+	got, err = Size("2G").Resolve(8 << 30)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2<<30), got)
+
+	got, err = SizeRemaining.Resolve(8 << 30)
+	require.NoError(t, err)
+	assert.Equal(t, int64(-1), got)
+
+	_, err = Size("25%").Bytes()
+	assert.Error(t, err)
+}
+
+func TestSize_YAMLRoundTrip(t *testing.T) {
+	var entries []StorageConfig
+	require.NoError(t, yaml.Unmarshal([]byte(`
+- {type: partition, id: p1, size: 1073741824}
+- {type: partition, id: p2, size: 2 GiB}
+- {type: lvm_partition, id: lv0, size: 20%}
+- {type: lvm_partition, id: lv1, size: -1}
+- {type: lvm_partition, id: lv2, size: 12 parsecs}
+`), &entries))
+	assert.Equal(t, []Size{"1073741824", "2G", "20%", SizeRemaining, "12 parsecs"},
+		[]Size{entries[0].Size, entries[1].Size, entries[2].Size, entries[3].Size, entries[4].Size})
+
+	out, err := yaml.Marshal(entries[:4])
+	require.NoError(t, err)
+	assert.Contains(t, string(out), "size: 1073741824\n")
+	assert.Contains(t, string(out), "size: 2G\n")
+	assert.Contains(t, string(out), "size: 20%\n")
+	assert.Contains(t, string(out), "size: -1\n")
+}
+
+func TestSize_JSONRoundTrip(t *testing.T) {
+	var sizes []Size
+	require.NoError(t, json.Unmarshal([]byte(`[1073741824, "2 GiB", "20%", -1]`), &sizes))
+	assert.Equal(t, []Size{"1073741824", "2G", "20%", SizeRemaining}, sizes)
+
+	out, err := json.Marshal(sizes)
+	require.NoError(t, err)
+	assert.JSONEq(t, `[1073741824, "2G", "20%", -1]`, string(out))
+}
@@ -0,0 +1,407 @@
+package config
+
+import (
+	"fmt"
+)
+
+// Curtin storage action types.
+const (
+	StorageDisk           = "disk"
+	StoragePartition      = "partition"
+	StorageFormat         = "format"
+	StorageLVMVolgroup    = "lvm_volgroup"
+	StorageLVMPartition   = "lvm_partition"
+	StorageDMCrypt        = "dm_crypt"
+	StorageMount          = "mount"
+	StorageRAID           = "raid"
+	StorageBcache         = "bcache"
+	StorageZpool          = "zpool"
+	StorageZFS            = "zfs"
+	StorageNVMeController = "nvme_controller"
+)
+
+// Issue codes of the storage graph checks.
+const (
+	CodeUnknownType      = "unknown-type"
+	CodeDuplicateID      = "duplicate-id"
+	CodeUnknownReference = "unknown-reference"
+	CodeInvalidReference = "invalid-reference"
+	CodeReferenceOrder   = "reference-order"
+	CodeDuplicateNumber  = "duplicate-partition-number"
+	CodeGrubDevice       = "grub-device"
+	CodeInvalidSize      = "invalid-size"
+	CodeNoSpace          = "no-space"
+)
+
+// luksHeaderSize is the space cryptsetup reserves for the LUKS2 header.
+const luksHeaderSize = 16 << 20
+
+// storageRef is a reference from a storage action to another one.
+type storageRef struct {
+	field   string   // JSON pointer of the reference below the action, e.g. "device" or "devices/0"
+	id      string   // Referenced action ID
+	targets []string // Action types the reference may point to
+}
+
+// references returns the actions e refers to.
+func (e *StorageConfig) references() []storageRef {
+	volumes := []string{StorageDisk, StoragePartition, StorageLVMPartition, StorageDMCrypt, StorageRAID, StorageBcache}
+	switch e.Type {
+	case StorageDisk:
+		if e.NVMeController != "" {
+			return []storageRef{{"nvme_controller", e.NVMeController, []string{StorageNVMeController}}}
+		}
+	case StoragePartition:
+		return []storageRef{{"device", e.Device, []string{StorageDisk, StorageRAID}}}
+	case StorageFormat:
+		return []storageRef{{"volume", e.Volume, volumes}}
+	case StorageLVMVolgroup:
+		return listRefs("devices", e.Devices, volumes)
+	case StorageLVMPartition:
+		return []storageRef{{"volgroup", e.Volgroup, []string{StorageLVMVolgroup}}}
+	case StorageDMCrypt:
+		return []storageRef{{"volume", e.Volume, []string{StorageDisk, StoragePartition, StorageLVMPartition, StorageRAID}}}
+	case StorageMount:
+		return []storageRef{{"device", e.Device, []string{StorageFormat}}}
+	case StorageRAID:
+		members := []string{StorageDisk, StoragePartition}
+		return append(listRefs("devices", e.Devices, members), listRefs("spare_devices", e.SpareDevices, members)...)
+	case StorageBcache:
+		return []storageRef{
+			{"backing_device", e.BackingDevice, []string{StorageDisk, StoragePartition, StorageRAID, StorageLVMPartition, StorageDMCrypt}},
+			{"cache_device", e.CacheDevice, []string{StorageDisk, StoragePartition, StorageRAID}},
+		}
+	case StorageZpool:
+		return listRefs("vdevs", e.Vdevs, []string{StorageDisk, StoragePartition, StorageDMCrypt, StorageRAID, StorageBcache})
+	case StorageZFS:
+		return []storageRef{{"pool", e.Pool, []string{StorageZpool}}}
+	}
+	return nil
+}
+
+// listRefs returns the references of a list field like "devices".
+func listRefs(field string, ids []string, targets []string) []storageRef {
+	refs := make([]storageRef, 0, len(ids))
+	for i, id := range ids {
+		refs = append(refs, storageRef{fmt.Sprintf("%s/%d", field, i), id, targets})
+	}
+	return refs
+}
+
+// knownStorageType reports whether curtin has an action of type t.
+func knownStorageType(t string) bool {
+	switch t {
+	case StorageDisk, StoragePartition, StorageFormat, StorageLVMVolgroup, StorageLVMPartition, StorageDMCrypt, StorageMount,
+		StorageRAID, StorageBcache, StorageZpool, StorageZFS, StorageNVMeController:
+		return true
+	}
+	return false
+}
+
+// checkGraph validates the storage actions as a graph: unique IDs, references
+// that resolve to earlier actions of a suitable type, partition numbers, the
+// grub device and the sizes of partitions and logical volumes.
+func (s *Storage) checkGraph(r *ValidationResult, path string) {
+	entries := s.Config
+	index := make(map[string]int, len(entries))
+	for i := range entries {
+		e := &entries[i]
+		at := fmt.Sprintf("%s/config/%d", path, i)
+
+		if !knownStorageType(e.Type) {
+			r.addError(at+"/type", CodeUnknownType, fmt.Sprintf("unknown storage type %q", e.Type), "")
+		}
+		if e.ID == "" {
+			r.addError(at+"/id", CodeRequired, "storage action has no id", "")
+		} else if first, ok := index[e.ID]; ok {
+			r.addError(at+"/id", CodeDuplicateID, fmt.Sprintf("id %q is already used by entry %d", e.ID, first), "give every storage action a unique id")
+		} else {
+			index[e.ID] = i
+		}
+	}
+
+	for i := range entries {
+		e := &entries[i]
+		at := fmt.Sprintf("%s/config/%d", path, i)
+
+		if (e.Type == StorageLVMVolgroup || e.Type == StorageRAID) && len(e.Devices) == 0 {
+			r.addError(at+"/devices", CodeRequired, fmt.Sprintf("%s %s has no devices", e.Type, e.ID), "")
+		}
+		if e.Type == StorageZpool && len(e.Vdevs) == 0 {
+			r.addError(at+"/vdevs", CodeRequired, fmt.Sprintf("zpool %s has no vdevs", e.ID), "")
+		}
+		for _, ref := range e.references() {
+			if ref.id == "" {
+				r.addError(at+"/"+ref.field, CodeRequired, fmt.Sprintf("%s %s has no %s", e.Type, e.ID, ref.field), "")
+				continue
+			}
+			j, ok := index[ref.id]
+			if !ok {
+				r.addError(at+"/"+ref.field, CodeUnknownReference, fmt.Sprintf("%s %s refers to unknown id %q", e.Type, e.ID, ref.id), "")
+				continue
+			}
+			target := entries[j].Type
+			if !containsString(ref.targets, target) {
+				r.addError(at+"/"+ref.field, CodeInvalidReference,
+					fmt.Sprintf("%s %s refers to %s %s, expected %s", e.Type, e.ID, target, ref.id, joinOr(ref.targets)), "")
+				continue
+			}
+			if j > i {
+				r.addError(at+"/"+ref.field, CodeReferenceOrder,
+					fmt.Sprintf("%s %s refers to %s, which is defined later", e.Type, e.ID, ref.id),
+					fmt.Sprintf("move %s before %s", ref.id, e.ID))
+			}
+		}
+
+		switch e.Type {
+		case StorageFormat:
+			if e.Fstype == "" {
+				r.addError(at+"/fstype", CodeRequired, fmt.Sprintf("format %s has no fstype", e.ID), "")
+			}
+		case StorageLVMVolgroup, StorageLVMPartition:
+			if e.Name == "" {
+				r.addError(at+"/name", CodeRequired, fmt.Sprintf("%s %s has no name", e.Type, e.ID), "")
+			}
+		case StorageRAID:
+			s.checkRAID(r, at, e, index)
+		case StorageBcache:
+			e.checkBcache(r, at)
+		case StorageZpool:
+			e.checkZpool(r, at)
+		case StorageZFS:
+			e.checkZFS(r, at)
+		case StorageNVMeController:
+			e.checkNVMeController(r, at)
+		case StorageMount:
+			if e.Path == "" && !s.mountsSwap(e, index) {
+				r.addError(at+"/path", CodeRequired, fmt.Sprintf("mount %s has no path", e.ID), "")
+			}
+		}
+	}
+
+	s.checkPartitionNumbers(r, path)
+	s.checkGrubDevice(r, path)
+	s.checkSizes(r, path, index)
+}
+
+// mountsSwap reports whether mount e mounts a swap format, which needs no path.
+func (s *Storage) mountsSwap(e *StorageConfig, index map[string]int) bool {
+	j, ok := index[e.Device]
+	return ok && s.Config[j].Type == StorageFormat && s.Config[j].Fstype == "swap"
+}
+
+// checkPartitionNumbers reports partitions sharing a number on the same disk.
+func (s *Storage) checkPartitionNumbers(r *ValidationResult, path string) {
+	type key struct {
+		device string
+		number int
+	}
+	seen := make(map[key]string)
+	for i, e := range s.Config {
+		if e.Type != StoragePartition || e.Number == 0 {
+			continue
+		}
+		k := key{e.Device, e.Number}
+		if other, ok := seen[k]; ok {
+			r.addError(fmt.Sprintf("%s/config/%d/number", path, i), CodeDuplicateNumber,
+				fmt.Sprintf("partition %s and %s both have number %d on %s", other, e.ID, e.Number, e.Device), "")
+			continue
+		}
+		seen[k] = e.ID
+	}
+}
+
+// checkGrubDevice requires a device to install the bootloader to. Several grub
+// devices are only needed for mirrored boot disks, so they only cause a warning
+// when the disks do not hold members of a raid1.
+func (s *Storage) checkGrubDevice(r *ValidationResult, path string) {
+	var grub []int
+	for i, e := range s.Config {
+		if e.GrubDevice {
+			grub = append(grub, i)
+		}
+	}
+	switch {
+	case len(grub) == 0:
+		r.addError(path+"/config", CodeGrubDevice, "no storage action has grub_device set, the bootloader cannot be installed",
+			"set grub_device on the boot disk, or on the ESP partition for UEFI")
+	case len(grub) > 1 && !s.mirrored(grub):
+		r.addWarning(fmt.Sprintf("%s/config/%d/grub_device", path, grub[1]), CodeGrubDevice,
+			fmt.Sprintf("grub is installed on %d devices", len(grub)), "set grub_device on a single device unless the boot disks are mirrored")
+	}
+}
+
+// mirrored reports whether the actions at indexes are disks that each hold a
+// partition of a raid1.
+func (s *Storage) mirrored(indexes []int) bool {
+	members := make(map[string]bool)
+	for _, e := range s.Config {
+		if e.Type == StorageRAID && e.RAIDLevel.normalize() == "raid1" {
+			for _, dev := range e.Devices {
+				members[dev] = true
+			}
+		}
+	}
+	for _, i := range indexes {
+		disk := s.Config[i]
+		if disk.Type != StorageDisk {
+			return false
+		}
+		found := false
+		for _, e := range s.Config {
+			if e.Type == StoragePartition && e.Device == disk.ID && members[e.ID] {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// checkSizes validates the sizes of partitions and logical volumes: they are
+// valid sizes, -1 is only used for the rest of the space on the last one of a
+// disk or volume group, percentages add up to at most 100%, and logical
+// volumes fit the volume group when its size is known.
+func (s *Storage) checkSizes(r *ValidationResult, path string, index map[string]int) {
+	// The last partition or logical volume on every disk or volume group
+	last := make(map[string]int)
+	for i, e := range s.Config {
+		if parent := sizeParent(&e); parent != "" {
+			last[parent] = i
+		}
+	}
+
+	const suggestion = `set a size like 2147483648, "2G" or "20%", or -1 for the remaining space`
+	used := make(map[string]int64)
+	percent := make(map[string]float64)
+	fill := make(map[string]bool)
+	for i, e := range s.Config {
+		parent := sizeParent(&e)
+		if parent == "" || e.Preserve {
+			continue
+		}
+		at := fmt.Sprintf("%s/config/%d/size", path, i)
+		if e.Size == "" {
+			r.addError(at, CodeRequired, fmt.Sprintf("%s %s has no size", e.Type, e.ID), suggestion)
+			continue
+		}
+		if err := e.Size.Validate(); err != nil {
+			r.addError(at, CodeInvalidSize, fmt.Sprintf("%s %s: %v", e.Type, e.ID, err), suggestion)
+			continue
+		}
+		if p, ok := e.Size.Percent(); ok {
+			percent[parent] += p
+			continue
+		}
+		bytes, _ := e.Size.Bytes()
+		switch {
+		case bytes == -1:
+			if last[parent] != i {
+				r.addError(at, CodeInvalidSize, fmt.Sprintf("%s %s uses the remaining space of %s but is not its last one", e.Type, e.ID, parent),
+					fmt.Sprintf("move %s after the other %ss of %s", e.ID, e.Type, parent))
+			}
+			fill[parent] = true
+		case bytes == 0:
+			r.addError(at, CodeInvalidSize, fmt.Sprintf("%s %s has a size of 0", e.Type, e.ID), suggestion)
+		default:
+			used[parent] += bytes
+		}
+	}
+
+	for i, e := range s.Config {
+		at := fmt.Sprintf("%s/config/%d", path, i)
+		if percent[e.ID] > 100 {
+			r.addError(at, CodeNoSpace, fmt.Sprintf("sizes on %s add up to %g%%", e.ID, percent[e.ID]), "")
+			continue
+		}
+		if e.Type != StorageLVMVolgroup {
+			continue
+		}
+		capacity, ok := s.volgroupSize(&e, index)
+		if !ok {
+			continue
+		}
+		need := used[e.ID] + int64(float64(capacity)*percent[e.ID]/100)
+		switch {
+		case need > capacity:
+			r.addError(at, CodeNoSpace, fmt.Sprintf("logical volumes of %s need %s but the volume group has %s", e.ID, FormatSize(need), FormatSize(capacity)), "")
+		case fill[e.ID] && need == capacity:
+			r.addError(at, CodeNoSpace, fmt.Sprintf("no space is left in %s for the logical volume using the remaining space", e.ID), "")
+		}
+	}
+}
+
+// sizeParent returns the disk or volume group whose space a partition or
+// logical volume takes, or "" for other actions.
+func sizeParent(e *StorageConfig) string {
+	switch e.Type {
+	case StoragePartition:
+		return e.Device
+	case StorageLVMPartition:
+		return e.Volgroup
+	}
+	return ""
+}
+
+// volgroupSize returns the size of a volume group when the sizes of all its
+// physical volumes are known.
+func (s *Storage) volgroupSize(vg *StorageConfig, index map[string]int) (int64, bool) {
+	var total int64
+	for _, dev := range vg.Devices {
+		size, ok := s.volumeSize(dev, index, 0)
+		if !ok {
+			return 0, false
+		}
+		total += size
+	}
+	return total, len(vg.Devices) > 0
+}
+
+// volumeSize returns the size of the partition or encrypted volume id, when it
+// does not depend on the size of a disk.
+func (s *Storage) volumeSize(id string, index map[string]int, depth int) (int64, bool) {
+	i, ok := index[id]
+	if !ok || depth > len(s.Config) {
+		return 0, false
+	}
+	e := &s.Config[i]
+	switch e.Type {
+	case StoragePartition, StorageLVMPartition:
+		size, err := e.Size.Bytes()
+		return size, err == nil && size > 0
+	case StorageDMCrypt:
+		size, ok := s.volumeSize(e.Volume, index, depth+1)
+		if !ok || size <= luksHeaderSize {
+			return 0, false
+		}
+		return size - luksHeaderSize, true
+	}
+	return 0, false
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// joinOr joins words as "a, b or c".
+func joinOr(words []string) string {
+	switch len(words) {
+	case 0:
+		return ""
+	case 1:
+		return words[0]
+	}
+	out := words[0]
+	for _, w := range words[1 : len(words)-1] {
+		out += ", " + w
+	}
+	return out + " or " + words[len(words)-1]
+}
@@ -0,0 +1,208 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// RAIDLevel is the level of a raid action. Levels are read from YAML and JSON
+// numbers or strings and normalized to the names curtin accepts, e.g. 10 and
+// "RAID10" become "raid10".
+type RAIDLevel string
+
+// raidMinDevices is the number of devices every supported RAID level needs.
+var raidMinDevices = map[RAIDLevel]int{
+	"raid0":  2,
+	"raid1":  2,
+	"raid5":  3,
+	"raid6":  4,
+	"raid10": 4,
+}
+
+// normalize returns the curtin name of the level, or l when it is unknown.
+func (l RAIDLevel) normalize() RAIDLevel {
+	v := strings.TrimPrefix(strings.ToLower(strings.TrimSpace(string(l))), "raid")
+	switch v {
+	case "stripe":
+		v = "0"
+	case "mirror":
+		v = "1"
+	}
+	if _, ok := raidMinDevices[RAIDLevel("raid"+v)]; ok {
+		return RAIDLevel("raid" + v)
+	}
+	return l
+}
+
+// UnmarshalYAML reads a level from a YAML number or string.
+func (l *RAIDLevel) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind != yaml.ScalarNode {
+		return fmt.Errorf("line %d: raidlevel must be a number or a string", value.Line)
+	}
+	*l = RAIDLevel(value.Value).normalize()
+	return nil
+}
+
+// UnmarshalJSON reads a level from a JSON number or string.
+func (l *RAIDLevel) UnmarshalJSON(data []byte) error {
+	var raw interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&raw); err != nil {
+		return err
+	}
+	switch v := raw.(type) {
+	case json.Number:
+		*l = RAIDLevel(v.String()).normalize()
+	case string:
+		*l = RAIDLevel(v).normalize()
+	case nil:
+		*l = ""
+	default:
+		return fmt.Errorf("raidlevel must be a number or a string")
+	}
+	return nil
+}
+
+// checkRAID validates a raid: a known level with enough devices, spare devices
+// only for levels with redundancy, and every member used once.
+func (s *Storage) checkRAID(r *ValidationResult, at string, e *StorageConfig, index map[string]int) {
+	if e.Name == "" {
+		r.addError(at+"/name", CodeRequired, fmt.Sprintf("raid %s has no name", e.ID), "")
+	}
+	level := e.RAIDLevel.normalize()
+	min, ok := raidMinDevices[level]
+	switch {
+	case e.RAIDLevel == "":
+		r.addError(at+"/raidlevel", CodeRequired, fmt.Sprintf("raid %s has no raidlevel", e.ID), "")
+	case !ok:
+		r.addError(at+"/raidlevel", CodeInvalidValue, fmt.Sprintf("raid %s has an unknown raidlevel %q", e.ID, e.RAIDLevel),
+			"use raid0, raid1, raid5, raid6 or raid10")
+	case len(e.Devices) > 0 && len(e.Devices) < min:
+		r.addError(at+"/devices", CodeInvalidValue, fmt.Sprintf("%s needs at least %d devices, raid %s has %d", level, min, e.ID, len(e.Devices)), "")
+	}
+	if level == "raid0" && len(e.SpareDevices) > 0 {
+		r.addError(at+"/spare_devices", CodeInvalidValue, fmt.Sprintf("raid %s is a raid0, which cannot rebuild onto spare devices", e.ID), "")
+	}
+
+	used := make(map[string]bool)
+	disks := make(map[string]string)
+	for i, dev := range append(append([]string{}, e.Devices...), e.SpareDevices...) {
+		field := fmt.Sprintf("devices/%d", i)
+		if i >= len(e.Devices) {
+			field = fmt.Sprintf("spare_devices/%d", i-len(e.Devices))
+		}
+		if used[dev] {
+			r.addError(at+"/"+field, CodeInvalidReference, fmt.Sprintf("raid %s uses %s twice", e.ID, dev), "")
+			continue
+		}
+		used[dev] = true
+		j, ok := index[dev]
+		if !ok || s.Config[j].Type != StoragePartition {
+			continue
+		}
+		disk := s.Config[j].Device
+		if other, ok := disks[disk]; ok && level != "raid0" {
+			r.addWarning(at+"/"+field, CodeInvalidValue,
+				fmt.Sprintf("%s and %s of raid %s are on the same disk %s, a failure of the disk loses both", other, dev, e.ID, disk),
+				"put every member on a different disk")
+		}
+		disks[disk] = dev
+	}
+}
+
+// checkBcache validates the cache mode and devices of a bcache.
+func (e *StorageConfig) checkBcache(r *ValidationResult, at string) {
+	switch e.CacheMode {
+	case "", "writethrough", "writeback", "writearound", "none":
+	default:
+		r.addError(at+"/cache_mode", CodeInvalidValue, fmt.Sprintf("bcache %s has an unknown cache_mode %q", e.ID, e.CacheMode),
+			"use writethrough, writeback, writearound or none")
+	}
+	if e.BackingDevice != "" && e.BackingDevice == e.CacheDevice {
+		r.addError(at+"/cache_device", CodeInvalidReference, fmt.Sprintf("bcache %s uses %s as backing and cache device", e.ID, e.CacheDevice), "")
+	}
+}
+
+// zpoolName matches valid ZFS pool names.
+var zpoolName = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_.:-]*$`)
+
+// checkZpool validates the name, mountpoint and properties of a zpool.
+func (e *StorageConfig) checkZpool(r *ValidationResult, at string) {
+	switch {
+	case e.Pool == "":
+		r.addError(at+"/pool", CodeRequired, fmt.Sprintf("zpool %s has no pool name", e.ID), "")
+	case !zpoolName.MatchString(e.Pool) || reservedPoolName(e.Pool):
+		r.addError(at+"/pool", CodeInvalidValue, fmt.Sprintf("%q is not a valid ZFS pool name", e.Pool),
+			"start with a letter and use letters, digits, _ - . and :")
+	}
+	if e.Mountpoint != "" {
+		checkZFSMountpoint(r, at+"/mountpoint", e.Mountpoint)
+	}
+	if ashift, ok := e.PoolProperties["ashift"]; ok {
+		if n, err := strconv.Atoi(ashift); err != nil || n < 9 || n > 16 {
+			r.addError(at+"/pool_properties/ashift", CodeInvalidValue, fmt.Sprintf("ashift of zpool %s must be between 9 and 16", e.ID),
+				"use 12 for disks with 4K sectors")
+		}
+	}
+	if mountpoint, ok := e.FSProperties["mountpoint"]; ok {
+		checkZFSMountpoint(r, at+"/fs_properties/mountpoint", mountpoint)
+	}
+}
+
+// reservedPoolName reports whether name starts with a word zpool reserves for vdev types.
+func reservedPoolName(name string) bool {
+	for _, word := range []string{"mirror", "raidz", "draid", "spare", "log", "cache", "special", "dedup"} {
+		if strings.HasPrefix(name, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkZFS validates the volume and mountpoint of a zfs dataset.
+func (e *StorageConfig) checkZFS(r *ValidationResult, at string) {
+	if e.Volume == "" {
+		r.addError(at+"/volume", CodeRequired, fmt.Sprintf("zfs %s has no volume", e.ID), "")
+	}
+	if mountpoint, ok := e.Properties["mountpoint"]; ok {
+		checkZFSMountpoint(r, at+"/properties/mountpoint", mountpoint)
+	}
+}
+
+// checkZFSMountpoint checks that a ZFS mountpoint is an absolute path, none or legacy.
+func checkZFSMountpoint(r *ValidationResult, at, mountpoint string) {
+	if mountpoint != "none" && mountpoint != "legacy" && !strings.HasPrefix(mountpoint, "/") {
+		r.addError(at, CodeInvalidValue, fmt.Sprintf("ZFS mountpoint %q is not an absolute path", mountpoint), "use an absolute path, none or legacy")
+	}
+}
+
+// checkNVMeController validates the transport of an NVMe controller: local
+// PCIe controllers need no address, NVMe over TCP needs an IP address and port.
+func (e *StorageConfig) checkNVMeController(r *ValidationResult, at string) {
+	switch e.Transport {
+	case "":
+		r.addError(at+"/transport", CodeRequired, fmt.Sprintf("nvme_controller %s has no transport", e.ID), "use pcie or tcp")
+	case "pcie":
+		if e.TCPAddr != "" || e.TCPPort != 0 {
+			r.addError(at+"/transport", CodeInvalidValue, fmt.Sprintf("nvme_controller %s uses pcie but has a TCP address", e.ID),
+				"remove tcp_addr and tcp_port or use the tcp transport")
+		}
+	case "tcp":
+		if net.ParseIP(e.TCPAddr) == nil {
+			r.addError(at+"/tcp_addr", CodeInvalidValue, fmt.Sprintf("nvme_controller %s needs the IP address of the target in tcp_addr", e.ID), "")
+		}
+		if e.TCPPort < 1 || e.TCPPort > 65535 {
+			r.addError(at+"/tcp_port", CodeInvalidValue, fmt.Sprintf("nvme_controller %s needs a tcp_port between 1 and 65535", e.ID), "use 4420, the NVMe/TCP default")
+		}
+	default:
+		r.addError(at+"/transport", CodeInvalidValue, fmt.Sprintf("nvme_controller %s has an unknown transport %q", e.ID, e.Transport), "use pcie or tcp")
+	}
+}
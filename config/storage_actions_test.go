@@ -0,0 +1,93 @@
+package config
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestRAIDLevel_Unmarshal(t *testing.T) {
+	var entries []StorageConfig
+	require.NoError(t, yaml.Unmarshal([]byte(`
+- {type: raid, id: md0, raidlevel: 10}
+- {type: raid, id: md1, raidlevel: RAID1}
+- {type: raid, id: md2, raidlevel: mirror}
+- {type: raid, id: md3, raidlevel: raid7}
+`), &entries))
+	assert.Equal(t, []RAIDLevel{"raid10", "raid1", "raid1", "raid7"},
+		[]RAIDLevel{entries[0].RAIDLevel, entries[1].RAIDLevel, entries[2].RAIDLevel, entries[3].RAIDLevel})
+
+	var e StorageConfig
+	require.NoError(t, json.Unmarshal([]byte(`{"type": "raid", "raidlevel": 5}`), &e))
+	assert.Equal(t, RAIDLevel("raid5"), e.RAIDLevel)
+}
+
+func TestStorage_CheckRAID(t *testing.T) {
+	issues := storageIssues([]StorageConfig{
+		{Type: StorageDisk, ID: "disk0", Ptable: "gpt", GrubDevice: true},
+		{Type: StorageDisk, ID: "disk1", Ptable: "gpt"},
+		{Type: StoragePartition, ID: "p0", Device: "disk0", Size: "10G"},
+		{Type: StoragePartition, ID: "p1", Device: "disk0", Size: "10G"},
+		{Type: StoragePartition, ID: "p2", Device: "disk1", Size: "10G"},
+		{Type: StorageRAID, ID: "md0", Name: "md0", RAIDLevel: "raid10", Devices: []string{"p0", "p2"}},
+		{Type: StorageRAID, ID: "md1", Name: "md1", RAIDLevel: "raid0", Devices: []string{"p0", "p2"}, SpareDevices: []string{"p1"}},
+		{Type: StorageRAID, ID: "md2", Name: "md2", RAIDLevel: "raid1", Devices: []string{"p0", "p1"}, SpareDevices: []string{"p0"}},
+		{Type: StorageRAID, ID: "md3", RAIDLevel: "raid7", Devices: []string{"disk1"}},
+	})
+
+	assert.Equal(t, map[string]string{
+		"/config/5/devices":         CodeInvalidValue,
+		"/config/6/spare_devices":   CodeInvalidValue,
+		"/config/7/devices/1":       CodeInvalidValue,
+		"/config/7/spare_devices/0": CodeInvalidReference,
+		"/config/8/name":            CodeRequired,
+		"/config/8/raidlevel":       CodeInvalidValue,
+	}, issues)
+}
+
+func TestStorage_CheckBcacheAndNVMe(t *testing.T) {
+	issues := storageIssues([]StorageConfig{
+		{Type: StorageNVMeController, ID: "nvme0", Transport: "tcp", TCPAddr: "172.16.82.78", TCPPort: 4420},
+		{Type: StorageNVMeController, ID: "nvme1", Transport: "tcp", TCPAddr: "target.example"},
+		{Type: StorageNVMeController, ID: "nvme2", Transport: "pcie", TCPPort: 4420},
+		{Type: StorageDisk, ID: "disk0", Ptable: "gpt", GrubDevice: true, NVMeController: "nvme0"},
+		{Type: StorageDisk, ID: "disk1", NVMeController: "disk0"},
+		{Type: StorageBcache, ID: "bcache0", BackingDevice: "disk0", CacheDevice: "disk1", CacheMode: "writeback"},
+		{Type: StorageBcache, ID: "bcache1", BackingDevice: "disk1", CacheDevice: "disk1", CacheMode: "fast"},
+		{Type: StorageBcache, ID: "bcache2", BackingDevice: "disk1"},
+	})
+
+	assert.Equal(t, map[string]string{
+		"/config/1/tcp_addr":        CodeInvalidValue,
+		"/config/1/tcp_port":        CodeInvalidValue,
+		"/config/2/transport":       CodeInvalidValue,
+		"/config/4/nvme_controller": CodeInvalidReference,
+		"/config/6/cache_mode":      CodeInvalidValue,
+		"/config/6/cache_device":    CodeInvalidReference,
+		"/config/7/cache_device":    CodeRequired,
+	}, issues)
+}
+
+func TestStorage_CheckZFS(t *testing.T) {
+	issues := storageIssues([]StorageConfig{
+		{Type: StorageDisk, ID: "disk0", Ptable: "gpt", GrubDevice: true},
+		{Type: StorageZpool, ID: "pool0", Pool: "mirror0", Vdevs: []string{"disk0"}, Mountpoint: "srv",
+			PoolProperties: map[string]string{"ashift": "20"}},
+		{Type: StorageZpool, ID: "pool1", Pool: "tank", FSProperties: map[string]string{"mountpoint": "none"}},
+		{Type: StorageZFS, ID: "zfs0", Pool: "pool1", Properties: map[string]string{"mountpoint": "data"}},
+		{Type: StorageZFS, ID: "zfs1", Pool: "disk0", Volume: "/data"},
+	})
+
+	assert.Equal(t, map[string]string{
+		"/config/1/pool":                   CodeInvalidValue,
+		"/config/1/mountpoint":             CodeInvalidValue,
+		"/config/1/pool_properties/ashift": CodeInvalidValue,
+		"/config/2/vdevs":                  CodeRequired,
+		"/config/3/volume":                 CodeRequired,
+		"/config/3/properties/mountpoint":  CodeInvalidValue,
+		"/config/4/pool":                   CodeInvalidReference,
+	}, issues)
+}
@@ -0,0 +1,111 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// storageIssues returns the codes of the storage issues by path.
+func storageIssues(entries []StorageConfig) map[string]string {
+	s := &Storage{Config: entries}
+	r := &ValidationResult{}
+	s.check(r, "", nil)
+	out := make(map[string]string)
+	for _, issue := range r.Issues {
+		out[issue.Path] = issue.Code
+	}
+	return out
+}
+
+func TestStorage_CheckGraph_Default(t *testing.T) {
+	s := NewDefaultConfig().Autoinstall.Storage
+	r := &ValidationResult{}
+	s.checkGraph(r, "")
+	assert.Empty(t, r.Issues)
+}
+
+func TestStorage_CheckGraph_References(t *testing.T) {
+	issues := storageIssues([]StorageConfig{
+		{Type: StorageDisk, ID: "disk0", Ptable: "gpt", GrubDevice: true},
+		{Type: StoragePartition, ID: "part1", Device: "disk9", Number: 1, Size: "1G"},
+		{Type: StorageFormat, ID: "fs1", Volume: "part9", Fstype: "ext4"},
+		{Type: StorageLVMPartition, ID: "lv0", Volgroup: "vg9", Name: "root", Size: SizeRemaining},
+		{Type: StorageMount, ID: "mount0", Device: "disk0", Path: "/"},
+		{Type: StorageMount, ID: "mount1", Device: "fs2", Path: "/srv"},
+		{Type: StorageFormat, ID: "fs2", Volume: "disk0", Fstype: "xfs"},
+		{Type: StorageMount, ID: "mount1", Device: "fs2"},
+		{Type: "raid5", ID: "md0"},
+	})
+
+	assert.Equal(t, map[string]string{
+		"/config/1/device":   CodeUnknownReference,
+		"/config/2/volume":   CodeUnknownReference,
+		"/config/3/volgroup": CodeUnknownReference,
+		"/config/4/device":   CodeInvalidReference,
+		"/config/5/device":   CodeReferenceOrder,
+		"/config/7/id":       CodeDuplicateID,
+		"/config/7/path":     CodeRequired,
+		"/config/8/type":     CodeUnknownType,
+	}, issues)
+}
+
+func TestStorage_CheckGraph_Partitions(t *testing.T) {
+	issues := storageIssues([]StorageConfig{
+		{Type: StorageDisk, ID: "disk0", Ptable: "gpt"},
+		{Type: StoragePartition, ID: "part1", Device: "disk0", Number: 1, Size: SizeRemaining},
+		{Type: StoragePartition, ID: "part2", Device: "disk0", Number: 1, Size: "1G"},
+		{Type: StoragePartition, ID: "part3", Device: "disk0", Number: 3},
+		{Type: StoragePartition, ID: "part4", Device: "disk0", Number: 4, Size: "-5"},
+	})
+
+	assert.Equal(t, map[string]string{
+		"/config":          CodeGrubDevice,
+		"/config/1/size":   CodeInvalidSize,
+		"/config/2/number": CodeDuplicateNumber,
+		"/config/3/size":   CodeRequired,
+		"/config/4/size":   CodeInvalidSize,
+	}, issues)
+}
+
+func TestStorage_CheckGraph_VolgroupSpace(t *testing.T) {
+	entries := []StorageConfig{
+		{Type: StorageDisk, ID: "disk0", Ptable: "gpt", GrubDevice: true},
+		{Type: StoragePartition, ID: "part1", Device: "disk0", Number: 1, Size: "10G"},
+		{Type: StorageDMCrypt, ID: "crypt0", Volume: "part1", KeyFile: "/tmp/key"},
+		{Type: StorageLVMVolgroup, ID: "vg0", Name: "vg0", Devices: []string{"crypt0"}},
+		{Type: StorageLVMPartition, ID: "lv0", Volgroup: "vg0", Name: "root", Size: "8G"},
+		{Type: StorageLVMPartition, ID: "lv1", Volgroup: "vg0", Name: "home", Size: SizeRemaining},
+	}
+	assert.Empty(t, storageIssues(entries))
+
+	// The LUKS header takes space from the volume group
+	entries[4].Size = "10G"
+	assert.Equal(t, map[string]string{"/config/3": CodeNoSpace}, storageIssues(entries))
+}
+
+func TestStorage_CheckGraph_Percentages(t *testing.T) {
+	entries := []StorageConfig{
+		{Type: StorageDisk, ID: "disk0", Ptable: "gpt", GrubDevice: true},
+		{Type: StoragePartition, ID: "part1", Device: "disk0", Number: 1, Size: "10G"},
+		{Type: StorageLVMVolgroup, ID: "vg0", Name: "vg0", Devices: []string{"part1"}},
+		{Type: StorageLVMPartition, ID: "lv0", Volgroup: "vg0", Name: "root", Size: "60%"},
+		{Type: StorageLVMPartition, ID: "lv1", Volgroup: "vg0", Name: "home", Size: "30%"},
+		{Type: StorageLVMPartition, ID: "lv2", Volgroup: "vg0", Name: "srv", Size: SizeRemaining},
+	}
+	assert.Empty(t, storageIssues(entries))
+
+	entries[4].Size = "50%"
+	assert.Equal(t, map[string]string{"/config/2": CodeNoSpace}, storageIssues(entries))
+
+	entries[4].Size = "lots"
+	assert.Equal(t, map[string]string{"/config/4/size": CodeInvalidSize}, storageIssues(entries))
+}
+
+func TestStorage_CheckGraph_GrubDevices(t *testing.T) {
+	issues := storageIssues([]StorageConfig{
+		{Type: StorageDisk, ID: "disk0", Ptable: "gpt", GrubDevice: true},
+		{Type: StorageDisk, ID: "disk1", Ptable: "gpt", GrubDevice: true},
+	})
+	assert.Equal(t, map[string]string{"/config/1/grub_device": CodeGrubDevice}, issues)
+}
@@ -0,0 +1,269 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Severity tells whether a validation issue blocks user-data generation.
+type Severity string
+
+const (
+	SeverityError   Severity = "error"   // The config cannot be installed
+	SeverityWarning Severity = "warning" // The config installs, but likely not as intended
+)
+
+// Issue codes reported by Check.
+const (
+	CodeRequired      = "required"
+	CodeInvalidValue  = "invalid-value"
+	CodePlaintextKey  = "plaintext-key"
+	CodeNoSSHLogin    = "no-ssh-login"
+	CodeNoInterfaceIP = "no-interface-address"
+	CodeNoDHCP        = "no-dhcp"
+)
+
+// Issue is a single problem found in a config.
+type Issue struct {
+	Path       string   `json:"path"` // JSON pointer of the offending value, e.g. /autoinstall/identity/hostname
+	Severity   Severity `json:"severity"`
+	Code       string   `json:"code"`
+	Message    string   `json:"message"`
+	Suggestion string   `json:"suggestion,omitempty"`
+}
+
+// ValidationResult collects every issue of a config.
+type ValidationResult struct {
+	Issues []Issue `json:"issues"`
+}
+
+// Valid reports whether the result has no errors; warnings are allowed.
+func (r *ValidationResult) Valid() bool {
+	return len(r.Errors()) == 0
+}
+
+// Errors returns the issues that block user-data generation.
+func (r *ValidationResult) Errors() []Issue {
+	return r.filter(SeverityError)
+}
+
+// Warnings returns the issues that do not block user-data generation.
+func (r *ValidationResult) Warnings() []Issue {
+	return r.filter(SeverityWarning)
+}
+
+// Err returns the errors of the result as a *ValidationError, or nil when it is valid.
+func (r *ValidationResult) Err() error {
+	if errs := r.Errors(); len(errs) > 0 {
+		return &ValidationError{Issues: errs}
+	}
+	return nil
+}
+
+func (r *ValidationResult) filter(severity Severity) []Issue {
+	var out []Issue
+	for _, issue := range r.Issues {
+		if issue.Severity == severity {
+			out = append(out, issue)
+		}
+	}
+	return out
+}
+
+func (r *ValidationResult) addError(path, code, message, suggestion string) {
+	r.Issues = append(r.Issues, Issue{Path: path, Severity: SeverityError, Code: code, Message: message, Suggestion: suggestion})
+}
+
+func (r *ValidationResult) addWarning(path, code, message, suggestion string) {
+	r.Issues = append(r.Issues, Issue{Path: path, Severity: SeverityWarning, Code: code, Message: message, Suggestion: suggestion})
+}
+
+// ValidationError lists the errors that make a config invalid.
+type ValidationError struct {
+	Issues []Issue
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Issues))
+	for _, issue := range e.Issues {
+		msgs = append(msgs, fmt.Sprintf("%s: %s", issue.Path, issue.Message))
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Validate checks the config and returns its errors as a *ValidationError.
+// Warnings do not make a config invalid, use Check to get them as well.
+func (c *Config) Validate() error {
+	return c.Check().Err()
+}
+
+// Check collects every error and warning of the config.
+func (c *Config) Check() *ValidationResult {
+	r := &ValidationResult{}
+	a := &c.Autoinstall
+	const root = "/autoinstall"
+
+	if a.Version == 0 {
+		r.addError(root+"/version", CodeRequired, "version must be non-zero", "set version to 1")
+	}
+
+	if a.PasswordPolicy != nil {
+		a.PasswordPolicy.check(r, root+"/password-policy")
+		if a.PasswordPolicy.LockIdentity && len(a.SSH.AuthorizedKeys) == 0 {
+			r.addWarning(root+"/password-policy/lock-identity", CodeNoSSHLogin,
+				fmt.Sprintf("the password of %s is locked and there are no authorized keys, the user cannot log in", a.Identity.Username),
+				"add an authorized key to ssh")
+		}
+	}
+	a.Identity.check(r, root+"/identity", a.PasswordPolicy)
+	a.Network.check(r, root+"/network")
+	a.Storage.check(r, root+"/storage", &a.Network)
+	if a.UserData != nil {
+		a.UserData.check(r, root+"/user-data", a.Identity.Username)
+	}
+	if a.Accounts != nil {
+		a.Accounts.check(r, root+"/accounts", a)
+	}
+
+	if a.SSH.InstallServer && !a.SSH.AllowPW && len(a.SSH.AuthorizedKeys) == 0 {
+		r.addWarning(root+"/ssh", CodeNoSSHLogin, "the SSH server allows neither passwords nor keys, nobody can log in over SSH",
+			"add an authorized key or set allow-pw to true")
+	}
+
+	for i, snap := range a.Snaps {
+		if snap.Name == "" {
+			r.addError(fmt.Sprintf("%s/snaps/%d/name", root, i), CodeRequired, "snap has no name", "")
+		}
+	}
+
+	names := make([]string, 0, len(a.Reporting))
+	for name := range a.Reporting {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		handler := a.Reporting[name]
+		handler.check(r, root+"/reporting/"+name)
+	}
+
+	return r
+}
+
+// CheckRelease collects the errors of the config with the installer of
+// release, or DefaultSchemaRelease when release is empty, that neither Check
+// nor the autoinstall schema find: storage layouts the release lacks.
+func (c *Config) CheckRelease(release string) *ValidationResult {
+	r := &ValidationResult{}
+	if release == "" {
+		release = DefaultSchemaRelease
+	}
+	if layout := c.Autoinstall.Storage.Layout; layout != nil && containsString(SchemaReleases, release) {
+		layout.checkRelease(r, "/autoinstall/storage/layout", release)
+	}
+	return r
+}
+
+// Validate performs basic checks for a reporting handler.
+func (h *ReportingHandler) Validate() error {
+	r := &ValidationResult{}
+	h.check(r, "")
+	return r.Err()
+}
+
+func (h *ReportingHandler) check(r *ValidationResult, path string) {
+	switch h.Type {
+	case "print", "rsyslog", "none":
+	case "webhook":
+		if h.Endpoint == "" {
+			r.addError(path+"/endpoint", CodeRequired, "webhook reporter requires an endpoint", "")
+		}
+	default:
+		r.addError(path+"/type", CodeInvalidValue, "type must be print, rsyslog, webhook or none", "")
+	}
+}
+
+// Validate performs basic checks for identity section.
+func (i *Identity) Validate() error {
+	r := &ValidationResult{}
+	i.check(r, "", nil)
+	return r.Err()
+}
+
+// check validates the identity; policy, which may be nil, applies to a
+// plaintext password.
+func (i *Identity) check(r *ValidationResult, path string, policy *PasswordPolicy) {
+	if i.Username == "" {
+		r.addError(path+"/username", CodeRequired, "username cannot be empty", "")
+	}
+	switch {
+	case policy != nil && policy.LockIdentity:
+		if i.Password != "" {
+			r.addWarning(path+"/password", CodeInvalidValue, "the identity password is locked by the password policy and not used",
+				"remove the password or lock-identity")
+		}
+	case i.Password == "":
+		r.addError(path+"/password", CodeRequired, "password cannot be empty", "")
+	default:
+		checkPassword(r, path+"/password", i.Username, i.Password, policy)
+	}
+	if i.Hostname == "" {
+		r.addError(path+"/hostname", CodeRequired, "hostname cannot be empty", "")
+	}
+}
+
+// Validate performs basic checks for network section.
+func (n *NetworkConfig) Validate() error {
+	r := &ValidationResult{}
+	n.check(r, "")
+	return r.Err()
+}
+
+func (n *NetworkConfig) check(r *ValidationResult, path string) {
+	if n.Version != 2 {
+		r.addError(path+"/version", CodeInvalidValue, "network config version must be 2", "set version to 2")
+	}
+	n.checkDevices(r, path)
+}
+
+// Validate performs basic checks for storage section.
+func (s *Storage) Validate() error {
+	r := &ValidationResult{}
+	s.check(r, "", nil)
+	return r.Err()
+}
+
+// check validates the storage actions, or those the layout expands to. Keys of
+// expanded layouts are checked with the layout. network, which may be nil when
+// it is unknown, is what the installer fetches generated keys over.
+func (s *Storage) check(r *ValidationResult, path string, network *NetworkConfig) {
+	layout := s.Layout != nil
+	if layout {
+		expanded, ok := s.expandLayout(r, path, network)
+		if !ok {
+			return
+		}
+		s = expanded
+	}
+	if s.Layout != nil {
+		return
+	}
+	if len(s.Config) == 0 {
+		r.addError(path+"/config", CodeRequired, "at least one storage config is required", "")
+		return
+	}
+	s.checkGraph(r, path)
+	if layout {
+		return
+	}
+	for i, entry := range s.Config {
+		if entry.Type != StorageDMCrypt {
+			continue
+		}
+		at := fmt.Sprintf("%s/config/%d", path, i)
+		entry.checkKey(r, at, network)
+		if entry.Key != "" {
+			plaintextKeyWarning(r, at+"/key", fmt.Sprintf("the key of dm_crypt %s", entry.ID))
+		}
+	}
+}
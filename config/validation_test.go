@@ -0,0 +1,57 @@
+package config
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfig_Check_CollectsAllErrors(t *testing.T) {
+	cfg := NewDefaultConfig()
+	cfg.Autoinstall.Version = 0
+	cfg.Autoinstall.Identity.Username = ""
+	cfg.Autoinstall.Identity.Hostname = ""
+	cfg.Autoinstall.Network.Version = 1
+	cfg.Autoinstall.Snaps = []Snap{{Channel: "stable"}}
+	cfg.Autoinstall.Reporting = map[string]ReportingHandler{"hook": {Type: "webhook"}}
+
+	result := cfg.Check()
+	assert.False(t, result.Valid())
+
+	paths := make(map[string]string)
+	for _, issue := range result.Errors() {
+		paths[issue.Path] = issue.Code
+	}
+	assert.Equal(t, map[string]string{
+		"/autoinstall/version":                 CodeRequired,
+		"/autoinstall/identity/username":       CodeRequired,
+		"/autoinstall/identity/hostname":       CodeRequired,
+		"/autoinstall/network/version":         CodeInvalidValue,
+		"/autoinstall/snaps/0/name":            CodeRequired,
+		"/autoinstall/reporting/hook/endpoint": CodeRequired,
+	}, paths)
+
+	var verr *ValidationError
+	require.True(t, errors.As(cfg.Validate(), &verr))
+	assert.Len(t, verr.Issues, len(paths))
+}
+
+func TestConfig_Check_WarningsDoNotBlock(t *testing.T) {
+	cfg := NewDefaultConfig()
+	cfg.Autoinstall.SSH.AllowPW = false
+	cfg.Autoinstall.Storage = Storage{Layout: &StorageLayout{Preset: "lvm-luks", Password: "ubuntu"}}
+
+	result := cfg.Check()
+	assert.True(t, result.Valid())
+	assert.NoError(t, cfg.Validate())
+
+	codes := make(map[string]string)
+	for _, issue := range result.Warnings() {
+		assert.NotEmpty(t, issue.Suggestion)
+		codes[issue.Code] = issue.Path
+	}
+	assert.Equal(t, "/autoinstall/storage/layout/password", codes[CodePlaintextKey])
+	assert.Equal(t, "/autoinstall/ssh", codes[CodeNoSSHLogin])
+}
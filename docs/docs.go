@@ -15,6 +15,133 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
+        "/build/events/{id}": {
+            "get": {
+                "description": "Follow a build live over Server-Sent Events. Log lines are sent as \"log\" events whose ID is the\n1-based index of the line; reconnecting with the Last-Event-ID header (or the lastEventId query\nparameter) resumes after that line. \"step\" and \"status\" events carry step transitions and progress.\nThe stream ends after the status event of a finished build.",
+                "produces": [
+                    "text/event-stream"
+                ],
+                "tags": [
+                    "iso"
+                ],
+                "summary": "Stream build events",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Build ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "ID of the last received event",
+                        "name": "Last-Event-ID",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "ID of the last received event, for clients that cannot set headers",
+                        "name": "lastEventId",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Event stream",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid last event ID",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "404": {
+                        "description": "Build ID does not exist",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/build/list": {
+            "get": {
+                "description": "List all known ISO builds, including builds from previous server runs, newest first",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "iso"
+                ],
+                "summary": "List builds",
+                "responses": {
+                    "200": {
+                        "description": "Builds retrieved successfully",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/build/{id}": {
+            "delete": {
+                "description": "Cancel a queued or running ISO build. Running commands are killed and the build workspace is removed.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "iso"
+                ],
+                "summary": "Cancel build",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Build ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Build cancellation requested",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "Build ID cannot be empty",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "404": {
+                        "description": "Build ID does not exist",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "409": {
+                        "description": "Build already finished",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
         "/config/default": {
             "get": {
                 "description": "Get the default configuration template",
@@ -90,7 +217,7 @@ const docTemplate = `{
         },
         "/config/validate": {
             "post": {
-                "description": "Validate a configuration object",
+                "description": "Validate a configuration object and return every error and warning with its JSON-pointer path, code and suggestion",
                 "consumes": [
                     "application/json"
                 ],
@@ -114,14 +241,14 @@ const docTemplate = `{
                 ],
                 "responses": {
                     "200": {
-                        "description": "Config validation passed",
+                        "description": "Config validation passed, with any warnings in issues",
                         "schema": {
                             "type": "object",
                             "additionalProperties": true
                         }
                     },
                     "400": {
-                        "description": "Config validation failed",
+                        "description": "Config validation failed, with all issues or schema violations",
                         "schema": {
                             "type": "object",
                             "additionalProperties": true
@@ -208,6 +335,48 @@ const docTemplate = `{
                 }
             }
         },
+        "/identity/verify": {
+            "post": {
+                "description": "Check whether a plaintext password matches a $1$, $5$, $6$ or $y$ crypt hash, such as the identity password of a config. Hashes of more than 5000000 rounds or 64 MiB of yescrypt memory are refused",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "identity"
+                ],
+                "summary": "Verify a password against a crypt hash",
+                "parameters": [
+                    {
+                        "description": "Password and hash",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/api.VerifyPasswordRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Verified, see match and algorithm",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request parameters, unsupported hash or a hash too expensive to verify",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
         "/iso/build/{id}/logs": {
             "get": {
                 "description": "Get the logs of an ISO build process",
@@ -341,6 +510,13 @@ const docTemplate = `{
                             "type": "object",
                             "additionalProperties": true
                         }
+                    },
+                    "503": {
+                        "description": "Build queue is full",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
                     }
                 }
             }
@@ -385,6 +561,69 @@ const docTemplate = `{
                 }
             }
         },
+        "/storage/presets": {
+            "get": {
+                "description": "Get the named storage layouts a storage layout shorthand can refer to, with their default sizes and the storage actions they expand to",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "storage"
+                ],
+                "summary": "List storage layout presets",
+                "responses": {
+                    "200": {
+                        "description": "Storage presets",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/storage/simulate": {
+            "post": {
+                "description": "Compute the partition tables, volume group space, filesystem sizes and mount table a storage config produces on disks of the given sizes, and whether it fits",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "storage"
+                ],
+                "summary": "Simulate a storage layout",
+                "parameters": [
+                    {
+                        "description": "Storage config and disk sizes",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/api.SimulateStorageRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Layout simulated, see layout.fits",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request parameters or storage config",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
         "/userdata/generate": {
             "post": {
                 "description": "Generate a user-data configuration file based on provided config",
@@ -418,7 +657,7 @@ const docTemplate = `{
                         }
                     },
                     "400": {
-                        "description": "Invalid request parameters",
+                        "description": "Invalid request parameters or schema violations",
                         "schema": {
                             "type": "object",
                             "additionalProperties": true
@@ -467,7 +706,7 @@ const docTemplate = `{
                         }
                     },
                     "400": {
-                        "description": "Invalid request parameters",
+                        "description": "Invalid request parameters or schema violations",
                         "schema": {
                             "type": "object",
                             "additionalProperties": true
@@ -496,12 +735,16 @@ const docTemplate = `{
                     "description": "local build Application packages",
                     "type": "string"
                 },
+                "arch": {
+                    "description": "ISO architecture (when sourceType is \"download\"): \"amd64\" (default) or \"arm64\"",
+                    "type": "string"
+                },
                 "codeName": {
                     "description": "Ubuntu release name (when sourceType is \"download\")",
                     "type": "string"
                 },
                 "destinationISO": {
-                    "description": "Output ISO file path",
+                    "description": "Output ISO file name, written into the output directory of the build",
                     "type": "string"
                 },
                 "gpgVerify": {
@@ -512,6 +755,10 @@ const docTemplate = `{
                     "description": "Whether to update MD5 checksum",
                     "type": "boolean"
                 },
+                "mode": {
+                    "description": "\"full\" (default) or \"overlay\"",
+                    "type": "string"
+                },
                 "packageList": {
                     "description": "Additional package list",
                     "type": "array",
@@ -537,6 +784,79 @@ const docTemplate = `{
                 }
             }
         },
+        "api.SimulateStorageRequest": {
+            "type": "object",
+            "properties": {
+                "diskSize": {
+                    "description": "Size of every disk, e.g. \"240GB\" or \"4T\"",
+                    "type": "string"
+                },
+                "diskSizes": {
+                    "description": "Sizes of single disks by storage ID, overriding diskSize",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "storage": {
+                    "description": "Storage section of the autoinstall config, with config or layout",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/config.Storage"
+                        }
+                    ]
+                }
+            }
+        },
+        "api.VerifyPasswordRequest": {
+            "type": "object",
+            "required": [
+                "hash",
+                "password"
+            ],
+            "properties": {
+                "hash": {
+                    "description": "$1$, $5$, $6$ or $y$ crypt hash, e.g. identity.password of a config",
+                    "type": "string"
+                },
+                "password": {
+                    "description": "Plaintext password",
+                    "type": "string"
+                }
+            }
+        },
+        "config.Accounts": {
+            "type": "object",
+            "properties": {
+                "groups": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/config.Group"
+                    }
+                },
+                "lock-root": {
+                    "description": "Lock the root password and refuse root logins over SSH",
+                    "type": "boolean"
+                },
+                "users": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/config.User"
+                    }
+                }
+            }
+        },
+        "config.ActiveDirectoryConfig": {
+            "type": "object",
+            "properties": {
+                "admin-name": {
+                    "type": "string"
+                },
+                "domain-name": {
+                    "type": "string"
+                }
+            }
+        },
         "config.AptConfig": {
             "type": "object",
             "properties": {
@@ -566,12 +886,42 @@ const docTemplate = `{
                 }
             }
         },
+        "config.AutoBool": {
+            "type": "string",
+            "enum": [
+                "auto",
+                "true",
+                "false"
+            ],
+            "x-enum-varnames": [
+                "AutoBoolAuto",
+                "AutoBoolTrue",
+                "AutoBoolFalse"
+            ]
+        },
         "config.Autoinstall": {
             "type": "object",
             "properties": {
+                "accounts": {
+                    "description": "Written into the user-data when it is generated",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/config.Accounts"
+                        }
+                    ]
+                },
+                "active-directory": {
+                    "$ref": "#/definitions/config.ActiveDirectoryConfig"
+                },
                 "apt": {
                     "$ref": "#/definitions/config.AptConfig"
                 },
+                "codecs": {
+                    "$ref": "#/definitions/config.CodecsConfig"
+                },
+                "debconf-selections": {
+                    "type": "string"
+                },
                 "drivers": {
                     "$ref": "#/definitions/config.DriversConfig"
                 },
@@ -581,9 +931,21 @@ const docTemplate = `{
                         "type": "string"
                     }
                 },
+                "error-commands": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
                 "identity": {
                     "$ref": "#/definitions/config.Identity"
                 },
+                "interactive-sections": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
                 "kernel": {
                     "$ref": "#/definitions/config.KernelConfig"
                 },
@@ -602,15 +964,47 @@ const docTemplate = `{
                 "network": {
                     "$ref": "#/definitions/config.NetworkConfig"
                 },
+                "oem": {
+                    "$ref": "#/definitions/config.OEMConfig"
+                },
                 "packages": {
                     "type": "array",
                     "items": {
                         "type": "string"
                     }
                 },
+                "password-policy": {
+                    "description": "Only used for validation",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/config.PasswordPolicy"
+                        }
+                    ]
+                },
+                "proxy": {
+                    "type": "string"
+                },
+                "refresh-installer": {
+                    "$ref": "#/definitions/config.RefreshInstallerConfig"
+                },
+                "reporting": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "$ref": "#/definitions/config.ReportingHandler"
+                    }
+                },
                 "shutdown": {
                     "type": "string"
                 },
+                "snaps": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/config.Snap"
+                    }
+                },
+                "source": {
+                    "$ref": "#/definitions/config.SourceConfig"
+                },
                 "ssh": {
                     "$ref": "#/definitions/config.SSHConfig"
                 },
@@ -620,12 +1014,19 @@ const docTemplate = `{
                 "timezone": {
                     "type": "string"
                 },
+                "ubuntu-pro": {
+                    "$ref": "#/definitions/config.UbuntuProConfig"
+                },
                 "updates": {
                     "type": "string"
                 },
                 "user-data": {
-                    "type": "object",
-                    "additionalProperties": true
+                    "description": "cloud-init user-data of the installed system",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/config.CloudConfig"
+                        }
+                    ]
                 },
                 "version": {
                     "type": "integer"
@@ -810,8 +1211,173 @@ const docTemplate = `{
                 "priority": {
                     "type": "integer"
                 },
-                "stp": {
-                    "type": "boolean"
+                "stp": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "config.CACerts": {
+            "type": "object",
+            "properties": {
+                "remove_defaults": {
+                    "type": "boolean"
+                },
+                "trusted": {
+                    "description": "PEM certificates",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "config.CloudConfig": {
+            "type": "object",
+            "properties": {
+                "bootcmd": {
+                    "description": "Run on every boot, before the network is up",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/config.Command"
+                    }
+                },
+                "ca_certs": {
+                    "$ref": "#/definitions/config.CACerts"
+                },
+                "groups": {
+                    "description": "Created before the users",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/config.CloudGroup"
+                    }
+                },
+                "ntp": {
+                    "$ref": "#/definitions/config.NTPConfig"
+                },
+                "package_upgrade": {
+                    "type": "boolean"
+                },
+                "runcmd": {
+                    "description": "Run once on the first boot",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/config.Command"
+                    }
+                },
+                "users": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/config.CloudUser"
+                    }
+                },
+                "write_files": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/config.WriteFile"
+                    }
+                }
+            }
+        },
+        "config.CloudGroup": {
+            "type": "object",
+            "properties": {
+                "members": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "name": {
+                    "type": "string"
+                }
+            }
+        },
+        "config.CloudUser": {
+            "type": "object",
+            "properties": {
+                "expiredate": {
+                    "description": "YYYY-MM-DD",
+                    "type": "string"
+                },
+                "gecos": {
+                    "description": "Real name",
+                    "type": "string"
+                },
+                "groups": {
+                    "description": "A list or a comma-separated string",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "hashed_passwd": {
+                    "type": "string"
+                },
+                "homedir": {
+                    "type": "string"
+                },
+                "lock_passwd": {
+                    "description": "cloud-init locks the password unless this is false",
+                    "type": "boolean"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "passwd": {
+                    "description": "Crypt hash",
+                    "type": "string"
+                },
+                "plain_text_passwd": {
+                    "type": "string"
+                },
+                "primary_group": {
+                    "type": "string"
+                },
+                "shell": {
+                    "type": "string"
+                },
+                "ssh_authorized_keys": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "sudo": {
+                    "description": "e.g. \"ALL=(ALL) NOPASSWD:ALL\"",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "system": {
+                    "type": "boolean"
+                },
+                "uid": {
+                    "type": "integer"
+                }
+            }
+        },
+        "config.CodecsConfig": {
+            "type": "object",
+            "properties": {
+                "install": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "config.Command": {
+            "type": "object",
+            "properties": {
+                "args": {
+                    "description": "Run directly when Shell is empty",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "shell": {
+                    "description": "Run with sh -c",
+                    "type": "string"
                 }
             }
         },
@@ -849,6 +1415,10 @@ const docTemplate = `{
                     "description": "e.g. \"largest\", \"smallest\", \"100G\"",
                     "type": "string"
                 },
+                "ssd": {
+                    "description": "true = match SSD only, false = match non-SSD only",
+                    "type": "boolean"
+                },
                 "wwn": {
                     "description": "World Wide Name",
                     "type": "string"
@@ -983,6 +1553,21 @@ const docTemplate = `{
                 }
             }
         },
+        "config.Group": {
+            "type": "object",
+            "properties": {
+                "members": {
+                    "description": "Users added to the group",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "name": {
+                    "type": "string"
+                }
+            }
+        },
         "config.GrubConfig": {
             "type": "object",
             "properties": {
@@ -1129,6 +1714,30 @@ const docTemplate = `{
                 }
             }
         },
+        "config.NTPConfig": {
+            "type": "object",
+            "properties": {
+                "enabled": {
+                    "type": "boolean"
+                },
+                "ntp_client": {
+                    "description": "auto, chrony, ntp, ntpdate, openntpd or systemd-timesyncd",
+                    "type": "string"
+                },
+                "pools": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "servers": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
         "config.Nameservers": {
             "type": "object",
             "properties": {
@@ -1224,6 +1833,34 @@ const docTemplate = `{
                 }
             }
         },
+        "config.OEMConfig": {
+            "type": "object",
+            "properties": {
+                "install": {
+                    "$ref": "#/definitions/config.AutoBool"
+                }
+            }
+        },
+        "config.PasswordPolicy": {
+            "type": "object",
+            "properties": {
+                "lock-identity": {
+                    "description": "Lock the identity password, the user logs in with SSH keys only",
+                    "type": "boolean"
+                },
+                "min-classes": {
+                    "description": "Of lowercase letters, uppercase letters, digits and other characters",
+                    "type": "integer"
+                },
+                "min-length": {
+                    "type": "integer"
+                },
+                "reject-username": {
+                    "description": "The password must not contain the user name",
+                    "type": "boolean"
+                }
+            }
+        },
         "config.PrimaryEntry": {
             "type": "object",
             "properties": {
@@ -1238,6 +1875,52 @@ const docTemplate = `{
                 }
             }
         },
+        "config.RefreshInstallerConfig": {
+            "type": "object",
+            "properties": {
+                "channel": {
+                    "description": "e.g. \"stable/ubuntu-$REL\"",
+                    "type": "string"
+                },
+                "update": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "config.ReportingHandler": {
+            "type": "object",
+            "properties": {
+                "consumer_key": {
+                    "description": "webhook OAuth",
+                    "type": "string"
+                },
+                "consumer_secret": {
+                    "type": "string"
+                },
+                "destination": {
+                    "description": "rsyslog destination, e.g. \"@192.168.0.1\"",
+                    "type": "string"
+                },
+                "endpoint": {
+                    "description": "webhook URL",
+                    "type": "string"
+                },
+                "level": {
+                    "description": "minimum level, e.g. \"INFO\"",
+                    "type": "string"
+                },
+                "token_key": {
+                    "type": "string"
+                },
+                "token_secret": {
+                    "type": "string"
+                },
+                "type": {
+                    "description": "print, rsyslog, webhook or none",
+                    "type": "string"
+                }
+            }
+        },
         "config.Route": {
             "type": "object",
             "properties": {
@@ -1308,6 +1991,41 @@ const docTemplate = `{
                 }
             }
         },
+        "config.Size": {
+            "type": "string",
+            "enum": [
+                "-1"
+            ],
+            "x-enum-varnames": [
+                "SizeRemaining"
+            ]
+        },
+        "config.Snap": {
+            "type": "object",
+            "properties": {
+                "channel": {
+                    "description": "default: stable",
+                    "type": "string"
+                },
+                "classic": {
+                    "type": "boolean"
+                },
+                "name": {
+                    "type": "string"
+                }
+            }
+        },
+        "config.SourceConfig": {
+            "type": "object",
+            "properties": {
+                "id": {
+                    "type": "string"
+                },
+                "search_drivers": {
+                    "type": "boolean"
+                }
+            }
+        },
         "config.Storage": {
             "type": "object",
             "properties": {
@@ -1320,6 +2038,14 @@ const docTemplate = `{
                 "grub": {
                     "$ref": "#/definitions/config.GrubConfig"
                 },
+                "layout": {
+                    "description": "A storage preset expanded into Config, or a Subiquity layout",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/config.StorageLayout"
+                        }
+                    ]
+                },
                 "swap": {
                     "$ref": "#/definitions/config.SwapConfig"
                 }
@@ -1328,6 +2054,18 @@ const docTemplate = `{
         "config.StorageConfig": {
             "type": "object",
             "properties": {
+                "backing_device": {
+                    "description": "For bcache, the slow device",
+                    "type": "string"
+                },
+                "cache_device": {
+                    "description": "For bcache, the fast device",
+                    "type": "string"
+                },
+                "cache_mode": {
+                    "description": "For bcache: writethrough, writeback, writearound or none",
+                    "type": "string"
+                },
                 "device": {
                     "type": "string"
                 },
@@ -1344,6 +2082,13 @@ const docTemplate = `{
                 "flag": {
                     "type": "string"
                 },
+                "fs_properties": {
+                    "description": "For zpool, properties of its root dataset",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
                 "fstype": {
                     "description": "e.g. \"ext4\", \"btrfs\", \"xfs\"",
                     "type": "string"
@@ -1358,6 +2103,14 @@ const docTemplate = `{
                     "description": "For dm_crypt encryption",
                     "type": "string"
                 },
+                "key_source": {
+                    "description": "For dm_crypt instead of key: prompt or generate",
+                    "type": "string"
+                },
+                "key_url": {
+                    "description": "For dm_crypt, where the installer fetches a generated key",
+                    "type": "string"
+                },
                 "keyfile": {
                     "description": "For dm_crypt encryption",
                     "type": "string"
@@ -1365,27 +2118,84 @@ const docTemplate = `{
                 "match": {
                     "$ref": "#/definitions/config.DiskMatch"
                 },
+                "mountpoint": {
+                    "description": "For zpool",
+                    "type": "string"
+                },
                 "name": {
                     "type": "string"
                 },
                 "number": {
                     "type": "integer"
                 },
+                "nvme_controller": {
+                    "description": "For disks, ID of their nvme_controller",
+                    "type": "string"
+                },
                 "path": {
                     "type": "string"
                 },
+                "pool": {
+                    "description": "Pool name of a zpool, zpool ID of a zfs dataset",
+                    "type": "string"
+                },
+                "pool_properties": {
+                    "description": "For zpool, e.g. {\"ashift\": \"12\"}",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
                 "preserve": {
                     "type": "boolean"
                 },
+                "properties": {
+                    "description": "For zfs datasets, e.g. {\"mountpoint\": \"/home\"}",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
                 "ptable": {
                     "type": "string"
                 },
+                "raidlevel": {
+                    "description": "For raid: raid0, raid1, raid5, raid6 or raid10",
+                    "type": "string"
+                },
                 "size": {
+                    "description": "e.g. 2147483648, \"2G\", \"20%\" or -1 for the remaining space",
+                    "type": "string"
+                },
+                "spare_devices": {
+                    "description": "For raid",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "tcp_addr": {
+                    "description": "For nvme_controller over tcp",
+                    "type": "string"
+                },
+                "tcp_port": {
+                    "description": "For nvme_controller over tcp",
                     "type": "integer"
                 },
+                "transport": {
+                    "description": "For nvme_controller: pcie or tcp",
+                    "type": "string"
+                },
                 "type": {
                     "type": "string"
                 },
+                "vdevs": {
+                    "description": "For zpool",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
                 "volgroup": {
                     "type": "string"
                 },
@@ -1398,6 +2208,57 @@ const docTemplate = `{
                 }
             }
         },
+        "config.StorageLayout": {
+            "type": "object",
+            "properties": {
+                "disks": {
+                    "description": "Further disks of multi-disk presets, in order",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/config.DiskMatch"
+                    }
+                },
+                "encrypted": {
+                    "description": "Seal the key of Subiquity's hybrid layout in the TPM",
+                    "type": "boolean"
+                },
+                "key_source": {
+                    "description": "Source of the passphrase of encrypted presets instead of password: prompt or generate",
+                    "type": "string"
+                },
+                "key_url": {
+                    "description": "URL the installer fetches a generated passphrase from",
+                    "type": "string"
+                },
+                "match": {
+                    "description": "Disk to install to, the largest one by default",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/config.DiskMatch"
+                        }
+                    ]
+                },
+                "name": {
+                    "description": "Subiquity layout: direct, lvm, zfs or hybrid",
+                    "type": "string"
+                },
+                "password": {
+                    "description": "Passphrase of encrypted presets and of Subiquity's lvm and zfs layouts",
+                    "type": "string"
+                },
+                "preset": {
+                    "description": "Storage preset, e.g. \"lvm-luks\", see StoragePresets",
+                    "type": "string"
+                },
+                "sizes": {
+                    "description": "Sizes overriding the preset defaults by name, e.g. {\"boot\": \"1G\"}",
+                    "type": "object",
+                    "additionalProperties": {
+                        "$ref": "#/definitions/config.Size"
+                    }
+                }
+            }
+        },
         "config.SwapConfig": {
             "type": "object",
             "properties": {
@@ -1487,6 +2348,63 @@ const docTemplate = `{
                 }
             }
         },
+        "config.UbuntuProConfig": {
+            "type": "object",
+            "properties": {
+                "token": {
+                    "type": "string"
+                }
+            }
+        },
+        "config.User": {
+            "type": "object",
+            "properties": {
+                "authorized-keys": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "groups": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "lock-password": {
+                    "description": "Log in with authorized keys only",
+                    "type": "boolean"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "password": {
+                    "description": "Plain text or a crypt hash, hashed when user-data is generated",
+                    "type": "string"
+                },
+                "primary-group": {
+                    "type": "string"
+                },
+                "realname": {
+                    "type": "string"
+                },
+                "shell": {
+                    "description": "/bin/bash by default",
+                    "type": "string"
+                },
+                "sudo": {
+                    "description": "e.g. \"ALL=(ALL) NOPASSWD:ALL\"",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "uid": {
+                    "description": "Assigned by the system when 0",
+                    "type": "integer"
+                }
+            }
+        },
         "config.VLAN": {
             "type": "object",
             "properties": {
@@ -1680,6 +2598,36 @@ const docTemplate = `{
                     "type": "string"
                 }
             }
+        },
+        "config.WriteFile": {
+            "type": "object",
+            "properties": {
+                "append": {
+                    "type": "boolean"
+                },
+                "content": {
+                    "type": "string"
+                },
+                "defer": {
+                    "description": "Write after users and packages are set up",
+                    "type": "boolean"
+                },
+                "encoding": {
+                    "description": "e.g. b64 or gz+b64, plain text by default",
+                    "type": "string"
+                },
+                "owner": {
+                    "description": "user:group, root:root by default",
+                    "type": "string"
+                },
+                "path": {
+                    "type": "string"
+                },
+                "permissions": {
+                    "description": "Octal, e.g. \"0644\"",
+                    "type": "string"
+                }
+            }
         }
     }
 }`
@@ -0,0 +1,141 @@
+package generator
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+
+	"github.com/lefeck/ubuntu-autoinstaller/logger"
+)
+
+// Architectures of the live-server ISOs.
+const (
+	ArchAMD64 = "amd64"
+	ArchARM64 = "arm64"
+)
+
+// UbuntuArchiveKeyring verifies the packages of the APT mirrors.
+const UbuntuArchiveKeyring = "/usr/share/keyrings/ubuntu-archive-keyring.gpg"
+
+// archInfo describes where the ISO and packages of an architecture come from.
+type archInfo struct {
+	releaseURL string // Release directory of the ISO, formatted with the codename
+	mirror     string // APT mirror with the packages of the architecture
+}
+
+var archs = map[string]archInfo{
+	ArchAMD64: {
+		releaseURL: DownloadURL + "%s",
+		mirror:     "http://archive.ubuntu.com/ubuntu",
+	},
+	// Non-x86 live-server images and packages are only published on cdimage and ports.
+	ArchARM64: {
+		releaseURL: "https://cdimage.ubuntu.com/releases/%s/release",
+		mirror:     "http://ports.ubuntu.com/ubuntu-ports",
+	},
+}
+
+// ValidateArch checks that arch is a supported ISO architecture. An empty arch means amd64.
+func ValidateArch(arch string) error {
+	if arch == "" {
+		return nil
+	}
+	if _, ok := archs[arch]; !ok {
+		return fmt.Errorf("unsupported architecture %q, must be %s or %s", arch, ArchAMD64, ArchARM64)
+	}
+	return nil
+}
+
+// archOrDefault returns arch, or amd64 when it is empty.
+func archOrDefault(arch string) string {
+	if arch == "" {
+		return ArchAMD64
+	}
+	return arch
+}
+
+// isoNameRegex matches the live-server ISO file names of arch.
+func isoNameRegex(arch string) *regexp.Regexp {
+	return regexp.MustCompile(fmt.Sprintf(RegexISOName, regexp.QuoteMeta(archOrDefault(arch))))
+}
+
+// hostArch returns the Debian architecture of the machine running the build.
+func hostArch() string {
+	return runtime.GOARCH
+}
+
+// setupForeignApt points apt-get and apt-cache at a private APT state for arch
+// when it differs from the host, so packages of the target architecture are
+// resolved and downloaded from its mirror without touching the host setup.
+func (g *Generator) setupForeignApt(ctx context.Context, codename, arch string) error {
+	arch = archOrDefault(arch)
+	g.arch = arch
+	if arch == hostArch() {
+		g.aptOptions = nil
+		return nil
+	}
+	info, ok := archs[arch]
+	if !ok {
+		return ValidateArch(arch)
+	}
+	logger.Infof("Using %s packages from %s", arch, info.mirror)
+
+	root := g.Path.AptDir()
+	for _, dir := range []string{filepath.Join(root, "lists", "partial"), filepath.Join(root, "cache", "archives", "partial")} {
+		if err := os.MkdirAll(dir, DefaultDirPerm); err != nil {
+			return err
+		}
+	}
+	sources := filepath.Join(root, "sources.list")
+	var list strings.Builder
+	for _, suite := range []string{codename, codename + "-updates", codename + "-security"} {
+		fmt.Fprintf(&list, "deb [arch=%s signed-by=%s] %s %s main restricted universe multiverse\n", arch, UbuntuArchiveKeyring, info.mirror, suite)
+	}
+	if err := os.WriteFile(sources, []byte(list.String()), DefaultFilePerm); err != nil {
+		return fmt.Errorf("failed to write sources.list: %w", err)
+	}
+	status := filepath.Join(root, "status")
+	if err := touchFile(status); err != nil {
+		return err
+	}
+
+	g.aptOptions = []string{
+		"-o", "APT::Architecture=" + arch,
+		"-o", "APT::Architectures=" + arch,
+		"-o", "Dir::Etc::SourceList=" + sources,
+		"-o", "Dir::Etc::SourceParts=-",
+		"-o", "Dir::State=" + root,
+		"-o", "Dir::State::status=" + status,
+		"-o", "Dir::Cache=" + filepath.Join(root, "cache"),
+		"-o", "Acquire::Languages=none",
+	}
+	update := g.aptCommand("", AptGet, "update")
+	if _, _, err := g.executor.RunCmdWithAttemptsContext(ctx, update, 3, AptUpdateTimeout, g.outputOptions()...); err != nil {
+		return fmt.Errorf("failed to update %s package lists: %w", arch, err)
+	}
+	return nil
+}
+
+// aptCommand builds an apt-get or apt-cache command with the APT options of the
+// target architecture, running in dir when it is set.
+func (g *Generator) aptCommand(dir, tool string, args ...string) *exec.Cmd {
+	c := exec.Command(tool, append(append([]string{}, g.aptOptions...), args...)...)
+	c.Dir = dir
+	return c
+}
+
+// foreignDependency reports whether an apt-cache dependency line names a
+// package of another architecture, e.g. "libc6:i386" in an amd64 build.
+func foreignDependency(line, arch string) bool {
+	i := strings.LastIndex(line, ":")
+	if i < 0 || strings.ContainsAny(line, " \t") {
+		return false
+	}
+	qualifier := line[i+1:]
+	return qualifier != archOrDefault(arch) && qualifier != "all" && qualifier != "any"
+}
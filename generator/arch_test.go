@@ -0,0 +1,28 @@
+package generator
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test the ISO name and release URL follow the architecture.
+func TestArch_DownloadNames(t *testing.T) {
+	page := `<a href="ubuntu-22.04.5-live-server-amd64.iso">` + `<a href="ubuntu-22.04.5-live-server-arm64.iso">`
+	assert.Equal(t, "ubuntu-22.04.5-live-server-amd64.iso", isoNameRegex("").FindString(page))
+	assert.Equal(t, "ubuntu-22.04.5-live-server-arm64.iso", isoNameRegex(ArchARM64).FindString(page))
+
+	gen := &Generator{}
+	assert.Equal(t, "https://releases.ubuntu.com/jammy", gen.buildDownloadURL("jammy", ArchAMD64))
+	assert.Equal(t, "https://cdimage.ubuntu.com/releases/jammy/release", gen.buildDownloadURL("jammy", ArchARM64))
+
+	assert.NoError(t, ValidateArch(""))
+	assert.Error(t, ValidateArch("s390x"))
+}
+
+// Test dependencies of other architectures are dropped.
+func TestFilterDependencies(t *testing.T) {
+	raw := "vim\nlibc6:i386\nlibc6:arm64\n<debconf-2.0>\nvim-runtime\n"
+	assert.Equal(t, []string{"vim", "libc6:arm64", "vim-runtime"}, filterDependencies(raw, ArchARM64))
+	assert.Equal(t, []string{"vim", "vim-runtime"}, filterDependencies(raw, ArchAMD64))
+}
@@ -0,0 +1,322 @@
+package generator
+
+import (
+	"encoding/binary"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/lefeck/ubuntu-autoinstaller/iso9660"
+	"github.com/lefeck/ubuntu-autoinstaller/logger"
+)
+
+// System area (MBR) flavours of hybrid ISOs.
+const (
+	SystemAreaNone      = ""
+	SystemAreaGrub2     = "grub2"     // GRUB2 MBR, used since 22.04
+	SystemAreaIsohybrid = "isohybrid" // SYSLINUX isohybrid MBR, used up to 20.04
+)
+
+// Offsets of the boot information patched into El Torito boot images.
+const (
+	bootInfoTableOffset  = 8
+	grub2BootInfoOffset  = 2548
+	systemAreaLastSector = 15
+)
+
+// gptTypeEFI is the EFI System partition type GUID in on-disk byte order.
+const gptTypeEFI = "28732ac11ff8d211ba4b00a0c93ec93b"
+
+// BootLayout describes how a source ISO boots: its system area, partition
+// tables and El Torito catalog. It is read from the image, like
+// `xorriso -report_el_torito as_mkisofs` does, and replayed when repackaging.
+type BootLayout struct {
+	SourceISO          string
+	SystemArea         string
+	ProtectiveMBR      bool
+	ForceBootable      bool
+	PartitionOffset    int64  // In 2048-byte blocks
+	ISOPartitionType   string // MBR type ("0x00") or GPT type GUID of the ISO partition
+	AppendedPartitions []AppendedPartition
+	AppendedAsGPT      bool
+	GPTBasdat          bool // isohybrid GPT entry for the EFI image
+	APMHFSPlus         bool // isohybrid Apple Partition Map
+	Joliet             bool
+	Catalog            string
+	BootImages         []BootImage
+}
+
+// AppendedPartition is a partition stored after the ISO file system, e.g. the EFI partition.
+type AppendedPartition struct {
+	Number int
+	Type   string // "0xef" for MBR or a GPT type GUID
+	Start  int64  // Byte offset in the source ISO
+	Size   int64  // Size in bytes
+}
+
+// BootImage is one El Torito boot entry.
+type BootImage struct {
+	Path          string // File in the ISO tree, or an xorriso interval for appended partitions
+	EFI           bool
+	NoEmulation   bool
+	LoadSize      uint16
+	BootInfoTable bool
+	Grub2BootInfo bool
+}
+
+// InspectBootLayout reads the boot layout of the ISO at sourceISO.
+func (gen *Generator) InspectBootLayout(sourceISO string) (*BootLayout, error) {
+	img, err := iso9660.Open(sourceISO)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open ISO image: %w", err)
+	}
+	defer img.Close()
+
+	entries, err := img.BootEntries()
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("source ISO has no El Torito boot catalog")
+	}
+	partitions, err := img.Partitions()
+	if err != nil {
+		return nil, err
+	}
+
+	// Map data offsets to paths to find the catalog and boot image files.
+	files := make(map[int64]string)
+	if err := img.Walk(func(name string, f *iso9660.File) error {
+		if f.Mode.IsRegular() {
+			files[f.Offset()] = "/" + name
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	layout := &BootLayout{
+		SourceISO: sourceISO,
+		Joliet:    img.HasJoliet(),
+	}
+	layout.Catalog = files[img.BootCatalogOffset()]
+	if layout.Catalog == "" {
+		return nil, fmt.Errorf("boot catalog is not a file in the ISO tree")
+	}
+
+	layout.readPartitions(img, partitions)
+
+	for _, e := range entries {
+		bi := BootImage{
+			EFI:         e.Platform == iso9660.PlatformEFI,
+			NoEmulation: e.MediaType == iso9660.MediaNoEmulation,
+			LoadSize:    e.SectorCount,
+		}
+		if file, ok := files[e.Offset()]; ok {
+			bi.Path = file
+		} else if p := layout.appendedAt(e.Offset()); p != nil {
+			bi.Path = fmt.Sprintf("--interval:appended_partition_%d:all::", p.Number)
+		} else {
+			return nil, fmt.Errorf("boot image %d is neither a file nor an appended partition", e.Index)
+		}
+		bi.BootInfoTable, bi.Grub2BootInfo = bootInfo(img, &e)
+		layout.BootImages = append(layout.BootImages, bi)
+	}
+
+	// Only hybrid images that also boot from BIOS, which carry a partition table
+	// and x86 boot code, have a system area to copy; UEFI-only (e.g. arm64) images don't.
+	switch {
+	case len(partitions) == 0 || !layout.biosBoot():
+	case !layout.BootImages[0].EFI && strings.Contains(path.Base(layout.BootImages[0].Path), "isolinux"):
+		layout.SystemArea = SystemAreaIsohybrid
+	default:
+		layout.SystemArea = SystemAreaGrub2
+	}
+	if layout.SystemArea == SystemAreaIsohybrid {
+		layout.GPTBasdat = hasScheme(partitions, iso9660.SchemeGPT)
+		layout.APMHFSPlus = hasAPM(img)
+	}
+	return layout, nil
+}
+
+// readPartitions derives the partition options from the MBR and GPT entries.
+// The GPT describes the ISO partition only when the appended partitions are
+// GPT entries too, as with -appended_part_as_gpt; otherwise the MBR does.
+func (l *BootLayout) readPartitions(img *iso9660.Image, partitions []iso9660.Partition) {
+	appended := map[string][]AppendedPartition{}
+	isoPartition := map[string]*iso9660.Partition{}
+	for i, p := range partitions {
+		if p.Scheme == iso9660.SchemeMBR {
+			if p.Bootable {
+				l.ForceBootable = true
+			}
+			if p.Type == "ee" {
+				l.ProtectiveMBR = true
+			}
+		}
+		switch {
+		case strings.HasPrefix(p.Name, "Gap"):
+			// Padding partitions xorriso adds around appended ones; it recreates them itself.
+		case p.Start >= img.VolumeSize():
+			appended[p.Scheme] = append(appended[p.Scheme], AppendedPartition{
+				Number: p.Number,
+				Type:   partitionType(&p),
+				Start:  p.Start,
+				Size:   p.Size,
+			})
+		case p.Type == "ee" || p.Type == "ef" || p.Type == gptTypeEFI:
+		case isoPartition[p.Scheme] == nil:
+			isoPartition[p.Scheme] = &partitions[i]
+		}
+	}
+
+	scheme := iso9660.SchemeMBR
+	if len(appended[iso9660.SchemeGPT]) > 0 {
+		scheme = iso9660.SchemeGPT
+		l.AppendedAsGPT = true
+	}
+	l.AppendedPartitions = appended[scheme]
+	if p := isoPartition[scheme]; p != nil {
+		l.PartitionOffset = p.Start / iso9660.SectorSize
+		l.ISOPartitionType = partitionType(p)
+	}
+}
+
+// partitionType formats a partition type the way xorriso options take it.
+func partitionType(p *iso9660.Partition) string {
+	if p.Scheme == iso9660.SchemeMBR {
+		return "0x" + p.Type
+	}
+	return p.Type
+}
+
+// biosBoot reports whether any boot image is for BIOS rather than EFI firmware.
+func (l *BootLayout) biosBoot() bool {
+	for _, bi := range l.BootImages {
+		if !bi.EFI {
+			return true
+		}
+	}
+	return false
+}
+
+// appendedAt returns the appended partition that starts at offset.
+func (l *BootLayout) appendedAt(offset int64) *AppendedPartition {
+	for i := range l.AppendedPartitions {
+		if l.AppendedPartitions[i].Start == offset {
+			return &l.AppendedPartitions[i]
+		}
+	}
+	return nil
+}
+
+// bootInfo detects the boot info table (-boot-info-table) and the GRUB2 boot
+// info (--grub2-boot-info) that mkisofs patched into a boot image.
+func bootInfo(img *iso9660.Image, e *iso9660.BootEntry) (table, grub2 bool) {
+	buf := make([]byte, 16)
+	if e.Size >= bootInfoTableOffset+16 {
+		if _, err := img.ReadAt(buf, e.Offset()+bootInfoTableOffset); err == nil {
+			table = binary.LittleEndian.Uint32(buf[0:4]) == 16 &&
+				binary.LittleEndian.Uint32(buf[4:8]) == e.LoadRBA
+		}
+	}
+	if e.Size >= grub2BootInfoOffset+8 {
+		if _, err := img.ReadAt(buf[:8], e.Offset()+grub2BootInfoOffset); err == nil {
+			grub2 = binary.LittleEndian.Uint64(buf[:8]) == uint64(e.LoadRBA)*4+5
+		}
+	}
+	return table, grub2
+}
+
+// hasScheme reports whether any partition uses the given table scheme.
+func hasScheme(partitions []iso9660.Partition, scheme string) bool {
+	for _, p := range partitions {
+		if p.Scheme == scheme {
+			return true
+		}
+	}
+	return false
+}
+
+// hasAPM reports whether the image starts with an Apple Partition Map driver descriptor.
+func hasAPM(img *iso9660.Image) bool {
+	buf := make([]byte, 2)
+	_, err := img.ReadAt(buf, 0)
+	return err == nil && string(buf) == "ER"
+}
+
+// MkisofsArgs returns the `xorriso -as mkisofs` options that recreate the boot
+// layout. The system area and appended partitions are copied from the source ISO.
+func (l *BootLayout) MkisofsArgs() []string {
+	var args []string
+	if l.Joliet {
+		args = append(args, "-J", "-joliet-long")
+	}
+
+	// The partition tables are rebuilt by xorriso, so they are zeroed in the copy.
+	zero := "zero_mbrpt,zero_gpt"
+	if l.APMHFSPlus {
+		zero += ",zero_apm"
+	}
+	systemArea := fmt.Sprintf("--interval:local_fs:0s-%ds:%s:%s", systemAreaLastSector, zero, l.SourceISO)
+	switch l.SystemArea {
+	case SystemAreaGrub2:
+		args = append(args, "--grub2-mbr", systemArea)
+	case SystemAreaIsohybrid:
+		args = append(args, "-isohybrid-mbr", systemArea)
+	}
+	if l.ProtectiveMBR {
+		args = append(args, "--protective-msdos-label")
+	}
+	args = append(args, "-partition_offset", strconv.FormatInt(l.PartitionOffset, 10))
+	if l.ForceBootable {
+		args = append(args, "--mbr-force-bootable")
+	}
+	for _, p := range l.AppendedPartitions {
+		interval := fmt.Sprintf("--interval:local_fs:%dd-%dd::%s", p.Start/512, (p.Start+p.Size)/512-1, l.SourceISO)
+		args = append(args, "-append_partition", strconv.Itoa(p.Number), p.Type, interval)
+	}
+	if l.AppendedAsGPT {
+		args = append(args, "-appended_part_as_gpt")
+	}
+	if l.ISOPartitionType != "" {
+		args = append(args, "-iso_mbr_part_type", l.ISOPartitionType)
+	}
+
+	args = append(args, "-c", l.Catalog)
+	for i, bi := range l.BootImages {
+		if i > 0 {
+			args = append(args, "-eltorito-alt-boot")
+		}
+		if bi.EFI {
+			args = append(args, "-e", bi.Path)
+		} else {
+			args = append(args, "-b", bi.Path)
+		}
+		if bi.NoEmulation {
+			args = append(args, "-no-emul-boot")
+		}
+		if bi.LoadSize > 0 {
+			args = append(args, "-boot-load-size", strconv.Itoa(int(bi.LoadSize)))
+		}
+		if bi.BootInfoTable {
+			args = append(args, "-boot-info-table")
+		}
+		if bi.Grub2BootInfo {
+			args = append(args, "--grub2-boot-info")
+		}
+	}
+	if l.GPTBasdat {
+		args = append(args, "-isohybrid-gpt-basdat")
+	}
+	if l.APMHFSPlus {
+		args = append(args, "-isohybrid-apm-hfsplus")
+	}
+	return args
+}
+
+// logBootLayout logs the replayed options of a layout.
+func logBootLayout(layout *BootLayout) {
+	logger.Infof("Replaying boot layout of %s: %s", layout.SourceISO, strings.Join(layout.MkisofsArgs(), " "))
+}
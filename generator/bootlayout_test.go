@@ -0,0 +1,73 @@
+package generator
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Test the layout of a 22.04 ISO, as reported by `xorriso -report_el_torito
+// as_mkisofs`, is turned into the same options.
+func TestBootLayout_MkisofsArgsJammy(t *testing.T) {
+	layout := &BootLayout{
+		SourceISO:        "/data/ubuntu-22.04.iso",
+		SystemArea:       SystemAreaGrub2,
+		ForceBootable:    true,
+		PartitionOffset:  16,
+		ISOPartitionType: "a2a0d0ebe5b9334487c068b6b72699c7",
+		AppendedPartitions: []AppendedPartition{
+			{Number: 2, Type: gptTypeEFI, Start: 4099440 * 512, Size: 10144 * 512},
+		},
+		AppendedAsGPT: true,
+		Catalog:       "/boot.catalog",
+		BootImages: []BootImage{
+			{Path: "/boot/grub/i386-pc/eltorito.img", NoEmulation: true, LoadSize: 4, BootInfoTable: true, Grub2BootInfo: true},
+			{Path: "--interval:appended_partition_2:all::", EFI: true, NoEmulation: true},
+		},
+	}
+
+	want := "--grub2-mbr --interval:local_fs:0s-15s:zero_mbrpt,zero_gpt:/data/ubuntu-22.04.iso " +
+		"-partition_offset 16 --mbr-force-bootable " +
+		"-append_partition 2 28732ac11ff8d211ba4b00a0c93ec93b --interval:local_fs:4099440d-4109583d::/data/ubuntu-22.04.iso " +
+		"-appended_part_as_gpt -iso_mbr_part_type a2a0d0ebe5b9334487c068b6b72699c7 " +
+		"-c /boot.catalog -b /boot/grub/i386-pc/eltorito.img -no-emul-boot -boot-load-size 4 -boot-info-table --grub2-boot-info " +
+		"-eltorito-alt-boot -e --interval:appended_partition_2:all:: -no-emul-boot"
+	assert.Equal(t, want, strings.Join(layout.MkisofsArgs(), " "))
+}
+
+// sourceFixture writes the iso9660 package's test image to a temporary file.
+func sourceFixture(t *testing.T) string {
+	t.Helper()
+	f, err := os.Open(filepath.Join("..", "iso9660", "testdata", "rockridge.iso.gz"))
+	require.NoError(t, err)
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	require.NoError(t, err)
+	iso := filepath.Join(t.TempDir(), "source.iso")
+	out, err := os.Create(iso)
+	require.NoError(t, err)
+	_, err = io.Copy(out, zr)
+	require.NoError(t, err)
+	require.NoError(t, out.Close())
+	return iso
+}
+
+// Test the catalog and boot image of a plain El Torito ISO are found in the tree.
+func TestGenerator_InspectBootLayout(t *testing.T) {
+	layout, err := (&Generator{}).InspectBootLayout(sourceFixture(t))
+	require.NoError(t, err)
+	assert.Equal(t, SystemAreaNone, layout.SystemArea)
+	assert.True(t, layout.Joliet)
+	assert.Empty(t, layout.AppendedPartitions)
+	require.Len(t, layout.BootImages, 1)
+	assert.Equal(t, "/isolinux/isolinux.bin", layout.BootImages[0].Path)
+	assert.True(t, layout.BootImages[0].NoEmulation)
+	assert.Equal(t, uint16(4), layout.BootImages[0].LoadSize)
+	assert.NotEmpty(t, layout.Catalog)
+}
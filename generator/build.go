@@ -0,0 +1,214 @@
+package generator
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/lefeck/ubuntu-autoinstaller/logger"
+	"github.com/lefeck/ubuntu-autoinstaller/utils"
+)
+
+// Source types accepted by BuildOptions.SourceType.
+const (
+	SourceTypeLocal    = "local"
+	SourceTypeDownload = "download"
+)
+
+// Build modes accepted by BuildOptions.Mode.
+const (
+	BuildModeFull    = "full"    // Extract the whole ISO and write a new image from the tree
+	BuildModeOverlay = "overlay" // Extract only modified files and write them over the source image
+)
+
+// BuildOptions describes a complete ISO customization run.
+type BuildOptions struct {
+	SourceType     string   // "local" or "download"
+	SourceISO      string   // Local ISO file path (when SourceType is "local")
+	CodeName       string   // Ubuntu release name (when SourceType is "download")
+	Arch           string   // ISO architecture when SourceType is "download": amd64 (default) or arm64
+	DestinationISO string   // Output ISO file name or absolute path
+	UserData       string   // user-data configuration content
+	PackageList    []string // Additional package list
+	UseHWEKernel   bool     // Whether to use HWE kernel
+	MD5Checksum    bool     // Whether to update MD5 checksum
+	GPGVerify      bool     // Whether to perform GPG verification
+	Mode           string   // BuildModeFull (default) or BuildModeOverlay
+}
+
+// BuildReporter receives step transitions while Build runs.
+type BuildReporter interface {
+	// StepStarted is called before a step begins.
+	StepStarted(step, message string)
+	// StepCompleted is called after a step succeeded, with the overall progress in percent.
+	StepCompleted(step string, progress int, message string)
+	// CommandOutput is called for every output line of the commands a step runs.
+	CommandOutput(line string)
+}
+
+// Build runs the complete ISO build pipeline: prepare, download/verify, extract,
+// inject, packages, kernel params, HWE, md5 and repackage. In overlay mode only
+// the modified files are extracted and written over the source image. Cancelling
+// ctx stops the running step and kills the commands it started.
+func (gen *Generator) Build(ctx context.Context, opts *BuildOptions, reporter BuildReporter) error {
+	overlay := false
+	switch opts.Mode {
+	case "", BuildModeFull:
+	case BuildModeOverlay:
+		overlay = true
+	default:
+		return fmt.Errorf("unsupported build mode: %q", opts.Mode)
+	}
+
+	gen.output = reporter.CommandOutput
+	defer func() { gen.output = nil }()
+	// Step 1: Preprocessing - check packages
+	reporter.StepStarted("prepare", "📁 Preparing installation environment...")
+	if err := gen.PrepareEnvironment(ctx, opts); err != nil {
+		return fmt.Errorf("preprocessing failed: %w", err)
+	}
+	reporter.StepCompleted("prepare", 10, "✅ Installation environment ready")
+
+	var localImagePath string
+	var imageMeta *utils.ImageMeta
+	var err error
+
+	// Step 2: Process ISO file based on source type
+	switch opts.SourceType {
+	case SourceTypeDownload:
+		reporter.StepStarted("download", "🌎 Downloading ISO...")
+		localImagePath, err = gen.DownloadISOImage(ctx, opts.CodeName, opts.Arch, opts.GPGVerify)
+		if err != nil {
+			return fmt.Errorf("ISO download failed: %w", err)
+		}
+		reporter.StepCompleted("download", 30, "✅ ISO downloaded successfully")
+
+		if opts.GPGVerify {
+			reporter.StepStarted("verify", "🔐 Verifying ISO (GPG)...")
+			if err := gen.VerifyISO(ctx, opts.GPGVerify, localImagePath, opts.CodeName, opts.Arch); err != nil {
+				return fmt.Errorf("ISO verification failed: %w", err)
+			}
+			reporter.StepCompleted("verify", 40, "✅ ISO verified successfully")
+		}
+
+	case SourceTypeLocal:
+		reporter.StepStarted("upload", "📦 Using previously uploaded local ISO...")
+		if opts.SourceISO == "" {
+			return fmt.Errorf("local SourceISO path is empty")
+		}
+		if _, err := os.Stat(opts.SourceISO); err != nil {
+			return fmt.Errorf("local ISO not found: %w", err)
+		}
+		localImagePath = opts.SourceISO
+		reporter.StepCompleted("upload", 20, "✅ Local ISO ready")
+
+	default:
+		return fmt.Errorf("unsupported source type: %q", opts.SourceType)
+	}
+
+	imageMeta, err = utils.NewImageMeta(localImagePath)
+	if err != nil {
+		return fmt.Errorf("failed to get image meta: %w", err)
+	}
+	// The architecture of the ISO decides its boot layout and the packages to embed.
+	if err := ValidateArch(imageMeta.Arch); err != nil {
+		return fmt.Errorf("unsupported source ISO: %w", err)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// Step 3: Extract ISO image
+	var layout *BootLayout
+	if overlay {
+		reporter.StepStarted("extract", "📂 Extracting files to modify...")
+		if err := gen.ExtractOverlayFiles(ctx, localImagePath); err != nil {
+			return fmt.Errorf("ISO extraction failed: %w", err)
+		}
+		reporter.StepCompleted("extract", 50, "✅ Files to modify extracted")
+	} else {
+		reporter.StepStarted("extract", "📂 Extracting ISO contents...")
+		if err := gen.ExtractISO(ctx, imageMeta.CodeName, localImagePath); err != nil {
+			return fmt.Errorf("ISO extraction failed: %w", err)
+		}
+		reporter.StepCompleted("extract", 50, "✅ ISO contents extracted")
+
+		// Read the boot layout so it can be replayed; the templates remain as fallback.
+		layout, err = gen.InspectBootLayout(localImagePath)
+		if err != nil {
+			logger.Warnf("Failed to read boot layout of %s, using the %s template: %v", localImagePath, imageMeta.CodeName, err)
+			layout = nil
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// Step 4: Add configuration data (user-data and meta-data)
+	reporter.StepStarted("inject", "🧩 Injecting user-data configuration...")
+	userDataFile := gen.Path.UserDataFile(UserDataFile)
+	if err := os.WriteFile(userDataFile, []byte(opts.UserData), DefaultFilePerm); err != nil {
+		return fmt.Errorf("failed to create temporary user-data file: %w", err)
+	}
+	if err := gen.InjectNoCloudConfig(imageMeta.CodeName); err != nil {
+		return fmt.Errorf("failed to add config data: %w", err)
+	}
+	reporter.StepCompleted("inject", 60, "✅ user-data injected")
+
+	// Step 5: Download and prepare additional packages (if any)
+	if len(opts.PackageList) > 0 {
+		reporter.StepStarted("packages", "📦 Preparing additional packages...")
+		if err := gen.setupForeignApt(ctx, imageMeta.CodeName, imageMeta.Arch); err != nil {
+			return fmt.Errorf("failed to set up %s packages: %w", imageMeta.Arch, err)
+		}
+		if err := gen.PrepareLocalPackagesRepo(ctx, opts.PackageList); err != nil {
+			return fmt.Errorf("failed to download and prepare packages: %w", err)
+		}
+		reporter.StepCompleted("packages", 65, "✅ Extra packages prepared")
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// Step 6: Add autoinstall parameters to kernel command line
+	reporter.StepStarted("kernel", "⚙️ Adding autoinstall kernel parameters...")
+	if err := gen.AddAutoinstallKernelParams(imageMeta.CodeName); err != nil {
+		return fmt.Errorf("failed to add autoinstall parameter: %w", err)
+	}
+	reporter.StepCompleted("kernel", 70, "✅ Kernel parameters added")
+
+	// Step 7: Configure HWE kernel (if enabled)
+	reporter.StepStarted("hwe", "🧪 Configuring HWE kernel if requested...")
+	if err := gen.ConfigureHWEKernel(imageMeta.CodeName, opts.UseHWEKernel); err != nil {
+		return fmt.Errorf("failed to configure HWE kernel: %w", err)
+	}
+	reporter.StepCompleted("hwe", 80, "✅ HWE kernel configuration processed")
+
+	// Step 8: Update MD5 (if enabled)
+	reporter.StepStarted("md5", "🔢 Updating MD5 checksums if requested...")
+	if err := gen.UpdateGrubMD5Sums(imageMeta.CodeName, opts.MD5Checksum); err != nil {
+		return fmt.Errorf("failed to update MD5 checksum: %w", err)
+	}
+	reporter.StepCompleted("md5", 90, "✅ MD5 checksums updated")
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// Step 9: Repackage ISO image
+	reporter.StepStarted("repackage", "📦 Repackaging ISO image...")
+	if overlay {
+		err = gen.RepackageOverlayISO(ctx, imageMeta.CodeName, localImagePath, opts.DestinationISO)
+	} else {
+		err = gen.RepackageISOImage(ctx, imageMeta.CodeName, imageMeta.Arch, opts.DestinationISO, layout)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to repackage ISO: %w", err)
+	}
+	reporter.StepCompleted("repackage", 100, "✅ ISO repackaged successfully")
+
+	return nil
+}
@@ -2,6 +2,7 @@ package generator
 
 import (
 	"regexp"
+	"time"
 )
 
 const (
@@ -15,17 +16,15 @@ const (
 	Ping     = "ping"
 	AptGet   = "apt-get"
 	Xorriso  = "xorriso"
-	S7z      = "7z"
 	AptCache = "apt-cache"
 	Gpg      = "gpg"
+	Aptitude = "aptitude"
 
 	AptUpdateCmdTemplate = AptGet + " update -y"
 	// Command templates
 	PingCmdTemplate           = Ping + " -c 1 -w 1 8.8.8.8"
-	CurlCmdTemplate           = Curl + " -sSL " + DownloadURL + "%s"
+	CurlCmdTemplate           = Curl + " -sSL %s"
 	AptCmdTemplate            = AptGet + " install -y %s"
-	XorrisoCmdTemplate        = Xorriso + " -osirrox on -indev %s -extract / %s"
-	S7zCmdTemplate            = S7z + " -y x %s -o%s"
 	AptGetDownloadCmdTemplate = AptGet + " download %s"
 	AptCacheCmdTemplate       = AptCache + " depends %s"
 
@@ -46,9 +45,7 @@ const (
 	HWEKernelFile = CasperDir + "/hwe-vmlinuz"
 	HWEInitrdFile = CasperDir + "/hwe-initrd"
 
-	AptGetDownloadCmd          = "apt-get download %s"
-	AptCacheDependsCmdTemplate = "apt-cache depends --recurse --no-recommends --no-suggests --no-conflicts --no-breaks --no-replaces --no-enhances --no-pre-depends %s"
-	AptitudeShowCmd            = `aptitude show %s | grep "Provided by" | awk -F ' ' '{print $3}'`
+	AptitudeShowCmd = Aptitude + ` show %s | grep "Provided by" | awk -F ' ' '{print $3}'`
 
 	DpkgScanpackagesCmd         = "dpkg-scanpackages"
 	DpkgScanpackagesCmdTemplate = DpkgScanpackagesCmd + " ./"
@@ -65,13 +62,21 @@ const (
 	GrubInsertText     = "autoinstall ds=nocloud\\;s=/cdrom/"
 	ISOLinuxInsertText = "autoinstall ds=nocloud;s=/cdrom/"
 	GrubFilePerm       = 0644
+)
 
-	DebFilePattern = "*.deb"
+// Per-attempt command deadlines
+const (
+	PingTimeout        = 10 * time.Second
+	AptUpdateTimeout   = 5 * time.Minute
+	AptInstallTimeout  = 15 * time.Minute
+	AptDownloadTimeout = 10 * time.Minute
 )
 
 // Filtering conditions
 var (
-	RegexISOName   = `ubuntu-(\d{2}\.04)(\.\d+)?-live-server-amd64\.iso` // Regex to match Ubuntu ISO filenames
-	DepLineRegex   = regexp.MustCompile(`^[A-Za-z0-9]`)                  // Match lines starting with alphanumeric
-	ExcludeKeyword = "i386"                                              // Exclude this architecture
+	RegexISOName = `ubuntu-(\d{2}\.04)(\.\d+)?-live-server-%s\.iso` // Regex to match Ubuntu ISO filenames, formatted with the architecture
+	DepLineRegex = regexp.MustCompile(`^[A-Za-z0-9]`)               // Match lines starting with alphanumeric
+
+	// apt-cache arguments listing the dependencies of a package
+	AptCacheDependsArgs = []string{"depends", "--recurse", "--no-recommends", "--no-suggests", "--no-conflicts", "--no-breaks", "--no-replaces", "--no-enhances", "--no-pre-depends"}
 )
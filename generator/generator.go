@@ -3,19 +3,22 @@ package generator
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"crypto/md5"
+	"errors"
 	"fmt"
 	"io"
 
 	"github.com/lefeck/ubuntu-autoinstaller/cmd"
+	"github.com/lefeck/ubuntu-autoinstaller/iso9660"
 	"github.com/lefeck/ubuntu-autoinstaller/logger"
 	"github.com/lefeck/ubuntu-autoinstaller/utils"
 
 	"os"
 	"os/exec"
 	"path/filepath"
-	"regexp"
 	"strings"
+	"sync"
 
 	"text/template"
 	"time"
@@ -25,32 +28,32 @@ func (p Package) String() string {
 	return string(p)
 }
 
-// PackageInfo describes required system packages and the primary command to check.
+// PackageInfo describes required system packages and the command or file
+// whose presence shows they are installed.
 type PackageInfo struct {
 	Packages []string // System package names
 	Command  string   // Provided command used to check existence
+	File     string   // Provided file used to check existence when there is no command
 }
 
 type Package string
 
 const (
 	PackageXorriso  Package = "xorriso"
-	PackageSed      Package = "sed"
+	PackageIsolinux Package = "isolinux"
 	PackageCurl     Package = "curl"
 	PackageGpg      Package = "gpg"
-	Package7z       Package = "7z"
 	PackageDpkgDev  Package = "dpkg-dev"
-	PackageAptitude Package = "aptitude"
 )
 
 var packages = map[Package]PackageInfo{
 	PackageXorriso: {
-		Packages: []string{"xorriso", "isolinux", "binutils", "fakeroot"},
+		Packages: []string{"xorriso"},
 		Command:  "xorriso",
 	},
-	PackageSed: {
-		Packages: []string{"sed"},
-		Command:  "sed",
+	PackageIsolinux: {
+		Packages: []string{"isolinux"},
+		File:     ISOhdpfxPath,
 	},
 	PackageCurl: {
 		Packages: []string{"curl"},
@@ -60,24 +63,26 @@ var packages = map[Package]PackageInfo{
 		Packages: []string{"gpg"},
 		Command:  "gpg",
 	},
-	Package7z: {
-		Packages: []string{"p7zip-full"},
-		Command:  "7z",
-	},
 	PackageDpkgDev: {
 		Packages: []string{"dpkg-dev"},
 		Command:  "dpkg-scanpackages",
 	},
-	PackageAptitude: {
-		Packages: []string{"aptitude"},
-		Command:  "aptitude",
-	},
 }
 
 // Generator orchestrates the ISO build workflow.
 type Generator struct {
 	executor *cmd.Executor
 	Path     *utils.Path
+
+	// downloadMu serializes access to the download directory shared by all workspaces.
+	downloadMu *sync.Mutex
+
+	// output receives the output lines of long-running commands, if set.
+	output func(line string)
+
+	// arch and aptOptions select the packages of the target architecture; see setupForeignApt.
+	arch       string
+	aptOptions []string
 }
 
 // NewGenerator creates a Generator and prepares base directories.
@@ -88,23 +93,64 @@ func NewGenerator(executor *cmd.Executor, rootDir string) (*Generator, error) {
 	dirs := []string{
 		rootDir,
 		path.DownloadDir(),
+	}
+	dirs = append(dirs, workDirs(path)...)
+
+	// Create directories if not present
+	if err := makeDirs(dirs); err != nil {
+		return nil, err
+	}
+	logger.Infof("Using temporary directory: %s", rootDir)
+	return &Generator{
+		executor:   executor,
+		Path:       path,
+		downloadMu: &sync.Mutex{},
+	}, nil
+}
+
+// NewWorkspace returns a Generator that shares downloads with gen but keeps all
+// build files in its own workspace, so concurrent builds don't interfere.
+func (gen *Generator) NewWorkspace(id string) (*Generator, error) {
+	path := gen.Path.Workspace(id)
+	if err := makeDirs(workDirs(path)); err != nil {
+		return nil, err
+	}
+	logger.Infof("Using workspace directory: %s", path.WorkDir)
+	return &Generator{
+		executor:   gen.executor,
+		Path:       path,
+		downloadMu: gen.downloadMu,
+	}, nil
+}
+
+// outputOptions returns the executor options that stream command output to gen.output.
+func (gen *Generator) outputOptions() []cmd.Options {
+	if gen.output == nil {
+		return nil
+	}
+	return []cmd.Options{cmd.StdoutFunc(gen.output), cmd.StderrFunc(gen.output)}
+}
+
+// workDirs lists the per-build directories of a path.
+func workDirs(path *utils.Path) []string {
+	return []string{
+		path.WorkDir,
 		path.BuildDir(),
 		path.Mount(),
 		path.Packages(),
 		path.Scripts(),
+		path.OutputDir(),
 	}
+}
 
-	// Create directories if not present
+// makeDirs creates the given directories if not present.
+func makeDirs(dirs []string) error {
 	for _, dir := range dirs {
 		if err := os.MkdirAll(dir, DefaultDirPerm); err != nil {
-			return nil, fmt.Errorf("failed to create directory %s: %w", dir, err)
+			return fmt.Errorf("failed to create directory %s: %w", dir, err)
 		}
 	}
-	logger.Infof("Using temporary directory: %s", rootDir)
-	return &Generator{
-		executor: executor,
-		Path:     path,
-	}, nil
+	return nil
 }
 
 // isExistPackage checks if a package/command exists.
@@ -114,9 +160,9 @@ func (g *Generator) isExistPackage(command string) bool {
 }
 
 // CheckNetwork checks network connection.
-func (g *Generator) checkNetwork() error {
+func (g *Generator) checkNetwork(ctx context.Context) error {
 	logger.Info("Checking network connectivity...")
-	_, _, err := g.executor.RunCmdWithAttempts(PingCmdTemplate, 3, 5*time.Second)
+	_, _, err := g.executor.RunCmdWithAttemptsContext(ctx, PingCmdTemplate, 3, PingTimeout)
 	if err != nil {
 		logger.Errorf("Network connectivity check failed: %v", err)
 		return fmt.Errorf("network connectivity check failed: %v", err)
@@ -125,9 +171,9 @@ func (g *Generator) checkNetwork() error {
 	return nil
 }
 
-func (g *Generator) updateSource() error {
+func (g *Generator) updateSource(ctx context.Context) error {
 	logger.Info("Updating package list...")
-	_, _, err := g.executor.RunCmdWithAttempts(AptUpdateCmdTemplate, 3, 5*time.Second)
+	_, _, err := g.executor.RunCmdWithAttemptsContext(ctx, AptUpdateCmdTemplate, 3, AptUpdateTimeout, g.outputOptions()...)
 	if err != nil {
 		logger.Errorf("Failed to update package list: %v", err)
 		return fmt.Errorf("Failed to update package list: %v", err)
@@ -135,42 +181,49 @@ func (g *Generator) updateSource() error {
 	return nil
 }
 
-func (g *Generator) ensurePackagesInstalled(pkg Package) error {
-	info, ok := packages[pkg]
-	if !ok {
-		return fmt.Errorf("package %s not found", pkg)
+// ensurePackagesInstalled installs the system packages of pkgs whose command
+// or file is missing, with a single apt-get run.
+func (g *Generator) ensurePackagesInstalled(ctx context.Context, pkgs ...Package) error {
+	var missing []string
+	for _, pkg := range pkgs {
+		info, ok := packages[pkg]
+		if !ok {
+			return fmt.Errorf("package %s not found", pkg)
+		}
+		if g.isInstalled(info) {
+			logger.Infof("Package %s already installed, skip installation", pkg)
+			continue
+		}
+		logger.Infof("Package %s not found, attempting to install", pkg)
+		missing = append(missing, info.Packages...)
 	}
-	if g.isExistPackage(info.Command) {
-		logger.Infof("Command %s already exists, skip installation", info.Command)
+	if len(missing) == 0 {
 		return nil
 	}
 
-	logger.Infof("Command %s not found, attempting to install", info.Command)
-
-	if err := g.updateSource(); err != nil {
+	if err := g.updateSource(ctx); err != nil {
 		return err
 	}
 
-	if err := g.checkNetwork(); err != nil {
+	if err := g.checkNetwork(ctx); err != nil {
 		return err
 	}
 
-	var pkgs []string
-	for _, name := range info.Packages {
-		pkgs = append(pkgs, name)
-	}
-	if len(pkgs) > 0 {
-		if err := g.installPackages(strings.Join(pkgs, " ")); err != nil {
-			return err
-		}
+	return g.installPackages(ctx, strings.Join(missing, " "))
+}
+
+func (g *Generator) isInstalled(info PackageInfo) bool {
+	if info.Command != "" {
+		return g.isExistPackage(info.Command)
 	}
-	return nil
+	_, err := os.Stat(info.File)
+	return err == nil
 }
 
-func (g *Generator) installPackages(pkgs string) error {
+func (g *Generator) installPackages(ctx context.Context, pkgs string) error {
 	logger.Infof("Installing packages: %s", pkgs)
 	aptCmd := fmt.Sprintf(AptCmdTemplate, pkgs)
-	_, _, err := g.executor.RunCmdWithAttempts(aptCmd, 3, 5*time.Second)
+	_, _, err := g.executor.RunCmdWithAttemptsContext(ctx, aptCmd, 3, AptInstallTimeout, g.outputOptions()...)
 	if err != nil {
 		logger.Errorf("Package installation failed for %s: %v", pkgs, err)
 		return fmt.Errorf("installation of package %s failed: %v", pkgs, err)
@@ -180,67 +233,73 @@ func (g *Generator) installPackages(pkgs string) error {
 	return nil
 }
 
-// CheckPackages checks and installs necessary packages.
-func (g *Generator) checkPackages(codename string) error {
-	pkgs := []Package{
-		PackageXorriso,
-		PackageSed,
-		PackageCurl,
-		PackageGpg,
-		Package7z,
-		PackageDpkgDev,
-		PackageAptitude,
-	}
-	for _, pkg := range pkgs {
-		if err := g.ensurePackagesInstalled(pkg); err != nil {
-			return err
+// requiredPackages returns the packages whose commands a build with opts
+// runs. ISOs are extracted natively, so only repackaging needs xorriso; the
+// isolinux MBR of the focal template is installed by RepackageISOImage when the
+// boot layout cannot be replayed.
+func requiredPackages(opts *BuildOptions) []Package {
+	pkgs := []Package{PackageXorriso}
+	if opts.SourceType == SourceTypeDownload {
+		pkgs = append(pkgs, PackageCurl)
+		if opts.GPGVerify {
+			pkgs = append(pkgs, PackageGpg)
 		}
 	}
-	// Special check for focal release, like shell.
-	if codename == "focal" {
-		if _, err := os.Stat(ISOhdpfxPath); os.IsNotExist(err) {
-			return fmt.Errorf("isolinux is not installed. On Ubuntu, install the 'isolinux' package")
-		}
+	if len(opts.PackageList) > 0 {
+		pkgs = append(pkgs, PackageDpkgDev)
+	}
+	return pkgs
+}
+
+// checkPackages checks and installs the packages a build with opts needs.
+func (g *Generator) checkPackages(ctx context.Context, opts *BuildOptions) error {
+	if err := g.ensurePackagesInstalled(ctx, requiredPackages(opts)...); err != nil {
+		return err
 	}
 	logger.Info("All necessary packages are installed successfully")
 	return nil
 }
 
 // Preprocess combines creation and checks, similar to original shell.
-func (g *Generator) Preprocess(codename string) error {
-	return g.checkPackages(codename)
+func (g *Generator) Preprocess(ctx context.Context, opts *BuildOptions) error {
+	return g.checkPackages(ctx, opts)
 }
 
 // PrepareEnvironment ensures required system packages are installed. Wrapper for Preprocess.
-func (g *Generator) PrepareEnvironment(codename string) error {
-	return g.Preprocess(codename)
+func (g *Generator) PrepareEnvironment(ctx context.Context, opts *BuildOptions) error {
+	return g.Preprocess(ctx, opts)
 }
 
-// buildDownloadURL builds the Ubuntu release URL for the given codename.
-func (g *Generator) buildDownloadURL(codename string) string {
-	return DownloadURL + codename
+// buildDownloadURL builds the Ubuntu release URL for the given codename and architecture.
+func (g *Generator) buildDownloadURL(codename, arch string) string {
+	return fmt.Sprintf(archs[archOrDefault(arch)].releaseURL, codename)
 }
 
 // DownloadImage downloads the Ubuntu ISO page, resolves the filename and fetches the ISO.
-func (gen *Generator) DownloadImage(codename string, gpgVerify bool) (imagepath string, err error) {
-	url := gen.buildDownloadURL(codename)
+func (gen *Generator) DownloadImage(ctx context.Context, codename, arch string, gpgVerify bool) (imagepath string, err error) {
+	if err := ValidateArch(arch); err != nil {
+		return "", err
+	}
+	gen.downloadMu.Lock()
+	defer gen.downloadMu.Unlock()
+
+	url := gen.buildDownloadURL(codename, arch)
 	logger.Info("Checking for current release...")
 
 	// Fetch release page and extract ISO filename
 	logger.Infof("Fetching download page for Ubuntu %s...", codename)
-	curlCmdTemplate := fmt.Sprintf(CurlCmdTemplate, codename)
-	stdout, _, err := gen.executor.RunCmd(curlCmdTemplate)
+	curlCmdTemplate := fmt.Sprintf(CurlCmdTemplate, url)
+	stdout, _, err := gen.executor.RunCmdContext(ctx, curlCmdTemplate)
 	if err != nil {
 		logger.Errorf("Failed to fetch download page: %v", err)
 		return "", fmt.Errorf("failed to fetch download page: %w", err)
 	}
 
-	re := regexp.MustCompile(RegexISOName)
-	matches := re.FindStringSubmatch(stdout)
+	matches := isoNameRegex(arch).FindStringSubmatch(stdout)
 	if len(matches) == 0 {
 		return "", fmt.Errorf("no ISO file found on the download page")
 	}
-	fileName := matches[0] // 22.04.5-live-server-amd64.iso
+	fileName := matches[0] // ubuntu-22.04.5-live-server-amd64.iso
 
 	imagePath := gen.Path.DownloadFile(fileName) // /tmp/downloads/ubuntu-22.04.5-live-server-amd64.iso
 
@@ -250,7 +309,7 @@ func (gen *Generator) DownloadImage(codename string, gpgVerify bool) (imagepath
 	if _, err := os.Stat(imagePath); os.IsNotExist(err) {
 		logger.Infof("Downloading ISO image for Ubuntu %s %s...", version, codename)
 		downloadURL := fmt.Sprintf("%s/%s", url, fileName)
-		if err := utils.DownloadFile(downloadURL, imagePath); err != nil {
+		if err := utils.DownloadFileContext(ctx, downloadURL, imagePath); err != nil {
 			return "", fmt.Errorf("failed to download ISO: %w", err)
 		}
 		logger.Infof("Downloaded and saved to %s", imagePath)
@@ -267,37 +326,40 @@ func (gen *Generator) DownloadImage(codename string, gpgVerify bool) (imagepath
 }
 
 // DownloadISOImage is a clearer alias for DownloadImage.
-func (gen *Generator) DownloadISOImage(codename string, gpgVerify bool) (string, error) {
-	return gen.DownloadImage(codename, gpgVerify)
+func (gen *Generator) DownloadISOImage(ctx context.Context, codename, arch string, gpgVerify bool) (string, error) {
+	return gen.DownloadImage(ctx, codename, arch, gpgVerify)
 }
 
 // VerifyISO verifies ISO using downloaded SHA256SUMS and Ubuntu signing keys.
-func (gen *Generator) VerifyISO(gpgVerify bool, sourceISO string, codename string) error {
+func (gen *Generator) VerifyISO(ctx context.Context, gpgVerify bool, sourceISO string, codename, arch string) error {
 	if !gpgVerify {
 		logger.Info("Skipping verification of source ISO")
 		return nil
 	}
 
+	gen.downloadMu.Lock()
+	defer gen.downloadMu.Unlock()
+
 	shaSuffix := time.Now().Format("20060102150405")
 	shaSumsFile := gen.Path.Sha256SumsFile(shaSuffix)
 	shaSumsGPGFile := gen.Path.Sha256SumsGPGFile(shaSuffix)
 	keyringFile := gen.Path.KeyringFile(UbuntuGPGKeyID)
 
-	// Build base URL for the selected codename
-	baseURL := gen.buildDownloadURL(codename)
+	// Build base URL for the selected codename and architecture
+	baseURL := gen.buildDownloadURL(codename, arch)
 
 	// Download SHA256SUMS and SHA256SUMS.gpg
-	if err := gen.downloadSHA256Files(baseURL, shaSumsFile, shaSumsGPGFile); err != nil {
+	if err := gen.downloadSHA256Files(ctx, baseURL, shaSumsFile, shaSumsGPGFile); err != nil {
 		return err
 	}
 
 	// Download Ubuntu signing key (to custom keyring)
-	if err := gen.downloadSigningKey(keyringFile); err != nil {
+	if err := gen.downloadSigningKey(ctx, keyringFile); err != nil {
 		return err
 	}
 
 	// Verify GPG signature for SHA256SUMS file
-	if err := gen.verifyGPGSignature(keyringFile, shaSumsGPGFile, shaSumsFile); err != nil {
+	if err := gen.verifyGPGSignature(ctx, keyringFile, shaSumsGPGFile, shaSumsFile); err != nil {
 		return err
 	}
 
@@ -317,16 +379,16 @@ func (gen *Generator) VerifyISO(gpgVerify bool, sourceISO string, codename strin
 }
 
 // downloadSHA256Files downloads SHA256SUMS and SHA256SUMS.gpg to the download directory.
-func (g *Generator) downloadSHA256Files(baseURL, shaSumsFile, shaSumsGPGFile string) error {
+func (g *Generator) downloadSHA256Files(ctx context.Context, baseURL, shaSumsFile, shaSumsGPGFile string) error {
 	if _, err := os.Stat(shaSumsFile); os.IsNotExist(err) {
 		logger.Info("Downloading SHA256SUMS & SHA256SUMS.gpg files...")
 		// Must use the same directory as ISO
 		logger.Infof("Downloading SHA256SUMS from: %s/SHA256SUMS", baseURL)
-		if err := utils.DownloadFile(fmt.Sprintf("%s/SHA256SUMS", baseURL), shaSumsFile); err != nil {
+		if err := utils.DownloadFileContext(ctx, fmt.Sprintf("%s/SHA256SUMS", baseURL), shaSumsFile); err != nil {
 			return fmt.Errorf("failed to download SHA256SUMS: %w", err)
 		}
 		logger.Infof("Downloading SHA256SUMS.gpg from: %s/SHA256SUMS.gpg", baseURL)
-		if err := utils.DownloadFile(fmt.Sprintf("%s/SHA256SUMS.gpg", baseURL), shaSumsGPGFile); err != nil {
+		if err := utils.DownloadFileContext(ctx, fmt.Sprintf("%s/SHA256SUMS.gpg", baseURL), shaSumsGPGFile); err != nil {
 			return fmt.Errorf("failed to download SHA256SUMS.gpg: %w", err)
 		}
 	} else {
@@ -336,11 +398,11 @@ func (g *Generator) downloadSHA256Files(baseURL, shaSumsFile, shaSumsGPGFile str
 }
 
 // downloadSigningKey downloads the Ubuntu signing key to a local keyring file.
-func (g *Generator) downloadSigningKey(keyringFile string) error {
+func (g *Generator) downloadSigningKey(ctx context.Context, keyringFile string) error {
 	if _, err := os.Stat(keyringFile); os.IsNotExist(err) {
 		logger.Info("Downloading and saving Ubuntu signing key...")
 		gpgRecvKeyCmdTemplate := fmt.Sprintf(GpgRecvKeyCmdTemplate, keyringFile, UbuntuGPGKeyID)
-		_, _, err := g.executor.RunCmd(gpgRecvKeyCmdTemplate)
+		_, _, err := g.executor.RunCmdContext(ctx, gpgRecvKeyCmdTemplate)
 		if err != nil {
 			logger.Errorf("Failed to download Ubuntu signing key: %v", err)
 			return fmt.Errorf("failed to download Ubuntu signing key: %w", err)
@@ -353,7 +415,7 @@ func (g *Generator) downloadSigningKey(keyringFile string) error {
 }
 
 // verifyGPGSignature verifies the SHA256SUMS.gpg signature against the keyring.
-func (g *Generator) verifyGPGSignature(keyringFile, shaSumsGPGFile, shaSumsFile string) error {
+func (g *Generator) verifyGPGSignature(ctx context.Context, keyringFile, shaSumsGPGFile, shaSumsFile string) error {
 	logger.Infof("Verifying integrity and authenticity...")
 	logger.Infof("GPG command: gpg --keyring %s --verify %s %s", keyringFile, shaSumsGPGFile, shaSumsFile)
 
@@ -369,7 +431,7 @@ func (g *Generator) verifyGPGSignature(keyringFile, shaSumsGPGFile, shaSumsFile
 	}
 
 	gpgVerifyCmdTemplate := fmt.Sprintf(GpgVerifyCmdTemplate, keyringFile, shaSumsGPGFile, shaSumsFile)
-	_, stderr, err := g.executor.RunCmd(gpgVerifyCmdTemplate)
+	_, stderr, err := g.executor.RunCmdContext(ctx, gpgVerifyCmdTemplate)
 	if err != nil {
 		logger.Errorf("GPG signature verification failed: %v", err)
 		logger.Errorf("GPG stderr output: %s", stderr)
@@ -410,17 +472,13 @@ func (g *Generator) verifySHA256Checksum(shaSumsFile, digest string) error {
 // ISO extraction
 
 // ExtractISO extracts ISO contents into the build directory and fixes permissions.
-func (gen *Generator) ExtractISO(codename string, sourceISO string) error {
-	boot := gen.Path.Boot()
-
+func (gen *Generator) ExtractISO(ctx context.Context, codename string, sourceISO string) error {
 	logger.Info("Extracting ISO image...")
 
-	// Choose extractor by codename
-	if err := gen.extractISOImage(codename, sourceISO); err != nil {
+	if err := gen.resetBuildDir(); err != nil {
 		return err
 	}
-	// Cleanup and move boot artifacts
-	if err := gen.cleanupAndMoveFiles(codename, boot); err != nil {
+	if err := gen.extractISOImage(ctx, sourceISO); err != nil {
 		return err
 	}
 	// Fix permissions under build dir
@@ -432,53 +490,84 @@ func (gen *Generator) ExtractISO(codename string, sourceISO string) error {
 	return nil
 }
 
-// extractISOImage selects xorriso or 7z to extract based on codename.
-func (g *Generator) extractISOImage(codename string, sourceISO string) error {
-	buidDir := g.Path.BuildDir()
-	switch codename {
-	case "focal":
-		logger.Info("Extracting ISO using xorriso...")
-		xorrisoCmd := fmt.Sprintf(XorrisoCmdTemplate, sourceISO, buidDir)
-		_, _, err := g.executor.RunCmd(xorrisoCmd)
-		if err != nil {
-			logger.Errorf("Failed to extract ISO using xorriso: %v", err)
-			return fmt.Errorf("failed to extract ISO image using xorriso: %w", err)
-		}
-		logger.Info("Successfully extracted ISO using xorriso")
-	default:
-		logger.Info("Extracting ISO using 7z...")
-		s7zCmd := fmt.Sprintf(S7zCmdTemplate, sourceISO, buidDir)
-		_, _, err := g.executor.RunCmd(s7zCmd)
-		if err != nil {
-			logger.Errorf("Failed to extract ISO using 7z: %v", err)
-			return fmt.Errorf("failed to extract ISO image using 7z: %w", err)
-		}
-		logger.Info("Successfully extracted ISO using 7z")
+// extractISOImage unpacks the file tree into the build directory and the El Torito
+// boot images into the BOOT directory, where the xorriso templates expect them.
+func (g *Generator) extractISOImage(ctx context.Context, sourceISO string) error {
+	img, err := iso9660.Open(sourceISO)
+	if err != nil {
+		return fmt.Errorf("failed to open ISO image: %w", err)
+	}
+	defer img.Close()
+
+	if err := img.Extract(ctx, g.Path.BuildDir()); err != nil {
+		logger.Errorf("Failed to extract ISO: %v", err)
+		return fmt.Errorf("failed to extract ISO image: %w", err)
+	}
+
+	boot := g.Path.Boot()
+	if err := os.RemoveAll(boot); err != nil {
+		return fmt.Errorf("failed to remove bootdir: %w", err)
+	}
+	files, err := img.ExtractBootImages(boot)
+	if err != nil {
+		return fmt.Errorf("failed to extract boot images: %w", err)
+	}
+	for _, file := range files {
+		logger.Infof("Extracted boot image %s", file)
 	}
+	logger.Info("Successfully extracted ISO")
 	return nil
 }
 
-// cleanupAndMoveFiles removes unwanted dirs and moves [BOOT] to BOOT when required.
-func (gen *Generator) cleanupAndMoveFiles(codename string, boot string) error {
-	bootISO := gen.Path.BootISO()
-	switch codename {
-	case "focal":
-		if err := os.RemoveAll(bootISO); err != nil {
-			return fmt.Errorf("failed to remove [BOOT] directory: %w", err)
+// resetBuildDir empties the build directory and the boot images left by an
+// earlier build in the same working directory, since extraction does not
+// overwrite files.
+func (gen *Generator) resetBuildDir() error {
+	for _, dir := range []string{gen.Path.BuildDir(), gen.Path.Boot()} {
+		if err := os.RemoveAll(dir); err != nil {
+			return fmt.Errorf("failed to clear %s: %w", dir, err)
 		}
-	default:
-		// Remove existing boot dir if present
-		if _, err := os.Stat(boot); err == nil {
-			if err := os.RemoveAll(boot); err != nil {
-				return fmt.Errorf("failed to remove bootdir: %w", err)
-			}
+	}
+	return makeDirs(workDirs(gen.Path))
+}
+
+// overlayFiles are the files of the source ISO a build modifies. In overlay
+// mode only these are extracted; everything else stays in the source image.
+var overlayFiles = []string{GrubConfigPath, LoopBackConfigPath, TxtConfigPath, MD5SumFile}
+
+// ExtractOverlayFiles extracts only the files a build modifies into the build
+// directory. Files the source ISO does not have are skipped.
+func (gen *Generator) ExtractOverlayFiles(ctx context.Context, sourceISO string) error {
+	logger.Info("Extracting files to modify from ISO image...")
+	img, err := iso9660.Open(sourceISO)
+	if err != nil {
+		return fmt.Errorf("failed to open ISO image: %w", err)
+	}
+	defer img.Close()
+
+	if err := gen.resetBuildDir(); err != nil {
+		return err
+	}
+	buildDir := gen.Path.BuildDir()
+	for _, name := range overlayFiles {
+		if err := ctx.Err(); err != nil {
+			return err
 		}
-		if _, err := os.Stat(bootISO); !os.IsNotExist(err) {
-			logger.Infof("Moving [BOOT] from %s to %s", bootISO, boot)
-			if err := os.Rename(bootISO, boot); err != nil {
-				return fmt.Errorf("failed to move [BOOT] to bootDir: %w", err)
-			}
+		data, err := img.ReadFile(name)
+		if errors.Is(err, iso9660.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %s from ISO image: %w", name, err)
+		}
+		target := filepath.Join(buildDir, name)
+		if err := os.MkdirAll(filepath.Dir(target), DefaultDirPerm); err != nil {
+			return err
 		}
+		if err := os.WriteFile(target, data, DefaultFilePerm); err != nil {
+			return fmt.Errorf("failed to write %s: %w", name, err)
+		}
+		logger.Infof("Extracted %s", name)
 	}
 	return nil
 }
@@ -500,8 +589,10 @@ func (gen *Generator) adjustPermissions() error {
 	return nil
 }
 
-// RepackageISOImage rebuilds ISO using xorriso templates appropriate for codename.
-func (gen *Generator) RepackageISOImage(codename string, destinationISO string) error {
+// RepackageISOImage rebuilds the ISO from the build directory. The boot layout
+// of the source ISO is replayed when known; otherwise the xorriso template
+// appropriate for codename and arch is used.
+func (gen *Generator) RepackageISOImage(ctx context.Context, codename, arch string, destinationISO string, layout *BootLayout) error {
 	logger.Info("Repackaging extracted files into an ISO image...")
 
 	// Ensure destination has .iso extension
@@ -510,29 +601,38 @@ func (gen *Generator) RepackageISOImage(codename string, destinationISO string)
 	}
 
 	buildDir := gen.Path.BuildDir()
-	// Change into build directory while running xorriso
-	restore, err := changeDir(buildDir)
-	if err != nil {
-		return err
-	}
-	defer restore()
-
-	destinationISOFile := gen.Path.DownloadFile(destinationISO)
+	destinationISOFile := gen.DestinationISOFile(destinationISO)
 	// Generate final ISO label
 	isoName, err := gen.generateISOName(codename)
 	if err != nil {
 		return fmt.Errorf("failed to generate ISO name: %w", err)
 	}
 
-	// Build xorriso command from templates
-	cmdStr, err := gen.buildXorrisoCommand(codename, isoName, destinationISOFile)
-	if err != nil {
-		return fmt.Errorf("failed to build xorriso command: %w", err)
+	var xorrisoCmd *exec.Cmd
+	if layout != nil {
+		logBootLayout(layout)
+		args := append([]string{"-as", "mkisofs", "-r", "-V", isoName, "-o", destinationISOFile}, layout.MkisofsArgs()...)
+		xorrisoCmd = exec.Command(Xorriso, append(args, ".")...)
+		xorrisoCmd.Dir = buildDir
+	} else {
+		// Build xorriso command from templates; the focal one needs the isolinux MBR
+		if codename == "focal" && archOrDefault(arch) == ArchAMD64 {
+			if err := gen.ensurePackagesInstalled(ctx, PackageIsolinux); err != nil {
+				return err
+			}
+		}
+		cmdStr, err := gen.buildXorrisoCommand(codename, arch, isoName, destinationISOFile)
+		if err != nil {
+			return fmt.Errorf("failed to build xorriso command: %w", err)
+		}
+		if xorrisoCmd, err = commandInDir(buildDir, cmdStr); err != nil {
+			return err
+		}
 	}
 
-	// Execute xorriso
+	// Execute xorriso inside the build directory
 	logger.Info("Executing xorriso to create final ISO...")
-	_, _, err = gen.executor.RunCmd(cmdStr)
+	_, _, err = gen.executor.RunCmdContext(ctx, xorrisoCmd, gen.outputOptions()...)
 	if err != nil {
 		logger.Errorf("xorriso command failed: %v", err)
 		return err
@@ -542,6 +642,52 @@ func (gen *Generator) RepackageISOImage(codename string, destinationISO string)
 	return nil
 }
 
+// RepackageOverlayISO writes a new ISO that keeps the source ISO and adds or
+// replaces the files of the build directory, like `xorriso -indev -outdev`.
+// The boot setup of the source ISO is replayed by xorriso itself.
+func (gen *Generator) RepackageOverlayISO(ctx context.Context, codename string, sourceISO string, destinationISO string) error {
+	logger.Info("Writing overlay onto the source ISO image...")
+
+	if filepath.Ext(destinationISO) != ".iso" {
+		return fmt.Errorf("verification of iso image format failed")
+	}
+
+	destinationISOFile := gen.DestinationISOFile(destinationISO)
+	isoName, err := gen.generateISOName(codename)
+	if err != nil {
+		return fmt.Errorf("failed to generate ISO name: %w", err)
+	}
+	// xorriso would treat an existing image as a medium to append to.
+	if err := os.Remove(destinationISOFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove existing ISO: %w", err)
+	}
+
+	xorrisoCmd := exec.Command(Xorriso,
+		"-indev", sourceISO,
+		"-outdev", destinationISOFile,
+		"-volid", isoName,
+		"-map", gen.Path.BuildDir(), "/",
+		"-boot_image", "any", "replay",
+	)
+	logger.Info("Executing xorriso to create final ISO...")
+	if _, _, err := gen.executor.RunCmdContext(ctx, xorrisoCmd, gen.outputOptions()...); err != nil {
+		logger.Errorf("xorriso command failed: %v", err)
+		return err
+	}
+
+	logger.Infof("Successfully wrote overlay ISO: %s", destinationISO)
+	return nil
+}
+
+// DestinationISOFile resolves where the repackaged ISO is written: absolute paths
+// are used as-is, plain file names are placed in the output directory.
+func (gen *Generator) DestinationISOFile(destinationISO string) string {
+	if filepath.IsAbs(destinationISO) {
+		return destinationISO
+	}
+	return gen.Path.OutputFile(destinationISO)
+}
+
 // generateISOName generates the ISO name based on the codename.
 func (gen *Generator) generateISOName(codename string) (string, error) {
 	var isoName bytes.Buffer
@@ -552,8 +698,8 @@ func (gen *Generator) generateISOName(codename string) (string, error) {
 	return isoName.String(), nil
 }
 
-// buildXorrisoCommand builds the xorriso command based on the codename.
-func (gen *Generator) buildXorrisoCommand(codename, isoName, destinationISOFile string) (string, error) {
+// buildXorrisoCommand builds the xorriso command based on the codename and architecture.
+func (gen *Generator) buildXorrisoCommand(codename, arch, isoName, destinationISOFile string) (string, error) {
 	var cmdBuilder bytes.Buffer
 	data := map[string]string{
 		"Label":  isoName,
@@ -561,9 +707,12 @@ func (gen *Generator) buildXorrisoCommand(codename, isoName, destinationISOFile
 	}
 
 	var tmpl *template.Template
-	if codename == "focal" {
+	switch {
+	case archOrDefault(arch) != ArchAMD64:
+		tmpl = XorrisoCmdUEFITemplate
+	case codename == "focal":
 		tmpl = XorrisoCmdUbuntu2004Template
-	} else {
+	default:
 		tmpl = XorrisoCmdUbuntu2204Template
 	}
 
@@ -574,14 +723,15 @@ func (gen *Generator) buildXorrisoCommand(codename, isoName, destinationISOFile
 	return cmdBuilder.String(), nil
 }
 
-// CleanUp deletes the build directory tree.
+// CleanUp deletes the build directory tree and extracted boot images.
 func (gen *Generator) CleanUp() error {
-	buildDir := gen.Path.BuildDir()
-	if err := os.RemoveAll(buildDir); err != nil {
-		logger.Warnf("Failed to clean up temporary directory %s: %v", buildDir, err)
-		return fmt.Errorf("Failed to clean up temporary directory: %v", err)
+	for _, dir := range []string{gen.Path.BuildDir(), gen.Path.Boot()} {
+		if err := os.RemoveAll(dir); err != nil {
+			logger.Warnf("Failed to clean up temporary directory %s: %v", dir, err)
+			return fmt.Errorf("Failed to clean up temporary directory: %v", err)
+		}
+		logger.Infof("Successfully cleaned up temporary directory: %s", dir)
 	}
-	logger.Infof("Successfully cleaned up temporary directory: %s", buildDir)
 	return nil
 }
 
@@ -622,7 +772,7 @@ func (gen *Generator) InjectNoCloudConfig(codename string) error {
 }
 
 // DownloadAndPreparePackages downloads packages, builds a local repo and creates install script.
-func (g *Generator) DownloadAndPreparePackages(packages []string) error {
+func (g *Generator) DownloadAndPreparePackages(ctx context.Context, packages []string) error {
 	if len(packages) == 0 {
 		return nil
 	}
@@ -635,12 +785,12 @@ func (g *Generator) DownloadAndPreparePackages(packages []string) error {
 	}
 
 	// Download packages
-	if err := g.downloadPackages(pkgs); err != nil {
+	if err := g.downloadPackages(ctx, pkgs); err != nil {
 		return err
 	}
 
 	// Build local repo index files (Packages/Packages.gz)
-	if err := g.generateLocalRepoIndex(); err != nil {
+	if err := g.generateLocalRepoIndex(ctx); err != nil {
 		return err
 	}
 	logger.Info("Building local dependency packages")
@@ -655,8 +805,8 @@ func (g *Generator) DownloadAndPreparePackages(packages []string) error {
 }
 
 // PrepareLocalPackagesRepo is an alias for DownloadAndPreparePackages.
-func (g *Generator) PrepareLocalPackagesRepo(packages []string) error {
-	return g.DownloadAndPreparePackages(packages)
+func (g *Generator) PrepareLocalPackagesRepo(ctx context.Context, packages []string) error {
+	return g.DownloadAndPreparePackages(ctx, packages)
 }
 
 // parsePackageFile trims whitespace and removes comments/empty lines.
@@ -672,22 +822,25 @@ func (gen *Generator) parsePackageFile(packages []string) ([]string, error) {
 }
 
 // downloadPackages downloads all specified packages and moves .deb files.
-func (gen *Generator) downloadPackages(packages []string) error {
+func (gen *Generator) downloadPackages(ctx context.Context, packages []string) error {
 	pkgDir := gen.Path.Packages()
 	for _, pkg := range packages {
 		logger.Infof("Downloading and saving packages %s", pkg)
-		if err := gen.downloadPackage(pkgDir, pkg); err != nil {
+		if err := gen.downloadPackage(ctx, pkgDir, pkg); err != nil {
 			return fmt.Errorf("failed to download package %s: %w", pkg, err)
 		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		logger.Infof("Downloaded and saved all packages to %s/%s", pkgDir, pkg)
 	}
 	return nil
 }
 
 // generateLocalRepoIndex generates local APT repository index files.
-func (gen *Generator) generateLocalRepoIndex() error {
+func (gen *Generator) generateLocalRepoIndex(ctx context.Context) error {
 
-	if err := gen.buildPackagesIndex(); err != nil {
+	if err := gen.buildPackagesIndex(ctx); err != nil {
 		return fmt.Errorf("failed to generate package index: %w", err)
 	}
 	return nil
@@ -794,9 +947,9 @@ func modifyGrubConfig(path string, insertText string) error {
 }
 
 // downloadPackage is the main entry for downloading a package and its dependencies
-func (g *Generator) downloadPackage(destDir, pkg string) error {
+func (g *Generator) downloadPackage(ctx context.Context, destDir, pkg string) error {
 	// Step 1: Resolve dependencies
-	deps, err := g.resolveDependencies(pkg)
+	deps, err := g.resolveDependencies(ctx, pkg)
 	if err != nil {
 		return err
 	}
@@ -805,42 +958,38 @@ func (g *Generator) downloadPackage(destDir, pkg string) error {
 		return nil
 	}
 
-	// Step 2: Download dependencies
-	g.downloadDependencies(deps)
-
-	// Step 3: Move .deb files into the target directory
-	if err := moveDebFiles(destDir); err != nil {
-		return fmt.Errorf("failed to move deb files for %s: %w", pkg, err)
-	}
+	// Step 2: Download dependencies straight into the target directory
+	g.downloadDependencies(ctx, destDir, deps)
 
 	return nil
 }
 
-// resolveDependencies resolves package dependencies using apt-cache, and falls back to aptitude if needed.
-func (g *Generator) resolveDependencies(pkg string) ([]string, error) {
+// resolveDependencies resolves package dependencies using apt-cache, and falls back to aptitude if it is installed.
+func (g *Generator) resolveDependencies(ctx context.Context, pkg string) ([]string, error) {
 	logger.Infof("Resolving dependencies for package: %s", pkg)
 	// Try apt-cache first
-	aptCacheCmd := fmt.Sprintf(AptCacheDependsCmdTemplate, pkg)
-	out, _, err := g.executor.RunCmd(aptCacheCmd)
-	if err != nil || strings.TrimSpace(out) == "" {
-		// Fallback: aptitude
+	aptCacheCmd := g.aptCommand("", AptCache, append(AptCacheDependsArgs, pkg)...)
+	out, _, err := g.executor.RunCmdContext(ctx, aptCacheCmd)
+	if (err != nil || strings.TrimSpace(out) == "") && g.aptOptions == nil && g.isExistPackage(Aptitude) {
+		// Fallback: aptitude if installed, which only knows the host's packages
 		logger.Infof("apt-cache failed, trying aptitude for package: %s", pkg)
 		fallbackCmd := fmt.Sprintf(AptitudeShowCmd, pkg)
-		out, _, err = g.executor.RunCmd(fallbackCmd)
-		if err != nil {
-			logger.Errorf("Failed to resolve dependencies for %s: %v", pkg, err)
-			return nil, fmt.Errorf("failed to resolve dependencies for %s: %w", pkg, err)
-		}
+		out, _, err = g.executor.RunCmdContext(ctx, fallbackCmd)
+	}
+	if err != nil {
+		logger.Errorf("Failed to resolve dependencies for %s: %v", pkg, err)
+		return nil, fmt.Errorf("failed to resolve dependencies for %s: %w", pkg, err)
 	}
 
 	// Parse and filter dependencies
-	deps := filterDependencies(out)
+	deps := filterDependencies(out, g.arch)
 	logger.Infof("Resolved %d dependencies for package: %s", len(deps), pkg)
 	return deps, nil
 }
 
-// filterDependencies parses the raw command output and extracts valid dependencies.
-func filterDependencies(raw string) []string {
+// filterDependencies parses the raw command output and extracts the
+// dependencies of the target architecture.
+func filterDependencies(raw, arch string) []string {
 	lines := strings.Split(raw, "\n")
 	var deps []string
 	for _, line := range lines {
@@ -848,19 +997,22 @@ func filterDependencies(raw string) []string {
 		if line == "" {
 			continue
 		}
-		if DepLineRegex.MatchString(line) && !strings.Contains(line, ExcludeKeyword) {
+		if DepLineRegex.MatchString(line) && !foreignDependency(line, arch) {
 			deps = append(deps, line)
 		}
 	}
 	return deps
 }
 
-// downloadDependencies downloads each dependency using apt-get.
-func (g *Generator) downloadDependencies(deps []string) {
+// downloadDependencies downloads each dependency into destDir using apt-get.
+func (g *Generator) downloadDependencies(ctx context.Context, destDir string, deps []string) {
 	logger.Infof("Downloading %d dependencies...", len(deps))
 	for _, dep := range deps {
-		downloadCmd := fmt.Sprintf(AptGetDownloadCmd, dep)
-		_, _, err := g.executor.RunCmd(downloadCmd)
+		if ctx.Err() != nil {
+			return
+		}
+		downloadCmd := g.aptCommand(destDir, AptGet, "download", dep)
+		_, _, err := g.executor.RunCmdContext(ctx, downloadCmd, append(g.outputOptions(), cmd.CmdTimeout(AptDownloadTimeout))...)
 		if err != nil {
 			logger.Warnf("Failed to download dependency %s: %v", dep, err)
 			continue
@@ -870,56 +1022,36 @@ func (g *Generator) downloadDependencies(deps []string) {
 	logger.Info("Completed downloading dependencies")
 }
 
-func moveDebFiles(destDir string) error {
-	files, err := filepath.Glob(DebFilePattern)
-	if err != nil {
-		return err
-	}
-	for _, file := range files {
-		if err := os.Rename(file, filepath.Join(destDir, filepath.Base(file))); err != nil {
-			return err
-		}
+// commandInDir builds a command from a command line that runs in dir instead of
+// the process working directory, so concurrent builds never need to chdir.
+func commandInDir(dir, cmdline string) (*exec.Cmd, error) {
+	fields := strings.Fields(cmdline)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("empty command string")
 	}
-	return nil
+	c := exec.Command(fields[0], fields[1:]...)
+	c.Dir = dir
+	return c, nil
 }
 
-// ChangeDir  changes the current working directory.
-func changeDir(dir string) (func(), error) {
-	cwd, err := os.Getwd()
-	if err != nil {
-		return nil, fmt.Errorf("getwd failed: %w", err)
-	}
-
-	if err := os.Chdir(dir); err != nil {
-		return nil, fmt.Errorf("chdir to %s failed: %w", dir, err)
-	}
-
-	return func() {
-		if err := os.Chdir(cwd); err != nil {
-			logger.Warnf("failed to revert to original directory: %v", err)
-		}
-	}, nil
-}
-
-func (gen *Generator) buildPackagesIndex() error {
+func (gen *Generator) buildPackagesIndex(ctx context.Context) error {
 	logger.Info("Building local package repository index...")
 
-	// switch to dir
+	// Run inside the packages dir so the index contains relative paths
 	packagesDir := gen.Path.Packages()
-	restore, err := changeDir(packagesDir)
+	scanCmd, err := commandInDir(packagesDir, DpkgScanpackagesCmdTemplate)
 	if err != nil {
 		return err
 	}
-	defer restore()
 
 	logger.Info("Running dpkg-scanpackages to generate package index...")
-	stdout, _, err := gen.executor.RunCmd(DpkgScanpackagesCmdTemplate)
+	stdout, _, err := gen.executor.RunCmdContext(ctx, scanCmd)
 	if err != nil {
 		logger.Errorf("dpkg-scanpackages failed: %v", err)
 		return fmt.Errorf("dpkg-scanpackages error: %v", err)
 	}
 
-	pkgFile := "Packages"
+	pkgFile := filepath.Join(packagesDir, "Packages")
 	if err = os.WriteFile(pkgFile, []byte(stdout), 0644); err != nil {
 		logger.Errorf("Failed to write Packages file: %v", err)
 		return fmt.Errorf("write Packages failed: %w", err)
@@ -927,7 +1059,7 @@ func (gen *Generator) buildPackagesIndex() error {
 
 	// Compress to Packages.gz
 	logger.Info("Compressing package index to Packages.gz...")
-	if err = writeGzip(pkgFile, "Packages.gz"); err != nil {
+	if err = writeGzip(pkgFile, filepath.Join(packagesDir, "Packages.gz")); err != nil {
 		logger.Errorf("Failed to compress package index: %v", err)
 		return fmt.Errorf("gzip failed: %w", err)
 	}
@@ -1181,6 +1313,14 @@ func updateHWEConfig(filePath string) error {
 	return os.WriteFile(filePath, newContent, 0644)
 }
 
+// RemoveWorkspace deletes the whole workspace of a build, including any partial output.
+func (gen *Generator) RemoveWorkspace() error {
+	if gen.Path.WorkDir == gen.Path.RootDir {
+		return fmt.Errorf("refusing to remove the shared root directory %s", gen.Path.RootDir)
+	}
+	return os.RemoveAll(gen.Path.WorkDir)
+}
+
 // Cleanup removes temporary files and directories.
 func (gen *Generator) Cleanup() error {
 	return os.RemoveAll(gen.Path.RootDir)
@@ -0,0 +1,61 @@
+package generator
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lefeck/ubuntu-autoinstaller/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Test overlay mode extracts only the files a build modifies.
+func TestGenerator_ExtractOverlayFiles(t *testing.T) {
+	gen := &Generator{Path: utils.NewPath(t.TempDir())}
+	require.NoError(t, makeDirs(workDirs(gen.Path)))
+
+	require.NoError(t, gen.ExtractOverlayFiles(context.Background(), sourceFixture(t)))
+
+	grub, err := os.ReadFile(gen.Path.GrubConfigFile(GrubConfigPath))
+	require.NoError(t, err)
+	assert.Contains(t, string(grub), "menuentry")
+	_, err = os.Stat(gen.Path.TxtConfigFile(TxtConfigPath))
+	assert.ErrorIs(t, err, os.ErrNotExist, "files missing from the ISO are skipped")
+	_, err = os.Stat(filepath.Join(gen.Path.BuildDir(), "casper"))
+	assert.ErrorIs(t, err, os.ErrNotExist, "unmodified files stay in the source ISO")
+}
+
+// Test a kept working directory can be built in again: extraction clears what
+// the previous build left in the build directory.
+func TestGenerator_ExtractISOReusedWorkDir(t *testing.T) {
+	gen := &Generator{Path: utils.NewPath(t.TempDir())}
+	require.NoError(t, makeDirs(workDirs(gen.Path)))
+	source := sourceFixture(t)
+
+	require.NoError(t, gen.ExtractISO(context.Background(), "jammy", source))
+	stale := filepath.Join(gen.Path.BuildDir(), "stale.txt")
+	require.NoError(t, os.WriteFile(stale, nil, DefaultFilePerm))
+
+	require.NoError(t, gen.ExtractISO(context.Background(), "jammy", source))
+	_, err := os.Stat(stale)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	_, err = os.Stat(gen.Path.Packages())
+	assert.NoError(t, err, "the build directory layout is recreated")
+
+	require.NoError(t, gen.ExtractOverlayFiles(context.Background(), source))
+	_, err = os.Stat(filepath.Join(gen.Path.BuildDir(), "isolinux"))
+	assert.ErrorIs(t, err, os.ErrNotExist, "overlay mode starts from an empty build directory")
+}
+
+// Test the prepare step only requires the tools the build options use.
+func TestRequiredPackages(t *testing.T) {
+	assert.Equal(t, []Package{PackageXorriso}, requiredPackages(&BuildOptions{SourceType: SourceTypeLocal}))
+	assert.Equal(t, []Package{PackageXorriso, PackageCurl}, requiredPackages(&BuildOptions{SourceType: SourceTypeDownload}))
+	assert.Equal(t, []Package{PackageXorriso, PackageCurl, PackageGpg, PackageDpkgDev}, requiredPackages(&BuildOptions{
+		SourceType:  SourceTypeDownload,
+		GPGVerify:   true,
+		PackageList: []string{"vim"},
+	}))
+}
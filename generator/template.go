@@ -41,6 +41,19 @@ var (
 			"-isohybrid-gpt-basdat " +
 			"."))
 
+	// UEFI-only images, e.g. arm64, have a single El Torito entry for the appended EFI partition.
+	XorrisoCmdUEFITemplate = template.Must(template.New("xorriso").Parse(
+		Xorriso + " -as mkisofs -r " +
+			"-V {{.Label}} " +
+			"-o {{.Output}} " +
+			"-partition_offset 16 " +
+			"-append_partition 2 0xef ../BOOT/1-Boot-NoEmul.img " +
+			"-appended_part_as_gpt " +
+			"-iso_mbr_part_type a2a0d0ebe5b9334487c068b6b72699c7 " +
+			"-c /boot.catalog " +
+			"-e --interval:appended_partition_2::: " +
+			"-no-emul-boot ."))
+
 	ShellTemplate = `#!/bin/bash
 # The default installation package will be downloaded to /cdrom/mnt/packages/ directory
 cp /etc/apt/sources.list /etc/apt/sources.list.bak
@@ -1,6 +1,7 @@
 package generator
 
 import (
+	"bytes"
 	"fmt"
 
 	"gopkg.in/yaml.v3"
@@ -9,6 +10,9 @@ import (
 	"github.com/lefeck/ubuntu-autoinstaller/utils"
 )
 
+// cloudConfigHeader is the first line cloud-init requires in user-data.
+const cloudConfigHeader = "#cloud-config\n"
+
 // UserDataGenerator generates cloud-init user-data content.
 type UserDataGenerator struct{}
 
@@ -17,29 +21,118 @@ func NewUserDataGenerator() *UserDataGenerator {
 	return &UserDataGenerator{}
 }
 
-// GenerateFromConfig generates user-data from a config struct.
+// GenerateFromConfig generates user-data from a config struct and validates it
+// against the autoinstall schema of config.DefaultSchemaRelease.
 func (gen *UserDataGenerator) GenerateFromConfig(cfg *config.Config) ([]byte, error) {
+	return gen.GenerateForRelease(cfg, "")
+}
+
+// GenerateForRelease generates user-data from a config struct and validates it
+// against the autoinstall schema of release. Schema violations are returned as
+// a *config.SchemaError. Configs with generated dm_crypt keys need
+// GenerateWithKeys, which returns the keys.
+func (gen *UserDataGenerator) GenerateForRelease(cfg *config.Config, release string) ([]byte, error) {
+	userData, keys, err := gen.GenerateWithKeys(cfg, release)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) > 0 {
+		return nil, fmt.Errorf("dm_crypt %s has a generated key, which must be stored where the installer fetches it from", keys[0].ID)
+	}
+	return userData, nil
+}
+
+// GenerateWithKeys is like GenerateForRelease and also returns the dm_crypt
+// keys generated for the config. The keys are not part of the user-data and
+// must be uploaded to their URL before installing.
+func (gen *UserDataGenerator) GenerateWithKeys(cfg *config.Config, release string) ([]byte, []config.GeneratedKey, error) {
+	// Serialize config directly to avoid omitempty surprises via interface{}
+	return gen.generate(cfg, release, func() ([]byte, error) { return yaml.Marshal(cfg) })
+}
+
+// GenerateFromDocument is like GenerateWithKeys for a config loaded with
+// config.LoadDocument: the user-data keeps the keys of the document that the
+// config does not model, its comments and its key order.
+func (gen *UserDataGenerator) GenerateFromDocument(doc *config.Document, release string) ([]byte, []config.GeneratedKey, error) {
+	if doc == nil {
+		return nil, nil, fmt.Errorf("document must not be nil")
+	}
+	return gen.generate(doc.Config, release, doc.Marshal)
+}
+
+// generate prepares cfg for the installer and encodes it with marshal.
+func (gen *UserDataGenerator) generate(cfg *config.Config, release string, marshal func() ([]byte, error)) ([]byte, []config.GeneratedKey, error) {
 	// Validate configuration
 	if err := gen.validateConfig(cfg); err != nil {
-		return nil, fmt.Errorf("config validation failed: %v", err)
+		return nil, nil, fmt.Errorf("config validation failed: %w", err)
+	}
+
+	// Subiquity of the release must have the storage layout
+	if err := cfg.CheckRelease(release).Err(); err != nil {
+		return nil, nil, fmt.Errorf("config validation failed: %w", err)
+	}
+
+	// Replace the storage layout shorthand with the actions of its preset
+	if err := cfg.Autoinstall.Storage.ExpandLayout(); err != nil {
+		return nil, nil, fmt.Errorf("failed to expand storage layout: %w", err)
 	}
 
-	// Ensure identity.password is hashed with SHA-512 crypt ($6$...)
-	if cfg.Autoinstall.Identity.Password != "" && !utils.IsSHA512Crypt(cfg.Autoinstall.Identity.Password) {
-		hashed, err := utils.HashSHA512Crypt(cfg.Autoinstall.Identity.Password)
-		if err != nil {
-			return nil, fmt.Errorf("failed to hash password: %v", err)
+	// Generate, or prompt for, the dm_crypt keys that are not in the user-data
+	keys, err := cfg.Autoinstall.ResolveStorageKeys()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Ensure a plaintext identity.password is hashed with SHA-512 crypt ($6$...)
+	if err := hashPassword(&cfg.Autoinstall.Identity.Password); err != nil {
+		return nil, nil, err
+	}
+
+	// Write the accounts into the user-data, with hashed passwords
+	if accounts := cfg.Autoinstall.Accounts; accounts != nil {
+		for i := range accounts.Users {
+			if err := hashPassword(&accounts.Users[i].Password); err != nil {
+				return nil, nil, fmt.Errorf("user %s: %w", accounts.Users[i].Name, err)
+			}
 		}
-		cfg.Autoinstall.Identity.Password = hashed
 	}
+	cfg.Autoinstall.ExpandAccounts()
+
+	// Lock the identity password if the policy says so, the policy itself is not part of the user-data
+	cfg.Autoinstall.ApplyPasswordPolicy()
+
+	// Keep the identity user when the user-data has its own users
+	cfg.Autoinstall.MergeIdentity()
 
 	// Generate user-data YAML content
-	userData, err := gen.generateUserData(cfg)
+	userData, err := gen.generateUserData(marshal)
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate user-data: %v", err)
+		return nil, nil, fmt.Errorf("failed to generate user-data: %v", err)
 	}
 
-	return userData, nil
+	if err := config.ValidateUserDataSchema(userData, release); err != nil {
+		return nil, nil, fmt.Errorf("schema validation failed: %w", err)
+	}
+
+	return userData, keys, nil
+}
+
+// hashPassword replaces a plaintext password with its SHA-512 crypt hash.
+// Crypt hashes of any supported algorithm are kept, and values that only look
+// like a hash are rejected rather than hashed again.
+func hashPassword(password *string) error {
+	switch {
+	case *password == "" || utils.IsCryptHash(*password):
+		return nil
+	case utils.LooksLikeCryptHash(*password):
+		return fmt.Errorf("password is neither a complete $1$, $5$, $6$ or $y$ crypt hash nor plaintext")
+	}
+	hashed, err := utils.HashSHA512Crypt(*password)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %v", err)
+	}
+	*password = hashed
+	return nil
 }
 
 // validateConfig validates the provided config.
@@ -53,16 +146,17 @@ func (gen *UserDataGenerator) validateConfig(cfg *config.Config) error {
 }
 
 // generateUserData marshals the config into YAML with #cloud-config header.
-func (gen *UserDataGenerator) generateUserData(cfg *config.Config) ([]byte, error) {
-	// Serialize config directly to avoid omitempty surprises via interface{}
-	data, err := yaml.Marshal(cfg)
+func (gen *UserDataGenerator) generateUserData(marshal func() ([]byte, error)) ([]byte, error) {
+	data, err := marshal()
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal YAML: %v", err)
 	}
 
-	// Prepend required cloud-config header
-	result := append([]byte("#cloud-config\n"), data...)
-	return result, nil
+	// Prepend required cloud-config header, documents may have it already
+	if bytes.HasPrefix(data, []byte(cloudConfigHeader)) {
+		return data, nil
+	}
+	return append([]byte(cloudConfigHeader), data...), nil
 }
 
 // ValidateUserData checks that user-data YAML is syntactically valid and contains required fields.
@@ -0,0 +1,193 @@
+package iso9660
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// El Torito platform IDs.
+const (
+	PlatformX86 = 0x00
+	PlatformPPC = 0x01
+	PlatformMac = 0x02
+	PlatformEFI = 0xEF
+)
+
+// El Torito boot media types.
+const (
+	MediaNoEmulation = 0
+	MediaFloppy12    = 1
+	MediaFloppy144   = 2
+	MediaFloppy288   = 3
+	MediaHardDisk    = 4
+)
+
+// virtualSectorSize is the unit of the El Torito sector count.
+const virtualSectorSize = 512
+
+// BootEntry is an entry of the El Torito boot catalog.
+type BootEntry struct {
+	Index       int // 1-based position in the catalog
+	Platform    byte
+	Bootable    bool
+	MediaType   byte
+	LoadSegment uint16
+	SystemType  byte
+	SectorCount uint16 // Number of 512-byte sectors loaded by the firmware
+	LoadRBA     uint32 // Start of the boot image in 2048-byte blocks
+	Size        int64  // Size of the boot image in bytes
+}
+
+// Offset returns the byte offset of the boot image in the ISO image.
+func (e *BootEntry) Offset() int64 {
+	return int64(e.LoadRBA) * SectorSize
+}
+
+// FileName returns the name 7-Zip uses for the image, e.g. "2-Boot-NoEmul.img".
+func (e *BootEntry) FileName() string {
+	media := "NoEmul"
+	switch e.MediaType {
+	case MediaFloppy12:
+		media = "1.2M"
+	case MediaFloppy144:
+		media = "1.44M"
+	case MediaFloppy288:
+		media = "2.88M"
+	case MediaHardDisk:
+		media = "HardDisk"
+	}
+	return fmt.Sprintf("%d-Boot-%s.img", e.Index, media)
+}
+
+// BootCatalogOffset returns the byte offset of the El Torito boot catalog, or -1.
+func (img *Image) BootCatalogOffset() int64 {
+	return img.bootCatalog
+}
+
+// BootEntries returns the entries of the El Torito boot catalog, or nil if the
+// image is not bootable.
+func (img *Image) BootEntries() ([]BootEntry, error) {
+	if img.bootCatalog < 0 {
+		return nil, nil
+	}
+	catalog := make([]byte, SectorSize)
+	if _, err := img.r.ReadAt(catalog, img.bootCatalog); err != nil {
+		return nil, fmt.Errorf("failed to read boot catalog: %w", err)
+	}
+	if catalog[0] != 0x01 || catalog[30] != 0x55 || catalog[31] != 0xAA {
+		return nil, fmt.Errorf("invalid boot catalog validation entry")
+	}
+
+	partitions, err := img.Partitions()
+	if err != nil {
+		return nil, err
+	}
+
+	platform := catalog[1]
+	var entries []BootEntry
+	add := func(b []byte) {
+		e := BootEntry{
+			Index:       len(entries) + 1,
+			Platform:    platform,
+			Bootable:    b[0] == 0x88,
+			MediaType:   b[1] & 0x0F,
+			LoadSegment: binary.LittleEndian.Uint16(b[2:4]),
+			SystemType:  b[4],
+			SectorCount: binary.LittleEndian.Uint16(b[6:8]),
+			LoadRBA:     binary.LittleEndian.Uint32(b[8:12]),
+		}
+		e.Size = img.bootImageSize(&e, partitions)
+		entries = append(entries, e)
+	}
+
+	// Initial/default entry, then sections of further entries.
+	add(catalog[32:64])
+	for pos := 64; pos+32 <= len(catalog); {
+		header := catalog[pos]
+		if header != 0x90 && header != 0x91 {
+			break
+		}
+		platform = catalog[pos+1]
+		count := int(binary.LittleEndian.Uint16(catalog[pos+2 : pos+4]))
+		pos += 32
+		for i := 0; i < count && pos+32 <= len(catalog); i++ {
+			// Section entry extensions (0x44) carry no image of their own.
+			if catalog[pos] == 0x44 {
+				i--
+				pos += 32
+				continue
+			}
+			add(catalog[pos : pos+32])
+			pos += 32
+		}
+		if header == 0x91 {
+			break
+		}
+	}
+	return entries, nil
+}
+
+// bootImageSize determines how many bytes belong to a boot image. Emulated
+// floppies have fixed sizes. For no-emulation images the sector count is used,
+// unless the image is the start of a partition (e.g. the EFI partition appended
+// to hybrid images), whose full size is taken instead.
+func (img *Image) bootImageSize(e *BootEntry, partitions []Partition) int64 {
+	var size int64
+	switch e.MediaType {
+	case MediaFloppy12:
+		size = 1200 << 10
+	case MediaFloppy144:
+		size = 1440 << 10
+	case MediaFloppy288:
+		size = 2880 << 10
+	default:
+		size = int64(e.SectorCount) * virtualSectorSize
+		for _, p := range partitions {
+			if p.Start == e.Offset() && p.Size > size {
+				size = p.Size
+			}
+		}
+	}
+	if end := img.size - e.Offset(); size > end {
+		size = end
+	}
+	if size < 0 {
+		size = 0
+	}
+	return size
+}
+
+// ExtractBootImages writes every boot image into dir using 7-Zip's file names,
+// the layout the [BOOT] directory of `7z x` has, and returns the written paths.
+func (img *Image) ExtractBootImages(dir string) ([]string, error) {
+	entries, err := img.BootEntries()
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, e := range entries {
+		target := filepath.Join(dir, e.FileName())
+		out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+		if err != nil {
+			return nil, err
+		}
+		_, err = io.Copy(out, io.NewSectionReader(img.r, e.Offset(), e.Size))
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to extract boot image %s: %w", e.FileName(), err)
+		}
+		files = append(files, target)
+	}
+	return files, nil
+}
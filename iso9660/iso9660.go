@@ -0,0 +1,687 @@
+// Package iso9660 reads ISO9660 images, including the Joliet and Rock Ridge
+// extensions, El Torito boot catalogs and the partition tables of hybrid
+// images, so that installer ISOs can be unpacked without external tools.
+package iso9660
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// SectorSize is the logical block size of ISO9660 images.
+const SectorSize = 2048
+
+const (
+	systemAreaSectors = 16
+	maxDescriptors    = 64
+	maxDirDepth       = 64
+
+	descriptorBoot          = 0
+	descriptorPrimary       = 1
+	descriptorSupplementary = 2
+	descriptorTerminator    = 255
+
+	flagDirectory  = 0x02
+	flagMultiExtnt = 0x80
+)
+
+// ErrNotExist is returned when a path does not exist in the image.
+var ErrNotExist = errors.New("file does not exist in image")
+
+// extent is a contiguous run of file data.
+type extent struct {
+	offset int64
+	size   int64
+}
+
+// File describes a file, directory or symbolic link in the image.
+type File struct {
+	Name    string
+	Mode    os.FileMode
+	Size    int64
+	ModTime time.Time
+	Link    string // Symbolic link target (Rock Ridge only)
+
+	extents []extent
+}
+
+// Offset returns the byte offset of the file data in the image.
+func (f *File) Offset() int64 {
+	if len(f.extents) == 0 {
+		return -1
+	}
+	return f.extents[0].offset
+}
+
+// IsDir reports whether f is a directory.
+func (f *File) IsDir() bool {
+	return f.Mode.IsDir()
+}
+
+// Image is an opened ISO9660 image.
+type Image struct {
+	r      io.ReaderAt
+	closer io.Closer
+	size   int64
+
+	volumeID    string
+	volumeSize  int64
+	root        dirRecord
+	joliet      bool
+	hasJoliet   bool
+	rockRidge   bool
+	suspSkip    int
+	bootCatalog int64 // Byte offset of the El Torito boot catalog, or -1
+}
+
+// Open opens the ISO image at name.
+func Open(name string) (*Image, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	img, err := NewImage(f, info.Size())
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	img.closer = f
+	return img, nil
+}
+
+// NewImage reads the volume descriptors of an image of the given size from r.
+func NewImage(r io.ReaderAt, size int64) (*Image, error) {
+	img := &Image{r: r, size: size, bootCatalog: -1}
+	var primary, joliet *dirRecord
+	buf := make([]byte, SectorSize)
+
+	for i := 0; i < maxDescriptors; i++ {
+		if _, err := r.ReadAt(buf, int64(systemAreaSectors+i)*SectorSize); err != nil {
+			return nil, fmt.Errorf("failed to read volume descriptor: %w", err)
+		}
+		if string(buf[1:6]) != "CD001" {
+			return nil, fmt.Errorf("not an ISO9660 image")
+		}
+		switch buf[0] {
+		case descriptorBoot:
+			if strings.HasPrefix(string(buf[7:39]), "EL TORITO SPECIFICATION") {
+				img.bootCatalog = int64(binary.LittleEndian.Uint32(buf[71:75])) * SectorSize
+			}
+		case descriptorPrimary:
+			rec, err := parseDirRecord(buf[156:190])
+			if err != nil {
+				return nil, fmt.Errorf("invalid root directory record: %w", err)
+			}
+			primary = rec
+			img.volumeID = strings.TrimSpace(string(buf[40:72]))
+			img.volumeSize = int64(binary.LittleEndian.Uint32(buf[80:84])) * SectorSize
+		case descriptorSupplementary:
+			// Joliet is identified by its UCS-2 escape sequence.
+			esc := string(buf[88:91])
+			if esc == "%/@" || esc == "%/C" || esc == "%/E" {
+				if rec, err := parseDirRecord(buf[156:190]); err == nil {
+					joliet = rec
+				}
+			}
+		case descriptorTerminator:
+			i = maxDescriptors
+		}
+	}
+	if primary == nil {
+		return nil, fmt.Errorf("primary volume descriptor not found")
+	}
+
+	img.root = *primary
+	if err := img.detectRockRidge(); err != nil {
+		return nil, err
+	}
+	img.hasJoliet = joliet != nil
+	if !img.rockRidge && joliet != nil {
+		img.root = *joliet
+		img.joliet = true
+	}
+	return img, nil
+}
+
+// Close closes the underlying file if the image was opened with Open.
+func (img *Image) Close() error {
+	if img.closer != nil {
+		return img.closer.Close()
+	}
+	return nil
+}
+
+// VolumeID returns the volume label.
+func (img *Image) VolumeID() string { return img.volumeID }
+
+// VolumeSize returns the size of the ISO9660 file system in bytes. Partitions
+// appended to a hybrid image start at or after this offset.
+func (img *Image) VolumeSize() int64 { return img.volumeSize }
+
+// Size returns the size of the whole image in bytes.
+func (img *Image) Size() int64 { return img.size }
+
+// RockRidge reports whether names and attributes come from Rock Ridge entries.
+func (img *Image) RockRidge() bool { return img.rockRidge }
+
+// Joliet reports whether names come from the Joliet directory tree.
+func (img *Image) Joliet() bool { return img.joliet }
+
+// HasJoliet reports whether the image carries a Joliet directory tree.
+func (img *Image) HasJoliet() bool { return img.hasJoliet }
+
+// ReadAt reads raw bytes of the image at the given offset.
+func (img *Image) ReadAt(p []byte, off int64) (int, error) { return img.r.ReadAt(p, off) }
+
+// dirRecord is a parsed ISO9660 directory record.
+type dirRecord struct {
+	extent    int64
+	size      int64
+	flags     byte
+	name      []byte
+	recorded  time.Time
+	systemUse []byte
+}
+
+// parseDirRecord parses the directory record at the start of b.
+func parseDirRecord(b []byte) (*dirRecord, error) {
+	if len(b) < 34 || int(b[0]) > len(b) || b[0] < 34 {
+		return nil, fmt.Errorf("truncated directory record")
+	}
+	length := int(b[0])
+	nameLen := int(b[32])
+	if 33+nameLen > length {
+		return nil, fmt.Errorf("directory record name exceeds record length")
+	}
+	rec := &dirRecord{
+		extent:   int64(binary.LittleEndian.Uint32(b[2:6])) * SectorSize,
+		size:     int64(binary.LittleEndian.Uint32(b[10:14])),
+		flags:    b[25],
+		name:     b[33 : 33+nameLen],
+		recorded: recordingTime(b[18:25]),
+	}
+	suStart := 33 + nameLen
+	if nameLen%2 == 0 {
+		suStart++
+	}
+	if suStart < length {
+		rec.systemUse = b[suStart:length]
+	}
+	return rec, nil
+}
+
+// recordingTime decodes the 7-byte directory record timestamp.
+func recordingTime(b []byte) time.Time {
+	if b[0] == 0 && b[1] == 0 && b[2] == 0 {
+		return time.Time{}
+	}
+	zone := time.FixedZone("", int(int8(b[6]))*15*60)
+	return time.Date(1900+int(b[0]), time.Month(b[1]), int(b[2]), int(b[3]), int(b[4]), int(b[5]), 0, zone)
+}
+
+// readDir returns the records of the directory described by rec, without "." and "..".
+func (img *Image) readDir(rec *dirRecord) ([]*dirRecord, error) {
+	if rec.size > img.size || rec.extent+rec.size > img.size {
+		return nil, fmt.Errorf("directory extent beyond end of image")
+	}
+	data := make([]byte, rec.size)
+	if _, err := img.r.ReadAt(data, rec.extent); err != nil {
+		return nil, fmt.Errorf("failed to read directory: %w", err)
+	}
+	var records []*dirRecord
+	for pos := 0; pos < len(data); {
+		length := int(data[pos])
+		if length == 0 {
+			// Records never span sectors; skip the padding up to the next one.
+			pos = (pos/SectorSize + 1) * SectorSize
+			continue
+		}
+		if pos+length > len(data) {
+			return nil, fmt.Errorf("directory record exceeds directory extent")
+		}
+		r, err := parseDirRecord(data[pos : pos+length])
+		if err != nil {
+			return nil, err
+		}
+		pos += length
+		if len(r.name) == 1 && (r.name[0] == 0 || r.name[0] == 1) {
+			continue
+		}
+		records = append(records, r)
+	}
+	return records, nil
+}
+
+// detectRockRidge checks the root "." entry for the SUSP "SP" indicator.
+func (img *Image) detectRockRidge() error {
+	buf := make([]byte, 255)
+	if _, err := img.r.ReadAt(buf, img.root.extent); err != nil {
+		return fmt.Errorf("failed to read root directory: %w", err)
+	}
+	dot, err := parseDirRecord(buf)
+	if err != nil {
+		return fmt.Errorf("invalid root directory: %w", err)
+	}
+	su := dot.systemUse
+	if len(su) >= 7 && string(su[0:2]) == "SP" && su[4] == 0xBE && su[5] == 0xEF {
+		img.suspSkip = int(su[6])
+		// SUSP alone is not Rock Ridge; look for an RR entry anywhere in "." .
+		entries, err := img.suspEntries(su)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			switch e.sig {
+			case "RR", "PX", "NM", "ER":
+				img.rockRidge = true
+			}
+		}
+	}
+	return nil
+}
+
+// suspEntry is one System Use Sharing Protocol entry.
+type suspEntry struct {
+	sig  string
+	data []byte
+}
+
+// suspEntries parses a system use field, following continuation areas.
+func (img *Image) suspEntries(su []byte) ([]suspEntry, error) {
+	var entries []suspEntry
+	for hops := 0; hops < 32 && su != nil; hops++ {
+		next := []byte(nil)
+		for len(su) >= 4 {
+			length := int(su[2])
+			if length < 4 || length > len(su) {
+				break
+			}
+			e := suspEntry{sig: string(su[0:2]), data: su[4:length]}
+			su = su[length:]
+			switch e.sig {
+			case "ST":
+				su = nil
+			case "CE":
+				if len(e.data) < 24 {
+					continue
+				}
+				block := int64(binary.LittleEndian.Uint32(e.data[0:4]))
+				offset := int64(binary.LittleEndian.Uint32(e.data[8:12]))
+				size := int64(binary.LittleEndian.Uint32(e.data[16:20]))
+				if size > SectorSize*4 {
+					return nil, fmt.Errorf("continuation area too large")
+				}
+				next = make([]byte, size)
+				if _, err := img.r.ReadAt(next, block*SectorSize+offset); err != nil {
+					return nil, fmt.Errorf("failed to read continuation area: %w", err)
+				}
+			default:
+				entries = append(entries, e)
+			}
+		}
+		su = next
+	}
+	return entries, nil
+}
+
+// entry is a directory record resolved into a File.
+type entry struct {
+	file *File
+	dir  *dirRecord // Directory contents, for directories
+}
+
+// resolve turns the raw records of a directory into files, applying Rock Ridge
+// or Joliet naming and merging multi-extent files.
+func (img *Image) resolve(records []*dirRecord) ([]entry, error) {
+	var entries []entry
+	for i := 0; i < len(records); i++ {
+		rec := records[i]
+		f := &File{
+			Name:    img.plainName(rec.name),
+			ModTime: rec.recorded,
+			Mode:    0444,
+		}
+		dir := rec
+		if rec.flags&flagDirectory != 0 {
+			f.Mode = os.ModeDir | 0555
+		}
+
+		if img.rockRidge {
+			su := rec.systemUse
+			if img.suspSkip > 0 && len(su) >= img.suspSkip {
+				su = su[img.suspSkip:]
+			}
+			rr, err := img.suspEntries(su)
+			if err != nil {
+				return nil, err
+			}
+			skip := false
+			var name []byte
+			var link symlinkBuilder
+			for _, e := range rr {
+				switch e.sig {
+				case "NM":
+					if len(e.data) >= 1 && e.data[0]&0x06 == 0 {
+						name = append(name, e.data[1:]...)
+					}
+				case "PX":
+					if len(e.data) >= 4 {
+						f.Mode = unixMode(binary.LittleEndian.Uint32(e.data[0:4]))
+					}
+				case "SL":
+					link.add(e.data)
+				case "CL":
+					// Deep directory relocated by mkisofs; its contents live elsewhere.
+					if len(e.data) >= 4 {
+						loc := int64(binary.LittleEndian.Uint32(e.data[0:4])) * SectorSize
+						target, err := img.readDotRecord(loc)
+						if err != nil {
+							return nil, err
+						}
+						dir = target
+						f.Mode = os.ModeDir | f.Mode.Perm()
+					}
+				case "RE":
+					skip = true
+				}
+			}
+			if skip {
+				continue
+			}
+			if len(name) > 0 {
+				f.Name = string(name)
+			}
+			if f.Mode&os.ModeSymlink != 0 {
+				f.Link = link.String()
+			}
+		}
+
+		if f.Name == "" || f.Name == "." || f.Name == ".." || strings.ContainsAny(f.Name, "/\x00") {
+			return nil, fmt.Errorf("invalid file name %q in image", f.Name)
+		}
+
+		f.extents = append(f.extents, extent{offset: rec.extent, size: rec.size})
+		f.Size = rec.size
+		// Files larger than 4 GiB are split into records flagged as multi-extent.
+		for rec.flags&flagMultiExtnt != 0 && i+1 < len(records) {
+			i++
+			rec = records[i]
+			f.extents = append(f.extents, extent{offset: rec.extent, size: rec.size})
+			f.Size += rec.size
+		}
+
+		if f.IsDir() {
+			f.Size = 0
+			entries = append(entries, entry{file: f, dir: dir})
+		} else {
+			entries = append(entries, entry{file: f})
+		}
+	}
+	return entries, nil
+}
+
+// readDotRecord reads the "." record of the directory at offset.
+func (img *Image) readDotRecord(offset int64) (*dirRecord, error) {
+	buf := make([]byte, 255)
+	if _, err := img.r.ReadAt(buf, offset); err != nil {
+		return nil, fmt.Errorf("failed to read relocated directory: %w", err)
+	}
+	return parseDirRecord(buf)
+}
+
+// plainName decodes an ISO9660 or Joliet file identifier.
+func (img *Image) plainName(b []byte) string {
+	var name string
+	if img.joliet {
+		u := make([]uint16, len(b)/2)
+		for i := range u {
+			u[i] = binary.BigEndian.Uint16(b[2*i:])
+		}
+		name = string(utf16.Decode(u))
+	} else {
+		name = string(b)
+	}
+	if i := strings.LastIndexByte(name, ';'); i >= 0 {
+		name = name[:i]
+	}
+	return strings.TrimSuffix(name, ".")
+}
+
+// unixMode converts a POSIX st_mode into an os.FileMode.
+func unixMode(m uint32) os.FileMode {
+	mode := os.FileMode(m & 0777)
+	switch m & 0170000 {
+	case 0040000:
+		mode |= os.ModeDir
+	case 0120000:
+		mode |= os.ModeSymlink
+	}
+	if m&04000 != 0 {
+		mode |= os.ModeSetuid
+	}
+	if m&02000 != 0 {
+		mode |= os.ModeSetgid
+	}
+	if m&01000 != 0 {
+		mode |= os.ModeSticky
+	}
+	return mode
+}
+
+// symlinkBuilder assembles a link target from Rock Ridge SL entries, which
+// may be split over several entries and continuation areas.
+type symlinkBuilder struct {
+	parts []string
+	cont  bool // The last component continues in the next one
+}
+
+// add decodes the components of one SL entry.
+func (s *symlinkBuilder) add(data []byte) {
+	if len(data) < 1 {
+		return
+	}
+	comps := data[1:]
+	for len(comps) >= 2 {
+		flags, n := comps[0], int(comps[1])
+		if 2+n > len(comps) {
+			return
+		}
+		content := string(comps[2 : 2+n])
+		comps = comps[2+n:]
+		switch {
+		case flags&0x02 != 0:
+			content = "."
+		case flags&0x04 != 0:
+			content = ".."
+		case flags&0x08 != 0:
+			// Root: an empty first part makes the joined target absolute.
+			s.parts = []string{""}
+			s.cont = false
+			continue
+		}
+		if s.cont && len(s.parts) > 0 {
+			s.parts[len(s.parts)-1] += content
+		} else {
+			s.parts = append(s.parts, content)
+		}
+		s.cont = flags&0x01 != 0
+	}
+}
+
+// String returns the link target.
+func (s *symlinkBuilder) String() string {
+	if len(s.parts) == 1 && s.parts[0] == "" {
+		return "/"
+	}
+	return strings.Join(s.parts, "/")
+}
+
+// WalkFunc is called for every file visited by Walk with its slash-separated path.
+type WalkFunc func(name string, f *File) error
+
+// Walk visits every file in the image in directory order, parents before children.
+func (img *Image) Walk(fn WalkFunc) error {
+	return img.walk(&img.root, "", fn, 0)
+}
+
+// walk visits the entries of one directory recursively.
+func (img *Image) walk(rec *dirRecord, prefix string, fn WalkFunc, depth int) error {
+	if depth > maxDirDepth {
+		return fmt.Errorf("directory nesting too deep at %s", prefix)
+	}
+	records, err := img.readDir(rec)
+	if err != nil {
+		return fmt.Errorf("%s: %w", "/"+prefix, err)
+	}
+	entries, err := img.resolve(records)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		name := path.Join(prefix, e.file.Name)
+		if err := fn(name, e.file); err != nil {
+			return err
+		}
+		if e.dir != nil {
+			if err := img.walk(e.dir, name, fn, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Stat returns the file at the slash-separated path name.
+func (img *Image) Stat(name string) (*File, error) {
+	parts := strings.Split(strings.Trim(path.Clean("/"+name), "/"), "/")
+	dir := &img.root
+	if parts[0] == "" {
+		return &File{Name: "/", Mode: os.ModeDir | 0555}, nil
+	}
+	for i, part := range parts {
+		records, err := img.readDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		entries, err := img.resolve(records)
+		if err != nil {
+			return nil, err
+		}
+		var found *entry
+		for j := range entries {
+			if entries[j].file.Name == part {
+				found = &entries[j]
+				break
+			}
+		}
+		if found == nil {
+			return nil, fmt.Errorf("%s: %w", name, ErrNotExist)
+		}
+		if i == len(parts)-1 {
+			return found.file, nil
+		}
+		if found.dir == nil {
+			return nil, fmt.Errorf("%s: %w", name, ErrNotExist)
+		}
+		dir = found.dir
+	}
+	return nil, fmt.Errorf("%s: %w", name, ErrNotExist)
+}
+
+// Reader returns a reader for the contents of a regular file.
+func (img *Image) Reader(f *File) io.Reader {
+	readers := make([]io.Reader, 0, len(f.extents))
+	for _, e := range f.extents {
+		readers = append(readers, io.NewSectionReader(img.r, e.offset, e.size))
+	}
+	return io.MultiReader(readers...)
+}
+
+// ReadFile returns the contents of the file at the slash-separated path name.
+func (img *Image) ReadFile(name string) ([]byte, error) {
+	f, err := img.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if !f.Mode.IsRegular() {
+		return nil, fmt.Errorf("%s is not a regular file", name)
+	}
+	return io.ReadAll(img.Reader(f))
+}
+
+// Extract writes the whole directory tree into dest. Files are created
+// writable by the owner so that the tree can be modified afterwards.
+// Cancelling ctx stops the extraction between files.
+func (img *Image) Extract(ctx context.Context, dest string) error {
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return err
+	}
+	var dirs []string
+	var times []time.Time
+	err := img.Walk(func(name string, f *File) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		target := filepath.Join(dest, filepath.FromSlash(name))
+		// Never write through an existing symlink, e.g. one of a duplicate entry.
+		if info, err := os.Lstat(target); err == nil && (!f.IsDir() || !info.IsDir()) {
+			return fmt.Errorf("refusing to overwrite existing %s", target)
+		}
+		switch {
+		case f.IsDir():
+			if err := os.Mkdir(target, 0755); err != nil && !os.IsExist(err) {
+				return err
+			}
+			dirs = append(dirs, target)
+			times = append(times, f.ModTime)
+			return os.Chmod(target, f.Mode.Perm()|0700)
+		case f.Mode&os.ModeSymlink != 0:
+			return os.Symlink(f.Link, target)
+		default:
+			return img.extractFile(f, target)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	// Directory times change while their contents are written, so set them last.
+	for i, dir := range dirs {
+		if !times[i].IsZero() {
+			_ = os.Chtimes(dir, times[i], times[i])
+		}
+	}
+	return nil
+}
+
+// extractFile copies one regular file out of the image.
+func (img *Image) extractFile(f *File, target string) error {
+	out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.Mode.Perm()|0200)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, img.Reader(f)); err != nil {
+		out.Close()
+		return fmt.Errorf("failed to extract %s: %w", target, err)
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if !f.ModTime.IsZero() {
+		_ = os.Chtimes(target, f.ModTime, f.ModTime)
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package iso9660
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testdata/rockridge.iso.gz was created with
+//
+//	bsdtar --format iso9660 --options 'iso9660:rockridge,joliet,volume-id=TESTVOL,boot=isolinux/isolinux.bin,boot-type=no-emulation,boot-load-size=4'
+//
+// from a tree with a deep directory (relocated by Rock Ridge), a symlink and a
+// long file name.
+func loadFixture(t *testing.T) []byte {
+	t.Helper()
+	f, err := os.Open(filepath.Join("testdata", "rockridge.iso.gz"))
+	require.NoError(t, err)
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	require.NoError(t, err)
+	data, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	return data
+}
+
+// Test the Rock Ridge tree is listed with long names, symlinks and relocated directories.
+func TestImage_Walk(t *testing.T) {
+	data := loadFixture(t)
+	img, err := NewImage(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+
+	assert.Equal(t, "TESTVOL", img.VolumeID())
+	assert.True(t, img.RockRidge())
+
+	files := map[string]*File{}
+	require.NoError(t, img.Walk(func(name string, f *File) error {
+		files[name] = f
+		return nil
+	}))
+	require.Contains(t, files, "Long File Name with spaces.txt")
+	require.Contains(t, files, "a/b/c/d/e/f/g/h/i/deep.txt")
+	require.Contains(t, files, "vmlinuz-link")
+	assert.Equal(t, "casper/vmlinuz", files["vmlinuz-link"].Link)
+	assert.True(t, files["boot/grub"].IsDir())
+	assert.Equal(t, int64(2048), files["isolinux/isolinux.bin"].Size)
+
+	grub, err := img.ReadFile("/boot/grub/grub.cfg")
+	require.NoError(t, err)
+	assert.Contains(t, string(grub), "menuentry")
+
+	_, err = img.Stat("boot/missing.cfg")
+	assert.ErrorIs(t, err, ErrNotExist)
+}
+
+// Test the tree and boot images are extracted like `7z x` does.
+func TestImage_Extract(t *testing.T) {
+	data := loadFixture(t)
+	img, err := NewImage(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+
+	dest := t.TempDir()
+	require.NoError(t, img.Extract(context.Background(), dest))
+
+	content, err := os.ReadFile(filepath.Join(dest, "a/b/c/d/e/f/g/h/i/deep.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "deep\n", string(content))
+	link, err := os.Readlink(filepath.Join(dest, "vmlinuz-link"))
+	require.NoError(t, err)
+	assert.Equal(t, "casper/vmlinuz", link)
+	info, err := os.Stat(filepath.Join(dest, "casper/vmlinuz"))
+	require.NoError(t, err)
+	assert.NotZero(t, info.Mode().Perm()&0200, "extracted files must be writable")
+
+	files, err := img.ExtractBootImages(filepath.Join(dest, "[BOOT]"))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	assert.Equal(t, "1-Boot-NoEmul.img", filepath.Base(files[0]))
+	boot, err := os.ReadFile(files[0])
+	require.NoError(t, err)
+	isolinux, err := os.ReadFile(filepath.Join(dest, "isolinux/isolinux.bin"))
+	require.NoError(t, err)
+	assert.Equal(t, isolinux, boot)
+}
+
+// Test extraction stops when the context is cancelled.
+func TestImage_ExtractCancelled(t *testing.T) {
+	data := loadFixture(t)
+	img, err := NewImage(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, img.Extract(ctx, t.TempDir()), context.Canceled)
+}
+
+// Test MBR and GPT entries of a hybrid image are read, and a boot image that
+// starts a partition is sized by that partition.
+func TestImage_Partitions(t *testing.T) {
+	data := loadFixture(t)
+	img, err := NewImage(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+	entries, err := img.BootEntries()
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	bootOffset := entries[0].Offset()
+
+	// MBR: partition 1 covers the ISO, partition 2 is an EFI partition at the boot image.
+	mbr := data[446:512]
+	putMBREntry(mbr[0:16], 0x80, 0x17, 0, uint32(len(data)/512))
+	putMBREntry(mbr[16:32], 0x00, 0xEF, uint32(bootOffset/512), 8)
+	data[510], data[511] = 0x55, 0xAA
+
+	// GPT header at LBA 1 with its entry array at LBA 2.
+	gpt := data[512:1024]
+	copy(gpt, "EFI PART")
+	binary.LittleEndian.PutUint64(gpt[72:80], 2)
+	binary.LittleEndian.PutUint32(gpt[80:84], 4)
+	binary.LittleEndian.PutUint32(gpt[84:88], 128)
+	efiType, _ := hex.DecodeString("28732ac11ff8d211ba4b00a0c93ec93b")
+	entry := data[1024 : 1024+128]
+	copy(entry[0:16], efiType)
+	entry[16] = 1
+	binary.LittleEndian.PutUint64(entry[32:40], uint64(bootOffset/512))
+	binary.LittleEndian.PutUint64(entry[40:48], uint64(bootOffset/512)+7)
+	copy(entry[56:], []byte{'E', 0, 'F', 0, 'I', 0})
+
+	img, err = NewImage(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+	partitions, err := img.Partitions()
+	require.NoError(t, err)
+	require.Len(t, partitions, 3)
+	assert.Equal(t, Partition{Scheme: SchemeMBR, Number: 1, Type: "17", Bootable: true, Start: 0, Size: int64(len(data))}, partitions[0])
+	assert.Equal(t, "ef", partitions[1].Type)
+	assert.Equal(t, SchemeGPT, partitions[2].Scheme)
+	assert.Equal(t, "28732ac11ff8d211ba4b00a0c93ec93b", partitions[2].Type)
+	assert.Equal(t, "EFI", partitions[2].Name)
+	assert.Equal(t, bootOffset, partitions[2].Start)
+
+	entries, err = img.BootEntries()
+	require.NoError(t, err)
+	assert.Equal(t, int64(4096), entries[0].Size)
+}
+
+// putMBREntry fills a 16-byte MBR partition entry.
+func putMBREntry(e []byte, status, typ byte, start, sectors uint32) {
+	e[0] = status
+	e[4] = typ
+	binary.LittleEndian.PutUint32(e[8:12], start)
+	binary.LittleEndian.PutUint32(e[12:16], sectors)
+}
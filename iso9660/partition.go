@@ -0,0 +1,139 @@
+package iso9660
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+)
+
+// Partition table schemes.
+const (
+	SchemeMBR = "mbr"
+	SchemeGPT = "gpt"
+)
+
+const (
+	mbrSectorSize  = 512
+	gptMaxEntries  = 256
+	mbrTypeGPTProt = 0xEE
+)
+
+// Partition is an entry of the MBR or GPT partition table of a hybrid image.
+type Partition struct {
+	Scheme   string
+	Number   int    // 1-based entry number in its table
+	Type     string // MBR type byte ("ef") or GPT type GUID as on-disk hex bytes, the form xorriso expects
+	GUID     string // GPT partition GUID, empty for MBR
+	Name     string // GPT partition name, empty for MBR
+	Bootable bool   // MBR active flag
+	Start    int64  // Byte offset in the image
+	Size     int64  // Size in bytes
+}
+
+// Partitions returns the MBR and GPT partition entries of the image. Plain ISO
+// images without a partition table yield no partitions.
+func (img *Image) Partitions() ([]Partition, error) {
+	if img.size < 2*mbrSectorSize {
+		return nil, nil
+	}
+	mbr := make([]byte, mbrSectorSize)
+	if _, err := img.r.ReadAt(mbr, 0); err != nil {
+		return nil, fmt.Errorf("failed to read MBR: %w", err)
+	}
+	if mbr[510] != 0x55 || mbr[511] != 0xAA {
+		return nil, nil
+	}
+
+	var partitions []Partition
+	hasGPT := false
+	for i := 0; i < 4; i++ {
+		e := mbr[446+16*i : 446+16*(i+1)]
+		typ := e[4]
+		sectors := int64(binary.LittleEndian.Uint32(e[12:16]))
+		if typ == 0 || sectors == 0 {
+			continue
+		}
+		if typ == mbrTypeGPTProt {
+			hasGPT = true
+		}
+		partitions = append(partitions, Partition{
+			Scheme:   SchemeMBR,
+			Number:   i + 1,
+			Type:     fmt.Sprintf("%02x", typ),
+			Bootable: e[0] == 0x80,
+			Start:    int64(binary.LittleEndian.Uint32(e[8:12])) * mbrSectorSize,
+			Size:     sectors * mbrSectorSize,
+		})
+	}
+
+	// Hybrid images may carry a GPT next to a regular MBR, so always look for one.
+	gpt, err := img.gptPartitions()
+	if err != nil {
+		if hasGPT {
+			return nil, err
+		}
+		return partitions, nil
+	}
+	return append(partitions, gpt...), nil
+}
+
+// gptPartitions reads the GPT whose header is at LBA 1.
+func (img *Image) gptPartitions() ([]Partition, error) {
+	header := make([]byte, mbrSectorSize)
+	if _, err := img.r.ReadAt(header, mbrSectorSize); err != nil {
+		return nil, fmt.Errorf("failed to read GPT header: %w", err)
+	}
+	if string(header[0:8]) != "EFI PART" {
+		return nil, fmt.Errorf("no GPT header")
+	}
+	entriesLBA := int64(binary.LittleEndian.Uint64(header[72:80]))
+	count := int(binary.LittleEndian.Uint32(header[80:84]))
+	entrySize := int(binary.LittleEndian.Uint32(header[84:88]))
+	if count > gptMaxEntries || entrySize < 128 || entrySize > 1024 {
+		return nil, fmt.Errorf("unsupported GPT layout")
+	}
+	table := make([]byte, count*entrySize)
+	if _, err := img.r.ReadAt(table, entriesLBA*mbrSectorSize); err != nil {
+		return nil, fmt.Errorf("failed to read GPT entries: %w", err)
+	}
+
+	var partitions []Partition
+	zero := make([]byte, 16)
+	for i := 0; i < count; i++ {
+		e := table[i*entrySize : (i+1)*entrySize]
+		if bytes.Equal(e[0:16], zero) {
+			continue
+		}
+		first := int64(binary.LittleEndian.Uint64(e[32:40]))
+		last := int64(binary.LittleEndian.Uint64(e[40:48]))
+		if last < first {
+			continue
+		}
+		partitions = append(partitions, Partition{
+			Scheme: SchemeGPT,
+			Number: i + 1,
+			Type:   hex.EncodeToString(e[0:16]),
+			GUID:   hex.EncodeToString(e[16:32]),
+			Name:   utf16Name(e[56:128]),
+			Start:  first * mbrSectorSize,
+			Size:   (last - first + 1) * mbrSectorSize,
+		})
+	}
+	return partitions, nil
+}
+
+// utf16Name decodes a NUL-padded UTF-16LE GPT partition name.
+func utf16Name(b []byte) string {
+	u := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		c := binary.LittleEndian.Uint16(b[i:])
+		if c == 0 {
+			break
+		}
+		u = append(u, c)
+	}
+	return strings.TrimSpace(string(utf16.Decode(u)))
+}
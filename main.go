@@ -2,18 +2,29 @@ package main
 
 import (
 	"flag"
+	"os"
+	"path/filepath"
+
 	"github.com/gin-gonic/gin"
 	"github.com/lefeck/ubuntu-autoinstaller/api"
+	"github.com/lefeck/ubuntu-autoinstaller/cli"
 	"github.com/lefeck/ubuntu-autoinstaller/logger"
 	"github.com/lefeck/ubuntu-autoinstaller/server"
 )
 
 func main() {
+	// Headless subcommands (e.g. `build`) run without the web server
+	if len(os.Args) > 1 && cli.IsCommand(os.Args[1]) {
+		os.Exit(cli.Run(os.Args[1:]))
+	}
+
 	port := flag.Int("p", 8080, "Port to run the web server on")
 	mode := flag.String("m", gin.ReleaseMode, "Mode to run the server in (debug, release, test)")
+	dataDir := flag.String("d", filepath.Join(os.TempDir(), "ubuntu-autoinstaller"), "Data directory for downloads, builds and the build registry")
+	workers := flag.Int("w", api.DefaultBuildWorkers, "Number of ISO builds to run in parallel")
 	flag.Parse()
 
-	handler := api.NewHandler()
+	handler := api.NewHandler(*dataDir, *workers)
 	cfg := &server.ConfigInfo{
 		Mode: *mode,
 		Port: *port,
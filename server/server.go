@@ -70,6 +70,13 @@ func (s *Server) Routes() {
 	api.POST("/config/load", s.handler.LoadConfigFromYAML)
 	api.POST("/config/validate", s.handler.ValidateConfig)
 
+	// Storage endpoints
+	api.POST("/storage/simulate", s.handler.SimulateStorage)
+	api.GET("/storage/presets", s.handler.GetStoragePresets)
+
+	// Identity endpoints
+	api.POST("/identity/verify", s.handler.VerifyPassword)
+
 	// user-data endpoints
 	api.POST("/userdata/generate", s.handler.GenerateUserData)
 	api.POST("/userdata/preview", s.handler.PreviewUserData)
@@ -79,9 +86,12 @@ func (s *Server) Routes() {
 	api.POST("/iso/generate", s.handler.GenerateISO)
 
 	// Build status endpoints
+	api.GET("/build/list", s.handler.ListBuilds)
 	api.GET("/build/status/:id", s.handler.GetBuildStatus)
 	api.GET("/build/logs/:id", s.handler.GetBuildLogs)
+	api.GET("/build/events/:id", s.handler.StreamBuildEvents)
 	api.GET("/build/download/:id", s.handler.DownloadISO)
+	api.DELETE("/build/:id", s.handler.CancelBuild)
 }
 
 // Run starts the HTTP server.
@@ -451,11 +451,17 @@ function collectDMCryptConfig(storage, configItem) {
     const volume = storage.querySelector('.storage-volume-input')?.value;
     const dmName = storage.querySelector('.storage-dm-name-input')?.value;
     const key = storage.querySelector('.storage-key-input')?.value;
+    const keyFile = storage.querySelector('.storage-keyfile-input')?.value;
+    const keySource = storage.querySelector('.storage-key-source-input')?.value;
+    const keyUrl = storage.querySelector('.storage-key-url-input')?.value;
     const cipher = storage.querySelector('.storage-cipher-input')?.value;
 
     if (volume) configItem.volume = volume;
     if (dmName) configItem.dm_name = dmName;
     if (key) configItem.key = key;
+    if (keyFile) configItem.keyfile = keyFile;
+    if (keySource) configItem.key_source = keySource;
+    if (keyUrl) configItem.key_url = keyUrl;
     if (cipher) configItem.cipher = cipher;
 }
 
@@ -476,6 +482,8 @@ function convertSizeToBytes(size, unit) {
     const sizeValue = parseFloat(size);
     if (sizeValue === -1) {
         return -1;
+    } else if (unit === '%') {
+        return `${sizeValue}%`;
     } else if (sizeValue > 0) {
         let sizeInBytes = sizeValue;
         if (unit === 'K') sizeInBytes *= 1024;
@@ -978,6 +978,9 @@ function buildConfig() {
                             const sizeValue = parseFloat(lvSize);
                             if (sizeValue === -1) {
                                 configItem.size = -1;
+                            } else if (lvUnit === '%') {
+                                // Percentages of the volume group are passed through as "20%"
+                                configItem.size = `${sizeValue}%`;
                             } else if (sizeValue > 0) {
                                 // Convert to bytes for backend compatibility
                                 let sizeInBytes = sizeValue;
@@ -998,6 +1001,8 @@ function buildConfig() {
                         const dmName = storage.querySelector('.storage-dm-name-input')?.value;
                         const key = storage.querySelector('.storage-key-input')?.value;
                         const keyFile = storage.querySelector('.storage-keyfile-input')?.value;
+                        const keySource = storage.querySelector('.storage-key-source-input')?.value;
+                        const keyUrl = storage.querySelector('.storage-key-url-input')?.value;
                         const cryptPreserve = storage.querySelector('.storage-preserve-input')?.value;
                         const cryptWipe = storage.querySelector('.storage-wipe-input')?.value;
                         
@@ -1006,6 +1011,8 @@ function buildConfig() {
                         if (dmName) configItem.dm_name = dmName;
                         if (key) configItem.key = key;
                         if (keyFile) configItem.keyfile = keyFile;
+                        if (keySource) configItem.key_source = keySource;
+                        if (keyUrl) configItem.key_url = keyUrl;
                         if (cryptPreserve !== undefined) configItem.preserve = cryptPreserve === 'true';
                         if (cryptWipe) configItem.wipe = cryptWipe;
                         break;
@@ -1098,8 +1105,15 @@ async function validateConfig() {
         try {
             const result = await response.json();
             console.log('Response Body:', result);
-            showStatus('configStatus', result.success ? 'success' : 'error', result.message || result.error);
-            return { valid: !!result.success, errors: result.success ? [] : [result.message || result.error || 'Validation failed'] };
+            const problems = result.issues || result.violations || [];
+            highlightInvalidFields(problems.filter(p => p.severity !== 'warning'));
+            if (problems.length > 0) {
+                showStatus('configStatus', result.success ? 'warning' : 'error', formatIssues(result.success ? result.message : 'Config validation failed', problems));
+            } else {
+                showStatus('configStatus', result.success ? 'success' : 'error', result.message || result.error);
+            }
+            const errors = problems.filter(p => p.severity !== 'warning').map(p => `${p.path}: ${p.message}`);
+            return { valid: !!result.success, errors: result.success ? [] : (errors.length ? errors : [result.message || result.error || 'Validation failed']) };
         } catch (parseError) {
             console.error('Failed to parse response:', parseError);
             // If parsing fails, use local validation
@@ -1281,7 +1295,7 @@ async function previewUserData() {
             headers: {
                 'Content-Type': 'application/json'
             },
-            body: JSON.stringify({ config: config })
+            body: JSON.stringify({ config: config, source: document.getElementById('sourceDocument')?.value || '' })
         });
         
         console.log('API response status:', response.status);
@@ -1821,6 +1835,7 @@ async function generateISO() {
     const sourceType = document.querySelector('input[name="sourceType"]:checked').value;
     const sourceISO = document.getElementById('sourceISO')?.value || '';
     const codename = document.getElementById('codename').value;
+    const arch = document.getElementById('arch')?.value || 'amd64';
     const destinationISO = document.getElementById('destinationISO').value;
     const userData = document.getElementById('userDataContent').value;
     
@@ -1866,6 +1881,7 @@ async function generateISO() {
     // Get advanced options
     const useHWEKernel = document.getElementById('useHWEKernelCheckbox').checked;
     const md5Checksum = document.getElementById('md5ChecksumCheckbox').checked;
+    const mode = document.getElementById('overlayModeCheckbox')?.checked ? 'overlay' : 'full';
     
     // Start build process
     buildInProgress = true;
@@ -1883,12 +1899,14 @@ async function generateISO() {
             sourceType: sourceType,
             sourceISO: sourceISO,
             codeName: codename,
+            arch: arch,
             destinationISO: destinationISO,
             userData: userData,
             packageList: packageList,
             useHWEKernel: useHWEKernel,
             md5Checksum: md5Checksum,
-            gpgVerify: gpgVerify
+            gpgVerify: gpgVerify,
+            mode: mode
         };
         
         console.log('Build data:', data);
@@ -1924,8 +1942,12 @@ async function callGenerateISOAPI(data) {
         if (result.success && result.buildID) {
             addLog('info', `Build started with ID: ${result.buildID}`);
             
-            // Start polling for status updates
-            await pollBuildStatus(result.buildID);
+            // Follow the build live, polling only when the browser lacks SSE support
+            if (typeof EventSource !== 'undefined') {
+                followBuildEvents(result.buildID);
+            } else {
+                await pollBuildStatus(result.buildID);
+            }
             
         } else {
             throw new Error(result.error || 'Unknown error occurred');
@@ -1937,6 +1959,76 @@ async function callGenerateISOAPI(data) {
     }
 }
 
+/**
+ * Classify a build log line for display
+ */
+function buildLogLevel(logMessage) {
+    if (logMessage.includes('ERROR') || logMessage.includes('❌')) {
+        return 'error';
+    } else if (logMessage.includes('✅')) {
+        return 'success';
+    }
+    return 'info';
+}
+
+/**
+ * Follow build progress over Server-Sent Events and update UI.
+ * The browser reconnects automatically and resumes after the last received log line.
+ */
+function followBuildEvents(buildID) {
+    const source = new EventSource(`${API_BASE}/build/events/${buildID}`);
+    let finished = false;
+
+    source.addEventListener('log', (event) => {
+        const data = JSON.parse(event.data);
+        addLogToUI(data.output ? 'info' : buildLogLevel(data.line), data.line);
+    });
+
+    source.addEventListener('step', (event) => {
+        const data = JSON.parse(event.data);
+        updateStepsFromStatus({ [data.step]: data.state });
+    });
+
+    source.addEventListener('status', (event) => {
+        const status = JSON.parse(event.data);
+        document.getElementById('progressFill').style.width = `${status.progress}%`;
+
+        if (status.status === 'completed') {
+            finished = true;
+            source.close();
+            const completeStep = document.querySelector('[data-step="complete"]');
+            if (completeStep) {
+                completeStep.classList.remove('active');
+                completeStep.classList.add('completed');
+            }
+            currentBuildID = buildID; // Store build ID for download
+            showDownloadSection(buildID);
+            buildInProgress = false;
+        } else if (status.status === 'failed') {
+            finished = true;
+            source.close();
+            addLogToUI('error', `Build failed: ${status.error || 'Unknown error'}`);
+            buildInProgress = false;
+            restoreGenerateButton();
+        } else if (status.status === 'cancelled') {
+            finished = true;
+            source.close();
+            addLogToUI('warning', 'Build cancelled');
+            buildInProgress = false;
+            restoreGenerateButton();
+        }
+    });
+
+    source.onerror = () => {
+        // While the state is CONNECTING the browser retries on its own.
+        if (!finished && source.readyState === EventSource.CLOSED) {
+            addLog('error', 'Build event stream closed unexpectedly');
+            buildInProgress = false;
+            restoreGenerateButton();
+        }
+    };
+}
+
 /**
  * Poll build status and update UI
  */
@@ -1967,13 +2059,7 @@ async function pollBuildStatus(buildID) {
                     // Add new logs
                     for (let i = lastLogCount; i < logsResult.logs.length; i++) {
                         const logMessage = logsResult.logs[i];
-                        let logLevel = 'info';
-                        if (logMessage.includes('ERROR') || logMessage.includes('❌')) {
-                            logLevel = 'error';
-                        } else if (logMessage.includes('✅')) {
-                            logLevel = 'success';
-                        }
-                        addLogToUI(logLevel, logMessage);
+                        addLogToUI(buildLogLevel(logMessage), logMessage);
                     }
                     lastLogCount = logsResult.logs.length;
                 }
@@ -2000,6 +2086,11 @@ async function pollBuildStatus(buildID) {
                     buildInProgress = false;
                     restoreGenerateButton();
                     return; // Stop polling
+                } else if (status.status === 'cancelled') {
+                    addLogToUI('warning', 'Build cancelled');
+                    buildInProgress = false;
+                    restoreGenerateButton();
+                    return; // Stop polling
                 }
                 
                 // Continue polling if still running
@@ -2334,7 +2425,7 @@ async function generateUserData() {
             headers: {
                 'Content-Type': 'application/json'
             },
-            body: JSON.stringify({ config: config })
+            body: JSON.stringify({ config: config, source: document.getElementById('sourceDocument')?.value || '' })
         });
         
         // Keep consistent with original version: don't check response.ok, directly try to parse JSON
@@ -2361,7 +2452,23 @@ async function generateUserData() {
                 previewElement.style.display = 'block';
             }
             
-            showStatus('userdataStatus', 'success', 'User data configuration generated successfully');
+            highlightInvalidFields([]);
+            if (result.keys && result.keys.length > 0) {
+                // Generated dm_crypt keys are not in the user-data and are shown only once
+                const lines = result.keys.map(k => `dm_crypt ${k.id}: upload "${k.key}" to ${k.url}`);
+                showStatus('userdataStatus', 'warning', ['Store the generated encryption keys before installing, they are not shown again', ...lines].join('\n'));
+            } else if (result.warnings && result.warnings.length > 0) {
+                showStatus('userdataStatus', 'warning', formatIssues('User data configuration generated with warnings', result.warnings));
+            } else {
+                showStatus('userdataStatus', 'success', 'User data configuration generated successfully');
+            }
+        } else if (result.violations) {
+            highlightInvalidFields(result.violations);
+            const messages = result.violations.map(v => `${v.path}: ${v.message}`);
+            showStatus('userdataStatus', 'error', `User data does not match the ${result.release} autoinstall schema: ${messages.join('; ')}`);
+        } else if (result.issues) {
+            highlightInvalidFields(result.issues);
+            showStatus('userdataStatus', 'error', formatIssues('Config validation failed', result.issues));
         } else {
             showStatus('userdataStatus', 'error', result.error || 'User data generation failed');
         }
@@ -2395,6 +2502,7 @@ window.ConfigManager = {
     generateISO,
     callGenerateISOAPI,
     pollBuildStatus,
+    followBuildEvents,
     updateStepsFromStatus,
     addLog,
     addLogToUI,
@@ -2489,6 +2597,71 @@ function validateBasicRequired(statusId) {
     return true;
 }
 
+/**
+ * Format validation issues as one line each: severity, path, message and suggestion.
+ */
+function formatIssues(title, issues) {
+    const lines = issues.map(issue => {
+        const severity = issue.severity ? `[${issue.severity}] ` : '';
+        const suggestion = issue.suggestion ? ` (${issue.suggestion})` : '';
+        return `${severity}${issue.path}: ${issue.message}${suggestion}`;
+    });
+    return [title, ...lines].join('\n');
+}
+
+// Form fields holding the values at the JSON-pointer paths of schema violations
+const schemaFieldIds = {
+    '/autoinstall/identity/hostname': 'hostname',
+    '/autoinstall/identity/username': 'username',
+    '/autoinstall/identity/password': 'password',
+    '/autoinstall/identity/realname': 'realname',
+    '/autoinstall/identity': 'username',
+    '/autoinstall/locale': 'locale',
+    '/autoinstall/keyboard/layout': 'keyboard',
+    '/autoinstall/keyboard': 'keyboard',
+    '/autoinstall/timezone': 'timezone',
+    '/autoinstall/kernel': 'kernel',
+    '/autoinstall/kernel/package': 'kernel',
+    '/autoinstall/updates': 'updates',
+    '/autoinstall/shutdown': 'shutdown',
+    '/autoinstall/drivers/install': 'drivers',
+    '/autoinstall/ssh/authorized-keys': 'sshKeys',
+    '/autoinstall/ssh/allow-pw': 'sshAllowPW',
+    '/autoinstall/ssh/install-server': 'sshInstallServer',
+    '/autoinstall/apt/geoip': 'aptGeoIP',
+    '/autoinstall/apt/preserve_sources_list': 'aptPreserveSources',
+    '/autoinstall/apt/disable_components': 'aptDisableComponents',
+    '/autoinstall/network/version': 'networkVersion'
+};
+
+/**
+ * Highlight the form fields of validation issues or schema violations returned by the server.
+ * Paths without a field of their own fall back to their closest parent.
+ */
+function highlightInvalidFields(violations) {
+    Object.values(schemaFieldIds).forEach(id => {
+        const el = document.getElementById(id);
+        if (el) el.classList.remove('input-error');
+    });
+
+    let first = null;
+    violations.forEach(v => {
+        let path = v.path;
+        while (path && !schemaFieldIds[path]) {
+            path = path.substring(0, path.lastIndexOf('/'));
+        }
+        const el = path ? document.getElementById(schemaFieldIds[path]) : null;
+        if (!el) return;
+        el.classList.add('input-error');
+        if (!first) first = el;
+    });
+
+    if (first && first.scrollIntoView) {
+        first.scrollIntoView({ behavior: 'smooth', block: 'center' });
+        try { first.focus(); } catch (_) {}
+    }
+}
+
 /**
  * Validate APT required fields
  * - At least one primary repository exists
@@ -316,7 +316,8 @@ function buildCompleteConfig() {
         config.source = {
             type: sourceType,
             file: window.uploadedFileInfo || null,
-            codename: document.getElementById('codename')?.value || 'jammy'
+            codename: document.getElementById('codename')?.value || 'jammy',
+            arch: document.getElementById('arch')?.value || 'amd64'
         };
         
         // Add other form data
@@ -325,6 +326,7 @@ function buildCompleteConfig() {
         config.userDataContent = document.getElementById('userDataContent')?.value || '';
         config.useHWEKernel = document.getElementById('useHWEKernelCheckbox')?.checked || false;
         config.md5Checksum = document.getElementById('md5ChecksumCheckbox')?.checked || true;
+        config.mode = document.getElementById('overlayModeCheckbox')?.checked ? 'overlay' : 'full';
         config.gpgVerify = document.getElementById('gpgVerifyCheckbox')?.checked || true;
         
         return config;
@@ -381,6 +383,9 @@ async function pollBuildStatus(buildID) {
             } else if (status.status === 'failed') {
                 showStatus('userdataStatus', 'error', `Build failed: ${status.error || 'Unknown error'}`);
                 isoHideBuildProgress();
+            } else if (status.status === 'cancelled') {
+                showStatus('userdataStatus', 'warning', 'Build cancelled');
+                isoHideBuildProgress();
             } else {
                 // Continue polling
                 setTimeout(() => pollBuildStatus(buildID), 2000);
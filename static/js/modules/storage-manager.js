@@ -380,7 +380,7 @@ function getStorageTypeSpecificFields(type, ptable, wipe, match, number, display
                 </div>
                 <div class="form-row">
                     <div class="form-group">
-                        <label>Size <span class="hint-icon" data-tooltip="LV size, -1 for remaining space">?</span></label>
+                        <label>Size <span class="hint-icon" data-tooltip="LV size, a percentage of the volume group, or -1 for remaining space">?</span></label>
                         <div style="display: flex; gap: 10px; align-items: center;">
                             <input type="number" class="storage-size-input" value="${displaySize}" step="1" style="flex: 1;" required>
                             <select class="storage-size-unit" style="width: 80px;" required>
@@ -389,6 +389,7 @@ function getStorageTypeSpecificFields(type, ptable, wipe, match, number, display
                                 <option value="M" ${displayUnit === 'M' ? 'selected' : ''}>MB</option>
                                 <option value="G" ${displayUnit === 'G' ? 'selected' : ''}>GB</option>
                                 <option value="T" ${displayUnit === 'T' ? 'selected' : ''}>TB</option>
+                                <option value="%" ${displayUnit === '%' ? 'selected' : ''}>% of VG</option>
                             </select>
                         </div>
                     </div>
@@ -416,10 +417,24 @@ function getStorageTypeSpecificFields(type, ptable, wipe, match, number, display
                         <input type="text" class="storage-dm-name-input" value="${name || 'crypto'}" required>
                     </div>
                 </div>
-                <!-- Key Configuration (Key and Key File are mutually exclusive) -->
+                <!-- Key Configuration (Key Source, Key and Key File are mutually exclusive) -->
                 <div class="form-row">
                     <div class="form-group">
-                        <label class="optional">Key <span class="hint-icon" data-tooltip="Encryption key (default: secret, cannot be set together with Key File)">?</span></label>
+                        <label>Key Source <span class="hint-icon" data-tooltip="prompt: type the passphrase at the installer's storage screen; generate: a random key is generated with the user-data, stored outside the ISO and fetched from Key URL during installation; key: a Key or Key File below (a Key is stored in plain text in the user-data)">?</span></label>
+                        <select class="storage-key-source-input" onchange="window.StorageManager.toggleKeyFields(this)">
+                            <option value="" selected>key</option>
+                            <option value="prompt">prompt</option>
+                            <option value="generate">generate</option>
+                        </select>
+                    </div>
+                    <div class="form-group">
+                        <label class="optional">Key URL <span class="hint-icon" data-tooltip="http(s) URL the installer fetches the generated key from (e.g. https://keys.example.com/host1.key)">?</span></label>
+                        <input type="text" class="storage-key-url-input" disabled>
+                    </div>
+                </div>
+                <div class="form-row">
+                    <div class="form-group">
+                        <label class="optional">Key <span class="hint-icon" data-tooltip="Encryption key (default: secret), stored in plain text in the user-data (cannot be set together with Key File)">?</span></label>
                         <input type="password" class="storage-key-input" value="secret" onchange="window.StorageManager.toggleKeyFields(this)">
                     </div>
                     <div class="form-group">
@@ -547,14 +562,27 @@ function updateDiskMatchPlaceholder(select) {
 }
 
 /**
- * Toggle between Key and Key File fields for DM Crypt (mutually exclusive)
+ * Toggle the DM Crypt key fields: Key URL is only used by generated keys, Key
+ * and Key File only without a key source and are mutually exclusive
  */
 function toggleKeyFields(input) {
     const configDiv = input.closest('.storage-config');
+    const sourceInput = configDiv.querySelector('.storage-key-source-input');
+    const urlInput = configDiv.querySelector('.storage-key-url-input');
     const keyInput = configDiv.querySelector('.storage-key-input');
     const keyFileInput = configDiv.querySelector('.storage-keyfile-input');
-    
-    if (input === keyInput && input.value.trim() !== '') {
+
+    if (input === sourceInput) {
+        const source = sourceInput.value;
+        urlInput.disabled = source !== 'generate';
+        keyInput.disabled = source !== '';
+        keyFileInput.disabled = source !== '';
+        if (source !== 'generate') urlInput.value = '';
+        if (source !== '') {
+            keyInput.value = '';
+            keyFileInput.value = '';
+        }
+    } else if (input === keyInput && input.value.trim() !== '') {
         // If key is filled, clear key file
         keyFileInput.value = '';
     } else if (input === keyFileInput && input.value.trim() !== '') {
@@ -698,13 +726,23 @@ function validateStorageConfig(statusId = 'configStatus') {
             markError('.storage-volume-input', `DM-Crypt ${idx + 1}: Volume is required`);
             markError('.storage-dm-name-input', `DM-Crypt ${idx + 1}: DM name is required`);
             
-            // Check that either key or keyfile is provided (but not both)
+            // Check that a key source, or either key or keyfile is provided (but not both)
+            const sourceEl = cfg.querySelector('.storage-key-source-input');
+            const urlEl = cfg.querySelector('.storage-key-url-input');
             const keyEl = cfg.querySelector('.storage-key-input');
             const keyFileEl = cfg.querySelector('.storage-keyfile-input');
+            const source = sourceEl ? sourceEl.value : '';
             const hasKey = keyEl && keyEl.value && keyEl.value.trim() !== '';
             const hasKeyFile = keyFileEl && keyFileEl.value && keyFileEl.value.trim() !== '';
             
-            if (!hasKey && !hasKeyFile) {
+            if (source === 'generate') {
+                if (!urlEl || !/^https?:\/\/[^\s']+$/.test(urlEl.value.trim())) {
+                    errors.push(`DM-Crypt ${idx + 1}: Key URL must be an http or https URL for a generated key`);
+                    if (urlEl) urlEl.classList.add('input-error');
+                }
+            } else if (source === 'prompt') {
+                // The passphrase is typed in during installation
+            } else if (!hasKey && !hasKeyFile) {
                 errors.push(`DM-Crypt ${idx + 1}: Either Key or Key File must be provided`);
                 if (keyEl) keyEl.classList.add('input-error');
                 if (keyFileEl) keyFileEl.classList.add('input-error');
@@ -734,6 +772,99 @@ function validateStorageConfig(statusId = 'configStatus') {
     };
 }
 
+/**
+ * Simulate the storage layout on disks of the entered size and draw it
+ */
+async function simulateLayout() {
+    const diskSize = document.getElementById('simulateDiskSize')?.value.trim() || '';
+    const storage = buildConfig().autoinstall.storage;
+    const diagram = document.getElementById('storageLayoutDiagram');
+    if (diagram) diagram.innerHTML = '';
+
+    try {
+        const response = await fetch(`${API_BASE}/storage/simulate`, {
+            method: 'POST',
+            headers: {
+                'Content-Type': 'application/json'
+            },
+            body: JSON.stringify({ storage: storage, diskSize: diskSize })
+        });
+        const result = await response.json();
+        if (!result.success) {
+            const issues = (result.issues || []).map(i => `${i.path}: ${i.message}`);
+            showStatus('storageSimulateStatus', 'error', [result.error || 'Layout simulation failed', ...issues].join('\n'));
+            return;
+        }
+        const layout = result.layout;
+        showStatus('storageSimulateStatus', layout.fits ? 'success' : 'error', [result.message, ...(layout.problems || [])].join('\n'));
+        if (diagram) renderLayoutDiagram(diagram, layout);
+    } catch (error) {
+        console.error('Layout simulation error:', error);
+        showStatus('storageSimulateStatus', 'error', 'Layout simulation failed: ' + error.message);
+    }
+}
+
+/**
+ * Format a byte count with binary units, e.g. 2G
+ */
+function formatBytes(bytes) {
+    const units = ['B', 'K', 'M', 'G', 'T', 'P'];
+    let value = bytes;
+    let unit = 0;
+    while (unit < units.length - 1 && Math.abs(value) >= 1024) {
+        value /= 1024;
+        unit++;
+    }
+    return `${Math.floor(value * 100) / 100}${units[unit]}`;
+}
+
+/**
+ * Draw every disk and volume group as a bar whose segments are proportional to their size
+ */
+function renderLayoutDiagram(container, layout) {
+    const bar = (title, total, segments) => {
+        const row = document.createElement('div');
+        row.className = 'layout-row';
+        const label = document.createElement('div');
+        label.className = 'layout-label';
+        label.textContent = title;
+        row.appendChild(label);
+
+        const track = document.createElement('div');
+        track.className = 'layout-bar';
+        segments.forEach(seg => {
+            if (seg.size <= 0) return;
+            const el = document.createElement('div');
+            el.className = `layout-segment ${seg.kind}`;
+            el.style.flexGrow = String(seg.size / total);
+            el.title = `${seg.name}: ${formatBytes(seg.size)}${seg.usage ? ' (' + seg.usage + ')' : ''}`;
+            el.textContent = seg.name;
+            track.appendChild(el);
+        });
+        row.appendChild(track);
+        container.appendChild(row);
+    };
+
+    (layout.disks || []).forEach(disk => {
+        const segments = (disk.partitions || []).map(p => ({ name: p.id, size: p.size, usage: p.usage, kind: 'partition' }));
+        if (disk.usage) segments.push({ name: disk.id, size: disk.size, usage: disk.usage, kind: 'partition' });
+        segments.push({ name: 'free', size: disk.free, kind: 'free' });
+        bar(`${disk.id} (${formatBytes(disk.size)})`, disk.size, segments);
+    });
+    (layout.volumeGroups || []).forEach(vg => {
+        const segments = (vg.volumes || []).map(lv => ({ name: lv.name, size: lv.size, usage: lv.usage, kind: 'volume' }));
+        segments.push({ name: 'free', size: vg.free, kind: 'free' });
+        bar(`${vg.name} (${formatBytes(vg.size)})`, vg.size, segments);
+    });
+
+    const mounts = document.createElement('pre');
+    mounts.className = 'layout-mounts';
+    mounts.textContent = (layout.mounts || []).map(m =>
+        `${(m.path || '[SWAP]').padEnd(12)} ${m.fstype.padEnd(6)} ${m.volume.padEnd(16)} ${formatBytes(m.size).padStart(9)}${m.encrypted ? '  encrypted' : ''}`
+    ).join('\n');
+    container.appendChild(mounts);
+}
+
 // Export functions for use in other modules
 window.StorageManager = {
     initStorageConfigs,
@@ -745,7 +876,8 @@ window.StorageManager = {
     updateDiskMatchPlaceholder,
     toggleKeyFields,
     cleanupDuplicateAsterisks,
-    validateStorageConfig
+    validateStorageConfig,
+    simulateLayout
 };
 
 /**
@@ -46,7 +46,7 @@ function generateUserData() {
         fetch(`${API_BASE}/userdata/generate`, {
             method: 'POST',
             headers: { 'Content-Type': 'application/json' },
-            body: JSON.stringify({ config })
+            body: JSON.stringify({ config: config, source: document.getElementById('sourceDocument')?.value || '' })
         })
         .then(res => res.json())
         .then(result => {
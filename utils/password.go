@@ -1,40 +1,283 @@
 package utils
 
 import (
-	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
 	"errors"
-	"os/exec"
+	"fmt"
 	"regexp"
+	"strconv"
 	"strings"
 )
 
-var sha512CryptPattern = regexp.MustCompile(`^\$6\$[A-Za-z0-9./]+\$[A-Za-z0-9./]+$`)
-
 // IsSHA512Crypt checks if the given password string already looks like a SHA-512 crypt hash ($6$...)
 func IsSHA512Crypt(s string) bool {
-	return sha512CryptPattern.MatchString(strings.TrimSpace(s))
+	return CryptHashAlgorithm(s) == CryptSHA512
 }
 
-// HashSHA512Crypt uses openssl to generate a SHA-512 crypt hash for the given password.
-// It requires that `openssl` is available in PATH.
+// HashSHA512Crypt generates a SHA-512 crypt hash ($6$...) of the given password
+// with a random salt and the default rounds.
 func HashSHA512Crypt(plain string) (string, error) {
+	return Crypt(plain, CryptOptions{Algorithm: CryptSHA512})
+}
+
+// cryptHashPatterns match complete hashes of the supported algorithms.
+var cryptHashPatterns = []struct {
+	algorithm CryptAlgorithm
+	pattern   *regexp.Regexp
+}{
+	{CryptMD5, regexp.MustCompile(`^\$1\$[^$:\s]{0,8}\$[./0-9A-Za-z]{22}$`)},
+	{CryptSHA256, regexp.MustCompile(`^\$5\$(rounds=[0-9]+\$)?[^$:\s]{0,16}\$[./0-9A-Za-z]{43}$`)},
+	{CryptSHA512, regexp.MustCompile(`^\$6\$(rounds=[0-9]+\$)?[^$:\s]{0,16}\$[./0-9A-Za-z]{86}$`)},
+	{CryptYescrypt, regexp.MustCompile(`^\$y\$[./0-9A-Za-z]+\$[./0-9A-Za-z]*\$[./0-9A-Za-z]{43}$`)},
+}
+
+// cryptIDPattern matches the "$id$" prefix every modular crypt hash starts with.
+var cryptIDPattern = regexp.MustCompile(`^\$[0-9a-z]{1,2}\$`)
+
+// CryptHashAlgorithm returns the algorithm of a $1$, $5$, $6$ or $y$ crypt
+// hash, or "" when s is not a complete hash of one of them.
+func CryptHashAlgorithm(s string) CryptAlgorithm {
+	s = strings.TrimSpace(s)
+	for _, p := range cryptHashPatterns {
+		if p.pattern.MatchString(s) {
+			return p.algorithm
+		}
+	}
+	return ""
+}
+
+// IsCryptHash reports whether s is a $1$, $5$, $6$ or $y$ crypt hash, which
+// is stored as it is instead of being hashed again.
+func IsCryptHash(s string) bool {
+	return CryptHashAlgorithm(s) != ""
+}
+
+// LooksLikeCryptHash reports whether s starts like a crypt hash, "$id$",
+// whether or not it is a valid hash of a supported algorithm. Such a value is
+// almost certainly not meant as a plaintext password.
+func LooksLikeCryptHash(s string) bool {
+	return cryptIDPattern.MatchString(strings.TrimSpace(s))
+}
+
+// VerifyCrypt reports whether plain is the password of a $1$, $5$, $6$ or $y$
+// crypt hash.
+func VerifyCrypt(plain, hash string) (bool, error) {
+	hash = strings.TrimSpace(hash)
+	if !IsCryptHash(hash) {
+		return false, errors.New("not a $1$, $5$, $6$ or $y$ crypt hash")
+	}
+	computed, err := cryptWithSetting(plain, hash)
+	if err != nil {
+		return false, err
+	}
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(hash)) == 1, nil
+}
+
+// Limits of CheckCryptCost. The defaults are 5000 rounds and 16 MiB.
+const (
+	VerifyRoundsMax = 5000000  // SHA-256 and SHA-512 crypt rounds
+	VerifyMemoryMax = 64 << 20 // Bytes of yescrypt memory, yescrypt cost 7
+	verifyTimeMax   = 2        // yescrypt time parameter, one pass over the memory
+	verifyThreadMax = 4        // yescrypt parallelism, 1 in hashes of libxcrypt
+)
+
+// CheckCryptCost returns an error when verifying hash would take more than
+// VerifyRoundsMax rounds or VerifyMemoryMax bytes of memory. The cost is
+// chosen by whoever wrote the hash, so hashes of untrusted callers must be
+// checked before VerifyCrypt.
+func CheckCryptCost(hash string) error {
+	hash = strings.TrimSpace(hash)
+	switch CryptHashAlgorithm(hash) {
+	case CryptSHA256, CryptSHA512:
+		n, ok := strings.CutPrefix(hash[len("$6$"):], "rounds=")
+		if !ok {
+			return nil
+		}
+		n, _, _ = strings.Cut(n, "$")
+		if rounds, err := strconv.ParseUint(n, 10, 64); err != nil || rounds > VerifyRoundsMax {
+			return fmt.Errorf("hash has more than %d rounds", VerifyRoundsMax)
+		}
+	case CryptYescrypt:
+		p, _, err := decodeYescryptParams(hash[len("$y$"):])
+		if err != nil {
+			return err
+		}
+		if memory := 128 * uint64(p.r) * p.n; memory > VerifyMemoryMax {
+			return fmt.Errorf("hash needs %d MiB of memory, more than %d MiB", memory>>20, VerifyMemoryMax>>20)
+		}
+		if p.t > verifyTimeMax || p.p > verifyThreadMax {
+			return fmt.Errorf("hash has a yescrypt time of %d and parallelism of %d, more than %d and %d", p.t, p.p, verifyTimeMax, verifyThreadMax)
+		}
+	}
+	return nil
+}
+
+// CryptAlgorithm is a password hashing scheme of crypt(3).
+type CryptAlgorithm string
+
+const (
+	CryptSHA512   CryptAlgorithm = "sha512"   // $6$
+	CryptSHA256   CryptAlgorithm = "sha256"   // $5$
+	CryptYescrypt CryptAlgorithm = "yescrypt" // $y$, the default of Ubuntu since 22.04
+	CryptMD5      CryptAlgorithm = "md5"      // $1$, only verified, it is too weak for new hashes
+)
+
+// CryptOptions configures Crypt. The zero value hashes with SHA-512, the
+// default rounds and a random salt.
+type CryptOptions struct {
+	Algorithm CryptAlgorithm
+	// Rounds is the number of rounds of SHA-256 and SHA-512, 1000 to
+	// 999999999 and 5000 by default, or the cost of yescrypt, 1 to 11 and 5
+	// by default.
+	Rounds int
+	// Salt is the salt in the crypt alphabet [./0-9A-Za-z], random when
+	// empty. SHA-256 and SHA-512 use up to 16 characters of it.
+	Salt string
+}
+
+// Limits of the crypt parameters.
+const (
+	shaRoundsDefault    = 5000
+	shaRoundsMin        = 1000
+	shaRoundsMax        = 999999999
+	shaSaltMax          = 16
+	yescryptCostDefault = 5
+	yescryptCostMax     = 11
+	yescryptSaltBytes   = 16
+)
+
+// Crypt hashes the password like crypt(3) does with opts.
+func Crypt(plain string, opts CryptOptions) (string, error) {
 	if plain == "" {
 		return "", errors.New("password is empty")
 	}
+	setting, err := cryptSetting(opts)
+	if err != nil {
+		return "", err
+	}
+	return cryptWithSetting(plain, setting)
+}
 
-	cmd := exec.Command("openssl", "passwd", "-6", plain)
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-	if err := cmd.Run(); err != nil {
-		if stderr.Len() > 0 {
-			return "", errors.New(strings.TrimSpace(stderr.String()))
+// cryptSetting returns the prefix of a hash, the algorithm, its parameters
+// and the salt, for opts.
+func cryptSetting(opts CryptOptions) (string, error) {
+	if opts.Salt != "" && strings.Trim(opts.Salt, cryptAlphabet) != "" {
+		return "", fmt.Errorf("salt %q has characters outside of [./0-9A-Za-z]", opts.Salt)
+	}
+	switch opts.Algorithm {
+	case "", CryptSHA512, CryptSHA256:
+		prefix := "$6$"
+		if opts.Algorithm == CryptSHA256 {
+			prefix = "$5$"
 		}
-		return "", err
+		if opts.Rounds != 0 {
+			if opts.Rounds < shaRoundsMin || opts.Rounds > shaRoundsMax {
+				return "", fmt.Errorf("rounds must be %d to %d", shaRoundsMin, shaRoundsMax)
+			}
+			prefix += "rounds=" + strconv.Itoa(opts.Rounds) + "$"
+		}
+		salt := opts.Salt
+		if salt == "" {
+			b := make([]byte, 12)
+			if _, err := rand.Read(b); err != nil {
+				return "", err
+			}
+			salt = cryptEncode(b)
+		}
+		if len(salt) > shaSaltMax {
+			salt = salt[:shaSaltMax]
+		}
+		return prefix + salt, nil
+	case CryptMD5:
+		return "", errors.New("md5 crypt is too weak to hash new passwords")
+	case CryptYescrypt:
+		cost := opts.Rounds
+		if cost == 0 {
+			cost = yescryptCostDefault
+		}
+		if cost < 1 || cost > yescryptCostMax {
+			return "", fmt.Errorf("yescrypt cost must be 1 to %d", yescryptCostMax)
+		}
+		salt := opts.Salt
+		if salt == "" {
+			b := make([]byte, yescryptSaltBytes)
+			if _, err := rand.Read(b); err != nil {
+				return "", err
+			}
+			salt = cryptEncode(b)
+		} else if _, err := cryptDecode(salt); err != nil {
+			return "", fmt.Errorf("invalid yescrypt salt: %v", err)
+		}
+		return yescryptParamsForCost(cost).encode() + salt, nil
+	default:
+		return "", fmt.Errorf("unknown crypt algorithm %q", opts.Algorithm)
 	}
-	out := strings.TrimSpace(stdout.String())
-	if out == "" {
-		return "", errors.New("empty hash output from openssl")
+}
+
+// cryptWithSetting hashes the password with the algorithm, parameters and
+// salt of setting, which may be a complete hash.
+func cryptWithSetting(plain, setting string) (string, error) {
+	switch {
+	case strings.HasPrefix(setting, "$6$"):
+		return shaCrypt(plain, setting, sha512Crypt)
+	case strings.HasPrefix(setting, "$5$"):
+		return shaCrypt(plain, setting, sha256Crypt)
+	case strings.HasPrefix(setting, "$1$"):
+		return md5Crypt(plain, setting), nil
+	case strings.HasPrefix(setting, "$y$"):
+		return yescrypt(plain, setting)
+	default:
+		return "", fmt.Errorf("unsupported crypt setting %q", setting)
+	}
+}
+
+// cryptAlphabet are the characters of crypt's base-64 encoding, in order.
+const cryptAlphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// cryptEncode encodes b in crypt's little-endian base-64, three bytes to
+// four characters.
+func cryptEncode(b []byte) string {
+	var sb strings.Builder
+	for i := 0; i < len(b); i += 3 {
+		var v uint32
+		bits := 0
+		for j := i; j < i+3 && j < len(b); j++ {
+			v |= uint32(b[j]) << bits
+			bits += 8
+		}
+		for ; bits > 0; bits -= 6 {
+			sb.WriteByte(cryptAlphabet[v&0x3f])
+			v >>= 6
+		}
+	}
+	return sb.String()
+}
+
+// cryptDecode reverses cryptEncode.
+func cryptDecode(s string) ([]byte, error) {
+	var out []byte
+	for i := 0; i < len(s); i += 4 {
+		end := min(i+4, len(s))
+		if end-i == 1 {
+			return nil, errors.New("truncated base-64")
+		}
+		var v uint32
+		for j := i; j < end; j++ {
+			c := strings.IndexByte(cryptAlphabet, s[j])
+			if c < 0 {
+				return nil, fmt.Errorf("invalid base-64 character %q", s[j])
+			}
+			v |= uint32(c) << (6 * (j - i))
+		}
+		n := (end - i) * 6 / 8
+		for j := 0; j < n; j++ {
+			out = append(out, byte(v))
+			v >>= 8
+		}
+		if v != 0 {
+			return nil, errors.New("non-canonical base-64")
+		}
 	}
 	return out, nil
 }
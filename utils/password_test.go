@@ -0,0 +1,139 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Hashes generated with perl -e 'print crypt($password, $setting)' on Ubuntu
+// (glibc with libxcrypt).
+var cryptVectors = []struct {
+	password, setting, hash string
+}{
+	{"password", "$6$saltstring", "$6$saltstring$adDbXsJjcDlq2662QPgd.tkSOVmnG9Tt3oXl4HR60SusC3AGjirnDenVZp3DGwLwqy6iYKCzannhaX9DR72nN1"},
+	{"Hello world!", "$6$rounds=5000$toolongsaltstring", "$6$rounds=5000$toolongsaltstrin$iGlL7EUUfzNQx59x3ydJZ.zXPMUu1dOynSEl/vcNhLlas77qD0DzRswhhB6LdrXTz250at0syAfUXra.XrxAI1"},
+	{"correct horse battery staple €", "$6$rounds=1000$toolongsaltstring12345", "$6$rounds=1000$toolongsaltstrin$h.uAfWD1hfB17Q1lIBWU3CJGIB77x6.aBO2scr3dPe.ufbNEkayPNs6ElwwO6.MOtlJufhAcUhK0qYDWDdurm1"},
+	{"password", "$5$rounds=10000$saltstring", "$5$rounds=10000$saltstring$BXKRfHOWGOryjAm0GVQk8VRJRERBkg4gV1V0f0ddop."},
+	{"Hello world!", "$5$rounds=5000$toolongsaltstring", "$5$rounds=5000$toolongsaltstrin$0vuwUia3Nx9V/DqToMS8YLcfXpEXmSaC8wgguLIbus2"},
+	{"correct horse battery staple €", "$5$short", "$5$short$2m/NReBbHDDe6GfTm6x80pg.7Ss5h9UQqLuWmyW9To1"},
+	{"password", "$1$saltstri", "$1$saltstri$qQY4WxjABChYG1ccLpfkz/"},
+	{"Hello world!", "$1$12345678", "$1$12345678$2PB9bKzcmmTSPe9wORyDk/"},
+	{"", "$1$", "$1$$qRPK7m23GJusamGpoGLby/"},
+	{"password", "$y$j9T$F5Jx5fExrKuPp53xLKQ..1", "$y$j9T$F5Jx5fExrKuPp53xLKQ..1$tnSYvahCwPBHKZUspmcxMfb0.WiB9W.zEaKlOBL35rC"},
+	{"", "$y$j9T$F5Jx5fExrKuPp53xLKQ..1", "$y$j9T$F5Jx5fExrKuPp53xLKQ..1$5P1uc1zvKhieqEtKttbwCQrTPXpY1cK9wEnTDKAqLD8"},
+	{"pass", "$y$j9T$.2U.1EE/4Q.07ck0AoU1D.", "$y$j9T$.2U.1EE/4Q.07ck0AoU1D.$VXJDbv38RkbfW7KGsmEufJesqjpEs73rHjoaEuuUjKB"},
+	{"correct horse battery staple €", "$y$j75$.2U.1EE/4Q.07ck0AoU1D.", "$y$j75$.2U.1EE/4Q.07ck0AoU1D.$Qh19A7kzIwWvOnuieb49TMdtBej3oiNEM7E0b6SPfa9"},
+	{"correct horse battery staple €", "$y$j7T$.2U.1EE/4Q.07ck0AoU1D.", "$y$j7T$.2U.1EE/4Q.07ck0AoU1D.$ZLohd3d8RWEJsc5xB1EK3On87.CBmwrj0GCho1gNH48"},
+}
+
+func TestCryptWithSetting_Vectors(t *testing.T) {
+	for _, v := range cryptVectors {
+		hash, err := cryptWithSetting(v.password, v.setting)
+		require.NoError(t, err, v.setting)
+		assert.Equal(t, v.hash, hash)
+
+		// A complete hash is a setting as well
+		again, err := cryptWithSetting(v.password, v.hash)
+		require.NoError(t, err)
+		assert.Equal(t, v.hash, again)
+	}
+}
+
+func TestCrypt_Options(t *testing.T) {
+	hash, err := Crypt("password", CryptOptions{Salt: "saltstring"})
+	require.NoError(t, err)
+	assert.Equal(t, cryptVectors[0].hash, hash)
+
+	hash, err = Crypt("password", CryptOptions{Algorithm: CryptSHA256, Rounds: 10000, Salt: "saltstring"})
+	require.NoError(t, err)
+	assert.Equal(t, cryptVectors[3].hash, hash)
+
+	hash, err = Crypt("password", CryptOptions{Algorithm: CryptYescrypt, Salt: "F5Jx5fExrKuPp53xLKQ..1"})
+	require.NoError(t, err)
+	assert.Equal(t, cryptVectors[9].hash, hash)
+
+	// Costs are those of crypt_gensalt("$y$", cost) of libxcrypt
+	for cost, prefix := range map[int]string{1: "$y$j75$", 3: "$y$j7T$", 11: "$y$jFT$"} {
+		setting, err := cryptSetting(CryptOptions{Algorithm: CryptYescrypt, Rounds: cost, Salt: ".2U.1EE/4Q.07ck0AoU1D."})
+		require.NoError(t, err)
+		assert.Equal(t, prefix+".2U.1EE/4Q.07ck0AoU1D.", setting)
+	}
+	assert.Equal(t, ".2U.1EE/4Q.07ck0AoU1D.", cryptEncode([]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}))
+
+	a, err := HashSHA512Crypt("password")
+	require.NoError(t, err)
+	b, err := HashSHA512Crypt("password")
+	require.NoError(t, err)
+	assert.True(t, IsSHA512Crypt(a))
+	assert.NotEqual(t, a, b, "salts are random")
+	assert.Len(t, strings.Split(a, "$")[2], 16)
+
+	_, err = Crypt("", CryptOptions{})
+	assert.Error(t, err)
+	_, err = Crypt("password", CryptOptions{Rounds: 999})
+	assert.Error(t, err)
+	_, err = Crypt("password", CryptOptions{Algorithm: CryptYescrypt, Rounds: 12})
+	assert.Error(t, err)
+	_, err = Crypt("password", CryptOptions{Salt: "not a salt!"})
+	assert.Error(t, err)
+	_, err = Crypt("password", CryptOptions{Algorithm: "md5"})
+	assert.Error(t, err)
+}
+
+func TestCryptHashAlgorithm(t *testing.T) {
+	for _, v := range cryptVectors {
+		assert.True(t, IsCryptHash(v.hash), v.hash)
+	}
+	assert.Equal(t, CryptSHA512, CryptHashAlgorithm(cryptVectors[1].hash), "rounds are part of the hash")
+	assert.Equal(t, CryptMD5, CryptHashAlgorithm(cryptVectors[6].hash))
+	assert.Equal(t, CryptYescrypt, CryptHashAlgorithm(cryptVectors[9].hash))
+
+	for _, s := range []string{
+		"password",
+		"$6$saltstring$adDbXsJjcDlq2662QPgd", // Truncated
+		"$5$saltstring$BXKRfHOWGOryjAm0GVQk8VRJRERBkg4gV1V0f0ddop.x",
+		"$2b$12$R9h/cIPz0gi.URNNX3kh2OPST9/PgBkqquzi.Ss7KIUgO2t0jWMUW", // bcrypt
+		"$y$j9T$salt",
+	} {
+		assert.False(t, IsCryptHash(s), s)
+	}
+	assert.True(t, LooksLikeCryptHash("$2b$12$R9h/cIPz0gi"))
+	assert.True(t, LooksLikeCryptHash("$6$saltstring$adDbXsJjcDlq2662QPgd"))
+	assert.False(t, LooksLikeCryptHash("$ecret password"))
+	assert.False(t, LooksLikeCryptHash("password"))
+}
+
+func TestVerifyCrypt(t *testing.T) {
+	for _, v := range cryptVectors {
+		ok, err := VerifyCrypt(v.password, v.hash)
+		require.NoError(t, err)
+		assert.True(t, ok, v.hash)
+
+		ok, err = VerifyCrypt(v.password+"x", v.hash)
+		require.NoError(t, err)
+		assert.False(t, ok, v.hash)
+	}
+	_, err := VerifyCrypt("password", "$2b$12$R9h/cIPz0gi.URNNX3kh2OPST9/PgBkqquzi.Ss7KIUgO2t0jWMUW")
+	assert.Error(t, err)
+}
+
+func TestCheckCryptCost(t *testing.T) {
+	hash := func(setting string, n int) string { return setting + "$" + strings.Repeat("a", n) }
+	yescryptCost := func(cost int) string {
+		return hash(yescryptParamsForCost(cost).encode()+"saltsaltsaltsaltsaltsa", 43)
+	}
+
+	assert.NoError(t, CheckCryptCost(hash("$6$salt", 86)))
+	assert.NoError(t, CheckCryptCost(hash("$6$rounds=5000000$salt", 86)))
+	assert.Error(t, CheckCryptCost(hash("$6$rounds=5000001$salt", 86)))
+	assert.Error(t, CheckCryptCost(hash("$5$rounds=999999999$salt", 43)))
+	assert.NoError(t, CheckCryptCost("$1$saltstri$qQY4WxjABChYG1ccLpfkz/"))
+
+	assert.NoError(t, CheckCryptCost(yescryptCost(yescryptCostDefault)))
+	assert.NoError(t, CheckCryptCost(yescryptCost(7)), "64 MiB")
+	assert.Error(t, CheckCryptCost(yescryptCost(8)), "128 MiB")
+	assert.Error(t, CheckCryptCost(yescryptCost(yescryptCostMax)), "1 GiB")
+}
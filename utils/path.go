@@ -5,24 +5,37 @@ import (
 	"path/filepath"
 )
 
+// Path lays out the directories of a generator. RootDir holds state shared by
+// all builds (downloads, registry); WorkDir holds the files of a single build.
 type Path struct {
 	RootDir string
+	WorkDir string
 }
 
 // NewPath 构造一个 Path 实例
 func NewPath(rootDir string) *Path {
-	return &Path{RootDir: rootDir}
+	return &Path{RootDir: rootDir, WorkDir: rootDir}
+}
+
+// Workspace returns a Path sharing the root directory but building in its own workspace.
+func (p *Path) Workspace(id string) *Path {
+	return &Path{RootDir: p.RootDir, WorkDir: filepath.Join(p.WorkspacesDir(), id)}
 }
 
 // 顶层目录
-func (p *Path) BuildDir() string    { return filepath.Join(p.RootDir, "build") }
-func (p *Path) DownloadDir() string { return filepath.Join(p.RootDir, "download") }
-func (p *Path) ConfigDir() string   { return filepath.Join(p.RootDir, "config") }
-func (p *Path) Boot() string        { return filepath.Join(p.RootDir, "BOOT") }
+func (p *Path) DownloadDir() string   { return filepath.Join(p.RootDir, "download") }
+func (p *Path) ConfigDir() string     { return filepath.Join(p.RootDir, "config") }
+func (p *Path) RegistryDir() string   { return filepath.Join(p.RootDir, "registry") }
+func (p *Path) WorkspacesDir() string { return filepath.Join(p.RootDir, "workspaces") }
+
+// 工作目录
+func (p *Path) BuildDir() string  { return filepath.Join(p.WorkDir, "build") }
+func (p *Path) Boot() string      { return filepath.Join(p.WorkDir, "BOOT") }
+func (p *Path) OutputDir() string { return filepath.Join(p.WorkDir, "output") }
+func (p *Path) AptDir() string    { return filepath.Join(p.WorkDir, "apt") }
 
 // build 下的细节目录
-func (p *Path) Mount() string   { return filepath.Join(p.BuildDir(), "mnt") }
-func (p *Path) BootISO() string { return filepath.Join(p.BuildDir(), "[BOOT]") }
+func (p *Path) Mount() string { return filepath.Join(p.BuildDir(), "mnt") }
 
 func (p *Path) Packages() string { return filepath.Join(p.Mount(), "packages") }
 func (p *Path) Scripts() string  { return filepath.Join(p.Mount(), "script") }
@@ -31,6 +44,9 @@ func (p *Path) Scripts() string  { return filepath.Join(p.Mount(), "script") }
 func (p *Path) DownloadFile(fileName string) string {
 	return filepath.Join(p.DownloadDir(), fileName)
 }
+func (p *Path) OutputFile(fileName string) string {
+	return filepath.Join(p.OutputDir(), fileName)
+}
 func (p *Path) ScriptFile(fileName string) string {
 	return filepath.Join(p.Scripts(), fileName)
 }
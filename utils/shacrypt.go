@@ -0,0 +1,209 @@
+package utils
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+)
+
+// shaCryptVariant is SHA-256 or SHA-512 crypt.
+type shaCryptVariant struct {
+	prefix string
+	hash   func() hash.Hash
+	order  [][3]int // Digest bytes of each group of four characters of the hash, most significant first
+}
+
+// The digest byte order of SHA-crypt hashes, from the specification by
+// Ulrich Drepper. The last group has fewer than three bytes; index -1 is zero.
+var (
+	sha512Crypt = shaCryptVariant{prefix: "$6$", hash: sha512.New, order: [][3]int{
+		{0, 21, 42}, {22, 43, 1}, {44, 2, 23}, {3, 24, 45}, {25, 46, 4}, {47, 5, 26}, {6, 27, 48},
+		{28, 49, 7}, {50, 8, 29}, {9, 30, 51}, {31, 52, 10}, {53, 11, 32}, {12, 33, 54}, {34, 55, 13},
+		{56, 14, 35}, {15, 36, 57}, {37, 58, 16}, {59, 17, 38}, {18, 39, 60}, {40, 61, 19}, {62, 20, 41},
+		{-1, -1, 63},
+	}}
+	sha256Crypt = shaCryptVariant{prefix: "$5$", hash: sha256.New, order: [][3]int{
+		{0, 10, 20}, {21, 1, 11}, {12, 22, 2}, {3, 13, 23}, {24, 4, 14}, {15, 25, 5}, {6, 16, 26},
+		{27, 7, 17}, {18, 28, 8}, {9, 19, 29},
+		{-1, 31, 30},
+	}}
+)
+
+// shaCrypt computes the SHA-crypt hash of the password for setting, which is
+// "$6$", an optional "rounds=<n>$" and the salt, optionally followed by "$"
+// and a hash.
+func shaCrypt(plain, setting string, v shaCryptVariant) (string, error) {
+	rest := strings.TrimPrefix(setting, v.prefix)
+	rounds, custom := shaRoundsDefault, false
+	if r, ok := strings.CutPrefix(rest, "rounds="); ok {
+		n, after, found := strings.Cut(r, "$")
+		parsed, err := strconv.ParseUint(n, 10, 32)
+		if !found || err != nil {
+			return "", fmt.Errorf("invalid rounds in crypt setting %q", setting)
+		}
+		rounds, custom = min(max(int(parsed), shaRoundsMin), shaRoundsMax), true
+		rest = after
+	}
+	salt, _, _ := strings.Cut(rest, "$")
+	if len(salt) > shaSaltMax {
+		salt = salt[:shaSaltMax]
+	}
+
+	digest := shaCryptDigest(v.hash, []byte(plain), []byte(salt), rounds)
+
+	var sb strings.Builder
+	sb.WriteString(v.prefix)
+	if custom {
+		sb.WriteString("rounds=" + strconv.Itoa(rounds) + "$")
+	}
+	sb.WriteString(salt)
+	sb.WriteByte('$')
+	sb.WriteString(encodeDigest(digest, v.order))
+	return sb.String(), nil
+}
+
+// encodeDigest encodes the bytes of digest in groups of three, in the order
+// of the algorithm, to four characters each.
+func encodeDigest(digest []byte, order [][3]int) string {
+	var sb strings.Builder
+	for _, group := range order {
+		var w uint32
+		chars := 4
+		for _, i := range group {
+			w <<= 8
+			if i < 0 {
+				chars--
+				continue
+			}
+			w |= uint32(digest[i])
+		}
+		for ; chars > 0; chars-- {
+			sb.WriteByte(cryptAlphabet[w&0x3f])
+			w >>= 6
+		}
+	}
+	return sb.String()
+}
+
+// md5CryptOrder is the digest byte order of MD5 crypt hashes.
+var md5CryptOrder = [][3]int{{0, 6, 12}, {1, 7, 13}, {2, 8, 14}, {3, 9, 15}, {4, 10, 5}, {-1, -1, 11}}
+
+// md5Crypt computes the MD5 crypt hash ($1$) of the password for setting, for
+// verifying existing hashes.
+func md5Crypt(plain, setting string) string {
+	password := []byte(plain)
+	salt, _, _ := strings.Cut(strings.TrimPrefix(setting, "$1$"), "$")
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+
+	alt := md5.Sum([]byte(plain + salt + plain))
+	h := md5.New()
+	h.Write([]byte(plain + "$1$" + salt))
+	h.Write(repeatTo(alt[:], len(password)))
+	for n := len(password); n > 0; n >>= 1 {
+		if n&1 != 0 {
+			h.Write([]byte{0})
+		} else {
+			h.Write(password[:1])
+		}
+	}
+	digest := h.Sum(nil)
+
+	for i := 0; i < 1000; i++ {
+		h.Reset()
+		if i&1 != 0 {
+			h.Write(password)
+		} else {
+			h.Write(digest)
+		}
+		if i%3 != 0 {
+			h.Write([]byte(salt))
+		}
+		if i%7 != 0 {
+			h.Write(password)
+		}
+		if i&1 != 0 {
+			h.Write(digest)
+		} else {
+			h.Write(password)
+		}
+		digest = h.Sum(digest[:0])
+	}
+	return "$1$" + salt + "$" + encodeDigest(digest, md5CryptOrder)
+}
+
+// shaCryptDigest runs the SHA-crypt algorithm and returns the final digest.
+func shaCryptDigest(newHash func() hash.Hash, password, salt []byte, rounds int) []byte {
+	// Digest B: password, salt, password
+	h := newHash()
+	h.Write(password)
+	h.Write(salt)
+	h.Write(password)
+	b := h.Sum(nil)
+
+	// Digest A: password, salt, B for the length of the password, then B or
+	// the password for every bit of the length of the password
+	h.Reset()
+	h.Write(password)
+	h.Write(salt)
+	h.Write(repeatTo(b, len(password)))
+	for n := len(password); n > 0; n >>= 1 {
+		if n&1 != 0 {
+			h.Write(b)
+		} else {
+			h.Write(password)
+		}
+	}
+	a := h.Sum(nil)
+
+	// Sequence P: the password hashed once for every byte of it
+	h.Reset()
+	for range password {
+		h.Write(password)
+	}
+	p := repeatTo(h.Sum(nil), len(password))
+
+	// Sequence S: the salt hashed 16 plus A[0] times
+	h.Reset()
+	for i := 0; i < 16+int(a[0]); i++ {
+		h.Write(salt)
+	}
+	s := repeatTo(h.Sum(nil), len(salt))
+
+	c := a
+	for i := 0; i < rounds; i++ {
+		h.Reset()
+		if i&1 != 0 {
+			h.Write(p)
+		} else {
+			h.Write(c)
+		}
+		if i%3 != 0 {
+			h.Write(s)
+		}
+		if i%7 != 0 {
+			h.Write(p)
+		}
+		if i&1 != 0 {
+			h.Write(c)
+		} else {
+			h.Write(p)
+		}
+		c = h.Sum(c[:0])
+	}
+	return c
+}
+
+// repeatTo repeats b up to n bytes.
+func repeatTo(b []byte, n int) []byte {
+	out := make([]byte, 0, n)
+	for len(out) < n {
+		out = append(out, b[:min(len(b), n-len(out))]...)
+	}
+	return out
+}
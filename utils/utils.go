@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
@@ -29,7 +30,16 @@ func CalculateSHA256(filePath string) (string, error) {
 
 // downloadFile
 func DownloadFile(url, dest string) error {
-	resp, err := http.Get(url)
+	return DownloadFileContext(context.Background(), url, dest)
+}
+
+// DownloadFileContext downloads url to dest, aborting when ctx is done.
+func DownloadFileContext(ctx context.Context, url, dest string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return err
 	}
@@ -39,10 +49,13 @@ func DownloadFile(url, dest string) error {
 		return fmt.Errorf("bad status: %s", resp.Status)
 	}
 
-	out, err := os.Create(dest)
+	// Download into a temporary file and rename it once complete, so that an
+	// interrupted or concurrent download never leaves a truncated file at dest.
+	out, err := os.CreateTemp(filepath.Dir(dest), filepath.Base(dest)+".part-*")
 	if err != nil {
 		return err
 	}
+	defer os.Remove(out.Name())
 	defer out.Close()
 
 	size := resp.ContentLength
@@ -65,6 +78,12 @@ func DownloadFile(url, dest string) error {
 	if err != nil {
 		return err
 	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(out.Name(), dest); err != nil {
+		return err
+	}
 
 	fmt.Println("\nDownload complete")
 	return nil
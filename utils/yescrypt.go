@@ -0,0 +1,510 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+	"strings"
+)
+
+// yescrypt flags. Only the default flavor, which Ubuntu's libxcrypt uses, is
+// supported: read-write mode with 6 pwxform rounds, gather 4, simple 2 and
+// 12 KiB S-boxes.
+const (
+	yescryptRW       = 0x002
+	yescryptDefaults = yescryptRW | 0x004 | 0x010 | 0x020 | 0x080
+	yescryptPrehash  = 0x10000000
+)
+
+// pwxform parameters of the default flavor.
+const (
+	pwxSimple = 2
+	pwxGather = 4
+	pwxRounds = 6
+	sWidth    = 8
+
+	pwxWords = pwxGather * pwxSimple * 2           // 32-bit words of a pwxform block
+	sWords   = 3 * (1 << sWidth) * pwxSimple * 2   // 32-bit words of the three S-boxes
+	sMask    = ((1 << sWidth) - 1) * pwxSimple * 8 // Byte offset mask of an S-box lookup
+	sBoxSize = (1 << sWidth) * pwxSimple * 2       // 32-bit words of one S-box
+	sWMask   = (1<<sWidth)*pwxSimple - 1           // Mask of the S2 write position
+	sBlocks  = sWords * 4 / 128                    // 128-byte blocks of the S-boxes
+)
+
+// yescryptParams are the cost parameters of a yescrypt hash.
+type yescryptParams struct {
+	flags   uint32
+	n       uint64 // Block count, a power of 2
+	r, p, t uint32 // Block size in 128 bytes, parallelism and time
+}
+
+// yescryptParamsForCost returns the parameters libxcrypt uses for a cost of
+// 1 to 11; cost 5 is "j9T", 16 MiB of memory.
+func yescryptParamsForCost(cost int) yescryptParams {
+	if cost <= 2 {
+		return yescryptParams{flags: yescryptDefaults, n: 512 << cost, r: 8, p: 1}
+	}
+	return yescryptParams{flags: yescryptDefaults, n: 128 << cost, r: 32, p: 1}
+}
+
+// encode returns the "$y$<params>$" prefix of a hash.
+func (p yescryptParams) encode() string {
+	var sb strings.Builder
+	sb.WriteString("$y$")
+	flavor := p.flags
+	if flavor >= yescryptRW {
+		flavor = yescryptRW + p.flags>>2
+	}
+	sb.WriteString(encode64Uint32(flavor, 0))
+	sb.WriteString(encode64Uint32(uint32(bits.TrailingZeros64(p.n)), 1))
+	sb.WriteString(encode64Uint32(p.r, 1))
+	var have uint32
+	if p.p != 1 {
+		have |= 1
+	}
+	if p.t != 0 {
+		have |= 2
+	}
+	if have != 0 {
+		sb.WriteString(encode64Uint32(have, 1))
+		if p.p != 1 {
+			sb.WriteString(encode64Uint32(p.p, 2))
+		}
+		if p.t != 0 {
+			sb.WriteString(encode64Uint32(p.t, 1))
+		}
+	}
+	sb.WriteByte('$')
+	return sb.String()
+}
+
+// decodeYescryptParams parses the parameters after "$y$" and returns the rest.
+func decodeYescryptParams(s string) (yescryptParams, string, error) {
+	var p yescryptParams
+	var flavor, nLog2, have uint32
+	var ok bool
+	if flavor, s, ok = decode64Uint32(s, 0); !ok {
+		return p, "", errors.New("invalid flavor")
+	}
+	switch {
+	case flavor < yescryptRW:
+		p.flags = flavor
+	default:
+		p.flags = yescryptRW + (flavor-yescryptRW)<<2
+	}
+	if p.flags != yescryptDefaults {
+		return p, "", fmt.Errorf("unsupported yescrypt flavor %d", flavor)
+	}
+	if nLog2, s, ok = decode64Uint32(s, 1); !ok || nLog2 > 63 {
+		return p, "", errors.New("invalid N")
+	}
+	p.n = 1 << nLog2
+	if p.r, s, ok = decode64Uint32(s, 1); !ok {
+		return p, "", errors.New("invalid r")
+	}
+	p.p = 1
+	if s != "" && s[0] != '$' {
+		if have, s, ok = decode64Uint32(s, 1); !ok || have&^3 != 0 {
+			return p, "", errors.New("unsupported parameters")
+		}
+		if have&1 != 0 {
+			if p.p, s, ok = decode64Uint32(s, 2); !ok {
+				return p, "", errors.New("invalid p")
+			}
+		}
+		if have&2 != 0 {
+			if p.t, s, ok = decode64Uint32(s, 1); !ok {
+				return p, "", errors.New("invalid t")
+			}
+		}
+	}
+	if s == "" || s[0] != '$' {
+		return p, "", errors.New("missing salt")
+	}
+	if uint64(p.r)*uint64(p.p) >= 1<<30 || p.n < 4 || p.n/uint64(p.p) < 2 || uint64(p.r)*128*p.n > 1<<30 {
+		return p, "", errors.New("parameters out of range")
+	}
+	return p, s[1:], nil
+}
+
+// encode64Uint32 encodes an integer of at least minValue in yescrypt's variable
+// length encoding.
+func encode64Uint32(v, minValue uint32) string {
+	v -= minValue
+	start, end, chars, shift := uint32(0), uint32(47), 1, uint32(0)
+	for {
+		count := (end + 1 - start) << shift
+		if v < count {
+			break
+		}
+		start = end + 1
+		end = start + (62-end)/2
+		v -= count
+		chars++
+		shift += 6
+	}
+	out := []byte{cryptAlphabet[start+v>>shift]}
+	for ; chars > 1; chars-- {
+		shift -= 6
+		out = append(out, cryptAlphabet[(v>>shift)&0x3f])
+	}
+	return string(out)
+}
+
+// decode64Uint32 reverses encode64Uint32 and returns the rest of s.
+func decode64Uint32(s string, minValue uint32) (uint32, string, bool) {
+	if s == "" {
+		return 0, "", false
+	}
+	c := uint32(strings.IndexByte(cryptAlphabet, s[0]))
+	if c > 63 {
+		return 0, "", false
+	}
+	s = s[1:]
+	v := minValue
+	start, end, chars, shift := uint32(0), uint32(47), 1, uint32(0)
+	for c > end {
+		v += (end + 1 - start) << shift
+		start = end + 1
+		end = start + (62-end)/2
+		chars++
+		shift += 6
+	}
+	v += (c - start) << shift
+	for ; chars > 1; chars-- {
+		if s == "" {
+			return 0, "", false
+		}
+		c = uint32(strings.IndexByte(cryptAlphabet, s[0]))
+		if c > 63 {
+			return 0, "", false
+		}
+		s = s[1:]
+		shift -= 6
+		v += c << shift
+	}
+	return v, s, true
+}
+
+// yescrypt computes the yescrypt hash of the password for setting, which is
+// "$y$", the parameters and the salt, optionally followed by "$" and a hash.
+func yescrypt(plain, setting string) (string, error) {
+	params, rest, err := decodeYescryptParams(strings.TrimPrefix(setting, "$y$"))
+	if err != nil {
+		return "", fmt.Errorf("invalid yescrypt setting: %v", err)
+	}
+	saltStr := rest
+	if i := strings.LastIndexByte(rest, '$'); i >= 0 {
+		saltStr = rest[:i]
+	}
+	salt, err := cryptDecode(saltStr)
+	if err != nil {
+		return "", fmt.Errorf("invalid yescrypt salt: %v", err)
+	}
+	hash := yescryptKDF([]byte(plain), salt, params, 32)
+	return setting[:len(setting)-len(rest)] + saltStr + "$" + cryptEncode(hash), nil
+}
+
+// yescryptKDF derives a key of dkLen bytes. Large parameters are preceded
+// by a pass with 1/64 of the memory, as in the reference implementation.
+func yescryptKDF(passwd, salt []byte, p yescryptParams, dkLen int) []byte {
+	if p.flags&yescryptRW != 0 && p.n/uint64(p.p) >= 0x100 && p.n/uint64(p.p)*uint64(p.r) >= 0x20000 {
+		passwd = yescryptKDFBody(passwd, salt, yescryptParams{flags: p.flags | yescryptPrehash, n: p.n >> 6, r: p.r, p: p.p}, 32)
+	}
+	return yescryptKDFBody(passwd, salt, p, dkLen)
+}
+
+// yescryptKDFBody is a single pass of yescryptKDF.
+func yescryptKDFBody(passwd, salt []byte, p yescryptParams, dkLen int) []byte {
+	key := "yescrypt"
+	if p.flags&yescryptPrehash != 0 {
+		key = "yescrypt-prehash"
+	}
+	passwd = hmacSHA256([]byte(key), passwd)
+
+	s := 32 * int(p.r)
+	b := pbkdf2SHA256(passwd, salt, 128*int(p.r)*int(p.p))
+	passwd = append([]byte(nil), b[:32]...)
+
+	words := make([]uint32, len(b)/4)
+	for i := range words {
+		words[i] = binary.LittleEndian.Uint32(b[i*4:])
+	}
+	smix(words, s, p, passwd)
+	for i, w := range words {
+		binary.LittleEndian.PutUint32(b[i*4:], w)
+	}
+
+	dk := pbkdf2SHA256(passwd, b, max(dkLen, 32))
+	if p.flags&yescryptPrehash == 0 {
+		storedKey := sha256.Sum256(hmacSHA256(dk[:32], []byte("Client Key")))
+		copy(dk, storedKey[:])
+	}
+	return dk[:dkLen]
+}
+
+func hmacSHA256(key, msg []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(msg)
+	return mac.Sum(nil)
+}
+
+func pbkdf2SHA256(passwd, salt []byte, n int) []byte {
+	dk, err := pbkdf2.Key(sha256.New, string(passwd), salt, 1, n)
+	if err != nil {
+		panic(err) // Only for lengths beyond what yescrypt uses
+	}
+	return dk
+}
+
+// pwxformCtx are the S-boxes of a yescrypt block: S0, S1 and S2 are word
+// offsets into s, and w the write position in S2.
+type pwxformCtx struct {
+	s          []uint32
+	s0, s1, s2 int
+	w          int
+}
+
+// smix runs the sequential memory-hard mixing over the blocks b, each of s
+// words. passwd is updated with the S-box dependent key.
+func smix(b []uint32, s int, p yescryptParams, passwd []byte) {
+	r := s / 32
+	nChunk := p.n / uint64(p.p)
+	nLoopAll := nChunk
+	if p.t <= 1 {
+		if p.t != 0 {
+			nLoopAll *= 2
+		}
+		nLoopAll = (nLoopAll + 2) / 3
+	} else {
+		nLoopAll *= uint64(p.t) - 1
+	}
+	nLoopRW := nLoopAll / uint64(p.p)
+	nChunk &^= 1
+	nLoopAll = (nLoopAll + 1) &^ 1
+	nLoopRW = (nLoopRW + 1) &^ 1
+
+	v := make([]uint32, int(p.n)*s)
+	xy := make([]uint32, 2*s)
+	ctxs := make([]*pwxformCtx, p.p)
+	for i, vChunk := 0, uint64(0); i < int(p.p); i, vChunk = i+1, vChunk+nChunk {
+		np := nChunk
+		if i == int(p.p)-1 {
+			np = p.n - vChunk
+		}
+		bp := b[i*s : (i+1)*s]
+		vp := v[int(vChunk)*s:]
+
+		ctx := &pwxformCtx{s: make([]uint32, sWords)}
+		smix1(bp, 1, sBlocks, 0, ctx.s, xy, nil)
+		ctx.s2, ctx.s1, ctx.s0 = 0, sBoxSize, 2*sBoxSize
+		ctxs[i] = ctx
+		if i == 0 {
+			key := make([]byte, 64)
+			for j, w := range bp[s-16:] {
+				binary.LittleEndian.PutUint32(key[j*4:], w)
+			}
+			copy(passwd, hmacSHA256(key, passwd))
+		}
+		smix1(bp, r, np, p.flags, vp, xy, ctx)
+		smix2(bp, r, p2floor(np), nLoopRW, p.flags, vp, xy, ctx)
+	}
+	for i := 0; i < int(p.p); i++ {
+		smix2(b[i*s:(i+1)*s], r, p.n, nLoopAll-nLoopRW, p.flags&^yescryptRW, v, xy, ctxs[i])
+	}
+}
+
+// shuffle copies the blocks of b into x in the SIMD order of the reference
+// implementation, which pwxform and integerify depend on.
+func shuffle(x, b []uint32) {
+	for k := 0; k < len(b); k += 16 {
+		for i := 0; i < 16; i++ {
+			x[k+i] = b[k+i*5%16]
+		}
+	}
+}
+
+// unshuffle reverses shuffle.
+func unshuffle(b, x []uint32) {
+	for k := 0; k < len(b); k += 16 {
+		for i := 0; i < 16; i++ {
+			b[k+i*5%16] = x[k+i]
+		}
+	}
+}
+
+// smix1 fills v with n blocks derived from b.
+func smix1(b []uint32, r int, n uint64, flags uint32, v, xy []uint32, ctx *pwxformCtx) {
+	s := 32 * r
+	x, y := xy[:s], xy[s:2*s]
+	shuffle(x, b[:s])
+	for i := uint64(0); i < n; i++ {
+		copy(v[int(i)*s:], x)
+		if flags&yescryptRW != 0 && i > 1 {
+			j := wrap(integerify(x, r), i)
+			xorBlocks(x, v[int(j)*s:int(j+1)*s])
+		}
+		if ctx != nil {
+			blockmixPwxform(x, ctx, r)
+		} else {
+			blockmixSalsa8(x, y, r)
+		}
+	}
+	unshuffle(b[:s], x)
+}
+
+// smix2 mixes b with nLoop pseudo-random blocks of v.
+func smix2(b []uint32, r int, n, nLoop uint64, flags uint32, v, xy []uint32, ctx *pwxformCtx) {
+	s := 32 * r
+	x, y := xy[:s], xy[s:2*s]
+	shuffle(x, b[:s])
+	for i := uint64(0); i < nLoop; i++ {
+		j := integerify(x, r) & (n - 1)
+		vj := v[int(j)*s : int(j+1)*s]
+		xorBlocks(x, vj)
+		if flags&yescryptRW != 0 {
+			copy(vj, x)
+		}
+		if ctx != nil {
+			blockmixPwxform(x, ctx, r)
+		} else {
+			blockmixSalsa8(x, y, r)
+		}
+	}
+	unshuffle(b[:s], x)
+}
+
+// integerify returns the first 64 bits of the last 64-byte block of b.
+func integerify(b []uint32, r int) uint64 {
+	x := b[(2*r-1)*16:]
+	return uint64(x[13])<<32 | uint64(x[0])
+}
+
+// p2floor returns the largest power of 2 not above x.
+func p2floor(x uint64) uint64 {
+	for y := x & (x - 1); y != 0; y = x & (x - 1) {
+		x = y
+	}
+	return x
+}
+
+// wrap maps x into the blocks written after the largest power of 2 below i.
+func wrap(x, i uint64) uint64 {
+	n := p2floor(i)
+	return x&(n-1) + (i - n)
+}
+
+func xorBlocks(dst, src []uint32) {
+	for i := range dst {
+		dst[i] ^= src[i]
+	}
+}
+
+// blockmixSalsa8 is BlockMix of scrypt with Salsa20/8 over the 2r 64-byte
+// blocks of b; y is scratch space of the same size.
+func blockmixSalsa8(b, y []uint32, r int) {
+	var x [16]uint32
+	copy(x[:], b[(2*r-1)*16:])
+	for i := 0; i < 2*r; i++ {
+		xorBlocks(x[:], b[i*16:(i+1)*16])
+		salsa20(x[:], 8)
+		copy(y[i*16:], x[:])
+	}
+	for i := 0; i < r; i++ {
+		copy(b[i*16:(i+1)*16], y[i*2*16:])
+		copy(b[(i+r)*16:(i+r+1)*16], y[(i*2+1)*16:])
+	}
+}
+
+// blockmixPwxform is the BlockMix of yescrypt: pwxform over the 64-byte
+// blocks of b, then Salsa20/2 over the last one.
+func blockmixPwxform(b []uint32, ctx *pwxformCtx, r int) {
+	r1 := 2 * r
+	var x [pwxWords]uint32
+	copy(x[:], b[(r1-1)*pwxWords:])
+	for i := 0; i < r1; i++ {
+		if r1 > 1 {
+			xorBlocks(x[:], b[i*pwxWords:(i+1)*pwxWords])
+		}
+		pwxform(x[:], ctx)
+		copy(b[i*pwxWords:], x[:])
+	}
+	salsa20(b[(r1-1)*16:r1*16], 2)
+}
+
+// pwxform transforms a block with multiplications and S-box lookups, and
+// writes the intermediate values into S2.
+func pwxform(x []uint32, ctx *pwxformCtx) {
+	sb, s0, s1, s2, w := ctx.s, ctx.s0, ctx.s1, ctx.s2, ctx.w
+	for i := 0; i < pwxRounds; i++ {
+		for j := 0; j < pwxGather; j++ {
+			xj := x[j*pwxSimple*2 : (j+1)*pwxSimple*2]
+			p0 := s0 + int(xj[0]&sMask)/4
+			p1 := s1 + int(xj[1]&sMask)/4
+			for k := 0; k < pwxSimple; k++ {
+				v0 := uint64(sb[p0+2*k+1])<<32 | uint64(sb[p0+2*k])
+				v1 := uint64(sb[p1+2*k+1])<<32 | uint64(sb[p1+2*k])
+				v := uint64(xj[2*k+1])*uint64(xj[2*k]) + v0
+				v ^= v1
+				xj[2*k], xj[2*k+1] = uint32(v), uint32(v>>32)
+				if i != 0 && i != pwxRounds-1 {
+					sb[s2+2*w], sb[s2+2*w+1] = uint32(v), uint32(v>>32)
+					w++
+				}
+			}
+		}
+	}
+	ctx.s0, ctx.s1, ctx.s2 = s2, s0, s1
+	ctx.w = w & sWMask
+}
+
+// salsa20 applies the Salsa20 core with the given rounds to a 64-byte block
+// in the SIMD order.
+func salsa20(b []uint32, rounds int) {
+	var x [16]uint32
+	for i := 0; i < 16; i++ {
+		x[i*5%16] = b[i]
+	}
+	for i := 0; i < rounds; i += 2 {
+		x[4] ^= bits.RotateLeft32(x[0]+x[12], 7)
+		x[8] ^= bits.RotateLeft32(x[4]+x[0], 9)
+		x[12] ^= bits.RotateLeft32(x[8]+x[4], 13)
+		x[0] ^= bits.RotateLeft32(x[12]+x[8], 18)
+		x[9] ^= bits.RotateLeft32(x[5]+x[1], 7)
+		x[13] ^= bits.RotateLeft32(x[9]+x[5], 9)
+		x[1] ^= bits.RotateLeft32(x[13]+x[9], 13)
+		x[5] ^= bits.RotateLeft32(x[1]+x[13], 18)
+		x[14] ^= bits.RotateLeft32(x[10]+x[6], 7)
+		x[2] ^= bits.RotateLeft32(x[14]+x[10], 9)
+		x[6] ^= bits.RotateLeft32(x[2]+x[14], 13)
+		x[10] ^= bits.RotateLeft32(x[6]+x[2], 18)
+		x[3] ^= bits.RotateLeft32(x[15]+x[11], 7)
+		x[7] ^= bits.RotateLeft32(x[3]+x[15], 9)
+		x[11] ^= bits.RotateLeft32(x[7]+x[3], 13)
+		x[15] ^= bits.RotateLeft32(x[11]+x[7], 18)
+
+		x[1] ^= bits.RotateLeft32(x[0]+x[3], 7)
+		x[2] ^= bits.RotateLeft32(x[1]+x[0], 9)
+		x[3] ^= bits.RotateLeft32(x[2]+x[1], 13)
+		x[0] ^= bits.RotateLeft32(x[3]+x[2], 18)
+		x[6] ^= bits.RotateLeft32(x[5]+x[4], 7)
+		x[7] ^= bits.RotateLeft32(x[6]+x[5], 9)
+		x[4] ^= bits.RotateLeft32(x[7]+x[6], 13)
+		x[5] ^= bits.RotateLeft32(x[4]+x[7], 18)
+		x[11] ^= bits.RotateLeft32(x[10]+x[9], 7)
+		x[8] ^= bits.RotateLeft32(x[11]+x[10], 9)
+		x[9] ^= bits.RotateLeft32(x[8]+x[11], 13)
+		x[10] ^= bits.RotateLeft32(x[9]+x[8], 18)
+		x[12] ^= bits.RotateLeft32(x[15]+x[14], 7)
+		x[13] ^= bits.RotateLeft32(x[12]+x[15], 9)
+		x[14] ^= bits.RotateLeft32(x[13]+x[12], 13)
+		x[15] ^= bits.RotateLeft32(x[14]+x[13], 18)
+	}
+	for i := 0; i < 16; i++ {
+		b[i] += x[i*5%16]
+	}
+}